@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// defineFlags collects repeated -define NAME[=VALUE] flags. Only the names
+// matter: like #ifdef, a macro defined as 0 still counts as defined.
+type defineFlags map[string]bool
+
+func (d *defineFlags) String() string {
+	if d == nil {
+		return ""
+	}
+	names := make([]string, 0, len(*d))
+	for name := range *d {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func (d *defineFlags) Set(spec string) error {
+	name, _, _ := strings.Cut(spec, "=")
+	name = strings.TrimSpace(name)
+	if !macroNameRe.MatchString(name) {
+		return fmt.Errorf("invalid macro name %q", name)
+	}
+	if *d == nil {
+		*d = defineFlags{}
+	}
+	(*d)[name] = true
+	return nil
+}
+
+var (
+	macroNameRe   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	directiveRe   = regexp.MustCompile(`^\s*#\s*(ifdef|ifndef|if|elif|else|endif)\b\s*(.*)$`)
+	definedRe     = regexp.MustCompile(`^(!?)\s*defined\s*\(?\s*([A-Za-z_][A-Za-z0-9_]*)\s*\)?$`)
+	lineCommentRe = regexp.MustCompile(`\s*//.*$`)
+)
+
+// conditionalLines blanks the lines of inactive #ifdef, #ifndef and
+// #if [!]defined(NAME) branches, given the macros in defines. Lines keep
+// their positions so reported line numbers still match the header. Other
+// #if expressions can't be evaluated and are treated as true.
+func conditionalLines(lines []string, defines map[string]bool, log *logger) []string {
+	type frame struct {
+		parent bool // enclosing block is active
+		taken  bool // an earlier branch of this block was active
+		active bool
+	}
+	var stack []frame
+	active := true
+
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		m := directiveRe.FindStringSubmatch(line)
+		if m == nil {
+			if active {
+				out[i] = line
+			}
+			continue
+		}
+
+		directive, arg := m[1], strings.TrimSpace(lineCommentRe.ReplaceAllString(m[2], ""))
+		switch directive {
+		case "ifdef", "ifndef", "if":
+			cond := evalCondition(directive, arg, defines, log)
+			stack = append(stack, frame{parent: active, taken: cond, active: active && cond})
+		case "elif", "else":
+			if len(stack) == 0 {
+				log.Warnf("#%s without #if on line %d", directive, i+1)
+				continue
+			}
+			f := &stack[len(stack)-1]
+			cond := directive == "else" || evalCondition("if", arg, defines, log)
+			f.active = f.parent && !f.taken && cond
+			f.taken = f.taken || cond
+		case "endif":
+			if len(stack) == 0 {
+				log.Warnf("#endif without #if on line %d", i+1)
+				continue
+			}
+			stack = stack[:len(stack)-1]
+		}
+
+		active = true
+		if len(stack) > 0 {
+			active = stack[len(stack)-1].active
+		}
+	}
+	return out
+}
+
+// evalCondition reports whether an #ifdef, #ifndef or #if condition holds.
+func evalCondition(directive, arg string, defines map[string]bool, log *logger) bool {
+	switch directive {
+	case "ifdef":
+		return defines[arg]
+	case "ifndef":
+		return !defines[arg]
+	}
+	if m := definedRe.FindStringSubmatch(arg); m != nil {
+		return defines[m[2]] != (m[1] == "!")
+	}
+	log.Debugf("can't evaluate #if %s, treating it as true", arg)
+	return true
+}
+
+// headerLines splits a header into lines, dropping inactive conditional
+// blocks when opts.Defines is set.
+func headerLines(data []byte, opts parseOptions) []string {
+	lines := strings.Split(string(data), "\n")
+	if opts.Defines == nil {
+		return lines
+	}
+	return conditionalLines(lines, opts.Defines, opts.Logger)
+}
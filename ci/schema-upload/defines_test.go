@@ -0,0 +1,129 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// guardedHeader has a CO2 measurement only built with CONFIG_ENABLE_CO2 and
+// a VOC one dropped by CONFIG_NO_VOC.
+const guardedHeader = `namespace sensor {
+
+enum class MeasurementId : uint8_t {
+  Timestamp = 1,
+  Temperature,
+#ifdef CONFIG_ENABLE_CO2
+  Co2,
+#endif
+  Voc,
+  Count
+};
+
+MEASUREMENT_TRAIT(Timestamp, uint64_t, "timestamp", "ms");
+MEASUREMENT_TRAIT(Temperature, float, "temperature", "°C");
+#ifdef CONFIG_ENABLE_CO2
+MEASUREMENT_TRAIT(Co2, float, "co2", "ppm");
+#endif
+#ifndef CONFIG_NO_VOC
+MEASUREMENT_TRAIT(Voc, float, "voc", "ppm");
+#endif
+
+} // namespace sensor
+`
+
+func schemaKeys(schema SchemaRequest) string {
+	keys := make([]string, 0, len(schema.Measurements))
+	for key := range schema.Measurements {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+func TestParseMeasurementHeader_Defines(t *testing.T) {
+	tests := []struct {
+		name    string
+		defines map[string]bool
+		want    string
+	}{
+		{name: "unconditional by default", defines: nil, want: "co2,temperature,timestamp,voc"},
+		{name: "no macros defined", defines: map[string]bool{}, want: "temperature,timestamp,voc"},
+		{name: "co2 enabled", defines: map[string]bool{"CONFIG_ENABLE_CO2": true}, want: "co2,temperature,timestamp,voc"},
+		{name: "voc disabled", defines: map[string]bool{"CONFIG_NO_VOC": true}, want: "temperature,timestamp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := parseMeasurementHeader([]byte(guardedHeader), parseOptions{Defines: tt.defines})
+			if err != nil {
+				t.Fatalf("parseMeasurementHeader() error = %v", err)
+			}
+			if got := schemaKeys(schema); got != tt.want {
+				t.Errorf("measurements = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMeasurementHeader_DefinesKeepIDs(t *testing.T) {
+	// Without CO2 compiled in, Voc takes the enum value after Temperature
+	schema, err := parseMeasurementHeader([]byte(guardedHeader), parseOptions{Defines: map[string]bool{}})
+	if err != nil {
+		t.Fatalf("parseMeasurementHeader() error = %v", err)
+	}
+	if got := schema.Measurements["voc"].ID; got != 3 {
+		t.Errorf("voc ID = %d, want 3", got)
+	}
+}
+
+func TestConditionalLines(t *testing.T) {
+	lines := strings.Split(`a
+#if defined(FOO)
+b
+#elif !defined(BAR)
+c
+#else
+d
+#endif
+#ifdef BAR
+#if SOME_EXPR > 1
+e
+#endif
+#endif
+f`, "\n")
+
+	tests := []struct {
+		defines map[string]bool
+		want    string
+	}{
+		{defines: map[string]bool{"FOO": true}, want: "a b f"},
+		{defines: map[string]bool{}, want: "a c f"},
+		{defines: map[string]bool{"BAR": true}, want: "a d e f"},
+	}
+
+	for _, tt := range tests {
+		got := strings.Join(strings.Fields(strings.Join(conditionalLines(lines, tt.defines, defaultLogger()), " ")), " ")
+		if got != tt.want {
+			t.Errorf("conditionalLines(%v) = %q, want %q", tt.defines, got, tt.want)
+		}
+		if n := len(conditionalLines(lines, tt.defines, defaultLogger())); n != len(lines) {
+			t.Errorf("conditionalLines() returned %d lines, want %d", n, len(lines))
+		}
+	}
+}
+
+func TestDefineFlags(t *testing.T) {
+	var defines defineFlags
+	for _, spec := range []string{"CONFIG_ENABLE_CO2", "CONFIG_LEVEL=2"} {
+		if err := defines.Set(spec); err != nil {
+			t.Fatalf("Set(%q) error = %v", spec, err)
+		}
+	}
+	if got := defines.String(); got != "CONFIG_ENABLE_CO2,CONFIG_LEVEL" {
+		t.Errorf("String() = %q", got)
+	}
+	if err := defines.Set("1BAD"); err == nil {
+		t.Error("Set(1BAD) expected error")
+	}
+}
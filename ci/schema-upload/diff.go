@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// schemaChange is one difference between two schemas: a measurement added or
+// removed, or a single field of a measurement changed.
+type schemaChange struct {
+	Op    byte // '+' added, '-' removed, '~' changed
+	Key   string
+	Field string // Changed field, empty for added and removed measurements
+	Old   string
+	New   string
+}
+
+func (c schemaChange) String() string {
+	switch c.Op {
+	case '+':
+		return fmt.Sprintf("+ %s: %s", c.Key, c.New)
+	case '-':
+		return fmt.Sprintf("- %s: %s", c.Key, c.Old)
+	default:
+		return fmt.Sprintf("~ %s.%s: %s -> %s", c.Key, c.Field, c.Old, c.New)
+	}
+}
+
+// diffSchemas returns the changes that turn old into new, ordered by
+// measurement key.
+func diffSchemas(old, new SchemaRequest) []schemaChange {
+	keys := make(map[string]bool)
+	for key := range old.Measurements {
+		keys[key] = true
+	}
+	for key := range new.Measurements {
+		keys[key] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	var changes []schemaChange
+	for _, key := range sorted {
+		o, inOld := old.Measurements[key]
+		n, inNew := new.Measurements[key]
+		switch {
+		case !inOld:
+			changes = append(changes, schemaChange{Op: '+', Key: key, New: describeMeasurement(n)})
+		case !inNew:
+			changes = append(changes, schemaChange{Op: '-', Key: key, Old: describeMeasurement(o)})
+		default:
+			changes = append(changes, diffMeasurement(key, o, n)...)
+		}
+	}
+	return changes
+}
+
+// diffMeasurement compares the fields of one measurement present in both
+// schemas.
+func diffMeasurement(key string, o, n MeasurementSchema) []schemaChange {
+	fields := []struct {
+		name     string
+		old, new string
+	}{
+		{"id", fmt.Sprint(o.ID), fmt.Sprint(n.ID)},
+		{"name", fmt.Sprintf("%q", o.Name), fmt.Sprintf("%q", n.Name)},
+		{"type", o.Type, n.Type},
+		{"unit", o.Unit, n.Unit},
+	}
+
+	var changes []schemaChange
+	for _, f := range fields {
+		if f.old != f.new {
+			changes = append(changes, schemaChange{Op: '~', Key: key, Field: f.name, Old: f.old, New: f.new})
+		}
+	}
+	return changes
+}
+
+func describeMeasurement(m MeasurementSchema) string {
+	return fmt.Sprintf("id=%d name=%q type=%s unit=%s", m.ID, m.Name, m.Type, m.Unit)
+}
+
+// formatSchemaDiff renders changes one per line, or "" when there are none.
+func formatSchemaDiff(changes []schemaChange) string {
+	var b strings.Builder
+	for _, c := range changes {
+		b.WriteString(c.String())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
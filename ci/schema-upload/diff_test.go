@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiffSchemas(t *testing.T) {
+	old := SchemaRequest{Measurements: map[string]MeasurementSchema{
+		"temperature": {ID: 2, Name: "Temperature", Type: "float", Unit: "celsius"},
+		"humidity":    {ID: 3, Name: "Humidity", Type: "float", Unit: "percent"},
+		"voc":         {ID: 7, Name: "VOC", Type: "float", Unit: "ppm"},
+	}}
+	new := SchemaRequest{Measurements: map[string]MeasurementSchema{
+		"temperature": {ID: 2, Name: "Temperature", Type: "float", Unit: "celsius"},
+		"humidity":    {ID: 4, Name: "Relative Humidity", Type: "float", Unit: "percent"},
+		"co2":         {ID: 8, Name: "CO2", Type: "int", Unit: "ppm"},
+	}}
+
+	got := formatSchemaDiff(diffSchemas(old, new))
+	want := `+ co2: id=8 name="CO2" type=int unit=ppm
+~ humidity.id: 3 -> 4
+~ humidity.name: "Humidity" -> "Relative Humidity"
+- voc: id=7 name="VOC" type=float unit=ppm
+`
+	if got != want {
+		t.Errorf("diff =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDiffSchemas_Identical(t *testing.T) {
+	schema := testSchema()
+	if changes := diffSchemas(schema, schema); len(changes) != 0 {
+		t.Errorf("diffSchemas() = %v, want no changes", changes)
+	}
+}
+
+func TestDiffSchemas_SavedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schema.json")
+	saved := `{"measurements":{"temperature":{"id":2,"name":"Temperature","type":"float","unit":"fahrenheit"}}}`
+	if err := os.WriteFile(path, []byte(saved), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := loadSchema(path)
+	if err != nil {
+		t.Fatalf("loadSchema() error = %v", err)
+	}
+
+	changes := diffSchemas(schema, testSchema())
+	if len(changes) != 1 || changes[0].String() != "~ temperature.unit: fahrenheit -> celsius" {
+		t.Errorf("changes = %v, want the unit change", changes)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import "errors"
+
+// Exit codes CI can branch on. Errors without a category exit with
+// exitFailure.
+const (
+	exitFailure    = 1 // usage, file or other errors
+	exitValidation = 2 // measurement.hpp or the schema is invalid
+	exitUpload     = 3 // the backend couldn't be reached or rejected the request
+	exitAuth       = 4 // the API key couldn't be fetched or was refused
+)
+
+// exitError tags err with the process exit code. A nil err means the
+// failure has already been reported and nothing more is logged.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	if e.err == nil {
+		return ""
+	}
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error { return e.err }
+
+func validationError(err error) error { return &exitError{code: exitValidation, err: err} }
+
+func authError(err error) error { return &exitError{code: exitAuth, err: err} }
+
+// withCode tags err with code unless something it wraps already carries a
+// more specific one, such as an auth failure inside an upload error.
+func withCode(code int, err error) error {
+	var e *exitError
+	if errors.As(err, &e) {
+		return err
+	}
+	return &exitError{code: code, err: err}
+}
+
+// exitCode returns the process exit code for an error returned by run.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return exitFailure
+}
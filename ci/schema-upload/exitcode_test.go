@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExitCode(t *testing.T) {
+	auth := authError(errors.New("failed to get API key from Secret Manager: permission denied"))
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, 0},
+		{"uncategorized", errors.New("Failed to load schema: no such file"), exitFailure},
+		{"validation", validationError(errors.New("Error: Schema has no measurements")), exitValidation},
+		{"validation already reported", validationError(nil), exitValidation},
+		{"upload", withCode(exitUpload, errors.New("Failed to upload schema: request failed")), exitUpload},
+		{"auth", auth, exitAuth},
+		{"auth wrapped", fmt.Errorf("Error: %w", auth), exitAuth},
+		{"auth inside upload", withCode(exitUpload, fmt.Errorf("Failed to upload schema: %w", auth)), exitAuth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExitError_KeepsMessage(t *testing.T) {
+	err := withCode(exitUpload, fmt.Errorf("Failed to upload schema: %w", errors.New("request failed")))
+	if got := err.Error(); got != "Failed to upload schema: request failed" {
+		t.Errorf("Error() = %q, want the wrapped message", got)
+	}
+	if got := validationError(nil).Error(); got != "" {
+		t.Errorf("validationError(nil).Error() = %q, want empty", got)
+	}
+}
+
+func TestPublishSchema_ExitCodes(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		want   int
+	}{
+		{"unauthorized", http.StatusUnauthorized, exitAuth},
+		{"forbidden", http.StatusForbidden, exitAuth},
+		{"server error", http.StatusInternalServerError, exitUpload},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			err := publishSchema(context.Background(), testHTTPClient(t, time.Second), server.URL,
+				server.URL+"/admin/schemas/probe/1.0.0", "test-key", testSchema(), false)
+			if err == nil {
+				t.Fatal("publishSchema() error = nil")
+			}
+			if got := exitCode(withCode(exitUpload, err)); got != tt.want {
+				t.Errorf("exit code for %v = %d, want %d", err, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		err := publishSchema(context.Background(), testHTTPClient(t, time.Second), server.URL,
+			server.URL+"/admin/schemas/probe/1.0.0", "test-key", testSchema(), true)
+		if got := exitCode(withCode(exitUpload, err)); got != exitUpload {
+			t.Errorf("exit code for %v = %d, want %d", err, got, exitUpload)
+		}
+	})
+}
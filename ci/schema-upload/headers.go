@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// protectedHeaders are set by the tool itself; -header may only replace them
+// when -allow-header-override is given.
+var protectedHeaders = []string{"Authorization", "Content-Type"}
+
+// extraHeaders collects repeated -header key:value flags.
+type extraHeaders http.Header
+
+func (h *extraHeaders) String() string {
+	if h == nil {
+		return ""
+	}
+	var specs []string
+	for _, key := range h.keys() {
+		for _, value := range (*h)[key] {
+			specs = append(specs, key+":"+value)
+		}
+	}
+	return strings.Join(specs, ",")
+}
+
+func (h *extraHeaders) Set(spec string) error {
+	key, value, err := parseHeader(spec)
+	if err != nil {
+		return err
+	}
+	if *h == nil {
+		*h = extraHeaders{}
+	}
+	http.Header(*h).Add(key, value)
+	return nil
+}
+
+// keys returns the canonical header names in sorted order.
+func (h extraHeaders) keys() []string {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkOverrides rejects headers that would replace Authorization or
+// Content-Type unless allow is set.
+func (h extraHeaders) checkOverrides(allow bool) error {
+	if allow {
+		return nil
+	}
+	for _, name := range protectedHeaders {
+		if _, ok := h[name]; ok {
+			return fmt.Errorf("-header %s would override the header set by schema-upload (pass -allow-header-override if intended)", name)
+		}
+	}
+	return nil
+}
+
+// apply sets the headers on req, replacing any existing values.
+func (h extraHeaders) apply(req *http.Request) {
+	for key, values := range h {
+		req.Header[key] = append([]string(nil), values...)
+	}
+}
+
+// parseHeader splits a "key:value" spec, validating the name as an HTTP token
+// and rejecting line breaks in the value.
+func parseHeader(spec string) (string, string, error) {
+	key, value, ok := strings.Cut(spec, ":")
+	if !ok {
+		return "", "", fmt.Errorf("invalid header %q: want key:value", spec)
+	}
+	key = strings.TrimSpace(key)
+	value = strings.TrimSpace(value)
+
+	if key == "" {
+		return "", "", fmt.Errorf("invalid header %q: empty name", spec)
+	}
+	for _, r := range key {
+		if !isTokenChar(r) {
+			return "", "", fmt.Errorf("invalid header %q: name contains %q", spec, r)
+		}
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return "", "", fmt.Errorf("invalid header %q: value contains a line break", spec)
+	}
+	return http.CanonicalHeaderKey(key), value, nil
+}
+
+// isTokenChar reports whether r may appear in an HTTP header name (RFC 9110).
+func isTokenChar(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", r)
+}
+
+// headerTransport adds extra headers to every request, so they reach both
+// the health check and the upload.
+type headerTransport struct {
+	base    http.RoundTripper
+	headers extraHeaders
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	t.headers.apply(req)
+	return t.base.RoundTrip(req)
+}
+
+// withHeaders wraps client's transport so every request carries headers.
+func withHeaders(client *http.Client, headers extraHeaders) *http.Client {
+	if len(headers) == 0 {
+		return client
+	}
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	wrapped := *client
+	wrapped.Transport = &headerTransport{base: base, headers: headers}
+	return &wrapped
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		spec      string
+		wantKey   string
+		wantValue string
+		wantErr   string
+	}{
+		{spec: "X-Env:staging", wantKey: "X-Env", wantValue: "staging"},
+		{spec: "x-trace-id: abc123 ", wantKey: "X-Trace-Id", wantValue: "abc123"},
+		{spec: "X-Empty:", wantKey: "X-Empty", wantValue: ""},
+		{spec: "X-Url:https://example.com", wantKey: "X-Url", wantValue: "https://example.com"},
+		{spec: "X-Env", wantErr: "want key:value"},
+		{spec: ":staging", wantErr: "empty name"},
+		{spec: "X Env:staging", wantErr: "name contains"},
+		{spec: "X-Env:staging\r\nX-Evil: 1", wantErr: "line break"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			key, value, err := parseHeader(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseHeader(%q) error = %v, want %q", tt.spec, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseHeader(%q) error = %v", tt.spec, err)
+			}
+			if key != tt.wantKey || value != tt.wantValue {
+				t.Errorf("parseHeader(%q) = %q, %q, want %q, %q", tt.spec, key, value, tt.wantKey, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestExtraHeaders_CheckOverrides(t *testing.T) {
+	var headers extraHeaders
+	if err := headers.Set("authorization:Basic abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := headers.checkOverrides(false); err == nil || !strings.Contains(err.Error(), "-allow-header-override") {
+		t.Errorf("checkOverrides(false) error = %v, want override rejected", err)
+	}
+	if err := headers.checkOverrides(true); err != nil {
+		t.Errorf("checkOverrides(true) error = %v", err)
+	}
+}
+
+func TestPublishSchema_ExtraHeaders(t *testing.T) {
+	var seen []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Clone())
+		if r.Method == http.MethodGet {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	var headers extraHeaders
+	for _, spec := range []string{"X-Env:staging", "X-Trace-Id:abc123"} {
+		if err := headers.Set(spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := withHeaders(testHTTPClient(t, time.Second), headers)
+	err := publishSchema(context.Background(), client, server.URL,
+		server.URL+"/admin/schemas/probe/1.0.0", "test-key", testSchema(), true)
+	if err != nil {
+		t.Fatalf("publishSchema() error = %v", err)
+	}
+
+	if len(seen) != 2 {
+		t.Fatalf("server saw %d requests, want health check and upload", len(seen))
+	}
+	for i, h := range seen {
+		if h.Get("X-Env") != "staging" || h.Get("X-Trace-Id") != "abc123" {
+			t.Errorf("request %d headers = %v, want X-Env and X-Trace-Id", i, h)
+		}
+	}
+	if got := seen[1].Get("Authorization"); got != "Bearer test-key" {
+		t.Errorf("Authorization = %q, want Bearer test-key", got)
+	}
+}
+
+func TestFormatRequest_ExtraHeaders(t *testing.T) {
+	req, body, err := newUploadRequest(context.Background(), "https://api.example.com/admin/schemas/probe/1.2.3", "super-secret-key", testSchema())
+	if err != nil {
+		t.Fatalf("newUploadRequest() error = %v", err)
+	}
+
+	var headers extraHeaders
+	if err := headers.Set("X-Env:staging"); err != nil {
+		t.Fatal(err)
+	}
+	headers.apply(req)
+
+	got := formatRequest(req, body)
+	if !strings.Contains(got, "Authorization: Bearer <redacted>\nX-Env: staging\n") {
+		t.Errorf("formatRequest() =\n%s\nwant X-Env header after Authorization", got)
+	}
+}
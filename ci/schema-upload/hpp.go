@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// renderHeaderFragment converts a schema back into the MeasurementId enum and
+// MEASUREMENT_TRAIT lines of measurement.hpp. It is the inverse of
+// parseMeasurementHeader, used to scaffold firmware code from a backend schema.
+func renderHeaderFragment(schema SchemaRequest) string {
+	keys := sortedKeys(schema)
+
+	var b strings.Builder
+
+	b.WriteString("enum class MeasurementId : uint8_t {\n")
+	for i, key := range keys {
+		id := schema.Measurements[key].ID
+		// Only spell out values that don't follow on from the previous entry
+		if i == 0 || id != schema.Measurements[keys[i-1]].ID+1 {
+			fmt.Fprintf(&b, "  %s = %d,\n", enumName(key), id)
+		} else {
+			fmt.Fprintf(&b, "  %s,\n", enumName(key))
+		}
+	}
+	b.WriteString("  Count\n};\n\n")
+
+	for _, key := range keys {
+		m := schema.Measurements[key]
+		fmt.Fprintf(&b, "MEASUREMENT_TRAIT(%s, %s, %q, %q);\n",
+			enumName(key), cppType(m.Type), key, denormalizeUnit(m.Unit))
+	}
+
+	return b.String()
+}
+
+// enumName converts a snake_case measurement key to a CamelCase enum name.
+func enumName(key string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(key, "_") {
+		if part == "" {
+			continue
+		}
+		runes := []rune(part)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+	return b.String()
+}
+
+// cppType maps a backend type to the C++ type used in MEASUREMENT_TRAIT.
+func cppType(backendType string) string {
+	switch backendType {
+	case "int":
+		return "int32_t"
+	case "bool":
+		return "bool"
+	case "string":
+		return "const char *"
+	case "enum":
+		return "uint8_t"
+	default:
+		return "float"
+	}
+}
+
+// denormalizeUnit reverses normalizeUnit.
+func denormalizeUnit(unit string) string {
+	switch unit {
+	case "celsius":
+		return "°C"
+	case "percent":
+		return "%"
+	default:
+		return unit
+	}
+}
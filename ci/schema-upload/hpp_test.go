@@ -0,0 +1,86 @@
+package main
+
+import "testing"
+
+func TestRenderHeaderFragment_RoundTrip(t *testing.T) {
+	original, err := generateSchema(nil, parseOptions{})
+	if err != nil {
+		t.Fatalf("generateSchema() error = %v", err)
+	}
+	if len(original.Measurements) == 0 {
+		t.Fatal("generateSchema() returned no measurements")
+	}
+
+	fragment := renderHeaderFragment(original)
+
+	regenerated, err := parseMeasurementHeader([]byte(fragment), parseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("parseMeasurementHeader() error = %v", err)
+	}
+
+	if len(regenerated.Measurements) != len(original.Measurements) {
+		t.Fatalf("got %d measurements, want %d", len(regenerated.Measurements), len(original.Measurements))
+	}
+
+	for key, want := range original.Measurements {
+		got, ok := regenerated.Measurements[key]
+		if !ok {
+			t.Errorf("measurement %q missing after round trip", key)
+			continue
+		}
+		if got.ID != want.ID || got.Type != want.Type || got.Unit != want.Unit {
+			t.Errorf("%s = {ID:%d Type:%s Unit:%s}, want {ID:%d Type:%s Unit:%s}",
+				key, got.ID, got.Type, got.Unit, want.ID, want.Type, want.Unit)
+		}
+	}
+}
+
+func TestRenderHeaderFragment(t *testing.T) {
+	schema := SchemaRequest{Measurements: map[string]MeasurementSchema{
+		"temperature":  {ID: 2, Name: "Temperature", Type: "float", Unit: "celsius"},
+		"timestamp":    {ID: 1, Name: "Timestamp", Type: "int", Unit: "ms"},
+		"iaq_accuracy": {ID: 10, Name: "IAQ Accuracy", Type: "int", Unit: "/3"},
+	}}
+
+	want := `enum class MeasurementId : uint8_t {
+  Timestamp = 1,
+  Temperature,
+  IaqAccuracy = 10,
+  Count
+};
+
+MEASUREMENT_TRAIT(Timestamp, int32_t, "timestamp", "ms");
+MEASUREMENT_TRAIT(Temperature, float, "temperature", "°C");
+MEASUREMENT_TRAIT(IaqAccuracy, int32_t, "iaq_accuracy", "/3");
+`
+
+	got := renderHeaderFragment(schema)
+	if got != want {
+		t.Errorf("renderHeaderFragment() =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEnumName(t *testing.T) {
+	tests := map[string]string{
+		"temperature":  "Temperature",
+		"iaq_accuracy": "IaqAccuracy",
+		"co2":          "Co2",
+		"_leading":     "Leading",
+	}
+	for key, want := range tests {
+		if got := enumName(key); got != want {
+			t.Errorf("enumName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestCppType(t *testing.T) {
+	for _, backendType := range []string{"float", "int", "bool", "string"} {
+		if got, _ := mapType(cppType(backendType), nil); got != backendType {
+			t.Errorf("mapType(cppType(%q)) = %q", backendType, got)
+		}
+	}
+	if got := cppType("unknown"); got != "float" {
+		t.Errorf("cppType(unknown) = %q, want float fallback", got)
+	}
+}
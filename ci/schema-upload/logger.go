@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+)
+
+// logger is a minimal leveled logger. Warnings are always shown; debug
+// output only with -v so normal runs stay quiet.
+type logger struct {
+	out     *log.Logger
+	verbose bool
+}
+
+func newLogger(w io.Writer, verbose bool) *logger {
+	return &logger{out: log.New(w, "", log.LstdFlags), verbose: verbose}
+}
+
+// defaultLogger writes warnings to stderr and drops debug output.
+func defaultLogger() *logger {
+	return newLogger(os.Stderr, false)
+}
+
+func (l *logger) Debugf(format string, args ...any) {
+	if l.verbose {
+		l.out.Printf("Debug: "+format, args...)
+	}
+}
+
+func (l *logger) Warnf(format string, args ...any) {
+	l.out.Printf("Warning: "+format, args...)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseMeasurementHeader_VerboseOutput(t *testing.T) {
+	var buf bytes.Buffer
+	opts := parseOptions{Logger: newLogger(&buf, true)}
+
+	if _, err := parseMeasurementHeader([]byte(testHeader), opts); err != nil {
+		t.Fatalf("parseMeasurementHeader() error = %v", err)
+	}
+
+	want := []string{
+		"Debug: enum Timestamp = 1",
+		"Debug: enum Temperature = 2",
+		"Debug: enum Pressure = 4",
+		`Debug: trait id=Temperature type=float name=temperature unit="°C"`,
+		`Debug: trait id=Humidity type=float name=humidity unit="%"`,
+	}
+	for _, line := range want {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("debug output missing %q\ngot:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestParseMeasurementHeader_QuietByDefault(t *testing.T) {
+	var buf bytes.Buffer
+	opts := parseOptions{Logger: newLogger(&buf, false)}
+
+	if _, err := parseMeasurementHeader([]byte(testHeader), opts); err != nil {
+		t.Fatalf("parseMeasurementHeader() error = %v", err)
+	}
+
+	if buf.Len() != 0 {
+		t.Errorf("expected no output without -v, got:\n%s", buf.String())
+	}
+}
+
+func TestLogger_WarningsAlwaysShown(t *testing.T) {
+	var buf bytes.Buffer
+	l := newLogger(&buf, false)
+
+	l.Debugf("hidden")
+	l.Warnf("shown %d", 1)
+
+	if strings.Contains(buf.String(), "hidden") {
+		t.Error("debug output shown without verbose")
+	}
+	if !strings.Contains(buf.String(), "Warning: shown 1") {
+		t.Errorf("warning missing, got %q", buf.String())
+	}
+}
+
+func TestGenerateSchema_LogsHeaderPath(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := generateSchema(nil, parseOptions{Logger: newLogger(&buf, true)}); err != nil {
+		t.Fatalf("generateSchema() error = %v", err)
+	}
+
+	if !strings.Contains(buf.String(), "found measurement.hpp at ../../components/") {
+		t.Errorf("debug output missing header path, got:\n%s", buf.String())
+	}
+}
@@ -3,20 +3,24 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 	"unicode"
-
-	secretmanager "cloud.google.com/go/secretmanager/apiv1"
-	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 )
 
 // MeasurementSchema represents the backend schema format
@@ -30,10 +34,125 @@ type MeasurementSchema struct {
 // MEASUREMENT_TRAIT has 4 fields: ID, TYPE, NAME, UNIT
 const measurementTraitFieldCount = 4
 
+// defaultMaxID is the largest measurement ID the firmware's wire encoding
+// can carry: IDs are sent in a single byte.
+const defaultMaxID = 255
+
+// defaultUploadTimeout bounds the schema upload unless -timeout is given
+const defaultUploadTimeout = 30 * time.Second
+
+// Reserved MeasurementId entries. Count terminates the enum and is never a
+// measurement. Timestamp is a system entry with an explicit value (= 1) that
+// seeds the counter for the entries after it; it is part of the schema only
+// if it has a MEASUREMENT_TRAIT (the firmware sends it in every batch).
+const (
+	countEnumName     = "Count"
+	timestampEnumName = "Timestamp"
+)
+
+// parseOptions controls how measurement.hpp is parsed into a schema.
+type parseOptions struct {
+	// Strict turns consistency warnings (e.g. enum entries without a trait) into errors
+	Strict bool
+	// StrictTypes fails on trait types with no known backend mapping
+	StrictTypes bool
+	// NameOverrides maps trait names to human-readable names, taking
+	// precedence over defaultNameOverrides
+	NameOverrides map[string]string
+	// TypeOverrides maps C++ trait types to backend types, taking precedence
+	// over defaultTypeMap
+	TypeOverrides map[string]string
+	// Defines, when set, makes parsing preprocessor-aware: traits and enum
+	// entries in #ifdef/#ifndef blocks that these macros don't satisfy are
+	// skipped. Nil parses every line unconditionally.
+	Defines map[string]bool
+	// MaxID is the largest allowed measurement ID (defaultMaxID if zero)
+	MaxID uint32
+	// Logger receives warnings and -v debug output (stderr, quiet if nil)
+	Logger *logger
+}
+
+func (o parseOptions) withDefaults() parseOptions {
+	if o.Logger == nil {
+		o.Logger = defaultLogger()
+	}
+	if o.MaxID == 0 {
+		o.MaxID = defaultMaxID
+	}
+	return o
+}
+
+// defaultNameOverrides are built-in human-readable names for measurements
+// whose auto-generated name reads poorly.
+var defaultNameOverrides = map[string]string{
+	"co2": "CO2 Equivalent",
+	"voc": "Volatile Organic Compounds",
+}
+
+// defaultTypeMap maps C++ trait types, as normalized by normalizeCppType, to
+// backend types.
+var defaultTypeMap = map[string]string{
+	"float":            "float",
+	"double":           "float",
+	"int8_t":           "int",
+	"int16_t":          "int",
+	"int32_t":          "int",
+	"int64_t":          "int",
+	"uint8_t":          "int",
+	"uint16_t":         "int",
+	"uint32_t":         "int",
+	"uint64_t":         "int",
+	"bool":             "bool",
+	"char":             "string",
+	"char*":            "string",
+	"const char*":      "string",
+	"std::string":      "string",
+	"std::string_view": "string",
+}
+
+// backendTypes are the measurement types the backend accepts.
+var backendTypes = map[string]bool{
+	"float":  true,
+	"int":    true,
+	"bool":   true,
+	"string": true,
+	"enum":   true,
+}
+
 type SchemaRequest struct {
 	Measurements map[string]MeasurementSchema `json:"measurements"`
 }
 
+// MarshalJSON writes the measurements ordered by ID instead of by key, so the
+// payload is stable and reads in enum order. The wire format is unchanged: the
+// backend still receives an object keyed by measurement name.
+func (s SchemaRequest) MarshalJSON() ([]byte, error) {
+	if s.Measurements == nil {
+		return []byte(`{"measurements":null}`), nil
+	}
+
+	var b bytes.Buffer
+	b.WriteString(`{"measurements":{`)
+	for i, key := range sortedKeys(s) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(s.Measurements[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteString(`}}`)
+	return b.Bytes(), nil
+}
+
 type SchemaResponse struct {
 	App     string `json:"app"`
 	Version string `json:"version"`
@@ -68,262 +187,668 @@ func normalizeUnit(unit string) string {
 }
 
 func main() {
+	if err := run(); err != nil {
+		if msg := err.Error(); msg != "" {
+			log.Print(msg)
+		}
+		os.Exit(exitCode(err))
+	}
+}
+
+// run carries out the command selected by the flags. Its error carries the
+// process exit code (see exitCode).
+func run() error {
 	var (
-		appName    = flag.String("app", "probe", "Application name")
-		version    = flag.String("version", "", "Firmware version (required)")
-		apiURL     = flag.String("api-url", "https://telemetry-api-cn4vxdwjxq-uw.a.run.app", "Backend API URL")
-		projectID  = flag.String("project", "", "GCP project ID (required for Secret Manager)")
-		secretName = flag.String("secret", "github-actions-api-key", "Secret Manager secret name")
-		schemaFile = flag.String("schema", "", "Path to schema JSON file (optional, generates if not provided)")
-		dryRun     = flag.Bool("dry-run", false, "Generate schema but don't upload")
-		outputFile = flag.String("o", "", "Write generated schema to a file instead of stdout")
+		appName     = flag.String("app", "probe", "Application name")
+		version     = flag.String("version", "", "Firmware version (default: read from -version-file)")
+		apiURL      = flag.String("api-url", "https://telemetry-api-cn4vxdwjxq-uw.a.run.app", "Backend API URL")
+		projectID   = flag.String("project", "", "GCP project ID (required for Secret Manager)")
+		secretName  = flag.String("secret", "github-actions-api-key", "Name of the secret holding the API key")
+		secretKind  = flag.String("secret-source", "gcp", "Where to read -secret from: gcp (Secret Manager in -project) or env (environment variable)")
+		schemaFile  = flag.String("schema", "", "Path to schema JSON file (optional, generates if not provided)")
+		dryRun      = flag.Bool("dry-run", false, "Generate schema but don't upload")
+		outputFile  = flag.String("o", "", "Write generated schema to a file instead of stdout")
+		toHeader    = flag.String("to-hpp", "", "Convert a schema JSON file into measurement.hpp enum and traits, then exit")
+		strict      = flag.Bool("strict", false, "Fail instead of warning when measurement.hpp is inconsistent")
+		namesFile   = flag.String("names", "", "JSON file mapping measurement names to human-readable names (optional)")
+		list        = flag.Bool("list", false, "Print parsed measurements as a table and exit without uploading")
+		limit       = flag.Int("limit", 0, "Show only the first N measurements (by ID) in -list and the printed schema; uploads are unaffected (0 shows all)")
+		verbose     = flag.Bool("v", false, "Log each parsed enum entry and trait")
+		strictTypes = flag.Bool("strict-types", false, "Fail on trait types with no known backend type")
+		maxID       = flag.Uint("max-id", defaultMaxID, "Largest measurement ID the firmware can encode")
+		timeout     = flag.Duration("timeout", defaultUploadTimeout, "HTTP timeout for the schema upload")
+		caCert      = flag.String("ca-cert", "", "PEM CA bundle to trust in addition to the system roots (optional)")
+		validate    = flag.Bool("validate", false, "Only check measurement.hpp for consistency problems, then exit (non-zero on failure)")
+		versionFile = flag.String("version-file", "", "Read the firmware version from this file when -version is omitted (default: version.txt or PROJECT_VER in CMakeLists.txt)")
+		preflight   = flag.Bool("preflight", true, "Check GET {api-url}/health before uploading and fail early if the backend is unreachable or answers 5xx (skipped when it has no health endpoint)")
+		typeMapFile = flag.String("type-map", "", "JSON file mapping C++ trait types to backend types, added to the built-in map (optional)")
+		fetch       = flag.Bool("fetch", false, "Print the schema the backend currently has for -app and -version, then exit (table with -list)")
+		diffFile    = flag.String("diff-file", "", "Compare the generated schema with this saved schema JSON and exit non-zero if they differ (no upload)")
+		overrides   = flag.Bool("allow-header-override", false, "Let -header replace the Authorization and Content-Type headers")
 	)
+	var hppPaths headerPaths
+	flag.Var(&hppPaths, "hpp", "Measurement header to parse; repeat to merge several (default: find measurement.hpp)")
+	var headers extraHeaders
+	var defines defineFlags
+	flag.Var(&defines, "define", "Macro defined in the firmware build (NAME or NAME=VALUE); repeat for several. When given, traits in #ifdef/#ifndef blocks these don't satisfy are skipped")
+	flag.Var(&headers, "header", "Extra HTTP header as key:value, sent with every request; repeatable")
 	flag.Parse()
 
-	if *version == "" && !*dryRun {
-		log.Fatal("Error: -version is required unless in dry-run mode")
+	if err := headers.checkOverrides(*overrides); err != nil {
+		return fmt.Errorf("Error: %w", err)
+	}
+
+	var typeOverrides map[string]string
+	if *typeMapFile != "" {
+		var err error
+		typeOverrides, err = loadTypeMap(*typeMapFile)
+		if err != nil {
+			return fmt.Errorf("Failed to load type map: %w", err)
+		}
+	}
+
+	if *maxID == 0 || uint64(*maxID) > math.MaxUint32 {
+		return fmt.Errorf("Error: -max-id must be between 1 and %d", uint32(math.MaxUint32))
+	}
+
+	// Options for parsing the measurement headers, shared by -validate and
+	// schema generation so both see the same headers
+	opts := parseOptions{
+		Strict:        *strict,
+		StrictTypes:   *strictTypes,
+		TypeOverrides: typeOverrides,
+		Defines:       defines,
+		MaxID:         uint32(*maxID),
+		Logger:        newLogger(os.Stderr, *verbose),
+	}
+	if *namesFile != "" {
+		var err error
+		opts.NameOverrides, err = loadNameOverrides(*namesFile, opts.Logger)
+		if err != nil {
+			return fmt.Errorf("Failed to load name overrides: %w", err)
+		}
+	}
+
+	if *validate {
+		sources, err := readHeaders(hppPaths, opts.Logger)
+		if err != nil {
+			return fmt.Errorf("Failed to read measurement definitions: %w", err)
+		}
+		result := validateHeaders(sources, opts)
+		fmt.Print(result.Report(sourcePaths(sources)))
+		if len(result.Issues) > 0 {
+			return validationError(nil)
+		}
+		return nil
+	}
+
+	if *toHeader != "" {
+		schema, err := loadSchema(*toHeader)
+		if err != nil {
+			return fmt.Errorf("Failed to load schema: %w", err)
+		}
+		fragment := renderHeaderFragment(schema)
+		if *outputFile != "" {
+			if err := os.WriteFile(*outputFile, []byte(fragment), 0644); err != nil {
+				return fmt.Errorf("Failed to write header fragment to %s: %w", *outputFile, err)
+			}
+			fmt.Printf("✓ Header fragment written to %s\n", *outputFile)
+			return nil
+		}
+		fmt.Print(fragment)
+		return nil
+	}
+
+	resolved, source, err := resolveVersion(*version, *versionFile, newLogger(os.Stderr, *verbose))
+	switch {
+	case err == nil:
+		*version = resolved
+		if source != "" {
+			fmt.Fprintf(os.Stderr, "✓ Version %s from %s\n", resolved, source)
+		}
+	case *versionFile != "":
+		return fmt.Errorf("Error: %w", err)
+	case *fetch || (!*dryRun && !*list && *diffFile == ""):
+		return fmt.Errorf("Error: -version is required unless in dry-run mode (%w)", err)
+	}
+
+	if *fetch {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		url := fmt.Sprintf("%s/admin/schemas/%s/%s", *apiURL, *appName, *version)
+		secrets, err := newSecretSource(*secretKind, *projectID)
+		if err != nil {
+			return fmt.Errorf("Error: %w", err)
+		}
+		client, apiKey, err := connect(ctx, secrets, *secretName, *timeout, *caCert, headers)
+		if err != nil {
+			return fmt.Errorf("Error: %w", err)
+		}
+		schema, err := fetchSchema(ctx, client, url, apiKey)
+		if err != nil {
+			return withCode(exitUpload, fmt.Errorf("Failed to fetch schema: %w", err))
+		}
+		if *list {
+			fmt.Print(formatLimitedTable(schema, *limit))
+			return nil
+		}
+		schemaJSON, err := json.MarshalIndent(schema, "", "  ")
+		if err != nil {
+			return fmt.Errorf("Failed to marshal schema to JSON: %w", err)
+		}
+		fmt.Println(string(schemaJSON))
+		return nil
 	}
 
 	// Generate or load schema
 	var schema SchemaRequest
-	var err error
 
 	if *schemaFile != "" {
 		schema, err = loadSchema(*schemaFile)
 		if err != nil {
-			log.Fatalf("Failed to load schema: %v", err)
+			return fmt.Errorf("Failed to load schema: %w", err)
 		}
 	} else {
 		// Generate schema from measurement definitions
-		schema, err = generateSchema()
+		schema, err = generateSchema(hppPaths, opts)
 		if err != nil {
-			log.Fatalf("Failed to generate schema: %v", err)
+			return validationError(fmt.Errorf("Failed to generate schema: %w", err))
 		}
 	}
 
 	// Validate schema
 	if len(schema.Measurements) == 0 {
-		log.Fatal("Error: Schema has no measurements")
+		return validationError(errors.New("Error: Schema has no measurements"))
+	}
+
+	if *list {
+		fmt.Print(formatLimitedTable(schema, *limit))
+		return nil
+	}
+
+	if *diffFile != "" {
+		saved, err := loadSchema(*diffFile)
+		if err != nil {
+			return fmt.Errorf("Failed to load %s: %w", *diffFile, err)
+		}
+		changes := diffSchemas(saved, schema)
+		if len(changes) == 0 {
+			fmt.Printf("✓ Schema matches %s\n", *diffFile)
+			return nil
+		}
+		fmt.Printf("Schema differs from %s:\n", *diffFile)
+		fmt.Print(formatSchemaDiff(changes))
+		return &exitError{code: exitFailure}
 	}
 
 	schemaJSON, err := json.MarshalIndent(schema, "", "  ")
 	if err != nil {
-		log.Fatalf("Failed to marshal schema to JSON: %v", err)
+		return fmt.Errorf("Failed to marshal schema to JSON: %w", err)
 	}
 
 	// Print or write schema
 	if *outputFile != "" {
 		err := os.WriteFile(*outputFile, schemaJSON, 0644)
 		if err != nil {
-			log.Fatalf("Failed to write schema to %s: %v", *outputFile, err)
+			return fmt.Errorf("Failed to write schema to %s: %w", *outputFile, err)
 		}
 		fmt.Printf("✓ Schema written to %s\n", *outputFile)
-		return
+		return nil
 	} else {
+		shown, omitted := limitSchema(schema, *limit)
+		previewJSON, err := json.MarshalIndent(shown, "", "  ")
+		if err != nil {
+			return fmt.Errorf("Failed to marshal schema to JSON: %w", err)
+		}
 		fmt.Println("Generated schema:")
-		fmt.Println(string(schemaJSON))
+		fmt.Println(string(previewJSON))
+		fmt.Print(formatOmitted(omitted))
 		fmt.Println()
 	}
 
 	if *dryRun {
-		fmt.Println("Dry run - not uploading")
-		return
+		url := fmt.Sprintf("%s/admin/schemas/%s/%s", *apiURL, *appName, *version)
+		req, body, err := newUploadRequest(context.Background(), url, "", schema)
+		if err != nil {
+			return fmt.Errorf("Failed to build request: %w", err)
+		}
+		headers.apply(req)
+		fmt.Println("Dry run - not uploading. Request that would be sent:")
+		fmt.Println(formatRequest(req, body))
+		return nil
 	}
 
-	// Get API key from Secret Manager
-	if *projectID == "" {
-		log.Fatal("Error: -project is required for upload")
-	}
+	// Upload schema, aborting cleanly on Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-	apiKey, err := getSecretValue(*projectID, *secretName)
+	url := fmt.Sprintf("%s/admin/schemas/%s/%s", *apiURL, *appName, *version)
+	secrets, err := newSecretSource(*secretKind, *projectID)
+	if err != nil {
+		return fmt.Errorf("Error: %w", err)
+	}
+	client, apiKey, err := connect(ctx, secrets, *secretName, *timeout, *caCert, headers)
 	if err != nil {
-		log.Fatalf("Failed to get API key from Secret Manager: %v", err)
+		return fmt.Errorf("Error: %w", err)
 	}
-	fmt.Println("✓ Retrieved API key from Secret Manager")
 
-	// Upload schema
-	url := fmt.Sprintf("%s/admin/schemas/%s/%s", *apiURL, *appName, *version)
-	if err := uploadSchema(url, apiKey, schema); err != nil {
-		log.Fatalf("Failed to upload schema: %v", err)
+	if err := publishSchema(ctx, client, *apiURL, url, apiKey, schema, *preflight); err != nil {
+		return withCode(exitUpload, fmt.Errorf("Failed to upload schema: %w", err))
 	}
 
 	fmt.Printf("✓ Schema uploaded successfully for %s v%s\n", *appName, *version)
+	return nil
 }
 
-// getSecretValue retrieves a secret from GCP Secret Manager using Application Default Credentials
-func getSecretValue(projectID, secretName string) (string, error) {
-	ctx := context.Background()
+// connect fetches the API key from source and builds the HTTP client used
+// for backend requests, carrying any extra headers.
+func connect(ctx context.Context, source SecretSource, secretName string, timeout time.Duration, caCert string, headers extraHeaders) (*http.Client, string, error) {
+	apiKey, err := source.Secret(ctx, secretName)
+	if err != nil {
+		return nil, "", authError(fmt.Errorf("failed to get API key from %s: %w", source, err))
+	}
+	fmt.Fprintf(os.Stderr, "✓ Retrieved API key from %s\n", source)
 
-	client, err := secretmanager.NewClient(ctx)
+	client, err := newHTTPClient(timeout, caCert)
 	if err != nil {
-		return "", fmt.Errorf("failed to create Secret Manager client: %w", err)
+		return nil, "", fmt.Errorf("failed to configure HTTP client: %w", err)
 	}
-	defer client.Close()
+	return withHeaders(client, headers), apiKey, nil
+}
 
-	name := fmt.Sprintf("projects/%s/secrets/%s/versions/latest", projectID, secretName)
+// generateSchema parses the given headers into one schema. With no paths,
+// measurement.hpp is located as readMeasurementHeader does.
+func generateSchema(paths []string, opts parseOptions) (SchemaRequest, error) {
+	opts = opts.withDefaults()
 
-	req := &secretmanagerpb.AccessSecretVersionRequest{
-		Name: name,
+	headers, err := readHeaders(paths, opts.Logger)
+	if err != nil {
+		return SchemaRequest{}, err
 	}
+	return parseMeasurementHeaders(headers, opts)
+}
 
-	result, err := client.AccessSecretVersion(ctx, req)
-	if err != nil {
-		return "", fmt.Errorf("failed to access secret %s: %w", secretName, err)
+// readHeaders reads the given -hpp paths, or measurement.hpp found as
+// readMeasurementHeader does when there are none.
+func readHeaders(paths []string, log *logger) ([]headerSource, error) {
+	var headers []headerSource
+	if len(paths) == 0 {
+		data, path, err := readMeasurementHeader(log)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, headerSource{Path: path, Data: data})
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		headers = append(headers, headerSource{Path: path, Data: data})
 	}
+	return headers, nil
+}
 
-	return string(result.Payload.Data), nil
+// sourcePaths joins the header paths for messages.
+func sourcePaths(headers []headerSource) string {
+	paths := make([]string, len(headers))
+	for i, h := range headers {
+		paths[i] = h.Path
+	}
+	return strings.Join(paths, ", ")
 }
 
-func generateSchema() (SchemaRequest, error) {
-	// Read measurement.hpp to extract measurement definitions
-	// Try multiple possible paths (relative to repo root or ci directory)
+// headerSource is the contents of one measurement header and where it came from.
+type headerSource struct {
+	Path string
+	Data []byte
+}
+
+// headerPaths collects repeated -hpp flags.
+type headerPaths []string
+
+func (h *headerPaths) String() string { return strings.Join(*h, ",") }
+
+func (h *headerPaths) Set(path string) error {
+	*h = append(*h, path)
+	return nil
+}
+
+// readMeasurementHeader reads measurement.hpp, trying paths relative to the
+// repo root and the ci directory, and returns its contents and path.
+func readMeasurementHeader(log *logger) ([]byte, string, error) {
 	possiblePaths := []string{
 		"components/library/sensor_base/include/sensor/measurement.hpp",
 		"../components/library/sensor_base/include/sensor/measurement.hpp",
 		"../../components/library/sensor_base/include/sensor/measurement.hpp",
 	}
 
-	var data []byte
 	var err error
-
 	for _, path := range possiblePaths {
+		var data []byte
 		data, err = os.ReadFile(path)
 		if err == nil {
-			break
+			log.Debugf("found measurement.hpp at %s", path)
+			return data, path, nil
 		}
+		log.Debugf("measurement.hpp not at %s", path)
 	}
 
-	if err != nil {
-		return SchemaRequest{}, fmt.Errorf("failed to read measurement.hpp (tried %v): %w", possiblePaths, err)
-	}
+	return nil, "", fmt.Errorf("failed to read measurement.hpp (tried %v): %w", possiblePaths, err)
+}
 
-	// First, parse enum definition to map enum names to values
-	lines := strings.Split(string(data), "\n")
-	enumNameToValue := make(map[string]uint32)
-	enumValue := uint32(0)
-	inEnum := false
+// parseMeasurementHeader extracts the schema from the contents of measurement.hpp.
+func parseMeasurementHeader(data []byte, opts parseOptions) (SchemaRequest, error) {
+	return parseMeasurementHeaders([]headerSource{{Path: "measurement.hpp", Data: data}}, opts)
+}
 
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		if strings.Contains(trimmed, "enum class MeasurementId") {
-			inEnum = true
-			continue
+// parseMeasurementHeaders extracts one schema from several headers, e.g.
+// measurement.hpp and measurement_ext.hpp for optional sensors. Each file's
+// MeasurementId enum is parsed on its own and merged into a single map, so a
+// trait may refer to an entry declared in another file. An enum entry, ID or
+// schema key defined in more than one file is an error.
+func parseMeasurementHeaders(headers []headerSource, opts parseOptions) (SchemaRequest, error) {
+	opts = opts.withDefaults()
+
+	// First, parse the enum definitions to map enum names to values
+	enumNameToValue := make(map[string]uint32)
+	enumFile := make(map[string]string)
+	idFile := make(map[uint32]string)
+	idName := make(map[uint32]string)
+	enumTypes := make(map[string]bool)
+	for _, h := range headers {
+		lines := headerLines(h.Data, opts)
+		for _, entry := range parseEnumEntries(lines, opts.Logger) {
+			if other, ok := enumFile[entry.Name]; ok && other != h.Path {
+				return SchemaRequest{}, fmt.Errorf("enum entry %s is defined in both %s and %s", entry.Name, other, h.Path)
+			}
+			if other, ok := idFile[entry.Value]; ok && other != h.Path {
+				return SchemaRequest{}, fmt.Errorf("ID %d is used by both %s (%s) and %s (%s)",
+					entry.Value, idName[entry.Value], other, entry.Name, h.Path)
+			}
+			enumNameToValue[entry.Name] = entry.Value
+			enumFile[entry.Name] = h.Path
+			idFile[entry.Value] = h.Path
+			idName[entry.Value] = entry.Name
 		}
-		if inEnum {
-			if strings.Contains(trimmed, "Count") || strings.Contains(trimmed, "};") {
-				break
+		for name := range enumTypeNames(h.Data) {
+			enumTypes[name] = true
+		}
+	}
+
+	measurements := make(map[string]MeasurementSchema)
+	keyFile := make(map[string]string)
+	traits := make(map[string]bool)
+
+	// Overrides for human-readable names: file > built-in > auto-generated
+	nameOverrides := mergeNameOverrides(defaultNameOverrides, opts.NameOverrides)
+
+	for _, h := range headers {
+		for _, line := range headerLines(h.Data, opts) {
+			t, ok := parseTraitLine(line)
+			if !ok {
+				continue
 			}
-			// Skip comments and empty lines
-			if strings.HasPrefix(trimmed, "//") || trimmed == "" {
+			idStr, typeStr, nameStr, unitStr := t.ID, t.Type, t.Name, t.Unit
+
+			opts.Logger.Debugf("trait id=%s type=%s name=%s unit=%q", idStr, typeStr, nameStr, unitStr)
+
+			// Skip Count enum value
+			if idStr == countEnumName {
 				continue
 			}
-			// Parse enum entries like "Temperature," or "Timestamp = 1,"
-			if strings.Contains(trimmed, ",") {
-				entry := strings.TrimSpace(strings.Split(trimmed, ",")[0])
-				// Remove any trailing comments
-				if idx := strings.Index(entry, "//"); idx >= 0 {
-					entry = strings.TrimSpace(entry[:idx])
-				}
-				// Check for explicit value assignment (e.g., "Timestamp = 1")
-				var enumName string
-				if idx := strings.Index(entry, "="); idx >= 0 {
-					enumName = strings.TrimSpace(entry[:idx])
-					valueStr := strings.TrimSpace(entry[idx+1:])
-					if val, err := strconv.ParseUint(valueStr, 10, 32); err == nil {
-						enumValue = uint32(val)
-					}
-				} else {
-					enumName = entry
-				}
-				if enumName != "" {
-					enumNameToValue[enumName] = enumValue
-					enumValue++
+			traits[idStr] = true
+
+			// Get enum value from the map we built
+			enumID, ok := enumNameToValue[idStr]
+			if !ok {
+				opts.Logger.Warnf("Could not find enum value for %s, skipping", idStr)
+				continue
+			}
+
+			if enumID > opts.MaxID {
+				return SchemaRequest{}, fmt.Errorf("measurement %s has ID %d, above the maximum of %d the firmware can encode", idStr, enumID, opts.MaxID)
+			}
+			measurementID := enumID
+
+			// Map C++ types to backend types
+			backendType, known := mapType(typeStr, opts.TypeOverrides)
+			if enumTypes[typeStr] {
+				backendType, known = "enum", true
+			}
+			if !known {
+				if opts.StrictTypes {
+					return SchemaRequest{}, fmt.Errorf("unknown type %q for measurement %s", typeStr, idStr)
 				}
+				opts.Logger.Warnf("unknown type %q for measurement %s, using %q", typeStr, idStr, backendType)
+			}
+
+			// Generate human-readable name
+			humanName := toHumanReadable(idStr)
+			if override, exists := nameOverrides[nameStr]; exists {
+				humanName = override
+			}
+
+			if other, ok := keyFile[nameStr]; ok && other != h.Path {
+				return SchemaRequest{}, fmt.Errorf("measurement %q is defined in both %s and %s", nameStr, other, h.Path)
+			}
+			keyFile[nameStr] = h.Path
+
+			measurements[nameStr] = MeasurementSchema{
+				ID:   measurementID,
+				Name: humanName,
+				Type: backendType,
+				Unit: normalizeUnit(unitStr),
 			}
 		}
 	}
 
-	measurements := make(map[string]MeasurementSchema)
-
-	// Manual overrides for human-readable names
-	nameOverrides := map[string]string{
-		"co2": "CO2 Equivalent",
-		"voc": "Volatile Organic Compounds",
+	if missing := missingTraits(enumNameToValue, traits); len(missing) > 0 {
+		if opts.Strict {
+			return SchemaRequest{}, fmt.Errorf("enum entries without MEASUREMENT_TRAIT: %s", strings.Join(missing, ", "))
+		}
+		opts.Logger.Warnf("enum entries without MEASUREMENT_TRAIT: %s", strings.Join(missing, ", "))
 	}
 
+	return SchemaRequest{Measurements: measurements}, nil
+}
+
+// enumEntry is one MeasurementId entry with its resolved value.
+type enumEntry struct {
+	Name  string
+	Value uint32
+}
+
+// parseEnumEntries returns the MeasurementId entries before Count, in
+// declaration order, with explicit values (e.g. "Timestamp = 1") seeding the
+// counter for the entries after them.
+func parseEnumEntries(lines []string, log *logger) []enumEntry {
+	var entries []enumEntry
+	enumValue := uint32(0)
+	inEnum := false
+
 	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if !strings.HasPrefix(line, "MEASUREMENT_TRAIT(") {
+		trimmed := strings.TrimSpace(line)
+		if strings.Contains(trimmed, "enum class MeasurementId") {
+			inEnum = true
 			continue
 		}
-
-		// Parse: MEASUREMENT_TRAIT(Temperature, float, "temperature", "°C");
-		parts := strings.Split(line, ",")
-		if len(parts) < measurementTraitFieldCount {
+		if !inEnum {
 			continue
 		}
+		if strings.Contains(trimmed, countEnumName) || strings.Contains(trimmed, "};") {
+			break
+		}
+		// Skip comments and empty lines
+		if strings.HasPrefix(trimmed, "//") || trimmed == "" {
+			continue
+		}
+		// Parse enum entries like "Temperature," or "Timestamp = 1,"
+		if !strings.Contains(trimmed, ",") {
+			continue
+		}
+		entry := strings.TrimSpace(strings.Split(trimmed, ",")[0])
+		// Remove any trailing comments
+		if idx := strings.Index(entry, "//"); idx >= 0 {
+			entry = strings.TrimSpace(entry[:idx])
+		}
+		// Check for explicit value assignment (e.g., "Timestamp = 1")
+		enumName := entry
+		if idx := strings.Index(entry, "="); idx >= 0 {
+			enumName = strings.TrimSpace(entry[:idx])
+			valueStr := strings.TrimSpace(entry[idx+1:])
+			if val, err := strconv.ParseUint(valueStr, 0, 32); err == nil {
+				enumValue = uint32(val)
+			}
+		}
+		if enumName != "" {
+			log.Debugf("enum %s = %d", enumName, enumValue)
+			entries = append(entries, enumEntry{Name: enumName, Value: enumValue})
+			enumValue++
+		}
+	}
+	return entries
+}
 
-		// Extract ID (first param) - this is the enum name
-		idStr := strings.TrimSpace(strings.TrimPrefix(parts[0], "MEASUREMENT_TRAIT("))
+// trait is the raw content of one MEASUREMENT_TRAIT line.
+type trait struct {
+	ID   string // MeasurementId entry
+	Type string // C++ type
+	Name string // schema key
+	Unit string // unit as written in the header
+}
+
+// parseTraitLine parses a line such as
+// MEASUREMENT_TRAIT(Temperature, float, "temperature", "°C");
+func parseTraitLine(line string) (trait, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "MEASUREMENT_TRAIT(") {
+		return trait{}, false
+	}
 
-		// Extract TYPE (second param)
-		typeStr := strings.TrimSpace(parts[1])
+	parts := strings.Split(line, ",")
+	if len(parts) < measurementTraitFieldCount {
+		return trait{}, false
+	}
 
-		// Extract NAME (third param, remove quotes)
-		nameStr := strings.TrimSpace(parts[2])
-		nameStr = strings.Trim(nameStr, `"`)
+	// Extract UNIT (fourth param, remove quotes and closing paren)
+	unit := strings.TrimSpace(parts[3])
+	unit = strings.TrimSuffix(unit, ");")
 
-		// Extract UNIT (fourth param, remove quotes and closing paren)
-		unitStr := strings.TrimSpace(parts[3])
-		unitStr = strings.TrimSuffix(unitStr, ");")
-		unitStr = strings.Trim(unitStr, `"`)
+	return trait{
+		ID:   strings.TrimSpace(strings.TrimPrefix(parts[0], "MEASUREMENT_TRAIT(")),
+		Type: strings.TrimSpace(parts[1]),
+		Name: strings.Trim(strings.TrimSpace(parts[2]), `"`),
+		Unit: strings.Trim(unit, `"`),
+	}, true
+}
 
-		// Skip Count enum value
-		if idStr == "Count" {
+// missingTraits returns the enum names (in enum order) that have no
+// MEASUREMENT_TRAIT. Count and Timestamp are not measurements and are ignored.
+func missingTraits(enumNameToValue map[string]uint32, traits map[string]bool) []string {
+	var missing []string
+	for name := range enumNameToValue {
+		if name == countEnumName || name == timestampEnumName || traits[name] {
 			continue
 		}
+		missing = append(missing, name)
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		return enumNameToValue[missing[i]] < enumNameToValue[missing[j]]
+	})
+	return missing
+}
 
-		// Get enum value from the map we built
-		enumID, ok := enumNameToValue[idStr]
-		if !ok {
-			log.Printf("Warning: Could not find enum value for %s, skipping", idStr)
-			continue
+// loadNameOverrides reads a JSON object mapping trait names to human-readable
+// names. A missing file is not an error; it is reported on log and the
+// built-in names are used instead.
+func loadNameOverrides(path string, log *logger) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Warnf("name overrides file %s not found, using built-in names", path)
+			return nil, nil
 		}
+		return nil, err
+	}
+
+	var overrides map[string]string
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return overrides, nil
+}
 
-		measurementID := enumID
+// mergeNameOverrides layers overrides on top of base without modifying either.
+func mergeNameOverrides(base, overrides map[string]string) map[string]string {
+	merged := make(map[string]string, len(base)+len(overrides))
+	for name, human := range base {
+		merged[name] = human
+	}
+	for name, human := range overrides {
+		merged[name] = human
+	}
+	return merged
+}
 
-		// Map C++ types to backend types
-		backendType := mapType(typeStr)
+// loadTypeMap reads a JSON object mapping C++ trait types (e.g. a typedef
+// such as "temperature_t") to backend types. Keys are normalized like trait
+// types and every value must be a backend type.
+func loadTypeMap(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
 
-		// Generate human-readable name
-		humanName := toHumanReadable(idStr)
-		if override, exists := nameOverrides[nameStr]; exists {
-			humanName = override
-		}
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
 
-		measurements[nameStr] = MeasurementSchema{
-			ID:   measurementID,
-			Name: humanName,
-			Type: backendType,
-			Unit: normalizeUnit(unitStr),
+	types := make(map[string]string, len(raw))
+	for cppType, backendType := range raw {
+		if !backendTypes[backendType] {
+			return nil, fmt.Errorf("%s: type %q maps to unknown backend type %q", path, cppType, backendType)
 		}
+		types[normalizeCppType(cppType)] = backendType
 	}
+	return types, nil
+}
 
-	return SchemaRequest{Measurements: measurements}, nil
+// mapType maps a C++ trait type to its backend type, looking in overrides
+// (keyed by normalized type) before defaultTypeMap. Unknown types fall back
+// to "float" and report ok=false so the caller can warn or fail.
+func mapType(cppType string, overrides map[string]string) (backendType string, ok bool) {
+	normalized := normalizeCppType(cppType)
+	if backendType, ok := overrides[normalized]; ok {
+		return backendType, true
+	}
+	if backendType, ok := defaultTypeMap[normalized]; ok {
+		return backendType, true
+	}
+	return "float", false
 }
 
-func mapType(cppType string) string {
-	cppType = strings.TrimSpace(cppType)
-	switch cppType {
-	case "float", "double":
-		return "float"
-	case "int32_t", "int64_t", "uint32_t", "uint64_t", "uint8_t":
-		return "int"
-	case "bool":
-		return "bool"
-	default:
-		return "float" // Default fallback
+// normalizeCppType collapses whitespace so "const char *" and "const char*" match.
+func normalizeCppType(cppType string) string {
+	normalized := strings.Join(strings.Fields(cppType), " ")
+	return strings.ReplaceAll(normalized, " *", "*")
+}
+
+// enumTypeRegex matches enum declarations such as "enum class Mode : uint8_t {".
+var enumTypeRegex = regexp.MustCompile(`\benum\s+(?:class\s+|struct\s+)?(\w+)`)
+
+// enumTypeNames returns the enum types declared in the header other than
+// MeasurementId. Traits using one of them are enum-backed measurements.
+func enumTypeNames(data []byte) map[string]bool {
+	names := make(map[string]bool)
+	for _, match := range enumTypeRegex.FindAllSubmatch(data, -1) {
+		if name := string(match[1]); name != "MeasurementId" {
+			names[name] = true
+		}
 	}
+	return names
 }
 
 func loadSchema(path string) (SchemaRequest, error) {
@@ -340,21 +865,130 @@ func loadSchema(path string) (SchemaRequest, error) {
 	return schema, nil
 }
 
-func uploadSchema(url, apiKey string, schema SchemaRequest) error {
+// newHTTPClient returns the client used for backend requests. It honors
+// HTTPS_PROXY and, if caCertPath is set, trusts that PEM bundle in addition
+// to the system roots.
+func newHTTPClient(timeout time.Duration, caCertPath string) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+
+	if caCertPath != "" {
+		pem, err := os.ReadFile(caCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("read CA bundle: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caCertPath)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+// newUploadRequest builds the schema POST, returning the request and its body.
+func newUploadRequest(ctx context.Context, url, apiKey string, schema SchemaRequest) (*http.Request, []byte, error) {
 	jsonData, err := json.Marshal(schema)
 	if err != nil {
-		return fmt.Errorf("failed to marshal schema: %w", err)
+		return nil, nil, fmt.Errorf("failed to marshal schema: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonData))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{Timeout: 30 * time.Second}
+	return req, jsonData, nil
+}
+
+// formatRequest renders a request for display with the auth token redacted.
+func formatRequest(req *http.Request, body []byte) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s\n", req.Method, req.URL)
+	fmt.Fprintf(&b, "Content-Type: %s\n", req.Header.Get("Content-Type"))
+	b.WriteString("Authorization: Bearer <redacted>\n")
+	for _, key := range extraHeaders(req.Header).keys() {
+		if key == "Content-Type" || key == "Authorization" {
+			continue
+		}
+		for _, value := range req.Header[key] {
+			fmt.Fprintf(&b, "%s: %s\n", key, value)
+		}
+	}
+	b.WriteString("\n")
+	b.Write(body)
+	return b.String()
+}
+
+// publishSchema uploads schema to url, first confirming with checkHealth that
+// the backend at apiURL is up when preflight is set.
+func publishSchema(ctx context.Context, client *http.Client, apiURL, url, apiKey string, schema SchemaRequest, preflight bool) error {
+	if preflight {
+		checked, err := checkHealth(ctx, client, apiURL)
+		if err != nil {
+			return fmt.Errorf("preflight: %w", err)
+		}
+		if checked {
+			fmt.Println("✓ Backend is healthy")
+		} else {
+			fmt.Printf("⚠️  No health endpoint at %s (tried %s) - skipping preflight\n",
+				strings.TrimRight(apiURL, "/"), strings.Join(healthPaths, ", "))
+		}
+	}
+	return uploadSchema(ctx, client, url, apiKey, schema)
+}
+
+// healthPaths are tried in order by checkHealth; the second is only used when
+// the backend doesn't serve the first.
+var healthPaths = []string{"/health", "/healthz"}
+
+// checkHealth confirms the backend at apiURL is reachable and healthy before
+// anything is uploaded. It returns a "backend unreachable" error when the
+// backend can't be reached or a health endpoint answers 5xx. Not every
+// backend serves one, so any other answer reports checked as false and lets
+// the upload go ahead.
+func checkHealth(ctx context.Context, client *http.Client, apiURL string) (checked bool, err error) {
+	base := strings.TrimRight(apiURL, "/")
+
+	for _, path := range healthPaths {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+path, nil)
+		if err != nil {
+			return false, fmt.Errorf("failed to create health request: %w", err)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return false, fmt.Errorf("backend unreachable at %s: %w", base, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		switch status := resp.StatusCode; {
+		case status == http.StatusOK:
+			return true, nil
+		case status >= http.StatusInternalServerError:
+			return false, fmt.Errorf("backend unreachable at %s: %s returned status %d", base, path, status)
+		case status != http.StatusNotFound:
+			return false, nil
+		}
+	}
+	return false, nil
+}
+
+func uploadSchema(ctx context.Context, client *http.Client, url, apiKey string, schema SchemaRequest) error {
+	req, _, err := newUploadRequest(ctx, url, apiKey, schema)
+	if err != nil {
+		return err
+	}
+
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("request failed: %w", err)
@@ -363,7 +997,11 @@ func uploadSchema(url, apiKey string, schema SchemaRequest) error {
 
 	body, _ := io.ReadAll(resp.Body)
 
-	if resp.StatusCode != http.StatusCreated {
+	switch resp.StatusCode {
+	case http.StatusCreated:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return authError(fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(body)))
+	default:
 		return fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
@@ -375,3 +1013,36 @@ func uploadSchema(url, apiKey string, schema SchemaRequest) error {
 
 	return nil
 }
+
+// fetchSchema GETs the schema the backend currently stores at url.
+func fetchSchema(ctx context.Context, client *http.Client, url, apiKey string) (SchemaRequest, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return SchemaRequest{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return SchemaRequest{}, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return SchemaRequest{}, fmt.Errorf("no schema at %s", url)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return SchemaRequest{}, authError(fmt.Errorf("authentication failed with status %d: %s", resp.StatusCode, string(body)))
+	default:
+		return SchemaRequest{}, fmt.Errorf("fetch failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var schema SchemaRequest
+	if err := json.Unmarshal(body, &schema); err != nil {
+		return SchemaRequest{}, fmt.Errorf("failed to decode schema: %w", err)
+	}
+	return schema, nil
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testHeader is a trimmed-down measurement.hpp with the same layout as the firmware header.
+const testHeader = `namespace sensor {
+
+enum class MeasurementId : uint8_t {
+  Timestamp = 1,
+  Temperature,
+  Humidity,
+  Pressure,
+  Count
+};
+
+MEASUREMENT_TRAIT(Timestamp, uint64_t, "timestamp", "ms");
+MEASUREMENT_TRAIT(Temperature, float, "temperature", "°C");
+MEASUREMENT_TRAIT(Humidity, float, "humidity", "%");
+MEASUREMENT_TRAIT(Pressure, float, "pressure", "hPa");
+
+} // namespace sensor
+`
+
+// withoutLine returns the header with the line containing substr removed.
+func withoutLine(header, substr string) string {
+	var kept []string
+	for _, line := range strings.Split(header, "\n") {
+		if !strings.Contains(line, substr) {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+func TestParseMeasurementHeader_MissingTrait(t *testing.T) {
+	header := withoutLine(testHeader, "MEASUREMENT_TRAIT(Humidity")
+
+	t.Run("warns by default", func(t *testing.T) {
+		schema, err := parseMeasurementHeader([]byte(header), parseOptions{})
+		if err != nil {
+			t.Fatalf("parseMeasurementHeader() error = %v", err)
+		}
+		if _, ok := schema.Measurements["humidity"]; ok {
+			t.Error("humidity should not be in the schema without a trait")
+		}
+		if len(schema.Measurements) != 3 {
+			t.Errorf("got %d measurements, want 3", len(schema.Measurements))
+		}
+	})
+
+	t.Run("strict fails", func(t *testing.T) {
+		_, err := parseMeasurementHeader([]byte(header), parseOptions{Strict: true})
+		if err == nil {
+			t.Fatal("parseMeasurementHeader() expected error in strict mode")
+		}
+		if !strings.Contains(err.Error(), "Humidity") {
+			t.Errorf("error %q does not name the missing entry", err)
+		}
+	})
+}
+
+func TestParseMeasurementHeader_Complete(t *testing.T) {
+	schema, err := parseMeasurementHeader([]byte(testHeader), parseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("parseMeasurementHeader() error = %v", err)
+	}
+	if len(schema.Measurements) != 4 {
+		t.Errorf("got %d measurements, want 4", len(schema.Measurements))
+	}
+}
+
+func TestMissingTraits(t *testing.T) {
+	enum := map[string]uint32{"Timestamp": 1, "Temperature": 2, "Humidity": 3, "Pressure": 4, "Count": 5}
+	traits := map[string]bool{"Temperature": true}
+
+	got := missingTraits(enum, traits)
+	want := []string{"Humidity", "Pressure"}
+
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("missingTraits() = %v, want %v", got, want)
+	}
+}
+
+func TestNameOverridePrecedence(t *testing.T) {
+	header := `enum class MeasurementId : uint8_t {
+  Temperature = 2,
+  CO2,
+  VOC,
+  Count
+};
+
+MEASUREMENT_TRAIT(Temperature, float, "temperature", "°C");
+MEASUREMENT_TRAIT(CO2, float, "co2", "ppm");
+MEASUREMENT_TRAIT(VOC, float, "voc", "ppm");
+`
+
+	opts := parseOptions{NameOverrides: map[string]string{"co2": "Carbon Dioxide"}}
+	schema, err := parseMeasurementHeader([]byte(header), opts)
+	if err != nil {
+		t.Fatalf("parseMeasurementHeader() error = %v", err)
+	}
+
+	tests := map[string]string{
+		"co2":         "Carbon Dioxide",             // file
+		"voc":         "Volatile Organic Compounds", // built-in
+		"temperature": "Temperature",                // auto-generated
+	}
+	for key, want := range tests {
+		if got := schema.Measurements[key].Name; got != want {
+			t.Errorf("%s name = %q, want %q", key, got, want)
+		}
+	}
+
+	if defaultNameOverrides["co2"] != "CO2 Equivalent" {
+		t.Error("merging overrides must not modify the built-in map")
+	}
+}
+
+func TestLoadNameOverrides(t *testing.T) {
+	t.Run("valid file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "names.json")
+		if err := os.WriteFile(path, []byte(`{"iaq": "Air Quality Index"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := loadNameOverrides(path, defaultLogger())
+		if err != nil {
+			t.Fatalf("loadNameOverrides() error = %v", err)
+		}
+		if got["iaq"] != "Air Quality Index" {
+			t.Errorf("iaq = %q, want %q", got["iaq"], "Air Quality Index")
+		}
+	})
+
+	t.Run("missing file tolerated", func(t *testing.T) {
+		var out bytes.Buffer
+		got, err := loadNameOverrides(filepath.Join(t.TempDir(), "missing.json"), newLogger(&out, false))
+		if err != nil {
+			t.Fatalf("loadNameOverrides() error = %v", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("got %d overrides, want 0", len(got))
+		}
+		if !strings.Contains(out.String(), "Warning: name overrides file") {
+			t.Errorf("log = %q, want a warning about the missing file", out.String())
+		}
+	})
+
+	t.Run("invalid json", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "names.json")
+		if err := os.WriteFile(path, []byte(`{"iaq":`), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := loadNameOverrides(path, defaultLogger()); err == nil {
+			t.Error("loadNameOverrides() expected error for invalid JSON")
+		}
+	})
+}
+
+func TestParseMeasurementHeader_TimestampSeedsCounter(t *testing.T) {
+	// Representative enum block: Timestamp is reserved with an explicit value
+	// and has no trait of its own, so only the entries after it are measurements.
+	header := `enum class MeasurementId : uint8_t {
+  Timestamp = 1,
+  Temperature,
+  Humidity,
+  Count
+};
+
+MEASUREMENT_TRAIT(Temperature, float, "temperature", "°C");
+MEASUREMENT_TRAIT(Humidity, float, "humidity", "%");
+`
+
+	schema, err := parseMeasurementHeader([]byte(header), parseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("parseMeasurementHeader() error = %v", err)
+	}
+
+	if _, ok := schema.Measurements["timestamp"]; ok {
+		t.Error("timestamp without a trait must not be in the measurements map")
+	}
+	if got := schema.Measurements["temperature"].ID; got != 2 {
+		t.Errorf("temperature ID = %d, want 2", got)
+	}
+	if got := schema.Measurements["humidity"].ID; got != 3 {
+		t.Errorf("humidity ID = %d, want 3", got)
+	}
+}
+
+func TestParseMeasurementHeader_TimestampWithTrait(t *testing.T) {
+	// The firmware header declares a trait for Timestamp; it keeps its reserved ID
+	schema, err := parseMeasurementHeader([]byte(testHeader), parseOptions{})
+	if err != nil {
+		t.Fatalf("parseMeasurementHeader() error = %v", err)
+	}
+
+	if got := schema.Measurements["timestamp"].ID; got != 1 {
+		t.Errorf("timestamp ID = %d, want 1", got)
+	}
+	if got := schema.Measurements["temperature"].ID; got != 2 {
+		t.Errorf("temperature ID = %d, want 2", got)
+	}
+}
+
+func TestParseMeasurementHeader_HexEnumValue(t *testing.T) {
+	header := `enum class MeasurementId : uint8_t {
+  Timestamp = 0x10,
+  Temperature,
+  Count
+};
+
+MEASUREMENT_TRAIT(Temperature, float, "temperature", "°C");
+`
+
+	schema, err := parseMeasurementHeader([]byte(header), parseOptions{})
+	if err != nil {
+		t.Fatalf("parseMeasurementHeader() error = %v", err)
+	}
+	if got := schema.Measurements["temperature"].ID; got != 17 {
+		t.Errorf("temperature ID = %d, want 17", got)
+	}
+}
+
+func TestParseMeasurementHeader_MaxID(t *testing.T) {
+	schema, err := parseMeasurementHeader([]byte(testHeader), parseOptions{})
+	if err != nil {
+		t.Fatalf("parseMeasurementHeader() error = %v", err)
+	}
+	if got := schema.Measurements["pressure"].ID; got != 4 {
+		t.Errorf("pressure ID = %d, want 4", got)
+	}
+
+	header := strings.Replace(testHeader, "  Pressure,", "  Pressure = 300,", 1)
+	_, err = parseMeasurementHeader([]byte(header), parseOptions{})
+	if err == nil || !strings.Contains(err.Error(), "measurement Pressure has ID 300, above the maximum of 255") {
+		t.Errorf("parseMeasurementHeader() error = %v, want Pressure out of range", err)
+	}
+
+	_, err = parseMeasurementHeader([]byte(testHeader), parseOptions{MaxID: 3})
+	if err == nil || !strings.Contains(err.Error(), "measurement Pressure has ID 4, above the maximum of 3") {
+		t.Errorf("parseMeasurementHeader(MaxID: 3) error = %v, want Pressure out of range", err)
+	}
+}
+
+func TestMapType(t *testing.T) {
+	tests := []struct {
+		cppType string
+		want    string
+		known   bool
+	}{
+		{"float", "float", true},
+		{"double", "float", true},
+		{"uint8_t", "int", true},
+		{"int64_t", "int", true},
+		{"bool", "bool", true},
+		{"char", "string", true},
+		{"const char*", "string", true},
+		{"const char *", "string", true},
+		{" std::string ", "string", true},
+		{"temperature_t", "float", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cppType, func(t *testing.T) {
+			got, known := mapType(tt.cppType, nil)
+			if got != tt.want || known != tt.known {
+				t.Errorf("mapType(%q) = (%q, %t), want (%q, %t)", tt.cppType, got, known, tt.want, tt.known)
+			}
+		})
+	}
+}
+
+func TestParseMeasurementHeader_StringAndEnumTypes(t *testing.T) {
+	header := `enum class MeasurementId : uint8_t {
+  Timestamp = 1,
+  Status,
+  Mode,
+  Count
+};
+
+enum class OperatingMode : uint8_t { Idle, Active };
+
+MEASUREMENT_TRAIT(Status, const char *, "status", "");
+MEASUREMENT_TRAIT(Mode, OperatingMode, "mode", "");
+`
+
+	schema, err := parseMeasurementHeader([]byte(header), parseOptions{StrictTypes: true})
+	if err != nil {
+		t.Fatalf("parseMeasurementHeader() error = %v", err)
+	}
+
+	if got := schema.Measurements["status"].Type; got != "string" {
+		t.Errorf("status type = %q, want string", got)
+	}
+	if got := schema.Measurements["mode"].Type; got != "enum" {
+		t.Errorf("mode type = %q, want enum", got)
+	}
+}
+
+func TestParseMeasurementHeader_UnknownType(t *testing.T) {
+	header := `enum class MeasurementId : uint8_t {
+  Temperature = 2,
+  Count
+};
+
+MEASUREMENT_TRAIT(Temperature, temperature_t, "temperature", "°C");
+`
+
+	t.Run("warns by default", func(t *testing.T) {
+		var buf bytes.Buffer
+		schema, err := parseMeasurementHeader([]byte(header), parseOptions{Logger: newLogger(&buf, false)})
+		if err != nil {
+			t.Fatalf("parseMeasurementHeader() error = %v", err)
+		}
+		if got := schema.Measurements["temperature"].Type; got != "float" {
+			t.Errorf("temperature type = %q, want float fallback", got)
+		}
+		if !strings.Contains(buf.String(), `unknown type "temperature_t"`) {
+			t.Errorf("missing warning, got %q", buf.String())
+		}
+	})
+
+	t.Run("strict types fails", func(t *testing.T) {
+		_, err := parseMeasurementHeader([]byte(header), parseOptions{StrictTypes: true})
+		if err == nil || !strings.Contains(err.Error(), "temperature_t") {
+			t.Errorf("parseMeasurementHeader() error = %v, want unknown type error", err)
+		}
+	})
+
+	t.Run("type map resolves it under strict types", func(t *testing.T) {
+		opts := parseOptions{StrictTypes: true, TypeOverrides: map[string]string{"temperature_t": "int"}}
+		schema, err := parseMeasurementHeader([]byte(header), opts)
+		if err != nil {
+			t.Fatalf("parseMeasurementHeader() error = %v", err)
+		}
+		if got := schema.Measurements["temperature"].Type; got != "int" {
+			t.Errorf("temperature type = %q, want int from type map", got)
+		}
+	})
+}
+
+func TestDefaultTypeMap(t *testing.T) {
+	for cppType, backendType := range defaultTypeMap {
+		if !backendTypes[backendType] {
+			t.Errorf("defaultTypeMap[%q] = %q, not a backend type", cppType, backendType)
+		}
+		if normalizeCppType(cppType) != cppType {
+			t.Errorf("defaultTypeMap key %q is not normalized", cppType)
+		}
+	}
+}
+
+func TestMapType_Overrides(t *testing.T) {
+	overrides := map[string]string{"temperature_t": "float", "double": "int"}
+
+	tests := []struct {
+		cppType string
+		want    string
+	}{
+		{"temperature_t", "float"},
+		{"double", "int"},
+		{"uint8_t", "int"},
+		{"bool", "bool"},
+	}
+	for _, tt := range tests {
+		got, known := mapType(tt.cppType, overrides)
+		if got != tt.want || !known {
+			t.Errorf("mapType(%q) = (%q, %t), want (%q, true)", tt.cppType, got, known, tt.want)
+		}
+	}
+}
+
+func TestLoadTypeMap(t *testing.T) {
+	write := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "types.json")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("valid file", func(t *testing.T) {
+		got, err := loadTypeMap(write(t, `{"temperature_t": "float", "const  char *": "string"}`))
+		if err != nil {
+			t.Fatalf("loadTypeMap() error = %v", err)
+		}
+		if got["temperature_t"] != "float" || got["const char*"] != "string" {
+			t.Errorf("loadTypeMap() = %v", got)
+		}
+	})
+
+	t.Run("unknown backend type", func(t *testing.T) {
+		_, err := loadTypeMap(write(t, `{"temperature_t": "decimal"}`))
+		if err == nil || !strings.Contains(err.Error(), `"decimal"`) {
+			t.Errorf("loadTypeMap() error = %v, want unknown backend type", err)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := loadTypeMap(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+			t.Error("loadTypeMap() expected error for missing file")
+		}
+	})
+
+	t.Run("invalid json", func(t *testing.T) {
+		if _, err := loadTypeMap(write(t, `{"temperature_t":`)); err == nil {
+			t.Error("loadTypeMap() expected error for invalid JSON")
+		}
+	})
+}
+
+func TestSchemaRequest_MarshalJSONOrderedByID(t *testing.T) {
+	measurements := []struct {
+		key string
+		m   MeasurementSchema
+	}{
+		{"zeta", MeasurementSchema{ID: 1, Name: "Zeta", Type: "float", Unit: "ppm"}},
+		{"alpha", MeasurementSchema{ID: 3, Name: "Alpha", Type: "int", Unit: ""}},
+		{"mid", MeasurementSchema{ID: 2, Name: "Mid", Type: "bool", Unit: ""}},
+	}
+
+	forward := SchemaRequest{Measurements: map[string]MeasurementSchema{}}
+	backward := SchemaRequest{Measurements: map[string]MeasurementSchema{}}
+	for i := range measurements {
+		forward.Measurements[measurements[i].key] = measurements[i].m
+		j := len(measurements) - 1 - i
+		backward.Measurements[measurements[j].key] = measurements[j].m
+	}
+
+	a, err := json.Marshal(forward)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(backward)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Errorf("marshals differ:\n%s\n%s", a, b)
+	}
+
+	want := `{"measurements":{"zeta":{"id":1,"name":"Zeta","type":"float","unit":"ppm"},` +
+		`"mid":{"id":2,"name":"Mid","type":"bool","unit":""},` +
+		`"alpha":{"id":3,"name":"Alpha","type":"int","unit":""}}}`
+	if string(a) != want {
+		t.Errorf("json.Marshal() =\n%s\nwant\n%s", a, want)
+	}
+
+	// The ordered form must still decode into the backend's map shape
+	var decoded SchemaRequest
+	if err := json.Unmarshal(a, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if len(decoded.Measurements) != 3 || decoded.Measurements["alpha"].ID != 3 {
+		t.Errorf("decoded = %+v", decoded)
+	}
+}
+
+// testExtHeader is an optional-sensor header whose IDs follow on from testHeader.
+const testExtHeader = `namespace sensor {
+
+enum class MeasurementId : uint8_t {
+  Co2 = 20,
+  Voc,
+  Count
+};
+
+MEASUREMENT_TRAIT(Co2, float, "co2", "ppm");
+MEASUREMENT_TRAIT(Voc, float, "voc", "ppm");
+
+} // namespace sensor
+`
+
+func writeHeaders(t *testing.T, headers ...string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	var paths []string
+	for i, header := range headers {
+		path := filepath.Join(dir, fmt.Sprintf("measurement_%d.hpp", i))
+		if err := os.WriteFile(path, []byte(header), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestGenerateSchema_MergesHeaders(t *testing.T) {
+	paths := writeHeaders(t, testHeader, testExtHeader)
+
+	schema, err := generateSchema(paths, parseOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("generateSchema() error = %v", err)
+	}
+
+	want := map[string]uint32{"timestamp": 1, "temperature": 2, "humidity": 3, "pressure": 4, "co2": 20, "voc": 21}
+	if len(schema.Measurements) != len(want) {
+		t.Errorf("got %d measurements, want %d: %v", len(schema.Measurements), len(want), sortedKeys(schema))
+	}
+	for key, id := range want {
+		if m, ok := schema.Measurements[key]; !ok || m.ID != id {
+			t.Errorf("measurement %q = %+v (present %t), want ID %d", key, m, ok, id)
+		}
+	}
+}
+
+func TestGenerateSchema_MergeCollisions(t *testing.T) {
+	tests := []struct {
+		name    string
+		ext     string
+		wantErr string
+	}{
+		{
+			name:    "ID collision",
+			ext:     strings.Replace(testExtHeader, "Co2 = 20", "Co2 = 3", 1),
+			wantErr: "ID 3 is used by both Humidity",
+		},
+		{
+			name:    "key collision",
+			ext:     strings.Replace(testExtHeader, `"voc"`, `"humidity"`, 1),
+			wantErr: `measurement "humidity" is defined in both`,
+		},
+		{
+			name:    "enum entry collision",
+			ext:     strings.ReplaceAll(testExtHeader, "Voc", "Pressure"),
+			wantErr: "enum entry Pressure is defined in both",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths := writeHeaders(t, testHeader, tt.ext)
+			_, err := generateSchema(paths, parseOptions{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("generateSchema() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGenerateSchema_MissingHeader(t *testing.T) {
+	_, err := generateSchema([]string{filepath.Join(t.TempDir(), "missing.hpp")}, parseOptions{})
+	if err == nil || !strings.Contains(err.Error(), "missing.hpp") {
+		t.Errorf("generateSchema() error = %v, want error naming the file", err)
+	}
+}
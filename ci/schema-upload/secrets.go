@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+)
+
+// SecretSource retrieves the backend API key named by -secret.
+type SecretSource interface {
+	// Secret returns the value of the secret called name.
+	Secret(ctx context.Context, name string) (string, error)
+	// String names the source in messages, e.g. "Secret Manager".
+	String() string
+}
+
+// newSecretSource returns the source selected by -secret-source.
+func newSecretSource(kind, projectID string) (SecretSource, error) {
+	switch kind {
+	case "gcp":
+		if projectID == "" {
+			return nil, fmt.Errorf("-project is required to reach the backend")
+		}
+		return gcpSecretSource{projectID: projectID}, nil
+	case "env":
+		return envSecretSource{getenv: os.Getenv}, nil
+	default:
+		return nil, fmt.Errorf("invalid -secret-source %q: want gcp or env", kind)
+	}
+}
+
+// gcpSecretSource reads the latest version of a secret from GCP Secret
+// Manager using Application Default Credentials.
+type gcpSecretSource struct {
+	projectID string
+}
+
+func (s gcpSecretSource) String() string { return "Secret Manager" }
+
+func (s gcpSecretSource) Secret(ctx context.Context, name string) (string, error) {
+	client, err := secretmanager.NewClient(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to create Secret Manager client: %w", err)
+	}
+	defer client.Close()
+
+	req := &secretmanagerpb.AccessSecretVersionRequest{
+		Name: fmt.Sprintf("projects/%s/secrets/%s/versions/latest", s.projectID, name),
+	}
+
+	result, err := client.AccessSecretVersion(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("failed to access secret %s: %w", name, err)
+	}
+
+	return string(result.Payload.Data), nil
+}
+
+// envSecretSource reads a secret from the environment variable named after
+// it: upper case with dashes as underscores, so github-actions-api-key is
+// read from GITHUB_ACTIONS_API_KEY.
+type envSecretSource struct {
+	getenv func(string) string
+}
+
+func (s envSecretSource) String() string { return "the environment" }
+
+func (s envSecretSource) Secret(ctx context.Context, name string) (string, error) {
+	key := envVarName(name)
+	value := strings.TrimSpace(s.getenv(key))
+	if value == "" {
+		return "", fmt.Errorf("$%s is not set", key)
+	}
+	return value, nil
+}
+
+func envVarName(secretName string) string {
+	return strings.ToUpper(strings.ReplaceAll(secretName, "-", "_"))
+}
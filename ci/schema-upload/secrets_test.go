@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSecretSource returns a fixed value, recording the name it was asked for.
+type fakeSecretSource struct {
+	value string
+	err   error
+	asked string
+}
+
+func (f *fakeSecretSource) String() string { return "fake" }
+
+func (f *fakeSecretSource) Secret(ctx context.Context, name string) (string, error) {
+	f.asked = name
+	return f.value, f.err
+}
+
+func TestConnect_SecretSource(t *testing.T) {
+	source := &fakeSecretSource{value: "test-key"}
+	client, apiKey, err := connect(context.Background(), source, "github-actions-api-key", time.Second, "", nil)
+	if err != nil {
+		t.Fatalf("connect() error = %v", err)
+	}
+	if client == nil || apiKey != "test-key" {
+		t.Errorf("connect() = %v, %q, want client and test-key", client, apiKey)
+	}
+	if source.asked != "github-actions-api-key" {
+		t.Errorf("Secret() asked for %q, want github-actions-api-key", source.asked)
+	}
+}
+
+func TestConnect_SecretSourceError(t *testing.T) {
+	source := &fakeSecretSource{err: errors.New("permission denied")}
+	_, _, err := connect(context.Background(), source, "github-actions-api-key", time.Second, "", nil)
+	if err == nil || !strings.Contains(err.Error(), "from fake: permission denied") {
+		t.Fatalf("connect() error = %v, want source error", err)
+	}
+	if code := exitCode(err); code != exitAuth {
+		t.Errorf("exitCode() = %d, want %d", code, exitAuth)
+	}
+}
+
+func TestEnvSecretSource(t *testing.T) {
+	env := map[string]string{"GITHUB_ACTIONS_API_KEY": " env-key\n"}
+	source := envSecretSource{getenv: func(key string) string { return env[key] }}
+
+	got, err := source.Secret(context.Background(), "github-actions-api-key")
+	if err != nil {
+		t.Fatalf("Secret() error = %v", err)
+	}
+	if got != "env-key" {
+		t.Errorf("Secret() = %q, want env-key", got)
+	}
+
+	if _, err := source.Secret(context.Background(), "admin-api-key"); err == nil || !strings.Contains(err.Error(), "$ADMIN_API_KEY is not set") {
+		t.Errorf("Secret(unset) error = %v, want ADMIN_API_KEY not set", err)
+	}
+}
+
+func TestNewSecretSource(t *testing.T) {
+	tests := []struct {
+		kind      string
+		projectID string
+		want      string
+		wantErr   string
+	}{
+		{kind: "gcp", projectID: "probe-staging", want: "Secret Manager"},
+		{kind: "gcp", wantErr: "-project is required"},
+		{kind: "env", want: "the environment"},
+		{kind: "vault", wantErr: `invalid -secret-source "vault"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind+"/"+tt.projectID, func(t *testing.T) {
+			source, err := newSecretSource(tt.kind, tt.projectID)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("newSecretSource(%q, %q) error = %v, want %q", tt.kind, tt.projectID, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newSecretSource(%q, %q) error = %v", tt.kind, tt.projectID, err)
+			}
+			if source.String() != tt.want {
+				t.Errorf("source = %q, want %q", source.String(), tt.want)
+			}
+		})
+	}
+}
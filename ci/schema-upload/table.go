@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// sortedKeys returns the schema's measurement keys ordered by ID.
+func sortedKeys(schema SchemaRequest) []string {
+	keys := make([]string, 0, len(schema.Measurements))
+	for key := range schema.Measurements {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		mi, mj := schema.Measurements[keys[i]], schema.Measurements[keys[j]]
+		if mi.ID != mj.ID {
+			return mi.ID < mj.ID
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// formatMeasurementTable renders the measurements as an aligned table sorted by ID.
+func formatMeasurementTable(schema SchemaRequest) string {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "ID\tKEY\tNAME\tTYPE\tUNIT")
+	for _, key := range sortedKeys(schema) {
+		m := schema.Measurements[key]
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", m.ID, key, m.Name, m.Type, m.Unit)
+	}
+	tw.Flush()
+
+	return b.String()
+}
+
+// limitSchema keeps the first limit measurements by ID for previews and
+// returns how many were dropped. A limit of zero or less keeps them all.
+func limitSchema(schema SchemaRequest, limit int) (SchemaRequest, int) {
+	if limit <= 0 || len(schema.Measurements) <= limit {
+		return schema, 0
+	}
+	kept := make(map[string]MeasurementSchema, limit)
+	for _, key := range sortedKeys(schema)[:limit] {
+		kept[key] = schema.Measurements[key]
+	}
+	return SchemaRequest{Measurements: kept}, len(schema.Measurements) - limit
+}
+
+// formatOmitted notes how many measurements -limit left out of a preview.
+func formatOmitted(omitted int) string {
+	if omitted == 0 {
+		return ""
+	}
+	return fmt.Sprintf("... %d more measurement(s) omitted by -limit\n", omitted)
+}
+
+// formatLimitedTable renders at most limit measurements as a table, followed
+// by a note of how many were omitted.
+func formatLimitedTable(schema SchemaRequest, limit int) string {
+	shown, omitted := limitSchema(schema, limit)
+	return formatMeasurementTable(shown) + formatOmitted(omitted)
+}
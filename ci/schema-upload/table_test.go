@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatMeasurementTable(t *testing.T) {
+	schema := SchemaRequest{Measurements: map[string]MeasurementSchema{
+		"iaq_accuracy": {ID: 6, Name: "IAQ Accuracy", Type: "int", Unit: "/3"},
+		"temperature":  {ID: 2, Name: "Temperature", Type: "float", Unit: "celsius"},
+	}}
+
+	got := formatMeasurementTable(schema)
+	want := `ID  KEY           NAME          TYPE   UNIT
+2   temperature   Temperature   float  celsius
+6   iaq_accuracy  IAQ Accuracy  int    /3
+`
+	if got != want {
+		t.Errorf("formatMeasurementTable() =\n%s\nwant:\n%s", got, want)
+	}
+
+	// Every column starts at the same offset on each line
+	lines := strings.Split(strings.TrimSpace(got), "\n")
+	for _, col := range []string{"KEY", "NAME", "TYPE", "UNIT"} {
+		offset := strings.Index(lines[0], col)
+		for _, line := range lines[1:] {
+			if line[offset-2:offset] != "  " || line[offset] == ' ' {
+				t.Errorf("column %s misaligned in line %q", col, line)
+			}
+		}
+	}
+}
+
+func TestSortedKeys(t *testing.T) {
+	schema := SchemaRequest{Measurements: map[string]MeasurementSchema{
+		"c": {ID: 3},
+		"a": {ID: 1},
+		"b": {ID: 2},
+	}}
+
+	if got := strings.Join(sortedKeys(schema), ","); got != "a,b,c" {
+		t.Errorf("sortedKeys() = %s, want a,b,c", got)
+	}
+}
+
+func TestFormatLimitedTable(t *testing.T) {
+	schema := SchemaRequest{Measurements: map[string]MeasurementSchema{
+		"pressure":    {ID: 4, Name: "Pressure", Type: "float", Unit: "hPa"},
+		"timestamp":   {ID: 1, Name: "Timestamp", Type: "int", Unit: "ms"},
+		"humidity":    {ID: 3, Name: "Humidity", Type: "float", Unit: "percent"},
+		"temperature": {ID: 2, Name: "Temperature", Type: "float", Unit: "celsius"},
+	}}
+
+	got := formatLimitedTable(schema, 2)
+	want := `ID  KEY          NAME         TYPE   UNIT
+1   timestamp    Timestamp    int    ms
+2   temperature  Temperature  float  celsius
+... 2 more measurement(s) omitted by -limit
+`
+	if got != want {
+		t.Errorf("formatLimitedTable(2) =\n%s\nwant:\n%s", got, want)
+	}
+
+	for _, limit := range []int{0, 4, 10} {
+		if got := formatLimitedTable(schema, limit); got != formatMeasurementTable(schema) {
+			t.Errorf("formatLimitedTable(%d) =\n%s\nwant the full table", limit, got)
+		}
+	}
+	if len(schema.Measurements) != 4 {
+		t.Errorf("limitSchema() modified the schema: %d measurements left", len(schema.Measurements))
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSchema() SchemaRequest {
+	return SchemaRequest{Measurements: map[string]MeasurementSchema{
+		"temperature": {ID: 2, Name: "Temperature", Type: "float", Unit: "celsius"},
+	}}
+}
+
+// testHTTPClient returns a client with the system trust store.
+func testHTTPClient(t *testing.T, timeout time.Duration) *http.Client {
+	t.Helper()
+	client, err := newHTTPClient(timeout, "")
+	if err != nil {
+		t.Fatalf("newHTTPClient() error = %v", err)
+	}
+	return client
+}
+
+func TestUploadSchema(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("unexpected method: %s", r.Method)
+		}
+		if r.Header.Get("Authorization") != "Bearer test-key" {
+			t.Errorf("unexpected auth header: %s", r.Header.Get("Authorization"))
+		}
+
+		var got SchemaRequest
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		if got.Measurements["temperature"].ID != 2 {
+			t.Errorf("unexpected body: %+v", got)
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(SchemaResponse{Message: "ok"})
+	}))
+	defer server.Close()
+
+	err := uploadSchema(context.Background(), testHTTPClient(t, time.Second), server.URL+"/admin/schemas/probe/1.0.0", "test-key", testSchema())
+	if err != nil {
+		t.Fatalf("uploadSchema() error = %v", err)
+	}
+}
+
+func TestUploadSchema_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	err := uploadSchema(context.Background(), testHTTPClient(t, 50*time.Millisecond), server.URL, "test-key", testSchema())
+	if err == nil {
+		t.Fatal("uploadSchema() expected timeout error")
+	}
+	if !strings.Contains(err.Error(), "deadline") {
+		t.Errorf("error %q does not mention the deadline", err)
+	}
+}
+
+func TestUploadSchema_Cancelled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	err := uploadSchema(ctx, testHTTPClient(t, time.Minute), server.URL, "test-key", testSchema())
+	if err == nil || !strings.Contains(err.Error(), "context canceled") {
+		t.Errorf("uploadSchema() error = %v, want context canceled", err)
+	}
+}
+
+// newBackend serves the schema endpoint and answers health checks on the
+// given paths with healthStatus. It records every request path.
+func newBackend(t *testing.T, healthStatus int, healthPaths ...string) (*httptest.Server, *[]string) {
+	t.Helper()
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		for _, p := range healthPaths {
+			if r.Method == http.MethodGet && r.URL.Path == p {
+				w.WriteHeader(healthStatus)
+				return
+			}
+		}
+		if r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/admin/schemas/") {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(server.Close)
+	return server, &paths
+}
+
+func TestPublishSchema_Preflight(t *testing.T) {
+	tests := []struct {
+		name         string
+		healthStatus int
+		healthPaths  []string
+		preflight    bool
+		wantErr      string
+		wantPaths    []string
+	}{
+		{
+			name:         "healthy",
+			healthStatus: http.StatusOK,
+			healthPaths:  []string{"/health"},
+			preflight:    true,
+			wantPaths:    []string{"/health", "/admin/schemas/probe/1.0.0"},
+		},
+		{
+			name:         "healthz fallback",
+			healthStatus: http.StatusOK,
+			healthPaths:  []string{"/healthz"},
+			preflight:    true,
+			wantPaths:    []string{"/health", "/healthz", "/admin/schemas/probe/1.0.0"},
+		},
+		{
+			name:         "unhealthy",
+			healthStatus: http.StatusServiceUnavailable,
+			healthPaths:  []string{"/health"},
+			preflight:    true,
+			wantErr:      "backend unreachable",
+			wantPaths:    []string{"/health"},
+		},
+		{
+			name:         "no health endpoint",
+			healthStatus: http.StatusOK,
+			preflight:    true,
+			wantPaths:    []string{"/health", "/healthz", "/admin/schemas/probe/1.0.0"},
+		},
+		{
+			name:         "health endpoint needs auth",
+			healthStatus: http.StatusUnauthorized,
+			healthPaths:  []string{"/health"},
+			preflight:    true,
+			wantPaths:    []string{"/health", "/admin/schemas/probe/1.0.0"},
+		},
+		{
+			name:         "preflight disabled",
+			healthStatus: http.StatusServiceUnavailable,
+			healthPaths:  []string{"/health"},
+			preflight:    false,
+			wantPaths:    []string{"/admin/schemas/probe/1.0.0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, paths := newBackend(t, tt.healthStatus, tt.healthPaths...)
+
+			err := publishSchema(context.Background(), testHTTPClient(t, time.Second), server.URL+"/",
+				server.URL+"/admin/schemas/probe/1.0.0", "test-key", testSchema(), tt.preflight)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("publishSchema() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("publishSchema() error = %v", err)
+			}
+
+			if strings.Join(*paths, " ") != strings.Join(tt.wantPaths, " ") {
+				t.Errorf("requests = %v, want %v", *paths, tt.wantPaths)
+			}
+		})
+	}
+}
+
+func TestCheckHealth_Unreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	_, err := checkHealth(context.Background(), testHTTPClient(t, time.Second), url)
+	if err == nil || !strings.Contains(err.Error(), "backend unreachable at "+url) {
+		t.Errorf("checkHealth() error = %v, want backend unreachable", err)
+	}
+}
+
+func TestFormatRequest(t *testing.T) {
+	url := "https://api.example.com/admin/schemas/probe/1.2.3"
+	req, body, err := newUploadRequest(context.Background(), url, "super-secret-key", testSchema())
+	if err != nil {
+		t.Fatalf("newUploadRequest() error = %v", err)
+	}
+
+	got := formatRequest(req, body)
+
+	if strings.Contains(got, "super-secret-key") {
+		t.Error("rendered request contains the API key")
+	}
+
+	want := `POST https://api.example.com/admin/schemas/probe/1.2.3
+Content-Type: application/json
+Authorization: Bearer <redacted>
+
+{"measurements":{"temperature":{"id":2,"name":"Temperature","type":"float","unit":"celsius"}}}`
+	if got != want {
+		t.Errorf("formatRequest() =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestNewHTTPClientCustomCA(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caPath, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("system roots reject self-signed", func(t *testing.T) {
+		err := uploadSchema(context.Background(), testHTTPClient(t, time.Second), server.URL, "test-key", testSchema())
+		if err == nil {
+			t.Fatal("uploadSchema() expected TLS verification error")
+		}
+	})
+
+	t.Run("custom CA trusted", func(t *testing.T) {
+		client, err := newHTTPClient(time.Second, caPath)
+		if err != nil {
+			t.Fatalf("newHTTPClient() error = %v", err)
+		}
+		if err := uploadSchema(context.Background(), client, server.URL, "test-key", testSchema()); err != nil {
+			t.Errorf("uploadSchema() error = %v", err)
+		}
+	})
+
+	t.Run("invalid bundle", func(t *testing.T) {
+		badPath := filepath.Join(t.TempDir(), "bad.pem")
+		if err := os.WriteFile(badPath, []byte("not a certificate"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newHTTPClient(time.Second, badPath); err == nil {
+			t.Error("newHTTPClient() expected error for bundle without certificates")
+		}
+	})
+}
+
+func TestFetchSchema(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("invalid api key"))
+			return
+		}
+		if r.Method != http.MethodGet || r.URL.Path != "/admin/schemas/probe/1.0.0" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(SchemaRequest{Measurements: map[string]MeasurementSchema{
+			"humidity":    {ID: 3, Name: "Humidity", Type: "float", Unit: "percent"},
+			"temperature": {ID: 2, Name: "Temperature", Type: "float", Unit: "celsius"},
+		}})
+	}))
+	defer server.Close()
+
+	t.Run("populated schema", func(t *testing.T) {
+		schema, err := fetchSchema(context.Background(), testHTTPClient(t, time.Second), server.URL+"/admin/schemas/probe/1.0.0", "test-key")
+		if err != nil {
+			t.Fatalf("fetchSchema() error = %v", err)
+		}
+
+		want := "ID  KEY          NAME         TYPE   UNIT\n" +
+			"2   temperature  Temperature  float  celsius\n" +
+			"3   humidity     Humidity     float  percent\n"
+		if got := formatMeasurementTable(schema); got != want {
+			t.Errorf("formatMeasurementTable() =\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := fetchSchema(context.Background(), testHTTPClient(t, time.Second), server.URL+"/admin/schemas/probe/9.9.9", "test-key")
+		if err == nil || !strings.Contains(err.Error(), "no schema at") {
+			t.Errorf("fetchSchema() error = %v, want not found", err)
+		}
+	})
+
+	t.Run("auth failure", func(t *testing.T) {
+		_, err := fetchSchema(context.Background(), testHTTPClient(t, time.Second), server.URL+"/admin/schemas/probe/1.0.0", "wrong-key")
+		if err == nil || !strings.Contains(err.Error(), "authentication failed with status 401: invalid api key") {
+			t.Errorf("fetchSchema() error = %v, want auth failure", err)
+		}
+	})
+}
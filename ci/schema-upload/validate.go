@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// knownUnits are the normalized units the backend knows how to display.
+// An empty unit marks a dimensionless measurement.
+var knownUnits = map[string]bool{
+	"":        true,
+	"celsius": true,
+	"percent": true,
+	"hPa":     true,
+	"Pa":      true,
+	"ppm":     true,
+	"ppb":     true,
+	"ms":      true,
+	"s":       true,
+	"V":       true,
+	"mV":      true,
+	"Ohm":     true,
+	"lux":     true,
+	"dB":      true,
+	"/3":      true,
+}
+
+// validationResult is the outcome of validateHeader.
+type validationResult struct {
+	// Measurements is the number of traits that would be in the schema
+	Measurements int
+	// Issues lists every problem found, in header order
+	Issues []string
+}
+
+// validateHeader runs all consistency checks on the contents of
+// measurement.hpp; see validateHeaders.
+func validateHeader(data []byte, opts parseOptions) validationResult {
+	return validateHeaders([]headerSource{{Path: "measurement.hpp", Data: data}}, opts)
+}
+
+// validateHeaders runs all consistency checks on the headers generateSchema
+// would merge: unique enum entries and IDs, unique measurement keys, a trait
+// for every enum entry and an enum entry for every trait, IDs within
+// opts.MaxID, known types and known units. Unlike parseMeasurementHeaders it
+// reports every problem instead of stopping at the first one. The headers are
+// then parsed with opts exactly as for an upload, so a clean result means
+// the schema can be generated.
+func validateHeaders(headers []headerSource, opts parseOptions) validationResult {
+	opts = opts.withDefaults()
+
+	var result validationResult
+	issuef := func(format string, args ...any) {
+		result.Issues = append(result.Issues, fmt.Sprintf(format, args...))
+	}
+
+	enumValues := make(map[string]uint32)
+	namesByValue := make(map[uint32][]string)
+	enumTypes := make(map[string]bool)
+	var lines []string
+	for _, h := range headers {
+		fileLines := headerLines(h.Data, opts)
+		for _, entry := range parseEnumEntries(fileLines, opts.Logger) {
+			if _, dup := enumValues[entry.Name]; dup {
+				issuef("duplicate enum entry %s", entry.Name)
+				continue
+			}
+			enumValues[entry.Name] = entry.Value
+			namesByValue[entry.Value] = append(namesByValue[entry.Value], entry.Name)
+		}
+		for name := range enumTypeNames(h.Data) {
+			enumTypes[name] = true
+		}
+		lines = append(lines, fileLines...)
+	}
+
+	values := make([]uint32, 0, len(namesByValue))
+	for value := range namesByValue {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	for _, value := range values {
+		if names := namesByValue[value]; len(names) > 1 {
+			issuef("duplicate measurement ID %d: %s", value, strings.Join(names, ", "))
+		}
+	}
+
+	traits := make(map[string]bool)
+	keys := make(map[string]string)
+	for _, line := range lines {
+		t, ok := parseTraitLine(line)
+		if !ok || t.ID == countEnumName {
+			continue
+		}
+
+		if traits[t.ID] {
+			issuef("duplicate MEASUREMENT_TRAIT for %s", t.ID)
+			continue
+		}
+		traits[t.ID] = true
+
+		id, ok := enumValues[t.ID]
+		if !ok {
+			issuef("MEASUREMENT_TRAIT(%s) has no MeasurementId entry", t.ID)
+			continue
+		}
+		if id > opts.MaxID {
+			issuef("measurement %s has ID %d, above the maximum of %d", t.ID, id, opts.MaxID)
+		}
+		if other, dup := keys[t.Name]; dup {
+			issuef("duplicate measurement key %q (%s and %s)", t.Name, other, t.ID)
+			continue
+		}
+		keys[t.Name] = t.ID
+		result.Measurements++
+
+		if _, known := mapType(t.Type, opts.TypeOverrides); !known && !enumTypes[t.Type] {
+			issuef("unknown type %q for %s", t.Type, t.ID)
+		}
+		if !knownUnits[normalizeUnit(t.Unit)] {
+			issuef("unknown unit %q for %s", t.Unit, t.ID)
+		}
+	}
+
+	if missing := missingTraits(enumValues, traits); len(missing) > 0 {
+		issuef("enum entries without MEASUREMENT_TRAIT: %s", strings.Join(missing, ", "))
+	}
+	if result.Measurements == 0 {
+		issuef("no measurements found")
+	}
+
+	// Options such as -strict-types can reject what the checks above allow
+	if len(result.Issues) == 0 {
+		if _, err := parseMeasurementHeaders(headers, opts); err != nil {
+			issuef("%v", err)
+		}
+	}
+
+	return result
+}
+
+// Report renders the result as a concise pass/fail summary for path.
+func (r validationResult) Report(path string) string {
+	if len(r.Issues) == 0 {
+		return fmt.Sprintf("✓ %s: %d measurements, all checks passed\n", path, r.Measurements)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "✗ %s: %d problem(s)\n", path, len(r.Issues))
+	for _, issue := range r.Issues {
+		fmt.Fprintf(&b, "  - %s\n", issue)
+	}
+	return b.String()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func quietOptions() parseOptions {
+	return parseOptions{Logger: newLogger(io.Discard, false)}
+}
+
+func TestValidateHeader_Clean(t *testing.T) {
+	result := validateHeader([]byte(testHeader), quietOptions())
+	if len(result.Issues) != 0 {
+		t.Fatalf("validateHeader() issues = %v, want none", result.Issues)
+	}
+	if result.Measurements != 4 {
+		t.Errorf("Measurements = %d, want 4", result.Measurements)
+	}
+
+	report := result.Report("measurement.hpp")
+	if report != "✓ measurement.hpp: 4 measurements, all checks passed\n" {
+		t.Errorf("Report() = %q", report)
+	}
+}
+
+func TestValidateHeader_RepoHeader(t *testing.T) {
+	data, path, err := readMeasurementHeader(newLogger(io.Discard, false))
+	if err != nil {
+		t.Skipf("measurement.hpp not found: %v", err)
+	}
+	if result := validateHeader(data, quietOptions()); len(result.Issues) != 0 {
+		t.Errorf("%s", result.Report(path))
+	}
+}
+
+func TestValidateHeader_Failures(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{
+			name:   "missing trait",
+			header: withoutLine(testHeader, "MEASUREMENT_TRAIT(Humidity"),
+			want:   "enum entries without MEASUREMENT_TRAIT: Humidity",
+		},
+		{
+			name:   "trait without enum entry",
+			header: strings.Replace(testHeader, "} // namespace", "MEASUREMENT_TRAIT(Lux, float, \"lux\", \"lux\");\n} // namespace", 1),
+			want:   "MEASUREMENT_TRAIT(Lux) has no MeasurementId entry",
+		},
+		{
+			name:   "duplicate ID",
+			header: strings.Replace(testHeader, "  Pressure,", "  Pressure = 2,", 1),
+			want:   "duplicate measurement ID 2: Temperature, Pressure",
+		},
+		{
+			name:   "duplicate enum entry",
+			header: strings.Replace(testHeader, "  Pressure,", "  Pressure,\n  Humidity,", 1),
+			want:   "duplicate enum entry Humidity",
+		},
+		{
+			name:   "duplicate key",
+			header: strings.Replace(testHeader, `"pressure"`, `"humidity"`, 1),
+			want:   `duplicate measurement key "humidity" (Humidity and Pressure)`,
+		},
+		{
+			name:   "duplicate trait",
+			header: strings.Replace(testHeader, "} // namespace", "MEASUREMENT_TRAIT(Pressure, float, \"pressure\", \"hPa\");\n} // namespace", 1),
+			want:   "duplicate MEASUREMENT_TRAIT for Pressure",
+		},
+		{
+			name:   "ID out of range",
+			header: strings.Replace(testHeader, "  Pressure,", "  Pressure = 0x100,", 1),
+			want:   "measurement Pressure has ID 256, above the maximum of 255",
+		},
+		{
+			name:   "unknown type",
+			header: strings.Replace(testHeader, "MEASUREMENT_TRAIT(Pressure, float", "MEASUREMENT_TRAIT(Pressure, Vec3", 1),
+			want:   `unknown type "Vec3" for Pressure`,
+		},
+		{
+			name:   "unknown unit",
+			header: strings.Replace(testHeader, `"hPa"`, `"furlongs"`, 1),
+			want:   `unknown unit "furlongs" for Pressure`,
+		},
+		{
+			name:   "no measurements",
+			header: "enum class MeasurementId : uint8_t {\n  Count\n};\n",
+			want:   "no measurements found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := validateHeader([]byte(tt.header), quietOptions())
+			found := false
+			for _, issue := range result.Issues {
+				if issue == tt.want {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("issues = %q, want %q", result.Issues, tt.want)
+			}
+
+			report := result.Report("measurement.hpp")
+			if !strings.HasPrefix(report, "✗ measurement.hpp:") || !strings.Contains(report, "  - "+tt.want+"\n") {
+				t.Errorf("Report() = %q", report)
+			}
+		})
+	}
+}
+
+func TestValidateHeaders_MergesHeaders(t *testing.T) {
+	opts := quietOptions()
+	headers, err := readHeaders(writeHeaders(t, testHeader, testExtHeader), opts.Logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := validateHeaders(headers, opts)
+	if len(result.Issues) != 0 {
+		t.Fatalf("validateHeaders() issues = %v, want none", result.Issues)
+	}
+	if result.Measurements != 6 {
+		t.Errorf("Measurements = %d, want 6", result.Measurements)
+	}
+	if paths := sourcePaths(headers); !strings.Contains(paths, "measurement_0.hpp, ") || !strings.HasSuffix(paths, "measurement_1.hpp") {
+		t.Errorf("sourcePaths() = %q, want both headers", paths)
+	}
+
+	clash := strings.Replace(testExtHeader, "Co2 = 20", "Co2 = 4", 1)
+	headers, err = readHeaders(writeHeaders(t, testHeader, clash), opts.Logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = validateHeaders(headers, opts)
+	if len(result.Issues) != 1 || result.Issues[0] != "duplicate measurement ID 4: Pressure, Co2" {
+		t.Errorf("validateHeaders() issues = %v, want the ID shared across headers", result.Issues)
+	}
+}
+
+func TestValidateHeaders_UsesOptions(t *testing.T) {
+	header := strings.Replace(testHeader, "  Pressure,", "  Pressure = 0x40,", 1)
+	headers := []headerSource{{Path: "measurement.hpp", Data: []byte(header)}}
+
+	if result := validateHeaders(headers, quietOptions()); len(result.Issues) != 0 {
+		t.Fatalf("validateHeaders() issues = %v, want none", result.Issues)
+	}
+
+	opts := quietOptions()
+	opts.MaxID = 0x3f
+	result := validateHeaders(headers, opts)
+	if len(result.Issues) != 1 || !strings.Contains(result.Issues[0], "Pressure has ID 64, above the maximum of 63") {
+		t.Errorf("validateHeaders(MaxID: 63) issues = %v", result.Issues)
+	}
+}
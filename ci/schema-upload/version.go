@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// defaultVersionFiles are searched in order for the firmware version when
+// neither -version nor -version-file is given. CMakeLists.txt is where the
+// firmware build sets PROJECT_VER.
+var defaultVersionFiles = []string{
+	"version.txt",
+	"../version.txt",
+	"../../version.txt",
+	"CMakeLists.txt",
+	"../CMakeLists.txt",
+	"../../CMakeLists.txt",
+}
+
+var (
+	// cmakeVersionRegex matches set(PROJECT_VER "1.2.3") in CMakeLists.txt
+	cmakeVersionRegex = regexp.MustCompile(`(?m)^\s*set\s*\(\s*PROJECT_VER\s+"([^"]+)"`)
+	// defineVersionRegex matches a generated header line such as
+	// #define PROJECT_VER "1.2.3" or #define FIRMWARE_VERSION "1.2.3"
+	defineVersionRegex = regexp.MustCompile(`(?m)^\s*#define\s+\w*VER\w*\s+"([^"]+)"`)
+)
+
+// errNoVersion means a version file was read but holds no version.
+var errNoVersion = errors.New("no version found")
+
+// parseVersion extracts the firmware version from the contents of a version
+// file: PROJECT_VER from a CMakeLists.txt, a quoted VERSION #define from a
+// generated header, or otherwise the first non-empty, non-comment line of a
+// plain version.txt.
+func parseVersion(data []byte) (string, error) {
+	if m := cmakeVersionRegex.FindSubmatch(data); m != nil {
+		return string(m[1]), nil
+	}
+	if m := defineVersionRegex.FindSubmatch(data); m != nil {
+		return string(m[1]), nil
+	}
+
+	text := string(data)
+	if strings.Contains(text, "cmake_minimum_required") || strings.Contains(text, "#pragma once") {
+		return "", errNoVersion
+	}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.ContainsAny(line, " \t") {
+			return "", fmt.Errorf("%w: %q is not a version", errNoVersion, line)
+		}
+		return strings.TrimPrefix(line, "v"), nil
+	}
+	return "", errNoVersion
+}
+
+// readVersionFile returns the firmware version stored in path.
+func readVersionFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	version, err := parseVersion(data)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	return version, nil
+}
+
+// resolveVersion returns the version to upload and where it came from. An
+// explicit -version wins; otherwise it is read from versionFile or, if that is
+// empty, the first of defaultVersionFiles holding a version. source is empty
+// when the version came from the flag.
+func resolveVersion(flagVersion, versionFile string, log *logger) (version, source string, err error) {
+	if flagVersion != "" {
+		return flagVersion, "", nil
+	}
+
+	if versionFile != "" {
+		version, err := readVersionFile(versionFile)
+		if err != nil {
+			return "", "", fmt.Errorf("read -version-file: %w", err)
+		}
+		return version, versionFile, nil
+	}
+
+	for _, path := range defaultVersionFiles {
+		version, err := readVersionFile(path)
+		if err != nil {
+			log.Debugf("no version from %s: %v", path, err)
+			continue
+		}
+		return version, path, nil
+	}
+	return "", "", fmt.Errorf("%w (tried %v)", errNoVersion, defaultVersionFiles)
+}
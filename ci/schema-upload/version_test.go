@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeVersionFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{"version.txt", "1.4.2\n", "1.4.2", false},
+		{"leading v and comment", "# firmware version\nv2.0.0-rc1\n", "2.0.0-rc1", false},
+		{"CMakeLists.txt", "cmake_minimum_required(VERSION 3.16)\n\nset(PROJECT_VER \"0.1.0\")\nproject(measurement_probe)\n", "0.1.0", false},
+		{"generated header", "#pragma once\n\n#define FIRMWARE_VERSION \"3.1.0\"\n", "3.1.0", false},
+		{"CMakeLists.txt without PROJECT_VER", "cmake_minimum_required(VERSION 3.16)\nproject(x)\n", "", true},
+		{"header without version", "#pragma once\n#define OTHER 1\n", "", true},
+		{"prose", "see the release notes\n", "", true},
+		{"empty", "\n\n", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseVersion([]byte(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseVersion() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveVersion(t *testing.T) {
+	log := newLogger(io.Discard, false)
+	path := writeVersionFile(t, t.TempDir(), "version.txt", "1.4.2\n")
+
+	t.Run("reads version file", func(t *testing.T) {
+		version, source, err := resolveVersion("", path, log)
+		if err != nil {
+			t.Fatalf("resolveVersion() error = %v", err)
+		}
+		if version != "1.4.2" || source != path {
+			t.Errorf("resolveVersion() = (%q, %q), want (%q, %q)", version, source, "1.4.2", path)
+		}
+	})
+
+	t.Run("explicit flag wins", func(t *testing.T) {
+		version, source, err := resolveVersion("9.9.9", path, log)
+		if err != nil {
+			t.Fatalf("resolveVersion() error = %v", err)
+		}
+		if version != "9.9.9" || source != "" {
+			t.Errorf("resolveVersion() = (%q, %q), want (%q, \"\")", version, source, "9.9.9")
+		}
+	})
+
+	t.Run("missing version file", func(t *testing.T) {
+		_, _, err := resolveVersion("", filepath.Join(t.TempDir(), "missing.txt"), log)
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("resolveVersion() error = %v, want not exist", err)
+		}
+	})
+
+	t.Run("searches default files", func(t *testing.T) {
+		dir := t.TempDir()
+		writeVersionFile(t, dir, "CMakeLists.txt", "set(PROJECT_VER \"0.1.0\")\n")
+		t.Chdir(dir)
+
+		version, source, err := resolveVersion("", "", log)
+		if err != nil {
+			t.Fatalf("resolveVersion() error = %v", err)
+		}
+		if version != "0.1.0" || source != "CMakeLists.txt" {
+			t.Errorf("resolveVersion() = (%q, %q), want (%q, %q)", version, source, "0.1.0", "CMakeLists.txt")
+		}
+	})
+}
@@ -31,6 +31,9 @@ inline constexpr uint64_t SLEEP_INTERVAL_SEC = 30;
 /// BSEC operation mode (generated by setup tool)
 inline constexpr bool BSEC_DEEP_SLEEP_MODE = false;
 
+/// BSEC library enabled; false for BME68x-only builds (generated by setup tool)
+inline constexpr bool USE_BSEC = true;
+
 /// Minimum battery voltage (mV) before entering permanent sleep
 inline constexpr uint32_t BATTERY_MIN_MV = 2400;
 
@@ -0,0 +1,95 @@
+package provision
+
+import (
+	"fmt"
+	"strings"
+
+	"measurement-probe/tools/provision/internal/gcloud"
+)
+
+// Cloud is the subset of gcloud used to find the backend and its admin API
+// key. Allows fakes in tests.
+type Cloud interface {
+	EnsureComponents() error
+	EnsureAuthenticated() error
+	ActiveAccount() (string, error)
+	CurrentProject() (string, error)
+	EnsureProject(project string) error
+	SetProject(project string) error
+	ServiceURL(service, region string) (string, error)
+	AdminAPIKey(project, secret string) (string, error)
+}
+
+// NewGCloud returns the Cloud backed by the gcloud CLI.
+func NewGCloud() Cloud {
+	return gcloudCLI{}
+}
+
+type gcloudCLI struct{}
+
+func (gcloudCLI) EnsureComponents() error            { return gcloud.EnsureComponents() }
+func (gcloudCLI) EnsureAuthenticated() error         { return gcloud.EnsureAuthenticated() }
+func (gcloudCLI) ActiveAccount() (string, error)     { return gcloud.GetActiveAccount() }
+func (gcloudCLI) CurrentProject() (string, error)    { return gcloud.GetCurrentProject() }
+func (gcloudCLI) EnsureProject(project string) error { return gcloud.EnsureProject(project) }
+func (gcloudCLI) SetProject(project string) error    { return gcloud.SetProject(project) }
+func (gcloudCLI) AdminAPIKey(project, secret string) (string, error) {
+	return gcloud.GetAdminAPIKey(project, secret)
+}
+func (gcloudCLI) ServiceURL(service, region string) (string, error) {
+	return gcloud.GetServiceURL(service, region)
+}
+
+// authenticate ensures gcloud is installed and logged in, and returns the
+// active account.
+func authenticate(c Cloud) (string, error) {
+	if err := c.EnsureComponents(); err != nil {
+		return "", err
+	}
+	if err := c.EnsureAuthenticated(); err != nil {
+		return "", fmt.Errorf("authentication failed: %w", err)
+	}
+	account, _ := c.ActiveAccount()
+	return account, nil
+}
+
+// CheckAccountDomain verifies that account belongs to domain ("example.com"
+// or "@example.com"). An empty domain accepts any account.
+func CheckAccountDomain(account, domain string) error {
+	domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+	if domain == "" {
+		return nil
+	}
+
+	account = strings.ToLower(strings.TrimSpace(account))
+	if strings.HasSuffix(account, "@"+domain) {
+		return nil
+	}
+	if account == "" {
+		account = "(none)"
+	}
+	return fmt.Errorf("active gcloud account %s is not in @%s - run: gcloud auth login <you>@%s (or gcloud config set account)",
+		account, domain, domain)
+}
+
+// selectProject resolves the project from flagProject or the gcloud default and
+// verifies access. An explicitly given project becomes the gcloud default.
+func selectProject(c Cloud, flagProject string) (string, error) {
+	projectID := flagProject
+	if projectID == "" {
+		var err error
+		projectID, err = c.CurrentProject()
+		if err != nil {
+			return "", fmt.Errorf("no project specified and none configured: use --project flag")
+		}
+	}
+	if err := c.EnsureProject(projectID); err != nil {
+		return "", err
+	}
+	if flagProject != "" {
+		if err := c.SetProject(projectID); err != nil {
+			return "", err
+		}
+	}
+	return projectID, nil
+}
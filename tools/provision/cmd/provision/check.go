@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"measurement-probe/tools/provision"
+)
+
+// checkStatus is the outcome of a single -check diagnostic.
+type checkStatus int
+
+const (
+	checkPassed checkStatus = iota
+	checkFailed
+	checkSkipped
+)
+
+// checkResult is one line of the -check report.
+type checkResult struct {
+	Name   string
+	Status checkStatus
+	Detail string
+}
+
+func (r checkResult) String() string {
+	switch r.Status {
+	case checkPassed:
+		return fmt.Sprintf("  ✅ %s: %s", r.Name, r.Detail)
+	case checkFailed:
+		return fmt.Sprintf("  ❌ %s: %s", r.Name, r.Detail)
+	default:
+		return fmt.Sprintf("  ⏭️  %s: %s", r.Name, r.Detail)
+	}
+}
+
+// skippedError marks a check that could not run because a prerequisite failed.
+type skippedError struct {
+	reason string
+}
+
+func (e skippedError) Error() string { return "skipped: " + e.reason }
+
+// checkOptions carries the flags the -check diagnostics depend on.
+type checkOptions struct {
+	project    string
+	service    string
+	region     string
+	apiKey     string
+	apiKeyFile string
+	secretName string
+	domain     string
+	getenv     func(string) string
+}
+
+// runChecks verifies gcloud, authentication, project access, service URL
+// resolution and admin API key access without touching a device or changing
+// gcloud configuration. Once a gcloud check fails, the checks that depend on
+// it are skipped.
+func runChecks(c provision.Cloud, opts checkOptions) []checkResult {
+	var results []checkResult
+	var blockedBy string
+
+	check := func(name string, fn func() (string, error)) {
+		if blockedBy != "" {
+			results = append(results, checkResult{name, checkSkipped, blockedBy + " failed"})
+			return
+		}
+		detail, err := fn()
+		var skip skippedError
+		if errors.As(err, &skip) {
+			results = append(results, checkResult{name, checkSkipped, skip.reason})
+			return
+		}
+		if err != nil {
+			results = append(results, checkResult{name, checkFailed, err.Error()})
+			blockedBy = name
+			return
+		}
+		results = append(results, checkResult{name, checkPassed, detail})
+	}
+
+	projectID := opts.project
+
+	check("gcloud CLI", func() (string, error) {
+		return "installed", c.EnsureComponents()
+	})
+	check("Authentication", func() (string, error) {
+		account, err := c.ActiveAccount()
+		if err != nil {
+			return "", err
+		}
+		if account == "" {
+			return "", errors.New("no active account - run: gcloud auth login")
+		}
+		return account, provision.CheckAccountDomain(account, opts.domain)
+	})
+	check("Project access", func() (string, error) {
+		if projectID == "" {
+			var err error
+			if projectID, err = c.CurrentProject(); err != nil {
+				return "", errors.New("no project specified and none configured: use --project flag")
+			}
+		}
+		return projectID, c.EnsureProject(projectID)
+	})
+	check("Service URL", func() (string, error) {
+		return c.ServiceURL(opts.service, opts.region)
+	})
+
+	// A key given locally does not need gcloud, so it is checked regardless.
+	gcloudBlocked := blockedBy
+	blockedBy = ""
+	check("Admin API key", func() (string, error) {
+		_, source, err := resolveAPIKey(opts.apiKey, opts.apiKeyFile, opts.getenv, func() (string, error) {
+			if gcloudBlocked != "" {
+				return "", skippedError{gcloudBlocked + " failed"}
+			}
+			return c.AdminAPIKey(projectID, opts.secretName)
+		})
+		return source, err
+	})
+
+	return results
+}
+
+// printChecks writes the -check report and returns an error if any check did
+// not pass.
+func printChecks(w io.Writer, results []checkResult) error {
+	fmt.Fprintln(w, "→ Checking provisioning prerequisites...")
+	failed := 0
+	for _, r := range results {
+		fmt.Fprintln(w, r)
+		if r.Status != checkPassed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks did not pass", failed, len(results))
+	}
+	fmt.Fprintln(w, "\n✓ Ready to provision")
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fakeCloud is a provision.Cloud whose calls succeed unless an error is set.
+type fakeCloud struct {
+	componentsErr error
+	account       string
+	projectErr    error
+	serviceErr    error
+	keyErr        error
+	setProject    string
+}
+
+func (f *fakeCloud) EnsureComponents() error        { return f.componentsErr }
+func (f *fakeCloud) EnsureAuthenticated() error     { return nil }
+func (f *fakeCloud) ActiveAccount() (string, error) { return f.account, nil }
+func (f *fakeCloud) CurrentProject() (string, error) {
+	return "default-project", nil
+}
+func (f *fakeCloud) EnsureProject(project string) error { return f.projectErr }
+func (f *fakeCloud) SetProject(project string) error {
+	f.setProject = project
+	return nil
+}
+func (f *fakeCloud) ServiceURL(service, region string) (string, error) {
+	if f.serviceErr != nil {
+		return "", f.serviceErr
+	}
+	return "https://" + service + "-" + region + ".run.app", nil
+}
+func (f *fakeCloud) AdminAPIKey(project, secret string) (string, error) {
+	if f.keyErr != nil {
+		return "", f.keyErr
+	}
+	return "secret-key", nil
+}
+
+func healthyCloud() *fakeCloud {
+	return &fakeCloud{account: "dev@example.com"}
+}
+
+func checkStatuses(results []checkResult) []checkStatus {
+	statuses := make([]checkStatus, len(results))
+	for i, r := range results {
+		statuses[i] = r.Status
+	}
+	return statuses
+}
+
+func TestRunChecks(t *testing.T) {
+	const (
+		ok   = checkPassed
+		fail = checkFailed
+		skip = checkSkipped
+	)
+	noEnv := func(string) string { return "" }
+
+	tests := []struct {
+		name   string
+		modify func(*fakeCloud)
+		apiKey string
+		want   []checkStatus
+	}{
+		{"all pass", func(*fakeCloud) {}, "", []checkStatus{ok, ok, ok, ok, ok}},
+		{"gcloud missing", func(f *fakeCloud) { f.componentsErr = errors.New("gcloud CLI not found") }, "", []checkStatus{fail, skip, skip, skip, skip}},
+		{"not logged in", func(f *fakeCloud) { f.account = "" }, "", []checkStatus{ok, fail, skip, skip, skip}},
+		{"no project access", func(f *fakeCloud) { f.projectErr = errors.New("cannot access project") }, "", []checkStatus{ok, ok, fail, skip, skip}},
+		{"service missing", func(f *fakeCloud) { f.serviceErr = errors.New("service not found") }, "", []checkStatus{ok, ok, ok, fail, skip}},
+		{"no secret access", func(f *fakeCloud) { f.keyErr = errors.New("no permission") }, "", []checkStatus{ok, ok, ok, ok, fail}},
+		{"wrong account domain", func(f *fakeCloud) { f.account = "dev@gmail.com" }, "", []checkStatus{ok, fail, skip, skip, skip}},
+		{"local key without gcloud", func(f *fakeCloud) { f.componentsErr = errors.New("gcloud CLI not found") }, "flag-key", []checkStatus{fail, skip, skip, skip, ok}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := healthyCloud()
+			tt.modify(fake)
+
+			results := runChecks(fake, checkOptions{
+				service: "telemetry-api",
+				region:  "us-west1",
+				apiKey:  tt.apiKey,
+				domain:  "@example.com",
+				getenv:  noEnv,
+			})
+
+			got := checkStatuses(results)
+			if len(got) != len(tt.want) {
+				t.Fatalf("statuses = %v, want %v", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("%s: status = %v, want %v (%s)", results[i].Name, got[i], tt.want[i], results[i].Detail)
+				}
+			}
+			if fake.setProject != "" {
+				t.Errorf("runChecks changed the gcloud project to %q", fake.setProject)
+			}
+		})
+	}
+}
+
+func TestPrintChecks(t *testing.T) {
+	var buf bytes.Buffer
+	err := printChecks(&buf, []checkResult{
+		{"gcloud CLI", checkPassed, "installed"},
+		{"Authentication", checkFailed, "no active account"},
+		{"Project access", checkSkipped, "Authentication failed"},
+	})
+	if err == nil || err.Error() != "2 of 3 checks did not pass" {
+		t.Errorf("printChecks() error = %v", err)
+	}
+
+	for _, want := range []string{"✅ gcloud CLI: installed", "❌ Authentication: no active account", "⏭️  Project access: Authentication failed"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestPrintChecksAllPassed(t *testing.T) {
+	var buf bytes.Buffer
+	if err := printChecks(&buf, []checkResult{{"gcloud CLI", checkPassed, "installed"}}); err != nil {
+		t.Errorf("printChecks() error = %v", err)
+	}
+}
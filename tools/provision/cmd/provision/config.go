@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const configFileName = "config.json"
+
+// fileConfig holds flag defaults read from config.json in the data directory
+// (~/.measurement-probe by default).
+// Keys are flag names; flags given on the command line take precedence.
+type fileConfig map[string]string
+
+// configurableFlags are the flags that may be defaulted from the config file.
+var configurableFlags = []string{"project", "region", "service", "require-account-domain"}
+
+// loadConfig reads the config file at path. A missing file yields an empty
+// config.
+func loadConfig(path string) (fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fileConfig{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	var cfg fileConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	for key := range cfg {
+		if !isConfigurable(key) {
+			return nil, fmt.Errorf("config %s: unsupported key %q (supported: %v)", path, key, configurableFlags)
+		}
+	}
+	return cfg, nil
+}
+
+// applyConfigDefaults sets every configurable flag that was not given on the
+// command line to its value from the config file at path.
+func applyConfigDefaults(fs *flag.FlagSet, path string) error {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	for _, name := range configurableFlags {
+		value, ok := cfg[name]
+		if !ok || value == "" || set[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("config %s: %s: %w", path, name, err)
+		}
+	}
+	return nil
+}
+
+func isConfigurable(name string) bool {
+	for _, n := range configurableFlags {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
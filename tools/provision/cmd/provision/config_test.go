@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestFlagSet() (*flag.FlagSet, *string, *string, *string) {
+	fs := flag.NewFlagSet("provision", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	project := fs.String("project", "", "")
+	region := fs.String("region", defaultRegion, "")
+	service := fs.String("service", defaultService, "")
+	return fs, project, region, service
+}
+
+func writeConfig(t *testing.T, content string) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Dir(configPath()), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configPath(), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// configPath returns the config file location for the current HOME.
+func configPath() string {
+	return resolveDataPaths("", os.Getenv).Config
+}
+
+func TestApplyConfigDefaults(t *testing.T) {
+	writeConfig(t, `{"project": "probe-prod", "region": "europe-west1", "service": "telemetry"}`)
+
+	fs, project, region, service := newTestFlagSet()
+	if err := fs.Parse([]string{"-region", "us-east1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfigDefaults(fs, configPath()); err != nil {
+		t.Fatalf("applyConfigDefaults() error = %v", err)
+	}
+
+	if *project != "probe-prod" {
+		t.Errorf("project = %q, want value from config", *project)
+	}
+	if *region != "us-east1" {
+		t.Errorf("region = %q, want flag value to win", *region)
+	}
+	if *service != "telemetry" {
+		t.Errorf("service = %q, want value from config", *service)
+	}
+}
+
+func TestApplyConfigDefaultsNoFile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	fs, project, region, service := newTestFlagSet()
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfigDefaults(fs, configPath()); err != nil {
+		t.Fatalf("applyConfigDefaults() error = %v", err)
+	}
+
+	if *project != "" || *region != defaultRegion || *service != defaultService {
+		t.Errorf("flags = %q, %q, %q, want built-in defaults", *project, *region, *service)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"invalid JSON", `{"region":`, "parse config"},
+		{"unknown key", `{"port": "/dev/ttyUSB0"}`, `unsupported key "port"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), configFileName)
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadConfig(path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadConfig() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// logLevel orders messages by severity.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = map[string]logLevel{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+// parseLogLevel parses a -log-level value.
+func parseLogLevel(s string) (logLevel, error) {
+	level, ok := logLevelNames[strings.ToLower(strings.TrimSpace(s))]
+	if !ok {
+		return 0, fmt.Errorf("invalid -log-level %q (want debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// logger writes the tool's progress messages. Debug and info messages go to
+// out, warnings and errors to errOut; messages below level are dropped.
+// Messages are written as given, so info output keeps its step-by-step look.
+type logger struct {
+	out    io.Writer
+	errOut io.Writer
+	level  logLevel
+}
+
+func newLogger(out, errOut io.Writer, level logLevel) *logger {
+	return &logger{out: out, errOut: errOut, level: level}
+}
+
+// log is the tool's logger. run configures it from -log-level, -quiet and
+// -json (which moves info output to stderr so stdout carries only JSON).
+var log = newLogger(os.Stdout, os.Stderr, levelInfo)
+
+func (l *logger) Debugf(format string, args ...any) {
+	if l.level <= levelDebug {
+		fmt.Fprintf(l.out, "  [debug] "+format, args...)
+	}
+}
+
+func (l *logger) Info(args ...any) {
+	if l.level <= levelInfo {
+		fmt.Fprintln(l.out, args...)
+	}
+}
+
+func (l *logger) Infof(format string, args ...any) {
+	if l.level <= levelInfo {
+		fmt.Fprintf(l.out, format, args...)
+	}
+}
+
+func (l *logger) Warn(args ...any) {
+	if l.level <= levelWarn {
+		fmt.Fprintln(l.errOut, args...)
+	}
+}
+
+func (l *logger) Warnf(format string, args ...any) {
+	if l.level <= levelWarn {
+		fmt.Fprintf(l.errOut, format, args...)
+	}
+}
+
+// Errorf is never suppressed.
+func (l *logger) Errorf(format string, args ...any) {
+	fmt.Fprintf(l.errOut, format, args...)
+}
+
+// Writer returns where the output of invoked tools (esptool, idf.py) goes:
+// the info stream, or nowhere above info level.
+func (l *logger) Writer() io.Writer {
+	if l.level > levelInfo {
+		return io.Discard
+	}
+	return l.out
+}
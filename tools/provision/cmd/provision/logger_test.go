@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// captureLog routes all log output to the returned buffer for the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	old := log
+	log = newLogger(&buf, &buf, levelInfo)
+	t.Cleanup(func() { log = old })
+	return &buf
+}
+
+func TestLoggerLevels(t *testing.T) {
+	tests := []struct {
+		level logLevel
+		want  []string
+	}{
+		{levelDebug, []string{"debug line", "info line", "warn line", "error line"}},
+		{levelInfo, []string{"info line", "warn line", "error line"}},
+		{levelWarn, []string{"warn line", "error line"}},
+		{levelError, []string{"error line"}},
+	}
+
+	for _, tt := range tests {
+		var out, errOut bytes.Buffer
+		l := newLogger(&out, &errOut, tt.level)
+		l.Debugf("debug line\n")
+		l.Info("→ info line")
+		l.Warnf("⚠️  warn line\n")
+		l.Errorf("❌ error line\n")
+
+		all := out.String() + errOut.String()
+		for _, line := range []string{"debug line", "info line", "warn line", "error line"} {
+			wanted := false
+			for _, w := range tt.want {
+				wanted = wanted || w == line
+			}
+			if got := strings.Contains(all, line); got != wanted {
+				t.Errorf("level %d: %q shown = %t, want %t", tt.level, line, got, wanted)
+			}
+		}
+		if strings.Contains(out.String(), "error line") || strings.Contains(out.String(), "warn line") {
+			t.Errorf("level %d: warnings and errors went to stdout: %q", tt.level, out.String())
+		}
+	}
+}
+
+func TestLoggerQuiet(t *testing.T) {
+	var out, errOut bytes.Buffer
+	l := newLogger(&out, &errOut, levelWarn) // -quiet
+
+	l.Info("→ Reading device MAC address...")
+	l.Infof("  ✓ Device MAC: %s\n", "aa:bb:cc:dd:ee:ff")
+	l.Errorf("\n❌ Error: %v\n", "read MAC: timeout")
+
+	if out.Len() != 0 {
+		t.Errorf("quiet logger printed info lines: %q", out.String())
+	}
+	if !strings.Contains(errOut.String(), "❌ Error: read MAC: timeout") {
+		t.Errorf("quiet logger suppressed the error: %q", errOut.String())
+	}
+	if _, err := l.Writer().Write([]byte("esptool chatter")); err != nil || out.Len() != 0 {
+		t.Errorf("tool output not discarded when quiet: %q", out.String())
+	}
+}
+
+func TestLoggerInfoKeepsFormatting(t *testing.T) {
+	var out bytes.Buffer
+	l := newLogger(&out, &out, levelInfo)
+	l.Info("\n→ Provisioning device with backend...")
+	l.Infof("  ✓ Port: %s\n", "/dev/ttyUSB0")
+
+	want := "\n→ Provisioning device with backend...\n  ✓ Port: /dev/ttyUSB0\n"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for name, want := range logLevelNames {
+		if got, err := parseLogLevel(strings.ToUpper(name)); err != nil || got != want {
+			t.Errorf("parseLogLevel(%q) = %d, %v", name, got, err)
+		}
+	}
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Error("parseLogLevel(verbose) expected error")
+	}
+}
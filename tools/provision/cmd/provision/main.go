@@ -1,31 +1,71 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"measurement-probe/tools/provision"
 	"measurement-probe/tools/provision/internal/api"
+	"measurement-probe/tools/provision/internal/audit"
+	"measurement-probe/tools/provision/internal/backup"
 	"measurement-probe/tools/provision/internal/endpoints"
 	"measurement-probe/tools/provision/internal/gcloud"
+	"measurement-probe/tools/provision/internal/idf"
 	"measurement-probe/tools/provision/internal/nvs"
 	"measurement-probe/tools/provision/internal/partition"
 	"measurement-probe/tools/provision/internal/serial"
 )
 
 const (
-	nvsPartitionName      = "nvs"
+	defaultNVSPartition   = "nvs"
+	nvsKeysSubType        = "nvs_keys"
 	defaultPartitionTable = "partitions.csv"
-	defaultService        = "telemetry-api"
-	defaultRegion         = "us-west1"
+	builtPartitionTable   = "build/partition_table/partition-table.bin"
+	defaultService        = provision.DefaultService
+	defaultRegion         = provision.DefaultRegion
+	auditLogFile          = "provision-log.ndjson"
+	verifyAuthTimeout     = 60 * time.Second
+	apiKeyEnv             = "ADMIN_API_KEY"
+	passphraseEnv         = "MEASUREMENT_PROBE_BACKUP_PASSPHRASE"
+	homeEnv               = "MEASUREMENT_PROBE_HOME"
 )
 
+// toolOptions is the local state and NVS layout run resolves from the flags
+// and hands to the helpers that flash, back up and log devices.
+type toolOptions struct {
+	Paths   dataPaths    // where the audit log and config live
+	Backups backup.Store // issued credentials
+	NVS     nvsOptions
+}
+
+// nvsOptions controls how credentials are written to the NVS partition.
+type nvsOptions struct {
+	IDFPath    string     // -idf-path; empty to search the usual locations
+	Partition  string     // partition the credentials are written to
+	Erase      bool       // wipe the whole partition before flashing
+	Encrypt    bool       // encrypt it, with the key in the nvs_keys partition
+	KeyFile    string     // existing nvs_keys binary; empty to generate a key
+	Namespaces nvsEntries // extra namespaces written alongside the credentials
+}
+
+// stdin is the one buffered reader on os.Stdin that every prompt reads from.
+// A reader per prompt would buffer past its own line and swallow answers
+// typed ahead or piped in for the next prompt.
+var stdin = bufio.NewReader(os.Stdin)
+
 func main() {
 	if err := run(); err != nil {
-		fmt.Fprintf(os.Stderr, "\n❌ Error: %v\n", err)
+		log.Errorf("\n❌ Error: %v\n", err)
 		os.Exit(1)
 	}
 }
@@ -39,162 +79,499 @@ func run() error {
 	macAddress := flag.String("mac", "", "Device MAC (skip auto-detection)")
 	dryRun := flag.Bool("dry-run", false, "Provision only, don't flash to device")
 	skipBuild := flag.Bool("skip-build", false, "Skip automatic rebuild")
+	assumeYes := flag.Bool("yes", false, "Register and flash without asking to confirm the port, MAC and backend")
+	noBuild := flag.Bool("no-build", false, "Never rebuild: fail if endpoints.hpp doesn't match the backend (for prebuilt CI firmware)")
+	jsonOutput := flag.Bool("json", false, "Print the result as a single JSON object on stdout")
+	fromBackup := flag.String("from-backup", "", "Re-flash NVS from the local backup for this device ID (no backend call)")
+	credsStdin := flag.Bool("creds-stdin", false, "Flash credentials read from stdin as JSON {\"device_id\", \"secret\"} (no backend call)")
+	idfPath := flag.String("idf-path", "", "ESP-IDF installation path (default $IDF_PATH or a standard install location)")
+	notifyURL := flag.String("notify-url", "", "POST {device_id, mac, timestamp} to this URL after a successful provision (best-effort, never the secret)")
+	verifyAuth := flag.Bool("verify-auth", false, "After flashing, watch the device log until it authenticates with the backend")
+	caCert := flag.String("ca-cert", "", "PEM CA bundle to trust for the backend, in addition to the system roots")
+	flashApp := flag.String("flash-app", "", "Also flash this application image to the app partition the device boots from (ota_0 in partitions.csv)")
+	nvsOnly := flag.String("nvs-only", "", "Write the NVS partition binary to this path instead of flashing it")
+	apiKeyFlag := flag.String("api-key", "", "Admin API key (default $"+apiKeyEnv+" or Secret Manager)")
+	apiKeyFile := flag.String("api-key-file", "", "Read the admin API key from this file")
+	secretName := flag.String("secret-name", gcloud.DefaultAdminAPIKeySecret, "Secret Manager secret holding the admin API key")
+	skipEndpoints := flag.Bool("skip-endpoints", false, "Don't validate or update endpoints.hpp and don't rebuild the firmware")
+	regenEndpoints := flag.Bool("regen-endpoints", false, "Rewrite endpoints.hpp from the current template even if BASE_URL matches, then rebuild")
+	endpointProfile := flag.String("endpoint-profile", "prod", "API paths to write to endpoints.hpp: prod or staging (use --regen-endpoints when switching an existing header)")
+	headerTimestamp := flag.Bool("header-timestamp", false, "Add a Generated: timestamp comment when rewriting endpoints.hpp")
+	baseURL := flag.String("base-url", "", "Backend URL to provision against (skips the Cloud Run lookup)")
+	accountDomain := flag.String("require-account-domain", "", "Fail early unless the active gcloud account is in this domain (e.g. @example.com)")
+	rotate := flag.String("rotate", "", "Issue a new secret for this device ID, update its backup and re-flash NVS (with --dry-run, don't flash)")
+	encryptBackups := flag.Bool("encrypt-backups", false, "Encrypt credential backups with the passphrase in $"+passphraseEnv)
+	listBackupsFlag := flag.Bool("list-backups", false, "List local credential backups (device IDs only) and exit")
+	backupDirFlag := flag.String("backup-dir", "", "Directory for credential backups, the audit log and config (default $"+homeEnv+" or ~/.measurement-probe)")
+	check := flag.Bool("check", false, "Check gcloud auth, project access, service URL and API key access, then exit")
+	encryptNVS := flag.Bool("encrypt-nvs", false, "Encrypt the NVS partition and flash its key to the nvs_keys partition")
+	screenOnly := flag.Bool("screen-only", false, "Read the MAC of every connected board (or --port) and report which are already provisioned, without provisioning")
+	macTimeout := flag.Duration("mac-timeout", serial.DefaultReadTimeout, "How long reading the device MAC may take")
+	macSourceFlag := flag.String("mac-source", string(provision.MACSourceEsptool), "How to read the device MAC: esptool, or serial to read it from the boot log when esptool can't reset the board")
+	showPartitionsFlag := flag.Bool("show-partitions", false, "Print the project's partition table (name, type, subtype, offset, size) and exit; JSON with --json")
+	readMAC := flag.Bool("read-mac", false, "Print the device MAC address and exit (no gcloud, backend or flashing)")
+	logLevelFlag := flag.String("log-level", "info", "Log level: debug, info, warn or error")
+	quiet := flag.Bool("quiet", false, "Only print warnings and errors (same as --log-level warn); combine with --json for the result")
+	eraseNVSFlag := flag.Bool("erase-nvs", false, "Erase the whole NVS partition before flashing credentials, dropping stale keys in other namespaces")
+	nvsKey := flag.String("nvs-key", "", "Existing nvs_keys partition binary to encrypt with (implies --encrypt-nvs; default: generate a key)")
+	var nvsEntryFlag nvsEntries
+	flag.Var(&nvsEntryFlag, "nvs-entry", "Extra NVS string to write alongside the credentials as namespace.key=value, e.g. wifi.ssid=factory-ap; repeatable")
+	nvsPartitionFlag := flag.String("nvs-partition", defaultNVSPartition, "Name of the NVS partition in the partition table to write credentials to")
 	flag.Parse()
 
-	fmt.Println("╔═══════════════════════════════════════════════════════════╗")
-	fmt.Println("║           Measurement Probe Provisioning Tool             ║")
-	fmt.Println("╚═══════════════════════════════════════════════════════════╝")
-	fmt.Println()
+	level, err := parseLogLevel(*logLevelFlag)
+	if err != nil {
+		return err
+	}
+	if *quiet && level < levelWarn {
+		level = levelWarn
+	}
+	log.level = level
+
+	local := resolveDataPaths(*backupDirFlag, os.Getenv)
+	if err := applyConfigDefaults(flag.CommandLine, local.Config); err != nil {
+		return err
+	}
 
-	// Step 1: Ensure gcloud authentication
-	fmt.Println("→ Checking gcloud authentication...")
-	if err := gcloud.EnsureAuthenticated(); err != nil {
-		return fmt.Errorf("authentication failed: %w", err)
+	tool := toolOptions{
+		Paths: local,
+		Backups: backup.Store{
+			Dir:        local.Backups,
+			Passphrase: os.Getenv(passphraseEnv),
+			Encrypt:    *encryptBackups,
+		},
+		NVS: nvsOptions{
+			IDFPath:    *idfPath,
+			Partition:  *nvsPartitionFlag,
+			Erase:      *eraseNVSFlag,
+			Encrypt:    *encryptNVS || *nvsKey != "",
+			KeyFile:    *nvsKey,
+			Namespaces: nvsEntryFlag,
+		},
+	}
+	if tool.Backups.Encrypt && tool.Backups.Passphrase == "" {
+		return fmt.Errorf("--encrypt-backups requires a passphrase in $%s", passphraseEnv)
+	}
+	if *nvsOnly != "" && *flashApp != "" {
+		return fmt.Errorf("--flash-app can't be combined with --nvs-only, which writes the NVS binary instead of flashing the device")
 	}
-	account, _ := gcloud.GetActiveAccount()
-	fmt.Printf("  ✓ Authenticated as: %s\n", account)
 
-	// Step 2: Ensure project access
-	fmt.Println("\n→ Checking GCP project access...")
-	projectID := *project
-	if projectID == "" {
-		var err error
-		projectID, err = gcloud.GetCurrentProject()
-		if err != nil {
-			return fmt.Errorf("no project specified and none configured: use --project flag")
-		}
+	if isTerminal(os.Stdin) {
+		gcloud.SetReauthPrompt(stdin, os.Stderr)
 	}
-	if err := gcloud.EnsureProject(projectID); err != nil {
+
+	macSource, err := provision.ParseMACSource(*macSourceFlag)
+	if err != nil {
 		return err
 	}
-	// Set project if it was provided explicitly
-	if *project != "" {
-		if err := gcloud.SetProject(projectID); err != nil {
-			return err
+	profile, err := endpoints.Profile(*endpointProfile)
+	if err != nil {
+		return err
+	}
+
+	if *readMAC {
+		// Only the MAC goes to stdout, so it can be piped into a spreadsheet
+		log.out = os.Stderr
+		return printMAC(os.Stdout, *port, detectPort, provision.NewMACReader(macSource, *macTimeout))
+	}
+
+	if *showPartitionsFlag {
+		log.out = os.Stderr
+		return showPartitions(os.Stdout, *jsonOutput)
+	}
+
+	if *jsonOutput {
+		log.out = os.Stderr
+	} else {
+		log.Info("╔═══════════════════════════════════════════════════════════╗")
+		log.Info("║           Measurement Probe Provisioning Tool             ║")
+		log.Info("╚═══════════════════════════════════════════════════════════╝")
+		log.Info()
+	}
+
+	if *listBackupsFlag {
+		return listBackups(log.out, tool.Backups)
+	}
+
+	if *credsStdin {
+		if *fromBackup != "" {
+			return fmt.Errorf("--creds-stdin and --from-backup are mutually exclusive")
 		}
+		return flashFromStdin(tool, stdin, *port, *macAddress, *jsonOutput)
+	}
+
+	if *fromBackup != "" {
+		return reflashFromBackup(tool, *fromBackup, *port, *macAddress, *jsonOutput)
 	}
-	fmt.Printf("  ✓ Project: %s\n", projectID)
 
-	// Step 3: Fetch Cloud Run service URL
-	fmt.Printf("\n→ Fetching Cloud Run service URL (%s in %s)...\n", *service, *region)
-	serviceURL, err := gcloud.GetServiceURL(*service, *region)
+	gc := provision.NewGCloud()
+
+	if *check {
+		return printChecks(log.out, runChecks(gc, checkOptions{
+			project:    *project,
+			service:    *service,
+			region:     *region,
+			apiKey:     *apiKeyFlag,
+			apiKeyFile: *apiKeyFile,
+			secretName: *secretName,
+			domain:     *accountDomain,
+			getenv:     os.Getenv,
+		}))
+	}
+
+	apiKey, keySource, err := localAPIKey(*apiKeyFlag, *apiKeyFile, os.Getenv)
 	if err != nil {
-		return fmt.Errorf("failed to get service URL: %w", err)
+		return fmt.Errorf("get admin API key: %w", err)
+	}
+	if apiKey != "" {
+		log.Debugf("admin API key from %s\n", keySource)
 	}
-	fmt.Printf("  ✓ Service URL: %s\n", serviceURL)
 
-	// Step 4: Validate/update endpoints.hpp
-	fmt.Println("\n→ Validating firmware configuration...")
+	opts := provision.Options{
+		Project:       *project,
+		Service:       *service,
+		Region:        *region,
+		BaseURL:       *baseURL,
+		APIKey:        apiKey,
+		SecretName:    *secretName,
+		AccountDomain: *accountDomain,
+		CACert:        *caCert,
+		Port:          *port,
+		MAC:           *macAddress,
+		MACTimeout:    *macTimeout,
+		MACSource:     macSource,
+		DryRun:        *dryRun,
+		Cloud:         gc,
+		Log:           log,
+	}
+
+	if *screenOnly || *rotate != "" {
+		conn, err := provision.Connect(context.Background(), opts)
+		if err != nil {
+			return err
+		}
+		client, err := api.NewClientWithCA(conn.BaseURL, conn.APIKey, *caCert)
+		if err != nil {
+			return err
+		}
+
+		if *screenOnly {
+			log.Info("\n→ Screening connected boards...")
+			results, err := screenPorts(*port, *macTimeout, client)
+			if err != nil {
+				return err
+			}
+			return printScreen(log.out, results)
+		}
+
+		log.Infof("\n→ Rotating secret for device %s...\n", *rotate)
+		flash := func(creds *nvs.Credentials) error {
+			serialPort := *port
+			if serialPort == "" {
+				if serialPort, err = detectPort(); err != nil {
+					return err
+				}
+			}
+			return writeNVS(tool.NVS, serialPort, "", creds)
+		}
+		resp, err := rotateSecret(client, *rotate, tool.Backups, *dryRun, flash)
+		logEvent(tool.Paths.AuditLog, *macAddress, *rotate, conn.BaseURL, err)
+		if err != nil {
+			return err
+		}
+		if *jsonOutput {
+			return writeJSONResult(os.Stdout, resp, *macAddress, conn.BaseURL)
+		}
+		log.Info("\n" + strings.Repeat("═", 60))
+		log.Info("✓ Secret rotated!")
+		printCredentials(resp, conn.BaseURL)
+		return nil
+	}
+
+	// Validate/update endpoints.hpp and rebuild if needed
 	cwd, _ := os.Getwd()
-	headerPath := endpoints.FindHeaderPath(cwd)
-	if headerPath == "" {
-		return fmt.Errorf("endpoints.hpp not found - are you in the project directory?")
+	headerOpts := []endpoints.Option{endpoints.WithEndpoints(profile)}
+	if *headerTimestamp {
+		headerOpts = append(headerOpts, endpoints.WithTimestamp(time.Now()))
+	}
+	if *notifyURL != "" {
+		if err := checkNotifyURL(*notifyURL); err != nil {
+			return err
+		}
 	}
+	if *noBuild && *regenEndpoints {
+		return fmt.Errorf("--regen-endpoints requires a rebuild and can't be combined with --no-build")
+	}
+	fw := firmwareOptions{SkipEndpoints: *skipEndpoints, SkipBuild: *skipBuild, NoBuild: *noBuild, Regenerate: *regenEndpoints}
+	opts.PrepareFirmware = func(serviceURL string) error {
+		return prepareFirmware(cwd, serviceURL, fw, runBuild, headerOpts...)
+	}
+	opts.DetectPort = detectPort
+	opts.Flasher = provision.FlasherFunc(func(ctx context.Context, serialPort string, issued provision.Credentials) error {
+		creds := &nvs.Credentials{DeviceID: issued.DeviceID, Secret: issued.Secret}
+		if *nvsOnly != "" {
+			return exportNVS(tool.NVS, *nvsOnly, creds)
+		}
+		return writeNVS(tool.NVS, serialPort, *flashApp, creds)
+	})
 
-	needsRebuild := false
-	if err := endpoints.ValidateOrUpdate(headerPath, serviceURL); err != nil {
-		fmt.Printf("  ⚠️  %v\n", err)
-		needsRebuild = true
-	} else {
-		fmt.Printf("  ✓ Firmware URL matches\n")
+	if *nvsOnly == "" {
+		opts.ConfirmFlash = func(res provision.Result) error {
+			return confirmFlash(res, *assumeYes, isTerminal(os.Stdin), stdin, os.Stderr)
+		}
 	}
 
-	// Step 5: Trigger rebuild if needed
-	if needsRebuild {
-		if *skipBuild {
-			fmt.Println("\n⚠️  Firmware needs rebuild but --skip-build specified")
-			fmt.Println("   Run 'idf.py build' manually before flashing")
-		} else {
-			fmt.Println("\n→ Rebuilding firmware...")
-			if err := runBuild(); err != nil {
-				return fmt.Errorf("build failed: %w", err)
+	res, err := provision.Provision(context.Background(), opts)
+	if res.MAC != "" {
+		logEvent(tool.Paths.AuditLog, res.MAC, res.DeviceID, res.BackendURL, err)
+	}
+	if err != nil {
+		return err
+	}
+	resp := provisionResponse(res)
+
+	if *notifyURL != "" {
+		notifyProvisioned(*notifyURL, res.DeviceID, res.MAC)
+	}
+
+	if *verifyAuth && !*dryRun && *nvsOnly == "" {
+		log.Infof("\n→ Waiting for device to authenticate (up to %s)...\n", verifyAuthTimeout)
+		if err := serial.VerifyAuth(res.Port, verifyAuthTimeout); err != nil {
+			// The device is registered and flashed: keep its credentials
+			log.Warn("\n⚠️  Device was provisioned but did not authenticate")
+			if reportErr := reportResult(tool.Backups, resp, res.MAC, res.BackendURL, *jsonOutput); reportErr != nil {
+				log.Warnf("⚠️  Could not print the result: %v\n", reportErr)
 			}
-			fmt.Println("  ✓ Build complete")
+			return fmt.Errorf("verify auth: %w", err)
 		}
+		log.Info("  ✓ Device authenticated with backend")
 	}
 
-	// Step 6: Get serial port
-	fmt.Println("\n→ Detecting device...")
-	serialPort := *port
-	if serialPort == "" && *macAddress == "" {
-		ports, err := serial.ListPorts()
+	if !*jsonOutput && !*dryRun && *nvsOnly == "" {
+		log.Info("\n" + strings.Repeat("═", 60))
+		log.Info("✓ Device provisioned successfully!")
+	}
+	return reportResult(tool.Backups, resp, res.MAC, res.BackendURL, *jsonOutput)
+}
+
+// provisionResponse converts the result of a provisioning run to the
+// backend's response shape used for output and backups.
+func provisionResponse(res provision.Result) *api.ProvisionResponse {
+	return &api.ProvisionResponse{
+		DeviceID:   res.DeviceID,
+		MACAddress: res.MAC,
+		Secret:     res.Secret,
+		CreatedAt:  res.CreatedAt,
+		Region:     res.Region,
+	}
+}
+
+// localAPIKey picks the admin API key from, in order: the -api-key flag, the
+// -api-key-file file and the ADMIN_API_KEY environment variable. It returns
+// an empty key when none is set, leaving Secret Manager as the source.
+func localAPIKey(flagKey, keyFile string, getenv func(string) string) (string, string, error) {
+	if key := strings.TrimSpace(flagKey); key != "" {
+		return key, "-api-key", nil
+	}
+
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
 		if err != nil {
-			return fmt.Errorf("list ports: %w", err)
+			return "", "", fmt.Errorf("read API key file: %w", err)
 		}
-		if len(ports) == 0 {
-			return fmt.Errorf("no serial ports found - is device connected?")
+		key := strings.TrimSpace(string(data))
+		if key == "" {
+			return "", "", fmt.Errorf("API key file %s is empty", keyFile)
 		}
-		if len(ports) > 1 {
-			fmt.Println("  Multiple ports found:")
-			for i, p := range ports {
-				fmt.Printf("    %d: %s\n", i+1, p)
-			}
-			return fmt.Errorf("specify port with --port flag")
+		return key, keyFile, nil
+	}
+
+	if key := strings.TrimSpace(getenv(apiKeyEnv)); key != "" {
+		return key, "$" + apiKeyEnv, nil
+	}
+	return "", "", nil
+}
+
+// resolveAPIKey picks the admin API key like localAPIKey, falling back to
+// Secret Manager via fetch. It returns the key and a description of its source.
+func resolveAPIKey(flagKey, keyFile string, getenv func(string) string, fetch func() (string, error)) (string, string, error) {
+	key, source, err := localAPIKey(flagKey, keyFile, getenv)
+	if err != nil || key != "" {
+		return key, source, err
+	}
+
+	key, err = fetch()
+	if err != nil {
+		return "", "", err
+	}
+	return key, "Secret Manager", nil
+}
+
+// rotateSecret issues a new secret for deviceID, records it in the backup in
+// store (keeping the old secret in the backup's history) and flashes it with
+// flash unless dryRun is set. The backup is checked before the backend is
+// asked, since rotating revokes the old secret, and written before flashing so
+// the new secret is never lost. If writing it still fails, the error carries
+// the new secret.
+func rotateSecret(client *api.Client, deviceID string, store backup.Store, dryRun bool, flash func(*nvs.Credentials) error) (*api.ProvisionResponse, error) {
+	if err := store.CheckRotate(deviceID); err != nil {
+		if errors.Is(err, backup.ErrNoPassphrase) {
+			return nil, fmt.Errorf("can't update the backup of %s - set $%s: %w", deviceID, passphraseEnv, err)
 		}
-		serialPort = ports[0]
+		return nil, fmt.Errorf("can't update the backup of %s: %w", deviceID, err)
 	}
-	if serialPort != "" {
-		fmt.Printf("  ✓ Port: %s\n", serialPort)
+
+	resp, err := client.RotateSecret(deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("rotate failed: %w", err)
+	}
+	log.Info("  ✓ New secret issued")
+
+	path, err := store.Rotate(resp.DeviceID, resp.Secret, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("secret rotated but the backup was not updated (%v) - the old secret no longer works, keep the new one for device %s: %s",
+			err, resp.DeviceID, resp.Secret)
+	}
+	log.Infof("  ✓ Backup updated: %s\n", path)
+
+	if dryRun {
+		log.Info("\n[Dry run] Skipping NVS flash")
+		return resp, nil
 	}
 
-	// Step 7: Read MAC address
-	mac := *macAddress
-	if mac == "" {
-		fmt.Println("\n→ Reading device MAC address...")
-		reader := serial.NewMACReader(serialPort)
+	if err := flash(&nvs.Credentials{DeviceID: resp.DeviceID, Secret: resp.Secret}); err != nil {
+		return nil, fmt.Errorf("secret rotated but flashing failed (re-flash with --from-backup %s): %w", resp.DeviceID, err)
+	}
+	return resp, nil
+}
+
+// reflashFromBackup writes previously issued credentials to a device without
+// contacting the backend, e.g. when replacing a board.
+func reflashFromBackup(tool toolOptions, deviceID, port, mac string, jsonOutput bool) error {
+	log.Infof("→ Loading backup for device %s...\n", deviceID)
+	saved, err := tool.Backups.Load(deviceID)
+	if err != nil {
+		return err
+	}
+	log.Info("  ✓ Backup loaded")
+
+	resp := &api.ProvisionResponse{
+		DeviceID:   saved.DeviceID,
+		MACAddress: mac,
+		Secret:     saved.Secret,
+		Region:     saved.Region,
+	}
+	if saved.CreatedAt != nil {
+		resp.CreatedAt = *saved.CreatedAt
+	}
+	return flashIssued(tool, resp, port, jsonOutput, "✓ Device re-flashed from backup!")
+}
+
+// flashIssued writes already-issued credentials to the device on port (or
+// the only connected one) and reports them, logging the outcome to the
+// audit log. done is printed above the credentials on success.
+func flashIssued(tool toolOptions, resp *api.ProvisionResponse, port string, jsonOutput bool, done string) error {
+	log.Info("\n→ Detecting device...")
+	serialPort := port
+	if serialPort == "" {
 		var err error
-		mac, err = reader.ReadMAC()
+		serialPort, err = detectPort()
 		if err != nil {
-			return fmt.Errorf("read MAC: %w", err)
+			return err
 		}
 	}
-	fmt.Printf("  ✓ Device MAC: %s\n", mac)
+	log.Infof("  ✓ Port: %s\n", serialPort)
 
-	// Step 8: Get admin API key and provision
-	fmt.Println("\n→ Provisioning device with backend...")
-	fmt.Println("  Fetching admin API key from Secret Manager...")
-	apiKey, err := gcloud.GetAdminAPIKey(projectID)
-	if err != nil {
-		return fmt.Errorf("get admin API key: %w", err)
+	creds := &nvs.Credentials{
+		DeviceID: resp.DeviceID,
+		Secret:   resp.Secret,
+	}
+	if err := writeNVS(tool.NVS, serialPort, "", creds); err != nil {
+		logEvent(tool.Paths.AuditLog, resp.MACAddress, resp.DeviceID, "", err)
+		return err
+	}
+	logEvent(tool.Paths.AuditLog, resp.MACAddress, resp.DeviceID, "", nil)
+
+	if jsonOutput {
+		return writeJSONResult(os.Stdout, resp, resp.MACAddress, "")
 	}
-	fmt.Println("  ✓ API key retrieved")
 
-	client := api.NewClient(serviceURL, apiKey)
-	resp, err := client.ProvisionDevice(mac)
+	log.Info("\n" + strings.Repeat("═", 60))
+	log.Info(done)
+	printCredentials(resp, "")
+	return nil
+}
+
+// listBackups prints the device ID and modification time of every backup in
+// store, newest first. Secrets are never printed; unreadable backups are
+// skipped with a warning.
+func listBackups(w io.Writer, store backup.Store) error {
+	entries, skipped, err := store.List()
 	if err != nil {
-		return fmt.Errorf("provision failed: %w", err)
+		return err
 	}
-	fmt.Printf("  ✓ Device ID: %s\n", resp.DeviceID)
 
-	if *dryRun {
-		fmt.Println("\n[Dry run] Skipping NVS flash")
-		printCredentials(resp, serviceURL)
+	fmt.Fprintf(w, "→ Credential backups in %s\n", store.Dir)
+	for _, err := range skipped {
+		fmt.Fprintf(w, "  ⚠️  Skipped: %v\n", err)
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "  No backups found")
 		return nil
 	}
 
-	// Step 9: Write to NVS
-	fmt.Println("\n→ Writing credentials to device NVS...")
-
-	// Get IDF_PATH
-	idfPath := os.Getenv("IDF_PATH")
-	if idfPath == "" {
-		return fmt.Errorf("IDF_PATH not set - source ESP-IDF environment")
+	fmt.Fprintf(w, "\n  %-38s %-20s\n", "DEVICE ID", "MODIFIED")
+	for _, e := range entries {
+		line := fmt.Sprintf("  %-38s %-20s", e.DeviceID, e.ModTime.Local().Format("2006-01-02 15:04:05"))
+		if e.Encrypted {
+			line += " (encrypted)"
+		}
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
 	}
+	fmt.Fprintf(w, "\n  %d backup(s)\n", len(entries))
+	return nil
+}
 
-	// Find partition table
-	partPath := findPartitionTable()
-	if partPath == "" {
-		return fmt.Errorf("partition table not found")
+// printMAC reads the MAC of the device on port (detected when empty) with
+// reader and writes it to w. It never talks to gcloud or the backend.
+func printMAC(w io.Writer, port string, detect func() (string, error), reader provision.MACReader) error {
+	if port == "" {
+		var err error
+		if port, err = detect(); err != nil {
+			return err
+		}
 	}
+	log.Infof("→ Reading MAC address on %s...\n", port)
 
-	partTable, err := partition.ParseFile(partPath)
+	mac, err := reader.ReadMAC(context.Background(), port)
 	if err != nil {
-		return fmt.Errorf("parse partition table: %w", err)
+		return fmt.Errorf("read MAC: %w", err)
 	}
+	fmt.Fprintln(w, mac)
+	return nil
+}
+
+// writeNVS generates the NVS partition image for creds as opts describes and
+// flashes it. If appImage is set, the application image is flashed afterwards.
+func writeNVS(opts nvsOptions, serialPort, appImage string, creds *nvs.Credentials) error {
+	log.Info("\n→ Writing credentials to device NVS...")
 
-	nvsPartition, err := partTable.FindByName(nvsPartitionName)
+	idfPath, table, nvsPartition, err := nvsTarget(opts)
 	if err != nil {
-		return fmt.Errorf("find NVS partition: %w", err)
+		return err
+	}
+
+	writer := nvs.NewWriter(idfPath, serialPort)
+	writer.SetOutput(log.Writer())
+	writer.SetEraseBeforeWrite(opts.Erase)
+	if opts.Erase {
+		log.Infof("  Erasing NVS partition %q (0x%x, %d bytes) first\n", nvsPartition.Name, nvsPartition.Offset, nvsPartition.Size)
+	}
+	if err := configureEncryption(writer, table, opts); err != nil {
+		return err
+	}
+	if err := configureNamespaces(writer, opts.Namespaces); err != nil {
+		return err
 	}
 
 	tmpDir, err := os.MkdirTemp("", "provision-*")
@@ -203,23 +580,181 @@ func run() error {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	creds := &nvs.Credentials{
-		DeviceID: resp.DeviceID,
-		Secret:   resp.Secret,
-	}
-
-	writer := nvs.NewWriter(idfPath, serialPort)
 	if err := writer.WriteCredentials(creds, tmpDir, nvsPartition.Offset, nvsPartition.Size); err != nil {
 		return fmt.Errorf("write NVS: %w", err)
 	}
 
-	fmt.Println("\n" + strings.Repeat("═", 60))
-	fmt.Println("✓ Device provisioned successfully!")
-	printCredentials(resp, serviceURL)
+	if appImage != "" {
+		log.Info("\n→ Flashing application image...")
+		if err := flashAppImage(writer, table, nvsPartition.Name, appImage); err != nil {
+			return err
+		}
+		log.Info("  ✓ Application flashed")
+	}
+	return nil
+}
+
+// flashAppImage writes the application image at appImage to the table's app
+// partition, refusing images that would not fit or a layout where the app
+// partition overlaps the NVS partition nvsName.
+func flashAppImage(writer *nvs.Writer, table *partition.Table, nvsName, appImage string) error {
+	info, err := os.Stat(appImage)
+	if err != nil {
+		return fmt.Errorf("app image: %w", err)
+	}
+
+	app, err := appFlashTarget(table, nvsName, int(info.Size()))
+	if err != nil {
+		return err
+	}
+
+	log.Infof("  Writing %s to %q at 0x%x\n", appImage, app.Name, app.Offset)
+	if err := writer.Flash(appImage, app.Offset); err != nil {
+		return fmt.Errorf("flash app: %w", err)
+	}
+	return nil
+}
+
+// appFlashTarget selects the partition for an application image of
+// imageSize bytes, which must not overlap the NVS partition nvsName.
+func appFlashTarget(table *partition.Table, nvsName string, imageSize int) (*partition.Entry, error) {
+	app, err := table.FindApp()
+	if err != nil {
+		return nil, err
+	}
+
+	if nvsPartition, err := table.FindByName(nvsName); err == nil && app.Overlaps(*nvsPartition) {
+		return nil, fmt.Errorf("app partition %q (0x%x) overlaps NVS partition %q (0x%x) - refusing to flash",
+			app.Name, app.Offset, nvsPartition.Name, nvsPartition.Offset)
+	}
+
+	if imageSize > app.Size {
+		return nil, fmt.Errorf("app image is %d bytes but partition %q holds %d", imageSize, app.Name, app.Size)
+	}
+
+	return app, nil
+}
+
+// exportNVS generates the NVS partition image for creds as opts describes at
+// outputPath for an external flasher, without touching the device.
+func exportNVS(opts nvsOptions, outputPath string, creds *nvs.Credentials) error {
+	log.Info("\n→ Generating NVS partition binary...")
+
+	idfPath, table, nvsPartition, err := nvsTarget(opts)
+	if err != nil {
+		return err
+	}
+
+	writer := nvs.NewWriter(idfPath, "")
+	writer.SetOutput(log.Writer())
+	if err := configureEncryption(writer, table, opts); err != nil {
+		return err
+	}
+	if err := configureNamespaces(writer, opts.Namespaces); err != nil {
+		return err
+	}
+	return generateNVSImage(writer, table, nvsPartition, creds, outputPath)
+}
+
+// configureEncryption enables encrypted NVS on writer when opts asks for it,
+// with the key flashed to the table's nvs_keys partition.
+func configureEncryption(writer *nvs.Writer, table *partition.Table, opts nvsOptions) error {
+	if !opts.Encrypt {
+		return nil
+	}
 
+	keys, err := table.FindBySubType(nvsKeysSubType)
+	if err != nil {
+		return fmt.Errorf("--encrypt-nvs needs an %s partition: %w", nvsKeysSubType, err)
+	}
+	if opts.KeyFile != "" {
+		if _, err := os.Stat(opts.KeyFile); err != nil {
+			return fmt.Errorf("NVS key: %w", err)
+		}
+	}
+
+	writer.SetEncryption(&nvs.Encryption{KeyFile: opts.KeyFile, KeysOffset: keys.Offset})
+	log.Infof("  Encrypting NVS (keys partition %q at 0x%x)\n", keys.Name, keys.Offset)
+	return nil
+}
+
+// generateNVSImage writes the NVS binary to outputPath and reports the flash
+// offset it belongs at.
+func generateNVSImage(writer *nvs.Writer, table *partition.Table, nvsPartition *partition.Entry, creds *nvs.Credentials, outputPath string) error {
+	tmpDir, err := os.MkdirTemp("", "provision-*")
+	if err != nil {
+		return fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := writer.GenerateImage(creds, tmpDir, outputPath, nvsPartition.Size); err != nil {
+		return fmt.Errorf("generate NVS: %w", err)
+	}
+
+	log.Infof("  ✓ Wrote %s\n", outputPath)
+	log.Infof("  Flash it to partition %q at offset 0x%x (size 0x%x)\n",
+		nvsPartition.Name, nvsPartition.Offset, nvsPartition.Size)
+
+	if keyPath := writer.KeyPath(outputPath); keyPath != "" {
+		if keys, err := table.FindBySubType(nvsKeysSubType); err == nil {
+			log.Infof("  Flash the NVS key %s to partition %q at offset 0x%x\n", keyPath, keys.Name, keys.Offset)
+		}
+	}
 	return nil
 }
 
+// nvsTarget resolves the ESP-IDF path, the project's partition table and the
+// NVS partition named in opts.
+func nvsTarget(opts nvsOptions) (string, *partition.Table, *partition.Entry, error) {
+	idfPath, err := idf.Find(opts.IDFPath)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	partPath := findPartitionTable()
+	if partPath == "" {
+		return "", nil, nil, fmt.Errorf("partition table not found")
+	}
+	log.Debugf("ESP-IDF: %s, partition table: %s\n", idfPath, partPath)
+
+	partTable, err := loadPartitionTable(partPath)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("parse partition table: %w", err)
+	}
+
+	nvsPartition, err := findNVSPartition(partTable, opts.Partition)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	return idfPath, partTable, nvsPartition, nil
+}
+
+// findNVSPartition looks up the NVS partition by name. When it is missing,
+// the error lists the table's data partitions to choose from.
+func findNVSPartition(table *partition.Table, name string) (*partition.Entry, error) {
+	entry, err := table.FindByName(name)
+	if err == nil {
+		if entry.Type != "data" {
+			return nil, fmt.Errorf("partition %q has type %s, not data - pick another with --nvs-partition", name, entry.Type)
+		}
+		return entry, nil
+	}
+
+	var names []string
+	for _, e := range table.FindAllByType("data") {
+		names = append(names, e.Name)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("NVS partition %q not found and the table has no data partitions", name)
+	}
+	return nil, fmt.Errorf("NVS partition %q not found - set --nvs-partition to one of the data partitions: %s",
+		name, strings.Join(names, ", "))
+}
+
+// findPartitionTable looks for partitions.csv in the working directory and
+// up to four parents, falling back to the table compiled into the build
+// directory when only build artifacts are present.
 func findPartitionTable() string {
 	if _, err := os.Stat(defaultPartitionTable); err == nil {
 		return defaultPartitionTable
@@ -227,9 +762,11 @@ func findPartitionTable() string {
 
 	dir, _ := os.Getwd()
 	for i := 0; i < 5; i++ {
-		candidate := filepath.Join(dir, defaultPartitionTable)
-		if _, err := os.Stat(candidate); err == nil {
-			return candidate
+		for _, name := range []string{defaultPartitionTable, builtPartitionTable} {
+			candidate := filepath.Join(dir, name)
+			if _, err := os.Stat(candidate); err == nil {
+				return candidate
+			}
 		}
 		parent := filepath.Dir(dir)
 		if parent == dir {
@@ -240,7 +777,138 @@ func findPartitionTable() string {
 	return ""
 }
 
+// loadPartitionTable parses the partition table at path, either the CSV or
+// the binary ESP-IDF compiles from it.
+func loadPartitionTable(path string) (*partition.Table, error) {
+	if filepath.Ext(path) == ".bin" {
+		return partition.ParseBinary(path)
+	}
+	return partition.ParseFile(path)
+}
+
+// firmwareOptions controls how prepareFirmware treats endpoints.hpp and the
+// firmware build.
+type firmwareOptions struct {
+	SkipEndpoints bool // leave endpoints.hpp and the build alone
+	SkipBuild     bool // never rebuild, even when endpoints.hpp changed
+	NoBuild       bool // fail instead of touching endpoints.hpp when it doesn't match
+	Regenerate    bool // rewrite endpoints.hpp even when BASE_URL matches
+}
+
+// prepareFirmware makes sure endpoints.hpp in the checkout containing dir
+// points at serviceURL, and rebuilds the firmware with build when it had to
+// be updated or was regenerated. With SkipEndpoints it does nothing, so
+// provisioning can run against already-built firmware outside a source
+// checkout. opts apply when the header is rewritten.
+func prepareFirmware(dir, serviceURL string, fw firmwareOptions, build func() error, opts ...endpoints.Option) error {
+	if fw.SkipEndpoints {
+		log.Info("\n→ Skipping firmware configuration (--skip-endpoints)")
+		return nil
+	}
+
+	log.Info("\n→ Validating firmware configuration...")
+	headerPath := endpoints.FindHeaderPath(dir)
+	if headerPath == "" {
+		return fmt.Errorf("endpoints.hpp not found - are you in the project directory? (use --skip-endpoints for prebuilt firmware)")
+	}
+
+	comparison, err := endpoints.CompareBaseURL(headerPath, serviceURL)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", headerPath, err)
+	}
+	if comparison.Stale() {
+		log.Warn("\n  ⚠️  endpoints.hpp points to a different backend than the one being provisioned:")
+		log.Warnf("       old: %s\n", comparison.Current)
+		log.Warnf("       new: %s\n", comparison.Expected)
+		log.Warn("     The old service may have been deleted - firmware built against it can't reach the backend.")
+	}
+	if fw.NoBuild && comparison.Current != comparison.Expected {
+		return fmt.Errorf("%s does not point at %s and --no-build forbids a rebuild - rebuild the firmware upstream",
+			headerPath, comparison.Expected)
+	}
+
+	if fw.Regenerate {
+		if err := endpoints.WriteHeader(headerPath, serviceURL, opts...); err != nil {
+			return fmt.Errorf("regenerate %s: %w", headerPath, err)
+		}
+		log.Warnf("  ⚠️  regenerated %s - rebuild required\n", headerPath)
+	} else if err := endpoints.ValidateOrUpdate(headerPath, serviceURL, opts...); err != nil {
+		log.Warnf("  ⚠️  %v\n", err)
+	} else {
+		log.Infof("  ✓ Firmware URL matches\n")
+		return nil
+	}
+
+	if !decideRebuild(fw.SkipBuild, isTerminal(os.Stdin), stdin, os.Stderr) {
+		if fw.SkipBuild {
+			log.Warn("\n⚠️  Firmware needs rebuild but --skip-build specified")
+		} else {
+			log.Warn("\n⚠️  Firmware needs rebuild but the rebuild was declined")
+		}
+		log.Warn("   Run 'idf.py build' manually before flashing")
+		return nil
+	}
+
+	log.Info("\n→ Rebuilding firmware...")
+	if err := build(); err != nil {
+		return fmt.Errorf("build failed: %w", err)
+	}
+	log.Info("  ✓ Build complete")
+	return nil
+}
+
+// decideRebuild reports whether to rebuild the firmware after endpoints.hpp
+// changed. skipBuild means never. Otherwise an interactive session is asked
+// on in and out, defaulting to yes, and a non-interactive one always
+// rebuilds.
+func decideRebuild(skipBuild, interactive bool, in *bufio.Reader, out io.Writer) bool {
+	if skipBuild {
+		return false
+	}
+	if !interactive {
+		return true
+	}
+
+	fmt.Fprint(out, "  Rebuild firmware now with idf.py build (can take several minutes)? [Y/n]: ")
+	answer, _ := in.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "n", "no":
+		return false
+	default:
+		return true
+	}
+}
+
+// confirmFlash shows the device about to be registered and flashed and, in an
+// interactive session without assumeYes, asks on in and out to go ahead,
+// defaulting to no. Several connected boards make it easy to read one MAC and
+// flash another. It runs before the backend issues a secret, so declining
+// leaves nothing to clean up.
+func confirmFlash(res provision.Result, assumeYes, interactive bool, in *bufio.Reader, out io.Writer) error {
+	if assumeYes || !interactive {
+		return nil
+	}
+
+	fmt.Fprintln(out, "\n  About to register and flash:")
+	fmt.Fprintf(out, "    Port:    %s\n", res.Port)
+	fmt.Fprintf(out, "    MAC:     %s\n", res.MAC)
+	fmt.Fprintf(out, "    Backend: %s\n", res.BackendURL)
+	fmt.Fprint(out, "  Register this device and flash its credentials? [y/N]: ")
+
+	answer, _ := in.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	default:
+		return fmt.Errorf("cancelled - device %s was not registered or flashed", res.MAC)
+	}
+}
+
 func runBuild() error {
+	if err := idf.RequireTool("idf.py"); err != nil {
+		return err
+	}
+
 	// Find project root (where CMakeLists.txt is)
 	dir, _ := os.Getwd()
 	for i := 0; i < 5; i++ {
@@ -256,39 +924,135 @@ func runBuild() error {
 
 	cmd := exec.Command("idf.py", "build")
 	cmd.Dir = dir
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = log.Writer()
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
 
+// reportResult prints the credentials (or the JSON result) and saves a backup
+// in store.
+func reportResult(store backup.Store, resp *api.ProvisionResponse, mac, baseURL string, jsonOutput bool) error {
+	if !jsonOutput {
+		printCredentials(resp, baseURL)
+	}
+	saveBackup(store, resp)
+	if jsonOutput {
+		return writeJSONResult(os.Stdout, resp, mac, baseURL)
+	}
+	return nil
+}
+
 func printCredentials(resp *api.ProvisionResponse, baseURL string) {
-	fmt.Println()
-	fmt.Println("╔══════════════════════════════════════════════════════════╗")
-	fmt.Println("║                  DEVICE CREDENTIALS                      ║")
-	fmt.Println("╠══════════════════════════════════════════════════════════╣")
-	fmt.Printf("║ Device ID: %-45s ║\n", resp.DeviceID)
+	log.Info()
+	log.Info("╔══════════════════════════════════════════════════════════╗")
+	log.Info("║                  DEVICE CREDENTIALS                      ║")
+	log.Info("╠══════════════════════════════════════════════════════════╣")
+	log.Infof("║ Device ID: %-45s ║\n", resp.DeviceID)
 	secretDisplay := resp.Secret
 	if len(secretDisplay) > 16 {
 		secretDisplay = secretDisplay[:16] + "..."
 	}
-	fmt.Printf("║ Secret:    %-45s ║\n", secretDisplay)
-	fmt.Println("╚══════════════════════════════════════════════════════════╝")
-	fmt.Println()
-	fmt.Printf("Backend: %s\n", baseURL)
+	log.Infof("║ Secret:    %-45s ║\n", secretDisplay)
+	if resp.Region != "" {
+		log.Infof("║ Region:    %-45s ║\n", resp.Region)
+	}
+	log.Info("╚══════════════════════════════════════════════════════════╝")
+	log.Info()
+	if baseURL != "" {
+		log.Infof("Backend: %s\n", baseURL)
+	}
+}
 
-	// Save backup
-	homeDir, _ := os.UserHomeDir()
-	credsDir := filepath.Join(homeDir, ".measurement-probe", "credentials")
-	_ = os.MkdirAll(credsDir, 0700)
+// saveBackup writes the device credentials to store.
+func saveBackup(store backup.Store, resp *api.ProvisionResponse) {
+	path, err := store.Save(&backup.Credentials{
+		DeviceID:  resp.DeviceID,
+		Secret:    resp.Secret,
+		CreatedAt: createdAt(resp),
+		Region:    resp.Region,
+	})
+	if err != nil {
+		log.Warnf("⚠️  Backup not saved: %v\n", err)
+		return
+	}
+	log.Infof("Backup saved: %s\n", path)
+}
+
+// dataPaths locates the tool's local state.
+type dataPaths struct {
+	Home     string // root of everything below
+	Backups  string // per-device credential backups
+	AuditLog string // provisioning audit log
+	Config   string // flag defaults
+}
+
+// resolveDataPaths places the tool's state under dir (the -backup-dir flag),
+// else under $MEASUREMENT_PROBE_HOME, else under ~/.measurement-probe.
+func resolveDataPaths(dir string, getenv func(string) string) dataPaths {
+	home := dir
+	if home == "" {
+		home = getenv(homeEnv)
+	}
+	if home == "" {
+		userHome, _ := os.UserHomeDir()
+		home = filepath.Join(userHome, ".measurement-probe")
+	}
 
-	credsFile := filepath.Join(credsDir, resp.DeviceID+".json")
-	content := fmt.Sprintf(`{
-  "device_id": "%s",
-  "secret": "%s"
+	return dataPaths{
+		Home:     home,
+		Backups:  filepath.Join(home, "credentials"),
+		AuditLog: filepath.Join(home, auditLogFile),
+		Config:   filepath.Join(home, configFileName),
+	}
 }
-`, resp.DeviceID, resp.Secret)
 
-	if err := os.WriteFile(credsFile, []byte(content), 0600); err == nil {
-		fmt.Printf("Backup saved: %s\n", credsFile)
+// logEvent appends a provisioning event to the audit log at auditLog.
+// Failures to write the log are reported but never abort the run.
+func logEvent(auditLog, mac, deviceID, backend string, provisionErr error) {
+	rec := audit.Record{
+		Timestamp: time.Now().UTC(),
+		MAC:       mac,
+		DeviceID:  deviceID,
+		Backend:   backend,
+		Success:   provisionErr == nil,
+	}
+	if provisionErr != nil {
+		rec.Error = provisionErr.Error()
+	}
+
+	if err := audit.Append(auditLog, rec); err != nil {
+		log.Warnf("  ⚠️  Could not write audit log: %v\n", err)
+	}
+}
+
+// provisionResult is the -json output for a provisioned device.
+type provisionResult struct {
+	DeviceID   string     `json:"device_id"`
+	Secret     string     `json:"secret"`
+	MAC        string     `json:"mac"`
+	BackendURL string     `json:"backend_url"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	Region     string     `json:"region,omitempty"`
+}
+
+// writeJSONResult prints the provisioning result as a single JSON object.
+func writeJSONResult(w io.Writer, resp *api.ProvisionResponse, mac, baseURL string) error {
+	return json.NewEncoder(w).Encode(provisionResult{
+		DeviceID:   resp.DeviceID,
+		Secret:     resp.Secret,
+		MAC:        mac,
+		BackendURL: baseURL,
+		CreatedAt:  createdAt(resp),
+		Region:     resp.Region,
+	})
+}
+
+// createdAt returns the response's creation time, or nil when the backend
+// didn't send one.
+func createdAt(resp *api.ProvisionResponse) *time.Time {
+	if resp.CreatedAt.IsZero() {
+		return nil
 	}
+	t := resp.CreatedAt
+	return &t
 }
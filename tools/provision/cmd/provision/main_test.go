@@ -0,0 +1,826 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"measurement-probe/tools/provision"
+	"measurement-probe/tools/provision/internal/api"
+	"measurement-probe/tools/provision/internal/backup"
+	"measurement-probe/tools/provision/internal/endpoints"
+	"measurement-probe/tools/provision/internal/nvs"
+	"measurement-probe/tools/provision/internal/partition"
+)
+
+func TestWriteJSONResult(t *testing.T) {
+	var buf bytes.Buffer
+	resp := &api.ProvisionResponse{
+		DeviceID: "device-123",
+		Secret:   "secret-456",
+	}
+
+	if err := writeJSONResult(&buf, resp, "aa:bb:cc:dd:ee:ff", "https://example.run.app"); err != nil {
+		t.Fatalf("writeJSONResult() error = %v", err)
+	}
+
+	// Must be a single line so scripts can read it directly
+	if lines := strings.Count(strings.TrimSpace(buf.String()), "\n"); lines != 0 {
+		t.Errorf("output spans %d lines, want 1", lines+1)
+	}
+
+	var got map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal output: %v", err)
+	}
+
+	want := map[string]string{
+		"device_id":   "device-123",
+		"secret":      "secret-456",
+		"mac":         "aa:bb:cc:dd:ee:ff",
+		"backend_url": "https://example.run.app",
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %q, want %q", key, got[key], value)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d fields, want %d", len(got), len(want))
+	}
+}
+
+func TestProvisionMetadataInOutputs(t *testing.T) {
+	captureLog(t)
+	store := backup.Store{Dir: t.TempDir()}
+
+	created := time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)
+	resp := &api.ProvisionResponse{DeviceID: "device-123", Secret: "secret-456", CreatedAt: created, Region: "europe-west1"}
+
+	var buf bytes.Buffer
+	if err := writeJSONResult(&buf, resp, "aa:bb:cc:dd:ee:ff", "https://example.run.app"); err != nil {
+		t.Fatalf("writeJSONResult() error = %v", err)
+	}
+	var result provisionResult
+	if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
+		t.Fatalf("unmarshal output: %v", err)
+	}
+	if result.Region != "europe-west1" || result.CreatedAt == nil || !result.CreatedAt.Equal(created) {
+		t.Errorf("JSON result = %+v, want region and created_at", result)
+	}
+
+	saveBackup(store, resp)
+	saved, err := store.Load("device-123")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if saved.Region != "europe-west1" || saved.CreatedAt == nil || !saved.CreatedAt.Equal(created) {
+		t.Errorf("backup = %+v, want region and created_at", saved)
+	}
+}
+
+// recordingRunner is a test double for nvs.CommandRunner.
+type recordingRunner struct {
+	calls [][]string
+}
+
+func (r *recordingRunner) Run(name string, args ...string) error {
+	r.calls = append(r.calls, append([]string{name}, args...))
+	return nil
+}
+
+func TestGenerateNVSImage(t *testing.T) {
+	buf := captureLog(t)
+
+	runner := &recordingRunner{}
+	writer := nvs.NewWriterWithRunner("/esp/idf", "", runner)
+	part := &partition.Entry{Name: "nvs", Type: "data", SubType: "nvs", Offset: 0x9000, Size: 0x6000}
+	outputPath := filepath.Join(t.TempDir(), "nvs.bin")
+
+	creds := &nvs.Credentials{DeviceID: "device-123", Secret: "secret-456"}
+	if err := generateNVSImage(writer, partition.NewTable([]partition.Entry{*part}), part, creds, outputPath); err != nil {
+		t.Fatalf("generateNVSImage() error = %v", err)
+	}
+
+	if len(runner.calls) != 1 {
+		t.Fatalf("got %d commands, want 1 (no flashing): %v", len(runner.calls), runner.calls)
+	}
+	call := runner.calls[0]
+	if call[0] != "python3" || !strings.HasSuffix(call[1], "nvs_partition_gen.py") {
+		t.Errorf("command = %v, want nvs_partition_gen.py", call)
+	}
+	if call[len(call)-2] != outputPath || call[len(call)-1] != "0x6000" {
+		t.Errorf("command = %v, want output %s and size 0x6000", call, outputPath)
+	}
+
+	if !strings.Contains(buf.String(), "offset 0x9000") {
+		t.Errorf("output does not report the partition offset:\n%s", buf.String())
+	}
+}
+
+func TestConfigureEncryption(t *testing.T) {
+	buf := captureLog(t)
+
+	withKeys := writeTable(t, "nvs, data, nvs, 0x9000, 0x5000,\nnvs_keys, data, nvs_keys, 0xe000, 0x1000, encrypted\n")
+	withoutKeys := writeTable(t, "nvs, data, nvs, 0x9000, 0x5000,\n")
+	outputPath := filepath.Join(t.TempDir(), "nvs.bin")
+
+	opts := nvsOptions{Encrypt: true}
+	writer := nvs.NewWriterWithRunner("/esp/idf", "", &recordingRunner{})
+	if err := configureEncryption(writer, withoutKeys, opts); err == nil || !strings.Contains(err.Error(), "nvs_keys") {
+		t.Errorf("configureEncryption() without nvs_keys error = %v", err)
+	}
+
+	if err := configureEncryption(writer, withKeys, opts); err != nil {
+		t.Fatalf("configureEncryption() error = %v", err)
+	}
+	if writer.KeyPath(outputPath) == "" {
+		t.Fatal("writer does not encrypt after configureEncryption()")
+	}
+
+	part, _ := withKeys.FindByName("nvs")
+	creds := &nvs.Credentials{DeviceID: "device-123", Secret: "secret-456"}
+	if err := generateNVSImage(writer, withKeys, part, creds, outputPath); err != nil {
+		t.Fatalf("generateNVSImage() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), `partition "nvs_keys" at offset 0xe000`) {
+		t.Errorf("output does not say where to flash the key:\n%s", buf.String())
+	}
+}
+
+// writeTable parses a partition table from CSV content.
+func writeTable(t *testing.T, content string) *partition.Table {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "partitions.csv")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	table, err := partition.ParseFile(path)
+	if err != nil {
+		t.Fatalf("ParseFile() error = %v", err)
+	}
+	return table
+}
+
+func TestFindNVSPartition(t *testing.T) {
+	table := writeTable(t, "nvs, data, nvs, 0x9000, 0x5000,\n"+
+		"nvs_cfg, data, nvs, 0xe000, 0x3000,\n"+
+		"phy_init, data, phy, 0x11000, 0x1000,\n"+
+		"factory, app, factory, 0x20000, 0x100000,\n")
+
+	tests := []struct {
+		name       string
+		wantOffset int
+		wantErr    string
+	}{
+		{name: "nvs", wantOffset: 0x9000},
+		{name: "nvs_cfg", wantOffset: 0xe000},
+		{name: "nvs_data", wantErr: "one of the data partitions: nvs, nvs_cfg, phy_init"},
+		{name: "factory", wantErr: "has type app, not data"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := findNVSPartition(table, tt.name)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("findNVSPartition(%q) error = %v, want %q", tt.name, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("findNVSPartition(%q) error = %v", tt.name, err)
+			}
+			if entry.Offset != tt.wantOffset {
+				t.Errorf("findNVSPartition(%q) offset = 0x%x, want 0x%x", tt.name, entry.Offset, tt.wantOffset)
+			}
+		})
+	}
+}
+
+func TestAppFlashTarget(t *testing.T) {
+	tests := []struct {
+		name       string
+		table      string
+		imageSize  int
+		wantOffset int
+		wantErr    string
+	}{
+		{
+			name:       "factory",
+			table:      "nvs, data, nvs, 0x9000, 0x6000,\nfactory, app, factory, 0x10000, 0x100000,\n",
+			imageSize:  0x80000,
+			wantOffset: 0x10000,
+		},
+		{
+			name:       "ota only",
+			table:      "nvs, data, nvs, 0x9000, 0x4000,\notadata, data, ota, 0xd000, 0x2000,\nota_0, app, ota_0, 0x10000, 0x100000,\nota_1, app, ota_1, 0x110000, 0x100000,\n",
+			imageSize:  0x80000,
+			wantOffset: 0x10000,
+		},
+		{
+			name:      "overlaps nvs",
+			table:     "nvs, data, nvs, 0x9000, 0x8000,\nfactory, app, factory, 0x10000, 0x100000,\n",
+			imageSize: 0x80000,
+			wantErr:   "overlaps NVS",
+		},
+		{
+			name:      "image too large",
+			table:     "nvs, data, nvs, 0x9000, 0x6000,\nfactory, app, factory, 0x10000, 0x100000,\n",
+			imageSize: 0x100001,
+			wantErr:   "holds",
+		},
+		{
+			name:    "no app partition",
+			table:   "nvs, data, nvs, 0x9000, 0x6000,\n",
+			wantErr: "no factory or ota_0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, err := appFlashTarget(writeTable(t, tt.table), "nvs", tt.imageSize)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("appFlashTarget() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("appFlashTarget() error = %v", err)
+			}
+			if app.Offset != tt.wantOffset {
+				t.Errorf("offset = 0x%x, want 0x%x", app.Offset, tt.wantOffset)
+			}
+		})
+	}
+}
+
+func TestFlashAppImage(t *testing.T) {
+	captureLog(t)
+
+	appImage := filepath.Join(t.TempDir(), "measurement_probe.bin")
+	if err := os.WriteFile(appImage, make([]byte, 1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := &recordingRunner{}
+	writer := nvs.NewWriterWithRunner("/esp/idf", "/dev/ttyUSB0", runner)
+	table := writeTable(t, "nvs, data, nvs, 0x9000, 0x6000,\nfactory, app, factory, 0x10000, 0x100000,\n")
+
+	if err := flashAppImage(writer, table, "nvs", appImage); err != nil {
+		t.Fatalf("flashAppImage() error = %v", err)
+	}
+
+	want := []string{"esptool.py", "--port", "/dev/ttyUSB0", "write_flash", "0x10000", appImage}
+	if len(runner.calls) != 1 || strings.Join(runner.calls[0], " ") != strings.Join(want, " ") {
+		t.Errorf("commands = %v, want %v", runner.calls, want)
+	}
+}
+
+func TestResolveAPIKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "api-key")
+	if err := os.WriteFile(keyFile, []byte("file-key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	env := func(key string) func(string) string {
+		return func(name string) string {
+			if name == apiKeyEnv {
+				return key
+			}
+			return ""
+		}
+	}
+	fetch := func() (string, error) { return "gcloud-key", nil }
+
+	tests := []struct {
+		name       string
+		flagKey    string
+		keyFile    string
+		envKey     string
+		wantKey    string
+		wantSource string
+	}{
+		{"flag wins", "flag-key", keyFile, "env-key", "flag-key", "-api-key"},
+		{"file over env", "", keyFile, "env-key", "file-key", keyFile},
+		{"env over gcloud", "", "", "env-key", "env-key", "$ADMIN_API_KEY"},
+		{"gcloud fallback", "", "", "", "gcloud-key", "Secret Manager"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, source, err := resolveAPIKey(tt.flagKey, tt.keyFile, env(tt.envKey), fetch)
+			if err != nil {
+				t.Fatalf("resolveAPIKey() error = %v", err)
+			}
+			if key != tt.wantKey || source != tt.wantSource {
+				t.Errorf("resolveAPIKey() = %q, %q, want %q, %q", key, source, tt.wantKey, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestResolveAPIKeyErrors(t *testing.T) {
+	emptyFile := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(emptyFile, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	noEnv := func(string) string { return "" }
+	fetch := func() (string, error) { return "", errors.New("no permission") }
+
+	if _, _, err := resolveAPIKey("", emptyFile, noEnv, fetch); err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Errorf("empty file: error = %v", err)
+	}
+	if _, _, err := resolveAPIKey("", filepath.Join(t.TempDir(), "missing"), noEnv, fetch); err == nil {
+		t.Error("missing file: expected error")
+	}
+	if _, _, err := resolveAPIKey("", "", noEnv, fetch); err == nil || err.Error() != "no permission" {
+		t.Errorf("gcloud failure: error = %v", err)
+	}
+}
+
+func TestPrepareFirmwareSkipEndpoints(t *testing.T) {
+	// No checkout here, so anything but a short-circuit would fail.
+	dir := t.TempDir()
+	build := func() error {
+		t.Error("build called with --skip-endpoints")
+		return nil
+	}
+
+	if err := prepareFirmware(dir, "https://example.run.app", firmwareOptions{SkipEndpoints: true}, build); err != nil {
+		t.Fatalf("prepareFirmware() error = %v", err)
+	}
+	if err := prepareFirmware(dir, "https://example.run.app", firmwareOptions{}, build); err == nil {
+		t.Error("prepareFirmware() without --skip-endpoints should fail outside a checkout")
+	}
+}
+
+func TestPrepareFirmwareRebuildsOnChange(t *testing.T) {
+	dir := t.TempDir()
+	headerPath := filepath.Join(dir, endpoints.RelativePath, endpoints.HeaderFileName)
+	if err := os.MkdirAll(filepath.Dir(headerPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := endpoints.WriteHeader(headerPath, "https://old.run.app"); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := captureLog(t)
+
+	builds := 0
+	build := func() error { builds++; return nil }
+
+	if err := prepareFirmware(dir, "https://new.run.app", firmwareOptions{}, build); err != nil {
+		t.Fatalf("prepareFirmware() error = %v", err)
+	}
+	for _, want := range []string{"old: https://old.run.app", "new: https://new.run.app"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := prepareFirmware(dir, "https://new.run.app", firmwareOptions{}, build); err != nil {
+		t.Fatalf("prepareFirmware() error = %v", err)
+	}
+	if strings.Contains(buf.String(), "different backend") {
+		t.Errorf("matching header reported as stale:\n%s", buf.String())
+	}
+	if builds != 1 {
+		t.Errorf("build ran %d times, want once (only when the URL changed)", builds)
+	}
+}
+
+func TestPrepareFirmwareNoBuild(t *testing.T) {
+	dir := t.TempDir()
+	headerPath := filepath.Join(dir, endpoints.RelativePath, endpoints.HeaderFileName)
+	if err := os.MkdirAll(filepath.Dir(headerPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := endpoints.WriteHeader(headerPath, "https://old.run.app"); err != nil {
+		t.Fatal(err)
+	}
+	captureLog(t)
+
+	build := func() error {
+		t.Error("build called with --no-build")
+		return nil
+	}
+	fw := firmwareOptions{NoBuild: true}
+
+	err := prepareFirmware(dir, "https://new.run.app", fw, build)
+	if err == nil || !strings.Contains(err.Error(), "--no-build") {
+		t.Fatalf("prepareFirmware() error = %v, want --no-build mismatch", err)
+	}
+	if url, _ := endpoints.ReadBaseURL(headerPath); url != "https://old.run.app" {
+		t.Errorf("BASE_URL = %q, want header left untouched", url)
+	}
+
+	if err := prepareFirmware(dir, "https://old.run.app", fw, build); err != nil {
+		t.Errorf("prepareFirmware() with matching URL error = %v", err)
+	}
+}
+
+func TestPrepareFirmwareRegenerate(t *testing.T) {
+	dir := t.TempDir()
+	headerPath := filepath.Join(dir, endpoints.RelativePath, endpoints.HeaderFileName)
+	if err := os.MkdirAll(filepath.Dir(headerPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// An old header from before the other endpoint constants existed
+	old := "inline constexpr std::string_view BASE_URL = \"https://same.run.app\";\n"
+	if err := os.WriteFile(headerPath, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := captureLog(t)
+
+	builds := 0
+	build := func() error { builds++; return nil }
+
+	if err := prepareFirmware(dir, "https://same.run.app", firmwareOptions{Regenerate: true}, build); err != nil {
+		t.Fatalf("prepareFirmware() error = %v", err)
+	}
+
+	content, err := os.ReadFile(headerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, constant := range []string{"BASE_URL", "AUTH_DEVICE", "AUTH_REFRESH", "TELEMETRY_PROTO", "COMMANDS", "DEVICE_INFO"} {
+		if !strings.Contains(string(content), "std::string_view "+constant+" = ") {
+			t.Errorf("regenerated header missing %s:\n%s", constant, content)
+		}
+	}
+	if !strings.Contains(buf.String(), "rebuild required") {
+		t.Errorf("output doesn't report a rebuild:\n%s", buf.String())
+	}
+	if builds != 1 {
+		t.Errorf("build ran %d times, want once", builds)
+	}
+}
+
+func newRotateServer(t *testing.T, status int) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/devices/device-123/rotate" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(api.ProvisionResponse{DeviceID: "device-123", Secret: "new-secret"})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRotateSecret(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := backup.Save(dir, &backup.Credentials{DeviceID: "device-123", Secret: "old-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	client := api.NewClient(newRotateServer(t, http.StatusOK).URL, "token")
+
+	var flashed *nvs.Credentials
+	flash := func(creds *nvs.Credentials) error {
+		flashed = creds
+		return nil
+	}
+
+	resp, err := rotateSecret(client, "device-123", backup.Store{Dir: dir}, false, flash)
+	if err != nil {
+		t.Fatalf("rotateSecret() error = %v", err)
+	}
+	if resp.Secret != "new-secret" {
+		t.Errorf("Secret = %q, want new-secret", resp.Secret)
+	}
+	if flashed == nil || flashed.DeviceID != "device-123" || flashed.Secret != "new-secret" {
+		t.Errorf("flashed = %+v, want the new credentials", flashed)
+	}
+
+	saved, err := backup.Load(dir, "device-123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Secret != "new-secret" {
+		t.Errorf("backup secret = %q, want new-secret", saved.Secret)
+	}
+	history, _ := backup.History(dir, "device-123")
+	if len(history) != 1 || history[0].Secret != "old-secret" {
+		t.Errorf("backup history = %+v, want the old secret", history)
+	}
+}
+
+func TestRotateSecretDryRun(t *testing.T) {
+	dir := t.TempDir()
+	client := api.NewClient(newRotateServer(t, http.StatusOK).URL, "token")
+
+	flash := func(*nvs.Credentials) error {
+		t.Error("flash called in dry-run mode")
+		return nil
+	}
+
+	if _, err := rotateSecret(client, "device-123", backup.Store{Dir: dir}, true, flash); err != nil {
+		t.Fatalf("rotateSecret() error = %v", err)
+	}
+	if saved, err := backup.Load(dir, "device-123"); err != nil || saved.Secret != "new-secret" {
+		t.Errorf("backup = %+v, %v, want the new secret saved in dry-run mode", saved, err)
+	}
+}
+
+func TestRotateSecretNotFound(t *testing.T) {
+	dir := t.TempDir()
+	client := api.NewClient(newRotateServer(t, http.StatusOK).URL, "token")
+
+	_, err := rotateSecret(client, "unknown-device", backup.Store{Dir: dir}, false, func(*nvs.Credentials) error {
+		t.Error("flash called for an unknown device")
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "device unknown-device not found") {
+		t.Errorf("rotateSecret() error = %v, want not found", err)
+	}
+	if _, err := os.Stat(backup.Path(dir, "unknown-device")); !os.IsNotExist(err) {
+		t.Error("backup written for an unknown device")
+	}
+}
+
+func TestRotateSecretEncryptedBackupWithoutPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	encrypted := backup.Store{Dir: dir, Passphrase: "hunter2", Encrypt: true}
+	if _, err := encrypted.Save(&backup.Credentials{DeviceID: "device-123", Secret: "old-secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		json.NewEncoder(w).Encode(api.ProvisionResponse{DeviceID: "device-123", Secret: "new-secret"})
+	}))
+	defer server.Close()
+	client := api.NewClient(server.URL, "token")
+
+	_, err := rotateSecret(client, "device-123", backup.Store{Dir: dir}, false, func(*nvs.Credentials) error {
+		t.Error("flash called without a backup")
+		return nil
+	})
+	if !errors.Is(err, backup.ErrNoPassphrase) || !strings.Contains(err.Error(), passphraseEnv) {
+		t.Errorf("rotateSecret() error = %v, want ErrNoPassphrase naming $%s", err, passphraseEnv)
+	}
+	// The old secret must still work: the backend was never asked to rotate
+	if calls != 0 {
+		t.Errorf("backend called %d times, want 0", calls)
+	}
+	if saved, err := encrypted.Load("device-123"); err != nil || saved.Secret != "old-secret" {
+		t.Errorf("backup = %+v, %v, want the old secret untouched", saved, err)
+	}
+}
+
+func TestListBackups(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	backupDir := resolveDataPaths("", os.Getenv).Backups
+	store := backup.Store{Dir: backupDir}
+
+	older := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	newer := older.Add(time.Hour)
+	for id, modTime := range map[string]time.Time{"device-old": older, "device-new": newer} {
+		path, err := store.Save(&backup.Credentials{DeviceID: id, Secret: "secret-" + id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(backupDir, "broken.json"), []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := listBackups(&buf, store); err != nil {
+		t.Fatalf("listBackups() error = %v", err)
+	}
+	output := buf.String()
+
+	if strings.Contains(output, "secret-") {
+		t.Errorf("listBackups() printed a secret:\n%s", output)
+	}
+	if !strings.Contains(output, "⚠️  Skipped:") || !strings.Contains(output, "broken.json") {
+		t.Errorf("listBackups() did not warn about the malformed backup:\n%s", output)
+	}
+	newIdx := strings.Index(output, "device-new")
+	oldIdx := strings.Index(output, "device-old")
+	if newIdx < 0 || oldIdx < 0 || newIdx > oldIdx {
+		t.Errorf("listBackups() should list device-new before device-old:\n%s", output)
+	}
+	if !strings.Contains(output, "2024-03-01 12:00:00") {
+		t.Errorf("listBackups() missing modification time:\n%s", output)
+	}
+	if !strings.Contains(output, "2 backup(s)") {
+		t.Errorf("listBackups() count wrong:\n%s", output)
+	}
+}
+
+func TestListBackupsEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := listBackups(&buf, backup.Store{Dir: filepath.Join(t.TempDir(), "missing")}); err != nil {
+		t.Fatalf("listBackups() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "No backups found") {
+		t.Errorf("output = %q", buf.String())
+	}
+}
+
+func TestResolveDataPaths(t *testing.T) {
+	t.Setenv("HOME", "/home/dev")
+	env := func(value string) func(string) string {
+		return func(name string) string {
+			if name == homeEnv {
+				return value
+			}
+			return ""
+		}
+	}
+
+	tests := []struct {
+		name     string
+		flagDir  string
+		envDir   string
+		wantHome string
+	}{
+		{"default", "", "", "/home/dev/.measurement-probe"},
+		{"env override", "", "/srv/probe", "/srv/probe"},
+		{"flag override", "./mfg-run", "/srv/probe", "./mfg-run"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resolveDataPaths(tt.flagDir, env(tt.envDir))
+			want := dataPaths{
+				Home:     tt.wantHome,
+				Backups:  filepath.Join(tt.wantHome, "credentials"),
+				AuditLog: filepath.Join(tt.wantHome, auditLogFile),
+				Config:   filepath.Join(tt.wantHome, configFileName),
+			}
+			if got != want {
+				t.Errorf("resolveDataPaths() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// recordingMACReader is a provision.MACReader that records the ports it
+// reads and answers with a fixed MAC.
+type recordingMACReader struct {
+	ports []string
+	mac   string
+}
+
+func (r *recordingMACReader) ReadMAC(ctx context.Context, port string) (string, error) {
+	r.ports = append(r.ports, port)
+	return r.mac, nil
+}
+
+func TestPrintMAC(t *testing.T) {
+	captureLog(t)
+
+	reader := &recordingMACReader{mac: "aa:bb:cc:dd:ee:ff"}
+	detected := 0
+	detect := func() (string, error) { detected++; return "/dev/ttyACM0", nil }
+
+	var stdout bytes.Buffer
+	if err := printMAC(&stdout, "", detect, reader); err != nil {
+		t.Fatalf("printMAC() error = %v", err)
+	}
+
+	if stdout.String() != "aa:bb:cc:dd:ee:ff\n" {
+		t.Errorf("stdout = %q, want only the MAC", stdout.String())
+	}
+	if detected != 1 {
+		t.Errorf("detect called %d times, want 1", detected)
+	}
+	if len(reader.ports) != 1 || reader.ports[0] != "/dev/ttyACM0" {
+		t.Errorf("read MAC on %q, want once on the detected port", reader.ports)
+	}
+}
+
+func TestPrintMACDetectFails(t *testing.T) {
+	reader := &recordingMACReader{}
+	detect := func() (string, error) { return "", errors.New("no serial ports found") }
+
+	if err := printMAC(&bytes.Buffer{}, "", detect, reader); err == nil {
+		t.Fatal("printMAC() expected error when no port is found")
+	}
+	if len(reader.ports) != 0 {
+		t.Errorf("read MAC on %q without a port", reader.ports)
+	}
+}
+
+func TestDecideRebuild(t *testing.T) {
+	tests := []struct {
+		name        string
+		skipBuild   bool
+		interactive bool
+		input       string
+		want        bool
+		wantPrompt  bool
+	}{
+		{name: "skip-build never rebuilds", skipBuild: true, interactive: true, input: "y\n", want: false},
+		{name: "non-interactive rebuilds", interactive: false, want: true},
+		{name: "enter takes default yes", interactive: true, input: "\n", want: true, wantPrompt: true},
+		{name: "yes", interactive: true, input: "Y\n", want: true, wantPrompt: true},
+		{name: "no", interactive: true, input: "n\n", want: false, wantPrompt: true},
+		{name: "no spelled out", interactive: true, input: " No \n", want: false, wantPrompt: true},
+		{name: "closed stdin takes default", interactive: true, input: "", want: true, wantPrompt: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			got := decideRebuild(tt.skipBuild, tt.interactive, bufio.NewReader(strings.NewReader(tt.input)), &out)
+			if got != tt.want {
+				t.Errorf("decideRebuild() = %t, want %t", got, tt.want)
+			}
+			if prompted := strings.Contains(out.String(), "[Y/n]"); prompted != tt.wantPrompt {
+				t.Errorf("prompted = %t, want %t (output %q)", prompted, tt.wantPrompt, out.String())
+			}
+		})
+	}
+}
+
+func TestConfirmFlash(t *testing.T) {
+	res := provision.Result{
+		MAC:        "AA:BB:CC:DD:EE:FF",
+		Port:       "/dev/ttyUSB1",
+		BackendURL: "https://telemetry.run.app",
+	}
+
+	tests := []struct {
+		name        string
+		assumeYes   bool
+		interactive bool
+		input       string
+		wantErr     bool
+		wantPrompt  bool
+	}{
+		{name: "yes flag skips the prompt", assumeYes: true, interactive: true, input: "n\n"},
+		{name: "non-interactive skips the prompt", interactive: false},
+		{name: "enter takes default no", interactive: true, input: "\n", wantErr: true, wantPrompt: true},
+		{name: "closed stdin takes default no", interactive: true, input: "", wantErr: true, wantPrompt: true},
+		{name: "no", interactive: true, input: "n\n", wantErr: true, wantPrompt: true},
+		{name: "yes", interactive: true, input: "y\n", wantPrompt: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := confirmFlash(res, tt.assumeYes, tt.interactive, bufio.NewReader(strings.NewReader(tt.input)), &out)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("confirmFlash() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if prompted := strings.Contains(out.String(), "[y/N]"); prompted != tt.wantPrompt {
+				t.Errorf("prompted = %t, want %t (output %q)", prompted, tt.wantPrompt, out.String())
+			}
+			if tt.wantPrompt {
+				for _, want := range []string{res.Port, res.MAC, res.BackendURL} {
+					if !strings.Contains(out.String(), want) {
+						t.Errorf("summary missing %q:\n%s", want, out.String())
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDecideRebuildSharedReader(t *testing.T) {
+	// Answers piped in for two prompts: each must see its own line
+	in := bufio.NewReader(strings.NewReader("n\ny\n"))
+	if decideRebuild(false, true, in, &bytes.Buffer{}) {
+		t.Error("first decideRebuild() = true, want the first answer (n)")
+	}
+	if !decideRebuild(false, true, in, &bytes.Buffer{}) {
+		t.Error("second decideRebuild() = false, want the second answer (y)")
+	}
+}
+
+func TestPromptsShareStdin(t *testing.T) {
+	// printf 'n\ny\n' | provision ...: decline the rebuild, confirm the flash
+	in := bufio.NewReader(strings.NewReader("n\ny\n"))
+
+	if decideRebuild(false, true, in, io.Discard) {
+		t.Error("decideRebuild() = true, want the first answer (n)")
+	}
+	res := provision.Result{MAC: "AA:BB:CC:DD:EE:FF"}
+	if err := confirmFlash(res, false, true, in, io.Discard); err != nil {
+		t.Errorf("confirmFlash() error = %v, want the second answer (y)", err)
+	}
+}
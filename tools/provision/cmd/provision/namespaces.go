@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"measurement-probe/tools/provision/internal/nvs"
+)
+
+// nvsEntries collects repeated -nvs-entry namespace.key=value flags into
+// namespaces written alongside the credentials, in the order first named.
+type nvsEntries []nvs.Namespace
+
+func (e *nvsEntries) String() string {
+	if e == nil {
+		return ""
+	}
+	var specs []string
+	for _, ns := range *e {
+		for _, entry := range ns.Entries {
+			specs = append(specs, ns.Name+"."+entry.Key+"="+entry.Value)
+		}
+	}
+	return strings.Join(specs, ",")
+}
+
+func (e *nvsEntries) Set(spec string) error {
+	name, value, ok := strings.Cut(spec, "=")
+	if !ok {
+		return fmt.Errorf("invalid NVS entry %q: want namespace.key=value", spec)
+	}
+	namespace, key, ok := strings.Cut(name, ".")
+	if !ok || namespace == "" || key == "" {
+		return fmt.Errorf("invalid NVS entry %q: want namespace.key=value", spec)
+	}
+
+	entry := nvs.Entry{Key: key, Value: value}
+	for i := range *e {
+		if (*e)[i].Name == namespace {
+			(*e)[i].Entries = append((*e)[i].Entries, entry)
+			return nil
+		}
+	}
+	*e = append(*e, nvs.Namespace{Name: namespace, Entries: []nvs.Entry{entry}})
+	return nil
+}
+
+// configureNamespaces adds the -nvs-entry namespaces to writer.
+func configureNamespaces(writer *nvs.Writer, namespaces nvsEntries) error {
+	for _, ns := range namespaces {
+		if err := writer.AddNamespace(ns); err != nil {
+			return fmt.Errorf("--nvs-entry: %w", err)
+		}
+		log.Infof("  Also writing namespace %q (%d keys)\n", ns.Name, len(ns.Entries))
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"measurement-probe/tools/provision/internal/nvs"
+)
+
+func TestNVSEntries_Set(t *testing.T) {
+	var entries nvsEntries
+	for _, spec := range []string{"wifi.ssid=factory-ap", "config.region=eu", "wifi.password=a=b"} {
+		if err := entries.Set(spec); err != nil {
+			t.Fatalf("Set(%q) error = %v", spec, err)
+		}
+	}
+
+	if got, want := entries.String(), "wifi.ssid=factory-ap,wifi.password=a=b,config.region=eu"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	for _, spec := range []string{"wifi.ssid", "ssid=x", ".ssid=x", "wifi.=x"} {
+		if err := entries.Set(spec); err == nil {
+			t.Errorf("Set(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestConfigureNamespaces(t *testing.T) {
+	captureLog(t)
+
+	var entries nvsEntries
+	if err := entries.Set("wifi.ssid=factory-ap"); err != nil {
+		t.Fatal(err)
+	}
+	writer := nvs.NewWriterWithRunner("/esp/idf", "", &recordingRunner{})
+	if err := configureNamespaces(writer, entries); err != nil {
+		t.Fatalf("configureNamespaces() error = %v", err)
+	}
+
+	csvPath := filepath.Join(t.TempDir(), "nvs.csv")
+	creds := &nvs.Credentials{DeviceID: "device-123", Secret: "secret-456"}
+	if err := writer.GenerateCSV(writer.Namespaces(creds), csvPath); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(content), "wifi,namespace,,\nssid,data,string,factory-ap\n") {
+		t.Errorf("CSV missing the wifi namespace:\n%s", content)
+	}
+
+	var spoofed nvsEntries
+	if err := spoofed.Set("cloud.device_id=spoofed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := configureNamespaces(nvs.NewWriterWithRunner("/esp/idf", "", &recordingRunner{}), spoofed); err == nil {
+		t.Error("configureNamespaces() allowed overriding the credentials namespace")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// notifyTimeout bounds the --notify-url request so a slow inventory system
+// never holds up the next board.
+const notifyTimeout = 5 * time.Second
+
+// provisionNotice is the --notify-url payload. It never carries the secret.
+type provisionNotice struct {
+	DeviceID  string    `json:"device_id"`
+	MAC       string    `json:"mac"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// checkNotifyURL rejects --notify-url values that aren't absolute http(s) URLs.
+func checkNotifyURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid --notify-url %q: want an http(s) URL", raw)
+	}
+	return nil
+}
+
+// postNotice POSTs notice as JSON to target, treating any non-2xx status as
+// an error.
+func postNotice(ctx context.Context, client *http.Client, target string, notice provisionNotice) error {
+	body, err := json.Marshal(notice)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", target, resp.Status)
+	}
+	return nil
+}
+
+// notifyProvisioned tells the inventory system at target that deviceID was
+// provisioned. It is best-effort: failures are logged, never returned.
+func notifyProvisioned(target, deviceID, mac string) {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	notice := provisionNotice{DeviceID: deviceID, MAC: mac, Timestamp: time.Now().UTC()}
+	if err := postNotice(ctx, http.DefaultClient, target, notice); err != nil {
+		log.Warnf("  ⚠️  Could not notify %s: %v\n", target, err)
+		return
+	}
+	log.Infof("  ✓ Notified %s\n", target)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifyProvisioned(t *testing.T) {
+	buf := captureLog(t)
+
+	var payload map[string]any
+	var contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s, want POST", r.Method)
+		}
+		contentType = r.Header.Get("Content-Type")
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("payload %q is not JSON: %v", body, err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	notifyProvisioned(server.URL, "device-123", "AA:BB:CC:DD:EE:FF")
+
+	if contentType != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", contentType)
+	}
+	if len(payload) != 3 || payload["device_id"] != "device-123" || payload["mac"] != "AA:BB:CC:DD:EE:FF" {
+		t.Errorf("payload = %v, want exactly device_id, mac and timestamp", payload)
+	}
+	stamp, _ := payload["timestamp"].(string)
+	if _, err := time.Parse(time.RFC3339, stamp); err != nil {
+		t.Errorf("timestamp %q is not RFC 3339: %v", stamp, err)
+	}
+	if !strings.Contains(buf.String(), "✓ Notified") {
+		t.Errorf("log = %q, want success line", buf.String())
+	}
+}
+
+func TestNotifyProvisionedFailure(t *testing.T) {
+	buf := captureLog(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "inventory down", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// Best-effort: the failure is only logged
+	notifyProvisioned(server.URL, "device-123", "AA:BB:CC:DD:EE:FF")
+	if !strings.Contains(buf.String(), "Could not notify") || !strings.Contains(buf.String(), "503") {
+		t.Errorf("log = %q, want a warning with the status", buf.String())
+	}
+
+	buf.Reset()
+	server.Close()
+	notifyProvisioned(server.URL, "device-123", "AA:BB:CC:DD:EE:FF")
+	if !strings.Contains(buf.String(), "Could not notify") {
+		t.Errorf("log = %q, want a warning for an unreachable URL", buf.String())
+	}
+}
+
+func TestCheckNotifyURL(t *testing.T) {
+	for _, raw := range []string{"https://inventory.example.com/hooks/provisioned", "http://localhost:8080"} {
+		if err := checkNotifyURL(raw); err != nil {
+			t.Errorf("checkNotifyURL(%q) error = %v", raw, err)
+		}
+	}
+	for _, raw := range []string{"inventory.example.com", "ftp://example.com", "https://", "://"} {
+		if err := checkNotifyURL(raw); err == nil {
+			t.Errorf("checkNotifyURL(%q) succeeded, want error", raw)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"measurement-probe/tools/provision/internal/partition"
+)
+
+// partitionRow is one partition in the -show-partitions output.
+type partitionRow struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	SubType   string `json:"subtype"`
+	Offset    string `json:"offset"`
+	Size      string `json:"size"`
+	SizeBytes int    `json:"size_bytes"`
+	Flags     string `json:"flags,omitempty"`
+}
+
+// showPartitions prints the project's partition table to w, as JSON or an
+// aligned table.
+func showPartitions(w io.Writer, jsonOutput bool) error {
+	path := findPartitionTable()
+	if path == "" {
+		return fmt.Errorf("partition table not found")
+	}
+	table, err := loadPartitionTable(path)
+	if err != nil {
+		return fmt.Errorf("parse partition table: %w", err)
+	}
+
+	if jsonOutput {
+		return writePartitionsJSON(w, table.Entries())
+	}
+	log.Infof("→ Partition table %s\n\n", path)
+	return formatPartitions(w, table.Entries())
+}
+
+func partitionRows(entries []partition.Entry) []partitionRow {
+	rows := make([]partitionRow, len(entries))
+	for i, e := range entries {
+		rows[i] = partitionRow{
+			Name:      e.Name,
+			Type:      e.Type,
+			SubType:   e.SubType,
+			Offset:    e.OffsetHex(),
+			Size:      e.HumanSize(),
+			SizeBytes: e.Size,
+			Flags:     e.Flags,
+		}
+		if rows[i].Offset == "" {
+			rows[i].Offset = "auto"
+		}
+	}
+	return rows
+}
+
+// formatPartitions writes entries as an aligned table.
+func formatPartitions(w io.Writer, entries []partition.Entry) error {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTYPE\tSUBTYPE\tOFFSET\tSIZE\tFLAGS")
+	for _, r := range partitionRows(entries) {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Name, r.Type, r.SubType, r.Offset, r.Size, r.Flags)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	// Rows without flags would otherwise end in padding
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+	return nil
+}
+
+// writePartitionsJSON writes entries as a JSON array.
+func writePartitionsJSON(w io.Writer, entries []partition.Entry) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(partitionRows(entries))
+}
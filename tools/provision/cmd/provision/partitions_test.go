@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"measurement-probe/tools/provision/internal/partition"
+)
+
+var samplePartitions = []partition.Entry{
+	{Name: "nvs", Type: "data", SubType: "nvs", Offset: 0x9000, Size: 0x4000},
+	{Name: "nvs_keys", Type: "data", SubType: "nvs_keys", Offset: 0xd000, Size: 0x1000, Flags: "encrypted"},
+	{Name: "factory", Type: "app", SubType: "factory", Offset: 0x10000, Size: 0x100000},
+	{Name: "storage", Type: "data", SubType: "littlefs", Size: 1500},
+}
+
+func TestFormatPartitions(t *testing.T) {
+	var buf bytes.Buffer
+	if err := formatPartitions(&buf, samplePartitions); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "NAME      TYPE  SUBTYPE   OFFSET   SIZE  FLAGS\n" +
+		"nvs       data  nvs       0x9000   16K\n" +
+		"nvs_keys  data  nvs_keys  0xd000   4K    encrypted\n" +
+		"factory   app   factory   0x10000  1M\n" +
+		"storage   data  littlefs  auto     1500\n"
+	if buf.String() != want {
+		t.Errorf("formatPartitions() =\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestWritePartitionsJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writePartitionsJSON(&buf, samplePartitions); err != nil {
+		t.Fatal(err)
+	}
+
+	var rows []partitionRow
+	if err := json.Unmarshal(buf.Bytes(), &rows); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, buf.String())
+	}
+	want := partitionRow{Name: "nvs_keys", Type: "data", SubType: "nvs_keys", Offset: "0xd000", Size: "4K", SizeBytes: 0x1000, Flags: "encrypted"}
+	if len(rows) != 4 || rows[1] != want {
+		t.Errorf("rows = %+v, want %+v second", rows, want)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"measurement-probe/tools/provision"
+	"measurement-probe/tools/provision/internal/serial"
+)
+
+// maxPortAttempts bounds how many invalid answers choosePort accepts.
+const maxPortAttempts = 3
+
+// detectPort returns the only connected serial port. With several connected
+// it asks which to use when stdin is a terminal, and otherwise returns an
+// error listing the candidates.
+func detectPort() (string, error) {
+	if !isTerminal(os.Stdin) {
+		return provision.DetectPort(log)
+	}
+
+	ports, err := serial.ListPortsDetailed()
+	if err != nil {
+		return "", fmt.Errorf("list ports: %w", err)
+	}
+	if len(ports) == 0 {
+		return "", fmt.Errorf("no serial ports found - is device connected?")
+	}
+	return choosePort(ports, stdin, os.Stderr)
+}
+
+// choosePort returns the only port in ports, or lists them on w and reads
+// the user's choice from r. Empty input takes the default from
+// defaultPortIndex.
+func choosePort(ports []serial.PortInfo, r *bufio.Reader, w io.Writer) (string, error) {
+	if len(ports) == 1 {
+		return ports[0].Path, nil
+	}
+
+	def := defaultPortIndex(ports)
+	fmt.Fprintln(w, "  Multiple ports found:")
+	for i, p := range ports {
+		mark := ""
+		if i == def {
+			mark = " (default)"
+		}
+		fmt.Fprintf(w, "    %d. %s%s\n", i+1, p, mark)
+	}
+
+	for attempt := 1; attempt <= maxPortAttempts; attempt++ {
+		fmt.Fprintf(w, "  Select port [1-%d, default=%d]: ", len(ports), def+1)
+
+		input, err := r.ReadString('\n')
+		input = strings.TrimSpace(input)
+		if input == "" {
+			if err != nil && err != io.EOF {
+				return "", fmt.Errorf("read port choice: %w", err)
+			}
+			return ports[def].Path, nil
+		}
+
+		idx, convErr := strconv.Atoi(input)
+		if convErr == nil && idx >= 1 && idx <= len(ports) {
+			return ports[idx-1].Path, nil
+		}
+		fmt.Fprintf(w, "  ⚠️  Invalid choice %q, enter a number from 1 to %d\n", input, len(ports))
+		if err != nil {
+			break
+		}
+	}
+	return "", fmt.Errorf("no port selected - specify one with --port")
+}
+
+// usbPortMarkers identify USB serial adapters and native USB ports, which is
+// what a board shows up as, unlike built-in UARTs such as /dev/ttyS0.
+var usbPortMarkers = []string{"ttyUSB", "ttyACM", "usbserial", "usbmodem", "wchusbserial"}
+
+// defaultPortIndex returns the first USB port, or the first that looks like
+// one by name when the OS doesn't report USB details, or 0.
+func defaultPortIndex(ports []serial.PortInfo) int {
+	for i, p := range ports {
+		if p.IsUSB() {
+			return i
+		}
+	}
+	for i, p := range ports {
+		for _, marker := range usbPortMarkers {
+			if strings.Contains(p.Path, marker) {
+				return i
+			}
+		}
+	}
+	return 0
+}
+
+// isTerminal reports whether f is an interactive terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"measurement-probe/tools/provision/internal/serial"
+)
+
+func portInfos(paths ...string) []serial.PortInfo {
+	ports := make([]serial.PortInfo, len(paths))
+	for i, path := range paths {
+		ports[i] = serial.PortInfo{Path: path}
+	}
+	return ports
+}
+
+func TestChoosePort(t *testing.T) {
+	tests := []struct {
+		name    string
+		ports   []serial.PortInfo
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "single port auto-selected",
+			ports: portInfos("/dev/ttyUSB0"),
+			want:  "/dev/ttyUSB0",
+		},
+		{
+			name:  "choice by number",
+			ports: portInfos("/dev/ttyUSB0", "/dev/ttyUSB1"),
+			input: "2\n",
+			want:  "/dev/ttyUSB1",
+		},
+		{
+			name:  "empty input takes USB default",
+			ports: portInfos("/dev/ttyS0", "/dev/ttyACM0"),
+			input: "\n",
+			want:  "/dev/ttyACM0",
+		},
+		{
+			name:  "invalid then valid",
+			ports: portInfos("/dev/ttyUSB0", "/dev/ttyUSB1"),
+			input: "9\n1\n",
+			want:  "/dev/ttyUSB0",
+		},
+		{
+			name:    "retries exhausted",
+			ports:   portInfos("/dev/ttyUSB0", "/dev/ttyUSB1"),
+			input:   "a\nb\nc\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			got, err := choosePort(tt.ports, bufio.NewReader(strings.NewReader(tt.input)), &out)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("choosePort() = %q, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("choosePort() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("choosePort() = %q, want %q", got, tt.want)
+			}
+			if len(tt.ports) == 1 && out.Len() != 0 {
+				t.Errorf("single port prompted:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestChoosePort_Menu(t *testing.T) {
+	var out bytes.Buffer
+	ports := []serial.PortInfo{
+		{Path: "/dev/ttyUSB0"},
+		{Path: "/dev/ttyUSB1", Product: "USB JTAG/serial debug unit", VID: "303a", PID: "1001"},
+	}
+	got, err := choosePort(ports, bufio.NewReader(strings.NewReader("\n")), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "/dev/ttyUSB1" {
+		t.Errorf("choosePort() = %q, want the port with USB details", got)
+	}
+
+	want := "  Multiple ports found:\n" +
+		"    1. /dev/ttyUSB0\n" +
+		"    2. /dev/ttyUSB1 - USB JTAG/serial debug unit (303a:1001) (default)\n" +
+		"  Select port [1-2, default=2]: "
+	if out.String() != want {
+		t.Errorf("menu =\n%q\nwant\n%q", out.String(), want)
+	}
+}
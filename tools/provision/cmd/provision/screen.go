@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"measurement-probe/tools/provision/internal/api"
+	"measurement-probe/tools/provision/internal/serial"
+)
+
+// screenConcurrency bounds concurrent esptool MAC reads when screening a tray.
+const screenConcurrency = 4
+
+// deviceLookup finds already provisioned devices. *api.Client implements it.
+type deviceLookup interface {
+	GetDeviceByMAC(mac string) (*api.Device, error)
+}
+
+// screenStatus is the -screen-only verdict for one board.
+type screenStatus int
+
+const (
+	screenNew screenStatus = iota
+	screenProvisioned
+	screenFailed
+)
+
+// screenResult is one line of the -screen-only report.
+type screenResult struct {
+	Port     string
+	MAC      string
+	Status   screenStatus
+	DeviceID string // set when already provisioned
+	Err      error  // set when the MAC could not be read or looked up
+}
+
+func (r screenResult) String() string {
+	switch r.Status {
+	case screenNew:
+		return fmt.Sprintf("  ✅ %s  %s  new", r.Port, r.MAC)
+	case screenProvisioned:
+		return fmt.Sprintf("  ⏭️  %s  %s  already provisioned (%s)", r.Port, r.MAC, r.DeviceID)
+	default:
+		return fmt.Sprintf("  ❌ %s  %s  %v", r.Port, r.MAC, r.Err)
+	}
+}
+
+// screenDevices decides, for each port in order, whether the board's MAC
+// (from macs, or the read error in readErrs) is new to the backend.
+func screenDevices(ports []string, macs map[string]string, readErrs map[string]error, lookup deviceLookup) []screenResult {
+	results := make([]screenResult, 0, len(ports))
+	for _, port := range ports {
+		r := screenResult{Port: port, MAC: macs[port]}
+		if err, failed := readErrs[port]; failed {
+			r.Status, r.Err = screenFailed, fmt.Errorf("read MAC: %w", err)
+			results = append(results, r)
+			continue
+		}
+
+		device, err := lookup.GetDeviceByMAC(r.MAC)
+		switch {
+		case errors.Is(err, api.ErrDeviceNotFound):
+			r.Status = screenNew
+		case err != nil:
+			r.Status, r.Err = screenFailed, fmt.Errorf("lookup: %w", err)
+		default:
+			r.Status, r.DeviceID = screenProvisioned, device.DeviceID
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// screenPorts reads the MAC of every board (or only port, if given), each
+// within timeout, and screens them against the backend without provisioning
+// anything.
+func screenPorts(port string, timeout time.Duration, lookup deviceLookup) ([]screenResult, error) {
+	ports := []string{port}
+	if port == "" {
+		var err error
+		if ports, err = serial.ListPorts(); err != nil {
+			return nil, fmt.Errorf("list ports: %w", err)
+		}
+		if len(ports) == 0 {
+			return nil, fmt.Errorf("no serial ports found - is device connected?")
+		}
+	}
+
+	macs, errs := serial.ReadMACs(ports, screenConcurrency, timeout, serial.ReadMACWithEsptool)
+	return screenDevices(ports, macs, errs, lookup), nil
+}
+
+// printScreen writes the -screen-only report. It returns an error if any board
+// could not be screened.
+func printScreen(w io.Writer, results []screenResult) error {
+	var fresh, provisioned, failed int
+	for _, r := range results {
+		fmt.Fprintln(w, r)
+		switch r.Status {
+		case screenNew:
+			fresh++
+		case screenProvisioned:
+			provisioned++
+		default:
+			failed++
+		}
+	}
+	fmt.Fprintf(w, "\n%d new, %d already provisioned, %d failed\n", fresh, provisioned, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d boards could not be screened", failed, len(results))
+	}
+	return nil
+}
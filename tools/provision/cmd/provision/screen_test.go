@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"measurement-probe/tools/provision/internal/api"
+)
+
+// fakeLookup is a deviceLookup backed by a map of MAC to device ID.
+type fakeLookup struct {
+	devices map[string]string
+	err     error
+	calls   []string
+}
+
+func (f *fakeLookup) GetDeviceByMAC(mac string) (*api.Device, error) {
+	f.calls = append(f.calls, mac)
+	if f.err != nil {
+		return nil, f.err
+	}
+	id, ok := f.devices[mac]
+	if !ok {
+		return nil, api.ErrDeviceNotFound
+	}
+	return &api.Device{DeviceID: id, MACAddress: mac}, nil
+}
+
+func TestScreenDevices(t *testing.T) {
+	ports := []string{"/dev/ttyUSB0", "/dev/ttyUSB1", "/dev/ttyUSB2"}
+	macs := map[string]string{
+		"/dev/ttyUSB0": "aa:bb:cc:dd:ee:01",
+		"/dev/ttyUSB1": "aa:bb:cc:dd:ee:02",
+	}
+	readErrs := map[string]error{"/dev/ttyUSB2": errors.New("timeout reading MAC after 30s")}
+	lookup := &fakeLookup{devices: map[string]string{"aa:bb:cc:dd:ee:02": "device-123"}}
+
+	results := screenDevices(ports, macs, readErrs, lookup)
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+
+	want := []struct {
+		status   screenStatus
+		deviceID string
+	}{
+		{screenNew, ""},
+		{screenProvisioned, "device-123"},
+		{screenFailed, ""},
+	}
+	for i, w := range want {
+		if results[i].Port != ports[i] {
+			t.Errorf("result %d port = %s, want %s (port order)", i, results[i].Port, ports[i])
+		}
+		if results[i].Status != w.status || results[i].DeviceID != w.deviceID {
+			t.Errorf("result %d = %+v, want status %d device %q", i, results[i], w.status, w.deviceID)
+		}
+	}
+
+	// A board whose MAC could not be read is never looked up
+	if len(lookup.calls) != 2 {
+		t.Errorf("looked up %v, want only the two read MACs", lookup.calls)
+	}
+
+	if got := results[1].String(); !strings.Contains(got, "already provisioned (device-123)") {
+		t.Errorf("String() = %q", got)
+	}
+	if got := results[0].String(); !strings.HasSuffix(got, "new") {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestScreenDevicesLookupError(t *testing.T) {
+	lookup := &fakeLookup{err: errors.New("device lookup failed (status 500): boom")}
+	results := screenDevices([]string{"/dev/ttyUSB0"}, map[string]string{"/dev/ttyUSB0": "aa:bb:cc:dd:ee:01"}, nil, lookup)
+
+	if results[0].Status != screenFailed || !strings.Contains(results[0].Err.Error(), "lookup") {
+		t.Errorf("result = %+v, want a failed lookup", results[0])
+	}
+}
+
+func TestPrintScreen(t *testing.T) {
+	results := []screenResult{
+		{Port: "/dev/ttyUSB0", MAC: "aa:bb:cc:dd:ee:01", Status: screenNew},
+		{Port: "/dev/ttyUSB1", MAC: "aa:bb:cc:dd:ee:02", Status: screenProvisioned, DeviceID: "device-123"},
+	}
+
+	var buf bytes.Buffer
+	if err := printScreen(&buf, results); err != nil {
+		t.Fatalf("printScreen() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "1 new, 1 already provisioned, 0 failed") {
+		t.Errorf("summary missing:\n%s", buf.String())
+	}
+
+	results = append(results, screenResult{Port: "/dev/ttyUSB2", Status: screenFailed, Err: errors.New("read MAC: timeout")})
+	if err := printScreen(&bytes.Buffer{}, results); err == nil {
+		t.Error("printScreen() expected error when a board could not be screened")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"measurement-probe/tools/provision/internal/api"
+)
+
+// stdinCredentials is the JSON accepted by --creds-stdin, as issued by an
+// upstream provisioning service.
+type stdinCredentials struct {
+	DeviceID string `json:"device_id"`
+	Secret   string `json:"secret"`
+}
+
+// readStdinCredentials decodes a single {device_id, secret} object from r and
+// checks that both fields are present.
+func readStdinCredentials(r io.Reader) (*stdinCredentials, error) {
+	var creds stdinCredentials
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&creds); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("no credentials on stdin - pipe in {\"device_id\": ..., \"secret\": ...}")
+		}
+		return nil, fmt.Errorf("invalid credentials JSON on stdin: %w", err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("invalid credentials JSON on stdin: expected a single object")
+	}
+
+	creds.DeviceID = strings.TrimSpace(creds.DeviceID)
+	var missing []string
+	if creds.DeviceID == "" {
+		missing = append(missing, "device_id")
+	}
+	if creds.Secret == "" {
+		missing = append(missing, "secret")
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("credentials on stdin are missing %s", strings.Join(missing, " and "))
+	}
+	return &creds, nil
+}
+
+// flashFromStdin writes credentials piped in by an upstream service to the
+// device, without contacting the backend.
+func flashFromStdin(tool toolOptions, r io.Reader, port, mac string, jsonOutput bool) error {
+	log.Info("→ Reading credentials from stdin...")
+	creds, err := readStdinCredentials(r)
+	if err != nil {
+		return err
+	}
+	log.Infof("  ✓ Device ID: %s\n", creds.DeviceID)
+
+	resp := &api.ProvisionResponse{
+		DeviceID:   creds.DeviceID,
+		MACAddress: mac,
+		Secret:     creds.Secret,
+	}
+	return flashIssued(tool, resp, port, jsonOutput, "✓ Device flashed with credentials from stdin!")
+}
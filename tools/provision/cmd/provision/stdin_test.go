@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadStdinCredentials(t *testing.T) {
+	creds, err := readStdinCredentials(strings.NewReader(`{"device_id": " device-123 ", "secret": "secret-456"}` + "\n"))
+	if err != nil {
+		t.Fatalf("readStdinCredentials() error = %v", err)
+	}
+	if creds.DeviceID != "device-123" || creds.Secret != "secret-456" {
+		t.Errorf("readStdinCredentials() = %+v, want device-123/secret-456", *creds)
+	}
+}
+
+func TestReadStdinCredentialsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{name: "missing secret", input: `{"device_id": "device-123"}`, wantErr: "missing secret"},
+		{name: "missing both", input: `{}`, wantErr: "missing device_id and secret"},
+		{name: "blank device ID", input: `{"device_id": "  ", "secret": "s"}`, wantErr: "missing device_id"},
+		{name: "invalid JSON", input: `{"device_id": "device-123",`, wantErr: "invalid credentials JSON"},
+		{name: "unknown field", input: `{"device_id": "d", "secret": "s", "mac": "x"}`, wantErr: "invalid credentials JSON"},
+		{name: "two objects", input: `{"device_id": "d", "secret": "s"} {"device_id": "e", "secret": "t"}`, wantErr: "single object"},
+		{name: "empty", input: "", wantErr: "no credentials on stdin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readStdinCredentials(strings.NewReader(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("readStdinCredentials(%q) error = %v, want %q", tt.input, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,10 +1,13 @@
 module measurement-probe/tools/provision
 
-go 1.21
+go 1.23.0
 
-require go.bug.st/serial v1.6.2
+require (
+	go.bug.st/serial v1.6.2
+	golang.org/x/crypto v0.41.0
+)
 
 require (
 	github.com/creack/goselect v0.1.2 // indirect
-	golang.org/x/sys v0.0.0-20220829200755-d48e67d00261 // indirect
+	golang.org/x/sys v0.35.0 // indirect
 )
@@ -2,10 +2,20 @@ package api
 
 import (
 	"bytes"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 )
 
@@ -17,66 +27,435 @@ type ProvisionResponse struct {
 	DeviceID   string `json:"device_id"`
 	MACAddress string `json:"mac_address"`
 	Secret     string `json:"secret"`
+	// CreatedAt and Region (the assigned ingest region) are only sent by
+	// newer backends and are zero when absent.
+	CreatedAt time.Time `json:"-"`
+	Region    string    `json:"region"`
+	// BadCreatedAt is a created_at the client could not parse, leaving
+	// CreatedAt zero. It is informational, so it never fails the decode.
+	BadCreatedAt string `json:"-"`
 }
 
+func (r *ProvisionResponse) UnmarshalJSON(data []byte) error {
+	type fields ProvisionResponse
+	aux := struct {
+		*fields
+		CreatedAt json.RawMessage `json:"created_at"`
+	}{fields: (*fields)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	r.CreatedAt, r.BadCreatedAt = parseTimestamp(aux.CreatedAt)
+	return nil
+}
+
+// timestampLayouts are the created_at formats accepted, most likely first.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+}
+
+// parseTimestamp leniently decodes an informational timestamp: a string in
+// one of timestampLayouts (UTC when it has no zone) or a number of Unix
+// seconds. Null, an empty string or a missing field give the zero time. An
+// unparseable value gives the zero time and the raw value, for a warning.
+func parseTimestamp(raw json.RawMessage) (time.Time, string) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return time.Time{}, ""
+	}
+
+	var seconds float64
+	if err := json.Unmarshal(raw, &seconds); err == nil {
+		sec := int64(seconds)
+		return time.Unix(sec, int64((seconds-float64(sec))*1e9)).UTC(), ""
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return time.Time{}, string(raw)
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, ""
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, ""
+		}
+	}
+	return time.Time{}, s
+}
+
+// APIError is returned when the backend answers with an unexpected status.
+// Use errors.As to inspect StatusCode, e.g. to skip devices that are already
+// provisioned (409) but abort on server errors.
+type APIError struct {
+	StatusCode int
+	Body       string
+	msg        string
+}
+
+func (e *APIError) Error() string {
+	if e.msg != "" {
+		return e.msg
+	}
+	return fmt.Sprintf("request failed (status %d): %s", e.StatusCode, e.Body)
+}
+
+// RetryPolicy controls how failed requests are retried. Lookups and
+// provisioning (which carries an Idempotency-Key, so the backend can answer a
+// repeat with the credentials it already issued) are retried on network errors
+// and 5xx responses. Other requests are only retried when they cannot have
+// reached the backend: a failed connection or a 503 from the Cloud Run front
+// end during a cold start. Other statuses (e.g. 409) are returned at once.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry. It doubles with each
+	// retry, up to MaxDelay, and is jittered to between half and all of it.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultRetryPolicy rides out a Cloud Run cold start.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    8 * time.Second,
+}
+
+// NoRetry makes a single attempt.
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
 type Client struct {
 	baseURL    string
 	authToken  string
 	httpClient *http.Client
+	retry      RetryPolicy
+	sleep      func(time.Duration)
 }
 
 func NewClient(baseURL, authToken string) *Client {
+	client, _ := NewClientWithCA(baseURL, authToken, "")
+	return client
+}
+
+// NewClientWithCA creates a client that also trusts the PEM CA bundle at
+// caCertPath, for TLS-terminating gateways. An empty path uses the system
+// roots. Proxies are taken from HTTPS_PROXY / NO_PROXY.
+func NewClientWithCA(baseURL, authToken, caCertPath string) (*Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+
+	if caCertPath != "" {
+		pem, err := os.ReadFile(caCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("read CA bundle: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caCertPath)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
 	return &Client{
 		baseURL:   baseURL,
 		authToken: authToken,
 		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout:   30 * time.Second,
+			Transport: transport,
 		},
+		retry: DefaultRetryPolicy,
+		sleep: time.Sleep,
+	}, nil
+}
+
+// SetRetryPolicy replaces the client's retry policy (DefaultRetryPolicy).
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	c.retry = policy
+}
+
+// backoff returns the jittered delay before retry number n (starting at 1).
+func (p RetryPolicy) backoff(n int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < n && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
 	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
 }
 
+// ProvisionDevice registers macAddress and returns the issued credentials.
+// Every attempt carries the same Idempotency-Key header, so retries after a
+// 5xx or a lost response don't register the device twice.
 func (c *Client) ProvisionDevice(macAddress string) (*ProvisionResponse, error) {
-	reqBody := ProvisionRequest{
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return nil, err
+	}
+	status, body, err := c.do(http.MethodPost, "/admin/devices/provision", ProvisionRequest{
 		MACAddress: macAddress,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if status == http.StatusConflict {
+		return nil, &APIError{
+			StatusCode: status,
+			Body:       string(body),
+			msg:        fmt.Sprintf("device already provisioned (MAC: %s)", macAddress),
+		}
+	}
+
+	if status != http.StatusCreated {
+		return nil, &APIError{
+			StatusCode: status,
+			Body:       string(body),
+			msg:        fmt.Sprintf("provision failed (status %d): %s", status, string(body)),
+		}
 	}
 
-	jsonBody, err := json.Marshal(reqBody)
+	var provResp ProvisionResponse
+	if err := json.Unmarshal(body, &provResp); err != nil {
+		return nil, fmt.Errorf("parse response: %w", err)
+	}
+
+	return &provResp, nil
+}
+
+// RotateSecret issues a new secret for an already provisioned device, keeping
+// its device ID. The old secret stops working once the device re-authenticates.
+func (c *Client) RotateSecret(deviceID string) (*ProvisionResponse, error) {
+	status, body, err := c.do(http.MethodPost, "/admin/devices/"+url.PathEscape(deviceID)+"/rotate", nil, "")
 	if err != nil {
-		return nil, fmt.Errorf("marshal request: %w", err)
+		return nil, err
+	}
+
+	if status == http.StatusNotFound {
+		return nil, &APIError{
+			StatusCode: status,
+			Body:       string(body),
+			msg:        fmt.Sprintf("device %s not found", deviceID),
+		}
 	}
 
-	url := c.baseURL + "/admin/devices/provision"
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(jsonBody))
+	if status != http.StatusOK && status != http.StatusCreated {
+		return nil, &APIError{
+			StatusCode: status,
+			Body:       string(body),
+			msg:        fmt.Sprintf("rotate failed (status %d): %s", status, string(body)),
+		}
+	}
+
+	var rotResp ProvisionResponse
+	if err := json.Unmarshal(body, &rotResp); err != nil {
+		return nil, fmt.Errorf("parse response: %w", err)
+	}
+	if rotResp.DeviceID == "" {
+		rotResp.DeviceID = deviceID
+	}
+
+	return &rotResp, nil
+}
+
+// Device is a provisioned device as returned by GetDeviceByMAC. The secret is
+// never returned.
+type Device struct {
+	DeviceID   string    `json:"device_id"`
+	MACAddress string    `json:"mac_address"`
+	CreatedAt  time.Time `json:"-"` // zero when absent or unparseable
+	Region     string    `json:"region"`
+}
+
+func (d *Device) UnmarshalJSON(data []byte) error {
+	type fields Device
+	aux := struct {
+		*fields
+		CreatedAt json.RawMessage `json:"created_at"`
+	}{fields: (*fields)(d)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	d.CreatedAt, _ = parseTimestamp(aux.CreatedAt)
+	return nil
+}
+
+// ErrDeviceNotFound is returned by GetDeviceByMAC when no device has the MAC.
+var ErrDeviceNotFound = errors.New("device not found")
+
+// ErrLookupUnsupported is returned by GetDeviceByMAC when the backend doesn't
+// serve the by-MAC lookup at all, so nothing can be said about the device.
+var ErrLookupUnsupported = errors.New("backend does not support GET /admin/devices/by-mac/{mac} - update the backend")
+
+// GetDeviceByMAC looks up the device provisioned for macAddress with
+// GET /admin/devices/by-mac/{mac}. It returns ErrDeviceNotFound if the MAC
+// has not been provisioned, which the backend answers with a 404 and a JSON
+// error naming the device, e.g. {"error": "device not found"}. Any other 404,
+// such as a router's "404 page not found", means the route is missing and
+// gives ErrLookupUnsupported.
+func (c *Client) GetDeviceByMAC(macAddress string) (*Device, error) {
+	status, body, err := c.do(http.MethodGet, "/admin/devices/by-mac/"+url.PathEscape(macAddress), nil, "")
 	if err != nil {
-		return nil, fmt.Errorf("create request: %w", err)
+		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	if status == http.StatusNotFound {
+		if isDeviceNotFound(body) {
+			return nil, ErrDeviceNotFound
+		}
+		return nil, fmt.Errorf("%w (404: %s)", ErrLookupUnsupported, strings.TrimSpace(string(body)))
+	}
 
-	resp, err := c.httpClient.Do(req)
+	if status != http.StatusOK {
+		return nil, &APIError{
+			StatusCode: status,
+			Body:       string(body),
+			msg:        fmt.Sprintf("device lookup failed (status %d): %s", status, string(body)),
+		}
+	}
+
+	var device Device
+	if err := json.Unmarshal(body, &device); err != nil {
+		return nil, fmt.Errorf("parse response: %w", err)
+	}
+	return &device, nil
+}
+
+// isDeviceNotFound reports whether a 404 body is the backend's JSON error
+// for an unknown device rather than a missing route.
+func isDeviceNotFound(body []byte) bool {
+	var payload map[string]any
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return false
+	}
+	for _, key := range []string{"error", "message", "detail"} {
+		if msg, ok := payload[key].(string); ok && strings.Contains(strings.ToLower(msg), "device") {
+			return true
+		}
+	}
+	return false
+}
+
+// newIdempotencyKey returns a random key identifying one logical request
+// across its retries.
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := crand.Read(b); err != nil {
+		return "", fmt.Errorf("generate idempotency key: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// do sends payload as JSON (or an empty body when nil) to path and returns
+// the status code and response body. A non-empty idempotencyKey is sent as
+// the Idempotency-Key header. Failed attempts are retried according to the
+// client's retry policy where shouldRetry allows it.
+func (c *Client) do(method, path string, payload any, idempotencyKey string) (int, []byte, error) {
+	var jsonBody []byte
+	if payload != nil {
+		var err error
+		if jsonBody, err = json.Marshal(payload); err != nil {
+			return 0, nil, fmt.Errorf("marshal request: %w", err)
+		}
+	}
+
+	attempts := c.retry.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	idempotent := method == http.MethodGet || idempotencyKey != ""
+	var (
+		status int
+		body   []byte
+		err    error
+	)
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			c.sleep(c.retry.backoff(attempt - 1))
+		}
+		status, body, err = c.doOnce(method, path, jsonBody, idempotencyKey)
+		if !shouldRetry(idempotent, status, err) {
+			break
+		}
+	}
+	return status, body, err
+}
+
+// shouldRetry reports whether a failed attempt may be repeated. Idempotent
+// requests are repeated on any transient failure; others only if they never
+// reached the backend, i.e. the connection failed or Cloud Run answered 503
+// while starting an instance.
+func shouldRetry(idempotent bool, status int, err error) bool {
 	if err != nil {
-		return nil, fmt.Errorf("execute request: %w", err)
+		if isDialError(err) {
+			return true
+		}
+		return idempotent && retryable(err)
 	}
-	defer resp.Body.Close()
+	if status == http.StatusServiceUnavailable {
+		return true
+	}
+	return idempotent && status >= http.StatusInternalServerError
+}
 
-	body, err := io.ReadAll(resp.Body)
+// isDialError reports whether err happened while connecting (DNS lookup or
+// TCP connect), before any of the request was sent.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// retryable reports whether a transport error may succeed on another attempt.
+// Certificate problems will not go away by themselves.
+func retryable(err error) bool {
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostErr x509.HostnameError
+	return !errors.As(err, &verifyErr) && !errors.As(err, &authorityErr) && !errors.As(err, &hostErr)
+}
+
+// doOnce makes a single request attempt with jsonBody (no body when nil).
+func (c *Client) doOnce(method, path string, jsonBody []byte, idempotencyKey string) (int, []byte, error) {
+	var reqBody io.Reader = http.NoBody
+	if jsonBody != nil {
+		reqBody = bytes.NewReader(jsonBody)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("read response: %w", err)
+		return 0, nil, fmt.Errorf("create request: %w", err)
 	}
 
-	if resp.StatusCode == http.StatusConflict {
-		return nil, fmt.Errorf("device already provisioned (MAC: %s)", macAddress)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.authToken)
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
 	}
 
-	if resp.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("provision failed (status %d): %s", resp.StatusCode, string(body))
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("execute request: %w", err)
 	}
+	defer resp.Body.Close()
 
-	var provResp ProvisionResponse
-	if err := json.Unmarshal(body, &provResp); err != nil {
-		return nil, fmt.Errorf("parse response: %w", err)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("read response: %w", err)
 	}
 
-	return &provResp, nil
+	return resp.StatusCode, body, nil
 }
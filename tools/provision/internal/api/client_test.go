@@ -2,9 +2,16 @@ package api
 
 import (
 	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestProvisionDevice(t *testing.T) {
@@ -62,8 +69,15 @@ func TestProvisionDevice(t *testing.T) {
 		client := NewClient(server.URL, "token")
 		_, err := client.ProvisionDevice("aa:bb:cc:dd:ee:ff")
 
-		if err == nil {
-			t.Error("expected error for conflict")
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("error = %v, want *APIError", err)
+		}
+		if apiErr.StatusCode != http.StatusConflict {
+			t.Errorf("StatusCode = %d, want %d", apiErr.StatusCode, http.StatusConflict)
+		}
+		if err.Error() != "device already provisioned (MAC: aa:bb:cc:dd:ee:ff)" {
+			t.Errorf("error = %q", err.Error())
 		}
 	})
 
@@ -75,10 +89,396 @@ func TestProvisionDevice(t *testing.T) {
 		defer server.Close()
 
 		client := NewClient(server.URL, "token")
+		client.SetRetryPolicy(NoRetry)
 		_, err := client.ProvisionDevice("aa:bb:cc:dd:ee:ff")
 
+		var apiErr *APIError
+		if !errors.As(fmt.Errorf("provision: %w", err), &apiErr) {
+			t.Fatalf("error = %v, want wrapped *APIError", err)
+		}
+		if apiErr.StatusCode != http.StatusInternalServerError {
+			t.Errorf("StatusCode = %d, want %d", apiErr.StatusCode, http.StatusInternalServerError)
+		}
+		if apiErr.Body != "internal error" {
+			t.Errorf("Body = %q, want %q", apiErr.Body, "internal error")
+		}
+		if err.Error() != "provision failed (status 500): internal error" {
+			t.Errorf("error = %q", err.Error())
+		}
+	})
+}
+
+func TestProvisionDeviceMetadata(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		wantCreatedAt time.Time
+		wantRegion    string
+		wantBad       string
+	}{
+		{
+			name:          "current backend",
+			body:          `{"device_id":"device-123","mac_address":"aa:bb:cc:dd:ee:ff","secret":"s","created_at":"2026-03-01T12:30:00Z","region":"europe-west1"}`,
+			wantCreatedAt: time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC),
+			wantRegion:    "europe-west1",
+		},
+		{
+			name: "older backend",
+			body: `{"device_id":"device-123","mac_address":"aa:bb:cc:dd:ee:ff","secret":"s"}`,
+		},
+		{
+			name:          "timestamp without zone",
+			body:          `{"device_id":"device-123","secret":"s","created_at":"2026-03-01 12:30:00"}`,
+			wantCreatedAt: time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC),
+		},
+		{
+			name:          "unix seconds",
+			body:          `{"device_id":"device-123","secret":"s","created_at":1772368200}`,
+			wantCreatedAt: time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC),
+		},
+		{
+			name:       "unparseable timestamp keeps the credentials",
+			body:       `{"device_id":"device-123","secret":"s","created_at":"01/03/2026 12:30","region":"europe-west1"}`,
+			wantRegion: "europe-west1",
+			wantBad:    "01/03/2026 12:30",
+		},
+		{
+			name:    "timestamp of the wrong type",
+			body:    `{"device_id":"device-123","secret":"s","created_at":{"seconds":1}}`,
+			wantBad: `{"seconds":1}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			resp, err := NewClient(server.URL, "token").ProvisionDevice("aa:bb:cc:dd:ee:ff")
+			if err != nil {
+				t.Fatalf("ProvisionDevice() error = %v", err)
+			}
+			if resp.DeviceID != "device-123" {
+				t.Errorf("DeviceID = %s, want device-123", resp.DeviceID)
+			}
+			if !resp.CreatedAt.Equal(tt.wantCreatedAt) {
+				t.Errorf("CreatedAt = %v, want %v", resp.CreatedAt, tt.wantCreatedAt)
+			}
+			if resp.Region != tt.wantRegion {
+				t.Errorf("Region = %q, want %q", resp.Region, tt.wantRegion)
+			}
+			if resp.Secret != "s" || resp.BadCreatedAt != tt.wantBad {
+				t.Errorf("Secret = %q, BadCreatedAt = %q, want s and %q", resp.Secret, resp.BadCreatedAt, tt.wantBad)
+			}
+		})
+	}
+}
+
+func TestGetDeviceByMAC(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("unexpected method: %s", r.Method)
+		}
+		switch r.URL.Path {
+		case "/admin/devices/by-mac/aa:bb:cc:dd:ee:ff":
+			w.Write([]byte(`{"device_id":"device-123","mac_address":"aa:bb:cc:dd:ee:ff"}`))
+		case "/admin/devices/by-mac/11:22:33:44:55:66":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"device not found"}`))
+		case "/admin/devices/by-mac/22:22:22:22:22:22":
+			http.NotFound(w, r)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("boom"))
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "token")
+	client.SetRetryPolicy(NoRetry)
+
+	device, err := client.GetDeviceByMAC("aa:bb:cc:dd:ee:ff")
+	if err != nil {
+		t.Fatalf("GetDeviceByMAC() error = %v", err)
+	}
+	if device.DeviceID != "device-123" {
+		t.Errorf("DeviceID = %s, want device-123", device.DeviceID)
+	}
+
+	if _, err := client.GetDeviceByMAC("11:22:33:44:55:66"); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("GetDeviceByMAC(unknown) error = %v, want ErrDeviceNotFound", err)
+	}
+
+	// A route the backend doesn't have must not read as "not provisioned"
+	if _, err := client.GetDeviceByMAC("22:22:22:22:22:22"); !errors.Is(err, ErrLookupUnsupported) || errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("GetDeviceByMAC(missing route) error = %v, want ErrLookupUnsupported", err)
+	}
+
+	var apiErr *APIError
+	if _, err := client.GetDeviceByMAC("00:00:00:00:00:00"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("GetDeviceByMAC(server error) error = %v, want *APIError 500", err)
+	}
+}
+
+func TestRotateSecret(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/admin/devices/device-123/rotate" {
+				t.Errorf("unexpected path: %s", r.URL.Path)
+			}
+			if r.Method != http.MethodPost {
+				t.Errorf("unexpected method: %s", r.Method)
+			}
+			if r.Header.Get("Authorization") != "Bearer test-token" {
+				t.Errorf("unexpected auth header: %s", r.Header.Get("Authorization"))
+			}
+
+			json.NewEncoder(w).Encode(ProvisionResponse{
+				DeviceID: "device-123",
+				Secret:   "new-secret",
+			})
+		}))
+		defer server.Close()
+
+		client := NewClient(server.URL, "test-token")
+		resp, err := client.RotateSecret("device-123")
+		if err != nil {
+			t.Fatalf("RotateSecret() error = %v", err)
+		}
+		if resp.DeviceID != "device-123" || resp.Secret != "new-secret" {
+			t.Errorf("RotateSecret() = %+v", *resp)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"not found"}`))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.URL, "test-token")
+		_, err := client.RotateSecret("missing-device")
 		if err == nil {
-			t.Error("expected error for server error")
+			t.Fatal("RotateSecret() expected error")
+		}
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+			t.Fatalf("error = %v, want *APIError with status 404", err)
+		}
+		if err.Error() != "device missing-device not found" {
+			t.Errorf("error = %q", err.Error())
+		}
+	})
+
+	t.Run("server error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("boom"))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.URL, "test-token")
+		client.SetRetryPolicy(NoRetry)
+		_, err := client.RotateSecret("device-123")
+		if err == nil || err.Error() != "rotate failed (status 500): boom" {
+			t.Errorf("error = %v", err)
+		}
+	})
+}
+
+func TestClientRetry(t *testing.T) {
+	provision := func(c *Client) error {
+		_, err := c.ProvisionDevice("aa:bb:cc:dd:ee:ff")
+		return err
+	}
+	lookup := func(c *Client) error {
+		_, err := c.GetDeviceByMAC("aa:bb:cc:dd:ee:ff")
+		return err
+	}
+
+	tests := []struct {
+		name         string
+		call         func(*Client) error
+		statuses     []int // response per attempt; the last one repeats
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"provision cold start then created", provision, []int{http.StatusServiceUnavailable, http.StatusCreated}, 2, false},
+		{"provision persistent server error", provision, []int{http.StatusInternalServerError}, 3, true},
+		{"provision conflict is not retried", provision, []int{http.StatusConflict}, 1, true},
+		{"lookup cold start then found", lookup, []int{http.StatusServiceUnavailable, http.StatusOK}, 2, false},
+		{"lookup persistent server error", lookup, []int{http.StatusInternalServerError}, 3, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int
+			var bodies, keys []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				keys = append(keys, r.Header.Get("Idempotency-Key"))
+
+				status := tt.statuses[min(attempts, len(tt.statuses)-1)]
+				attempts++
+				w.WriteHeader(status)
+				switch status {
+				case http.StatusCreated:
+					json.NewEncoder(w).Encode(ProvisionResponse{DeviceID: "device-123", Secret: "secret-456"})
+				case http.StatusOK:
+					json.NewEncoder(w).Encode(Device{DeviceID: "device-123"})
+				}
+			}))
+			defer server.Close()
+
+			client := NewClient(server.URL, "token")
+			client.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 4 * time.Second})
+			var delays []time.Duration
+			client.sleep = func(d time.Duration) { delays = append(delays, d) }
+
+			err := tt.call(client)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+			if len(delays) != tt.wantAttempts-1 {
+				t.Errorf("slept %d times, want %d", len(delays), tt.wantAttempts-1)
+			}
+			for i, d := range delays {
+				limit := time.Second << i
+				if d < limit/2 || d > limit {
+					t.Errorf("delay %d = %v, want between %v and %v", i+1, d, limit/2, limit)
+				}
+			}
+			for i := range bodies {
+				if bodies[i] != bodies[0] {
+					t.Errorf("attempt %d body = %q, want %q", i+1, bodies[i], bodies[0])
+				}
+				if keys[i] != keys[0] {
+					t.Errorf("attempt %d Idempotency-Key = %q, want %q", i+1, keys[i], keys[0])
+				}
+			}
+		})
+	}
+}
+
+func TestProvisionDeviceIdempotencyKey(t *testing.T) {
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(ProvisionResponse{DeviceID: "device-123", Secret: "secret-456"})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "token")
+	for range 2 {
+		if _, err := client.ProvisionDevice("aa:bb:cc:dd:ee:ff"); err != nil {
+			t.Fatalf("ProvisionDevice() error = %v", err)
+		}
+	}
+	if keys[0] == "" || keys[0] == keys[1] {
+		t.Errorf("Idempotency-Key = %q, want a fresh key per provision", keys)
+	}
+}
+
+func TestRotateSecretRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		wantAttempts int
+	}{
+		// Cloud Run answers 503 before the request reaches the app
+		{"cold start is retried", http.StatusServiceUnavailable, 3},
+		// The backend may have rotated the secret before failing
+		{"server error is not retried", http.StatusInternalServerError, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			client := NewClient(server.URL, "token")
+			client.SetRetryPolicy(RetryPolicy{MaxAttempts: 3})
+			client.sleep = func(time.Duration) {}
+
+			if _, err := client.RotateSecret("device-123"); err == nil {
+				t.Fatal("RotateSecret() succeeded")
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRotateSecretRetriesDialErrors(t *testing.T) {
+	// A closed server refuses connections, so the request never reaches it
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	client := NewClient(server.URL, "token")
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 3})
+	var sleeps int
+	client.sleep = func(time.Duration) { sleeps++ }
+
+	if _, err := client.RotateSecret("device-123"); err == nil {
+		t.Fatal("RotateSecret() succeeded against a closed server")
+	}
+	if sleeps != 2 {
+		t.Errorf("slept %d times, want 2 (connection errors are retried)", sleeps)
+	}
+}
+
+func TestNewClientWithCA(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(ProvisionResponse{DeviceID: "device-123", Secret: "secret-456"})
+	}))
+	defer server.Close()
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caPath, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("system roots", func(t *testing.T) {
+		client := NewClient(server.URL, "token")
+		if _, err := client.ProvisionDevice("aa:bb:cc:dd:ee:ff"); err == nil {
+			t.Error("expected TLS verification error for self-signed server")
+		}
+	})
+
+	t.Run("custom CA", func(t *testing.T) {
+		client, err := NewClientWithCA(server.URL, "token", caPath)
+		if err != nil {
+			t.Fatalf("NewClientWithCA() error = %v", err)
+		}
+		resp, err := client.ProvisionDevice("aa:bb:cc:dd:ee:ff")
+		if err != nil {
+			t.Fatalf("ProvisionDevice() error = %v", err)
+		}
+		if resp.DeviceID != "device-123" {
+			t.Errorf("DeviceID = %s, want device-123", resp.DeviceID)
+		}
+	})
+
+	t.Run("missing bundle", func(t *testing.T) {
+		if _, err := NewClientWithCA(server.URL, "token", filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+			t.Error("expected error for missing CA bundle")
 		}
 	})
 }
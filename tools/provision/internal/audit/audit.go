@@ -0,0 +1,46 @@
+// Package audit records provisioning events to an append-only log.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Record is a single provisioning event. It never carries the device secret.
+type Record struct {
+	Timestamp time.Time `json:"timestamp"`
+	MAC       string    `json:"mac"`
+	DeviceID  string    `json:"device_id,omitempty"`
+	Backend   string    `json:"backend"`
+	Success   bool      `json:"success"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Append writes the record as one line of newline-delimited JSON to path.
+// The file is opened in append mode and the line is written with a single
+// call, so concurrent runs never interleave partial records.
+func Append(path string, rec Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal record: %w", err)
+	}
+	line = append(line, '\n')
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create log directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("open log: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(line); err != nil {
+		return fmt.Errorf("write log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "provision-log.ndjson")
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	records := []Record{
+		{Timestamp: ts, MAC: "aa:bb:cc:dd:ee:ff", DeviceID: "device-1", Backend: "https://a.run.app", Success: true},
+		{Timestamp: ts, MAC: "11:22:33:44:55:66", Backend: "https://a.run.app", Error: "device already provisioned"},
+	}
+	for _, rec := range records {
+		if err := Append(path, rec); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+
+	var first Record
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("unmarshal first record: %v", err)
+	}
+	if first != records[0] {
+		t.Errorf("first record = %+v, want %+v", first, records[0])
+	}
+
+	var second map[string]any
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatalf("unmarshal second record: %v", err)
+	}
+	if second["success"] != false {
+		t.Errorf("success = %v, want false", second["success"])
+	}
+	if _, ok := second["device_id"]; ok {
+		t.Error("device_id should be omitted when empty")
+	}
+	if strings.Contains(string(content), "secret") {
+		t.Error("log must never contain a secret field")
+	}
+}
+
+func TestAppend_Concurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "provision-log.ndjson")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := Append(path, Record{MAC: "aa:bb:cc:dd:ee:ff", Success: true}); err != nil {
+				t.Errorf("Append() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Errorf("line %d is not valid JSON: %v", count+1, err)
+		}
+		count++
+	}
+	if count != 20 {
+		t.Errorf("got %d records, want 20", count)
+	}
+}
@@ -0,0 +1,301 @@
+// Package backup stores and loads local copies of issued device credentials.
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Credentials is the on-disk backup format for a device.
+type Credentials struct {
+	DeviceID  string     `json:"device_id"`
+	Secret    string     `json:"secret"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	Region    string     `json:"region,omitempty"`
+}
+
+// PreviousSecret is a secret that was replaced by Rotate.
+type PreviousSecret struct {
+	Secret    string    `json:"secret"`
+	RotatedAt time.Time `json:"rotated_at"`
+}
+
+// record is the backup file layout: the current credentials plus the secrets
+// they replaced, oldest first.
+type record struct {
+	Credentials
+	History []PreviousSecret `json:"history,omitempty"`
+}
+
+// Path returns the backup file path for a device in dir.
+func Path(dir, deviceID string) string {
+	return filepath.Join(dir, deviceID+".json")
+}
+
+// Store reads and writes the backups in Dir. With Encrypt set, backups are
+// written encrypted with Passphrase. Encrypted backups are decrypted
+// transparently on load when Passphrase is set.
+type Store struct {
+	Dir        string
+	Passphrase string
+	Encrypt    bool
+}
+
+// Save writes the credentials to dir, readable only by the current user.
+// It returns the path of the written file.
+func Save(dir string, creds *Credentials) (string, error) {
+	return Store{Dir: dir}.Save(creds)
+}
+
+// Rotate replaces the secret in the backup for deviceID in dir. See
+// Store.Rotate.
+func Rotate(dir, deviceID, newSecret string, at time.Time) (string, error) {
+	return Store{Dir: dir}.Rotate(deviceID, newSecret, at)
+}
+
+// History returns the secrets previously replaced in the backup for
+// deviceID in dir, oldest first.
+func History(dir, deviceID string) ([]PreviousSecret, error) {
+	return Store{Dir: dir}.History(deviceID)
+}
+
+// Load reads and validates the backup for deviceID from dir.
+func Load(dir, deviceID string) (*Credentials, error) {
+	return Store{Dir: dir}.Load(deviceID)
+}
+
+// Save writes the credentials, readable only by the current user. It returns
+// the path of the written file.
+func (s Store) Save(creds *Credentials) (string, error) {
+	if err := validateDeviceID(creds.DeviceID); err != nil {
+		return "", err
+	}
+
+	return s.write(&record{Credentials: *creds}, s.Encrypt)
+}
+
+// Rotate replaces the secret in the backup for deviceID with newSecret and
+// appends the old one to the backup's history. Without an existing backup a
+// new one is written. An encrypted backup stays encrypted. It returns the
+// path of the written file.
+func (s Store) Rotate(deviceID, newSecret string, at time.Time) (string, error) {
+	if err := validateDeviceID(deviceID); err != nil {
+		return "", err
+	}
+
+	rec, encrypted, err := s.readRecord(deviceID)
+	if os.IsNotExist(err) {
+		rec, err = &record{Credentials: Credentials{DeviceID: deviceID}}, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if rec.Secret != "" {
+		rec.History = append(rec.History, PreviousSecret{Secret: rec.Secret, RotatedAt: at.UTC()})
+	}
+	rec.Secret = newSecret
+	return s.write(rec, s.Encrypt || encrypted)
+}
+
+// CheckRotate reports whether Rotate could update the backup for deviceID:
+// an existing backup must be readable, and an encrypted one (or any with
+// Encrypt set) needs the passphrase. Check before asking the backend for a new
+// secret, which revokes the old one.
+func (s Store) CheckRotate(deviceID string) error {
+	if err := validateDeviceID(deviceID); err != nil {
+		return err
+	}
+
+	_, encrypted, err := s.readRecord(deviceID)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if (s.Encrypt || encrypted) && s.Passphrase == "" {
+		return ErrNoPassphrase
+	}
+	return nil
+}
+
+// History returns the secrets previously replaced in the backup for
+// deviceID, oldest first.
+func (s Store) History(deviceID string) ([]PreviousSecret, error) {
+	if err := validateDeviceID(deviceID); err != nil {
+		return nil, err
+	}
+	rec, _, err := s.readRecord(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return rec.History, nil
+}
+
+// Load reads and validates the backup for deviceID.
+func (s Store) Load(deviceID string) (*Credentials, error) {
+	if err := validateDeviceID(deviceID); err != nil {
+		return nil, err
+	}
+
+	path := Path(s.Dir, deviceID)
+	rec, _, err := s.readRecord(deviceID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no backup for device %s (looked for %s)", deviceID, path)
+		}
+		return nil, err
+	}
+	creds := rec.Credentials
+	if err := validate(path, deviceID, &creds); err != nil {
+		return nil, err
+	}
+
+	return &creds, nil
+}
+
+func validate(path, deviceID string, creds *Credentials) error {
+	if creds.DeviceID == "" {
+		return fmt.Errorf("backup %s is missing device_id", path)
+	}
+	if creds.Secret == "" {
+		return fmt.Errorf("backup %s is missing secret", path)
+	}
+	if creds.DeviceID != deviceID {
+		return fmt.Errorf("backup %s is for device %s, not %s", path, creds.DeviceID, deviceID)
+	}
+	return nil
+}
+
+func (s Store) write(rec *record, encrypt bool) (string, error) {
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return "", fmt.Errorf("create backup directory: %w", err)
+	}
+
+	content, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal backup: %w", err)
+	}
+	if encrypt {
+		if s.Passphrase == "" {
+			return "", ErrNoPassphrase
+		}
+		if content, err = seal(content, s.Passphrase); err != nil {
+			return "", err
+		}
+	}
+	content = append(content, '\n')
+
+	path := Path(s.Dir, rec.DeviceID)
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		return "", fmt.Errorf("write backup: %w", err)
+	}
+	return path, nil
+}
+
+// readRecord reads the backup file for deviceID, decrypting it if needed,
+// and reports whether it was encrypted. A missing file is reported with an
+// error satisfying os.IsNotExist.
+func (s Store) readRecord(deviceID string) (*record, bool, error) {
+	path := Path(s.Dir, deviceID)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, err
+		}
+		return nil, false, fmt.Errorf("read backup: %w", err)
+	}
+
+	encrypted := isEncrypted(content)
+	if encrypted {
+		if s.Passphrase == "" {
+			return nil, true, fmt.Errorf("backup %s: %w", path, ErrNoPassphrase)
+		}
+		if content, err = unseal(content, s.Passphrase); err != nil {
+			return nil, true, fmt.Errorf("backup %s: %w", path, err)
+		}
+	}
+
+	var rec record
+	if err := json.Unmarshal(content, &rec); err != nil {
+		return nil, encrypted, fmt.Errorf("parse backup %s: %w", path, err)
+	}
+	return &rec, encrypted, nil
+}
+
+// Entry describes one backup file found by List.
+type Entry struct {
+	DeviceID  string
+	Path      string
+	ModTime   time.Time
+	Encrypted bool
+}
+
+// List returns the backups in the store, most recently modified first.
+// Files that cannot be read or parsed are skipped and reported in skipped.
+// Encrypted backups are listed by file name when no passphrase is set.
+func (s Store) List() (entries []Entry, skipped []error, err error) {
+	files, err := os.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("read backup directory: %w", err)
+	}
+
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		deviceID := strings.TrimSuffix(name, ".json")
+
+		entry, err := s.entry(deviceID)
+		if err != nil {
+			skipped = append(skipped, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].ModTime.Equal(entries[j].ModTime) {
+			return entries[i].ModTime.After(entries[j].ModTime)
+		}
+		return entries[i].DeviceID < entries[j].DeviceID
+	})
+	return entries, skipped, nil
+}
+
+func (s Store) entry(deviceID string) (Entry, error) {
+	path := Path(s.Dir, deviceID)
+	info, err := os.Stat(path)
+	if err != nil {
+		return Entry{}, fmt.Errorf("read backup: %w", err)
+	}
+	entry := Entry{DeviceID: deviceID, Path: path, ModTime: info.ModTime()}
+
+	rec, encrypted, err := s.readRecord(deviceID)
+	entry.Encrypted = encrypted
+	if errors.Is(err, ErrNoPassphrase) {
+		return entry, nil
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	if err := validate(path, deviceID, &rec.Credentials); err != nil {
+		return Entry{}, err
+	}
+	return entry, nil
+}
+
+func validateDeviceID(deviceID string) error {
+	if deviceID == "" || deviceID != filepath.Base(deviceID) || deviceID == "." || deviceID == ".." {
+		return fmt.Errorf("invalid device ID %q", deviceID)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	creds := &Credentials{DeviceID: "device-123", Secret: "secret-456"}
+
+	path, err := Save(dir, creds)
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if path != filepath.Join(dir, "device-123.json") {
+		t.Errorf("Save() path = %s", path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("backup mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	got, err := Load(dir, "device-123")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if *got != *creds {
+		t.Errorf("Load() = %+v, want %+v", *got, *creds)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "valid",
+			content: `{"device_id": "device-123", "secret": "secret-456"}`,
+		},
+		{
+			name:    "missing secret",
+			content: `{"device_id": "device-123"}`,
+			wantErr: "missing secret",
+		},
+		{
+			name:    "missing device_id",
+			content: `{"secret": "secret-456"}`,
+			wantErr: "missing device_id",
+		},
+		{
+			name:    "invalid json",
+			content: `{"device_id":`,
+			wantErr: "parse backup",
+		},
+		{
+			name:    "different device",
+			content: `{"device_id": "device-999", "secret": "secret-456"}`,
+			wantErr: "is for device device-999",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "device-123.json"), []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			creds, err := Load(dir, "device-123")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Load() error = %v", err)
+				}
+				if creds.Secret != "secret-456" {
+					t.Errorf("Secret = %s, want secret-456", creds.Secret)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoad_MissingFile(t *testing.T) {
+	_, err := Load(t.TempDir(), "device-123")
+	if err == nil || !strings.Contains(err.Error(), "no backup for device device-123") {
+		t.Errorf("Load() error = %v, want missing backup error", err)
+	}
+}
+
+func TestLoad_InvalidDeviceID(t *testing.T) {
+	for _, id := range []string{"", "..", "../etc/passwd", "a/b"} {
+		if _, err := Load(t.TempDir(), id); err == nil {
+			t.Errorf("Load(%q) expected error", id)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Save(dir, &Credentials{DeviceID: "device-123", Secret: "secret-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	first := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	second := first.Add(24 * time.Hour)
+	if _, err := Rotate(dir, "device-123", "secret-2", first); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if _, err := Rotate(dir, "device-123", "secret-3", second); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+
+	got, err := Load(dir, "device-123")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got.Secret != "secret-3" {
+		t.Errorf("Secret = %q, want secret-3", got.Secret)
+	}
+
+	history, err := History(dir, "device-123")
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	want := []PreviousSecret{
+		{Secret: "secret-1", RotatedAt: first},
+		{Secret: "secret-2", RotatedAt: second},
+	}
+	if len(history) != len(want) {
+		t.Fatalf("History() = %+v, want %+v", history, want)
+	}
+	for i := range want {
+		if history[i].Secret != want[i].Secret || !history[i].RotatedAt.Equal(want[i].RotatedAt) {
+			t.Errorf("History()[%d] = %+v, want %+v", i, history[i], want[i])
+		}
+	}
+}
+
+func TestRotateWithoutBackup(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := Rotate(dir, "device-123", "secret-1", time.Now()); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+
+	got, err := Load(dir, "device-123")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got.Secret != "secret-1" {
+		t.Errorf("Secret = %q, want secret-1", got.Secret)
+	}
+	history, _ := History(dir, "device-123")
+	if len(history) != 0 {
+		t.Errorf("History() = %+v, want empty", history)
+	}
+}
@@ -0,0 +1,105 @@
+package backup
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	encryptedFormat = "aes-256-gcm+pbkdf2-sha256"
+	kdfIterations   = 600000
+	saltSize        = 16
+	keySize         = 32
+)
+
+// ErrNoPassphrase is returned when an encrypted backup is read or written
+// without a passphrase.
+var ErrNoPassphrase = errors.New("backup is encrypted but no passphrase was given")
+
+// ErrWrongPassphrase is returned when an encrypted backup cannot be decrypted.
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted backup")
+
+// envelope is the on-disk layout of an encrypted backup. The plaintext is the
+// JSON of an unencrypted backup.
+type envelope struct {
+	Format     string `json:"format"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func isEncrypted(content []byte) bool {
+	var env envelope
+	return json.Unmarshal(content, &env) == nil && env.Format != ""
+}
+
+func seal(plaintext []byte, passphrase string) ([]byte, error) {
+	env := envelope{
+		Format:     encryptedFormat,
+		Iterations: kdfIterations,
+		Salt:       make([]byte, saltSize),
+	}
+	if _, err := rand.Read(env.Salt); err != nil {
+		return nil, fmt.Errorf("generate salt: %w", err)
+	}
+
+	aead, err := newAEAD(passphrase, env.Salt, env.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	env.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(env.Nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	env.Ciphertext = aead.Seal(nil, env.Nonce, plaintext, []byte(env.Format))
+
+	return json.MarshalIndent(env, "", "  ")
+}
+
+func unseal(content []byte, passphrase string) ([]byte, error) {
+	var env envelope
+	if err := json.Unmarshal(content, &env); err != nil {
+		return nil, fmt.Errorf("parse encrypted backup: %w", err)
+	}
+	if env.Format != encryptedFormat {
+		return nil, fmt.Errorf("unsupported backup encryption %q", env.Format)
+	}
+	if env.Iterations < 1 {
+		return nil, fmt.Errorf("invalid key derivation iterations %d", env.Iterations)
+	}
+
+	aead, err := newAEAD(passphrase, env.Salt, env.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.Nonce) != aead.NonceSize() {
+		return nil, ErrWrongPassphrase
+	}
+	plaintext, err := aead.Open(nil, env.Nonce, env.Ciphertext, []byte(env.Format))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
+
+func newAEAD(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey(passphrase, salt, iterations, keySize))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// deriveKey derives a key of keyLen bytes from passphrase with
+// PBKDF2-HMAC-SHA256 (RFC 8018), as recorded in encryptedFormat.
+func deriveKey(passphrase string, salt []byte, iterations, keyLen int) []byte {
+	return pbkdf2.Key([]byte(passphrase), salt, iterations, keyLen, sha256.New)
+}
@@ -0,0 +1,171 @@
+package backup
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeriveKey(t *testing.T) {
+	// PBKDF2-HMAC-SHA256 test vectors from RFC 7914 section 11. The 64-byte
+	// keys span two blocks, so they also check the block index.
+	tests := []struct {
+		passphrase string
+		salt       string
+		iterations int
+		keyLen     int
+		want       string
+	}{
+		{"password", "salt", 1, 32, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, 32, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, 32, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwd", "salt", 1, 64, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+			"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, 64, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56" +
+			"a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+
+	for _, tt := range tests {
+		got := hex.EncodeToString(deriveKey(tt.passphrase, []byte(tt.salt), tt.iterations, tt.keyLen))
+		if got != tt.want {
+			t.Errorf("deriveKey(%q, %q, c=%d, %d) = %s, want %s", tt.passphrase, tt.salt, tt.iterations, tt.keyLen, got, tt.want)
+		}
+	}
+}
+
+func TestUnsealExistingBackup(t *testing.T) {
+	// Written by an earlier release; keys derived now must still open it
+	content := []byte(`{"format":"aes-256-gcm+pbkdf2-sha256","iterations":1000,` +
+		`"salt":"MDEyMzQ1Njc4OWFiY2RlZg==","nonce":"Zml4ZWQtbm9uY2Uh",` +
+		`"ciphertext":"EhIai/4PGte03pDAf/pnsXixanstJq63OY+q0qRo5rnjpccQv8BvUSWuDXW5KLvyvGebUprBhTDdG4+OGqVGcQ=="}`)
+
+	plaintext, err := unseal(content, "correct horse")
+	if err != nil {
+		t.Fatalf("unseal() error = %v", err)
+	}
+	if want := `{"device_id":"device-123","secret":"secret-456"}`; string(plaintext) != want {
+		t.Errorf("unseal() = %s, want %s", plaintext, want)
+	}
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	store := Store{Dir: dir, Passphrase: "correct horse", Encrypt: true}
+	creds := &Credentials{DeviceID: "device-123", Secret: "secret-456"}
+
+	path, err := store.Save(creds)
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "secret-456") || strings.Contains(string(content), "device_id") {
+		t.Errorf("encrypted backup contains plaintext:\n%s", content)
+	}
+
+	// Loading only needs the passphrase, not the Encrypt option
+	got, err := Store{Dir: dir, Passphrase: "correct horse"}.Load("device-123")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if *got != *creds {
+		t.Errorf("Load() = %+v, want %+v", *got, *creds)
+	}
+}
+
+func TestEncryptedWrongPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := (Store{Dir: dir, Passphrase: "correct horse", Encrypt: true}).Save(&Credentials{DeviceID: "device-123", Secret: "secret-456"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Store{Dir: dir, Passphrase: "battery staple"}.Load("device-123")
+	if !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Load() with wrong passphrase error = %v, want ErrWrongPassphrase", err)
+	}
+
+	_, err = Load(dir, "device-123")
+	if !errors.Is(err, ErrNoPassphrase) {
+		t.Errorf("Load() without passphrase error = %v, want ErrNoPassphrase", err)
+	}
+}
+
+func TestEncryptWithoutPassphrase(t *testing.T) {
+	_, err := Store{Dir: t.TempDir(), Encrypt: true}.Save(&Credentials{DeviceID: "device-123", Secret: "secret-456"})
+	if !errors.Is(err, ErrNoPassphrase) {
+		t.Errorf("Save() error = %v, want ErrNoPassphrase", err)
+	}
+}
+
+func TestRotateKeepsEncryption(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := (Store{Dir: dir, Passphrase: "pw", Encrypt: true}).Save(&Credentials{DeviceID: "device-123", Secret: "old"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotating without the Encrypt option must not downgrade the backup
+	store := Store{Dir: dir, Passphrase: "pw"}
+	path, err := store.Rotate("device-123", "new", time.Now())
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	content, _ := os.ReadFile(path)
+	if !isEncrypted(content) {
+		t.Error("Rotate() wrote a plaintext backup")
+	}
+
+	history, err := store.History("device-123")
+	if err != nil || len(history) != 1 || history[0].Secret != "old" {
+		t.Errorf("History() = %+v, %v", history, err)
+	}
+}
+
+func TestCheckRotate(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := (Store{Dir: dir, Passphrase: "pw", Encrypt: true}).Save(&Credentials{DeviceID: "device-123", Secret: "old"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		store    Store
+		deviceID string
+		wantErr  error
+	}{
+		{"encrypted with passphrase", Store{Dir: dir, Passphrase: "pw"}, "device-123", nil},
+		{"encrypted without passphrase", Store{Dir: dir}, "device-123", ErrNoPassphrase},
+		{"encrypted with wrong passphrase", Store{Dir: dir, Passphrase: "nope"}, "device-123", ErrWrongPassphrase},
+		{"no backup yet", Store{Dir: dir}, "device-456", nil},
+		{"no backup yet, encrypting without passphrase", Store{Dir: dir, Encrypt: true}, "device-456", ErrNoPassphrase},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.store.CheckRotate(tt.deviceID)
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckRotate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestListEncryptedWithoutPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := (Store{Dir: dir, Passphrase: "pw", Encrypt: true}).Save(&Credentials{DeviceID: "device-123", Secret: "s"}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, skipped, err := Store{Dir: dir}.List()
+	if err != nil || len(skipped) != 0 {
+		t.Fatalf("List() error = %v, skipped = %v", err, skipped)
+	}
+	if len(entries) != 1 || entries[0].DeviceID != "device-123" || !entries[0].Encrypted {
+		t.Errorf("List() = %+v, want one encrypted entry for device-123", entries)
+	}
+}
@@ -2,9 +2,14 @@ package endpoints
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 )
@@ -14,6 +19,9 @@ const (
 	RelativePath   = "components/library/cloud/include/cloud"
 )
 
+// ErrBaseURLNotFound is returned by ReadBaseURL for a header without BASE_URL.
+var ErrBaseURLNotFound = errors.New("BASE_URL not found")
+
 func FindHeaderPath(startDir string) string {
 	dir := startDir
 	for i := 0; i < 6; i++ {
@@ -52,13 +60,177 @@ func ReadBaseURL(headerPath string) (string, error) {
 			return line[start+1 : end], nil
 		}
 	}
-	return "", fmt.Errorf("BASE_URL not found in %s", headerPath)
+	return "", fmt.Errorf("%w in %s", ErrBaseURLNotFound, headerPath)
+}
+
+// URLComparison is the result of CompareBaseURL.
+type URLComparison struct {
+	Current  string // BASE_URL in the header; empty when Missing
+	Expected string
+	Missing  bool // the header doesn't exist or has no BASE_URL
+}
+
+// Stale reports whether the header has a BASE_URL other than the expected one,
+// e.g. a Cloud Run service that has since been deleted or renamed.
+func (c URLComparison) Stale() bool {
+	return !c.Missing && c.Current != c.Expected
+}
+
+// CompareBaseURL compares the header's BASE_URL against expectedURL without
+// modifying the header.
+func CompareBaseURL(headerPath, expectedURL string) (URLComparison, error) {
+	expectedURL, err := NormalizeBaseURL(expectedURL)
+	if err != nil {
+		return URLComparison{}, err
+	}
+
+	result := URLComparison{Expected: expectedURL}
+	current, err := ReadBaseURL(headerPath)
+	switch {
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, ErrBaseURLNotFound):
+		result.Missing = true
+	case err != nil:
+		return result, err
+	default:
+		result.Current = current
+	}
+	return result, nil
+}
+
+// hostnameRe matches a DNS hostname: dot-separated labels of letters, digits
+// and inner hyphens.
+var hostnameRe = regexp.MustCompile(`^(?i)[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)*$`)
+
+// NormalizeBaseURL validates a backend URL for the firmware and returns it in
+// canonical form: surrounding whitespace trimmed, https:// added to a bare
+// hostname, and trailing slashes removed so that appending an endpoint path
+// never doubles the slash. The host must be a hostname or an IPv4/IPv6
+// address and the scheme https.
+func NormalizeBaseURL(raw string) (string, error) {
+	s := strings.TrimSpace(raw)
+	if s == "" {
+		return "", fmt.Errorf("base URL is empty")
+	}
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL %q: %w", raw, err)
+	}
+	if u.Scheme != "https" {
+		return "", fmt.Errorf("base URL %q must use https", raw)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return "", fmt.Errorf("base URL %q has no host", raw)
+	}
+	if net.ParseIP(host) == nil && !hostnameRe.MatchString(host) {
+		return "", fmt.Errorf("base URL %q has an invalid host %q", raw, host)
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("base URL %q must not contain credentials, a query or a fragment", raw)
+	}
+
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// Endpoints are the API paths written to the header next to BASE_URL.
+type Endpoints struct {
+	AuthDevice     string
+	AuthRefresh    string
+	TelemetryProto string
+	Commands       string
+	DeviceInfo     string
+}
+
+// ProdEndpoints are the production API paths, used unless WithEndpoints
+// selects others.
+var ProdEndpoints = Endpoints{
+	AuthDevice:     "/auth/device",
+	AuthRefresh:    "/auth/refresh",
+	TelemetryProto: "/telemetry/proto",
+	Commands:       "/commands",
+	DeviceInfo:     "/devices/info",
+}
+
+// StagingEndpoints are the staging API paths, where telemetry goes to the v2
+// ingest route.
+var StagingEndpoints = Endpoints{
+	AuthDevice:     "/auth/device",
+	AuthRefresh:    "/auth/refresh",
+	TelemetryProto: "/v2/telemetry/proto",
+	Commands:       "/commands",
+	DeviceInfo:     "/devices/info",
+}
+
+// profiles maps endpoint profile names to their paths.
+var profiles = map[string]Endpoints{
+	"prod":    ProdEndpoints,
+	"staging": StagingEndpoints,
+}
+
+// Profile returns the endpoints for a profile name ("prod" or "staging").
+func Profile(name string) (Endpoints, error) {
+	e, ok := profiles[name]
+	if !ok {
+		return Endpoints{}, fmt.Errorf("unknown endpoint profile %q (want prod or staging)", name)
+	}
+	return e, nil
+}
+
+// Option customizes header generation.
+type Option func(*headerOptions)
+
+type headerOptions struct {
+	generated time.Time
+	endpoints Endpoints
+}
+
+// WithEndpoints writes e instead of ProdEndpoints.
+func WithEndpoints(e Endpoints) Option {
+	return func(o *headerOptions) { o.endpoints = e }
+}
+
+// WithTimestamp adds a "Generated:" comment with t to the header. Headers are
+// written without one by default so that regenerating an unchanged header
+// produces no diff.
+func WithTimestamp(t time.Time) Option {
+	return func(o *headerOptions) { o.generated = t }
+}
+
+// WriteHeader writes the endpoints header for url, normalized with
+// NormalizeBaseURL. The file is left untouched when it already has the exact
+// content.
+func WriteHeader(headerPath, url string, opts ...Option) error {
+	url, err := NormalizeBaseURL(url)
+	if err != nil {
+		return err
+	}
+
+	o := headerOptions{endpoints: ProdEndpoints}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	content := []byte(renderHeader(url, o))
+	if existing, err := os.ReadFile(headerPath); err == nil && bytes.Equal(existing, content) {
+		return nil
+	}
+	return os.WriteFile(headerPath, content, 0644)
 }
 
-func WriteHeader(headerPath, url string) error {
-	content := fmt.Sprintf(`// Auto-generated - DO NOT EDIT
-// Generated: %s
+func renderHeader(url string, o headerOptions) string {
+	generated := ""
+	if !o.generated.IsZero() {
+		generated = fmt.Sprintf("// Generated: %s\n", o.generated.UTC().Format(time.RFC3339))
+	}
 
+	return fmt.Sprintf(`// Auto-generated - DO NOT EDIT
+%s
 #pragma once
 
 #include <string_view>
@@ -67,22 +239,30 @@ namespace cloud::endpoints {
 
 inline constexpr std::string_view BASE_URL = "%s";
 
-inline constexpr std::string_view AUTH_DEVICE = "/auth/device";
-inline constexpr std::string_view AUTH_REFRESH = "/auth/refresh";
-inline constexpr std::string_view TELEMETRY_PROTO = "/telemetry/proto";
-inline constexpr std::string_view COMMANDS = "/commands";
-inline constexpr std::string_view DEVICE_INFO = "/devices/info";
+inline constexpr std::string_view AUTH_DEVICE = "%s";
+inline constexpr std::string_view AUTH_REFRESH = "%s";
+inline constexpr std::string_view TELEMETRY_PROTO = "%s";
+inline constexpr std::string_view COMMANDS = "%s";
+inline constexpr std::string_view DEVICE_INFO = "%s";
 
 } // namespace cloud::endpoints
-`, time.Now().UTC().Format(time.RFC3339), url)
-
-	return os.WriteFile(headerPath, []byte(content), 0644)
+`, generated, url, o.endpoints.AuthDevice, o.endpoints.AuthRefresh, o.endpoints.TelemetryProto,
+		o.endpoints.Commands, o.endpoints.DeviceInfo)
 }
 
-func ValidateOrUpdate(headerPath, expectedURL string) error {
+// ValidateOrUpdate checks that the header's BASE_URL is expectedURL (after
+// NormalizeBaseURL). When it
+// is, the file is not rewritten. Otherwise the header is regenerated with opts
+// and an error saying a rebuild is required is returned.
+func ValidateOrUpdate(headerPath, expectedURL string, opts ...Option) error {
+	expectedURL, err := NormalizeBaseURL(expectedURL)
+	if err != nil {
+		return err
+	}
+
 	currentURL, err := ReadBaseURL(headerPath)
 	if err != nil {
-		if writeErr := WriteHeader(headerPath, expectedURL); writeErr != nil {
+		if writeErr := WriteHeader(headerPath, expectedURL, opts...); writeErr != nil {
 			return fmt.Errorf("failed to generate %s: %w", headerPath, writeErr)
 		}
 		return fmt.Errorf("generated %s - rebuild required", headerPath)
@@ -92,7 +272,7 @@ func ValidateOrUpdate(headerPath, expectedURL string) error {
 		return nil
 	}
 
-	if writeErr := WriteHeader(headerPath, expectedURL); writeErr != nil {
+	if writeErr := WriteHeader(headerPath, expectedURL, opts...); writeErr != nil {
 		return fmt.Errorf("failed to update %s: %w", headerPath, writeErr)
 	}
 	return fmt.Errorf("updated %s: %s -> %s - rebuild required", headerPath, currentURL, expectedURL)
@@ -1,10 +1,14 @@
 package endpoints
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"measurement-probe/tools/provision/internal/golden"
 )
 
 func TestFindHeaderPath(t *testing.T) {
@@ -56,23 +60,46 @@ func TestWriteHeader(t *testing.T) {
 		t.Fatalf("WriteHeader() error = %v", err)
 	}
 
-	content, err := os.ReadFile(path)
-	if err != nil {
-		t.Fatal(err)
+	golden.AssertFile(t, "endpoints.hpp.golden", path)
+}
+
+func TestWriteHeader_WithTimestampGolden(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.hpp")
+
+	if err := WriteHeader(path, "https://example.run.app", WithTimestamp(time.Now())); err != nil {
+		t.Fatalf("WriteHeader() error = %v", err)
 	}
 
-	checks := []string{
-		"#pragma once",
-		"namespace cloud::endpoints",
-		`BASE_URL = "https://example.run.app"`,
-		"AUTH_DEVICE",
-		"TELEMETRY_PROTO",
+	golden.AssertFile(t, "endpoints_timestamp.hpp.golden", path)
+}
+
+func TestWriteHeader_Profiles(t *testing.T) {
+	tests := []struct {
+		profile string
+		golden  string
+	}{
+		{profile: "prod", golden: "endpoints.hpp.golden"},
+		{profile: "staging", golden: "endpoints_staging.hpp.golden"},
 	}
 
-	for _, check := range checks {
-		if !strings.Contains(string(content), check) {
-			t.Errorf("WriteHeader() content missing %q", check)
-		}
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			e, err := Profile(tt.profile)
+			if err != nil {
+				t.Fatalf("Profile(%q) error = %v", tt.profile, err)
+			}
+
+			path := filepath.Join(t.TempDir(), "endpoints.hpp")
+			if err := WriteHeader(path, "https://example.run.app", WithEndpoints(e)); err != nil {
+				t.Fatalf("WriteHeader() error = %v", err)
+			}
+
+			golden.AssertFile(t, tt.golden, path)
+		})
+	}
+
+	if _, err := Profile("qa"); err == nil {
+		t.Error("Profile(qa) expected error")
 	}
 }
 
@@ -173,3 +200,205 @@ func TestValidateOrUpdate(t *testing.T) {
 		}
 	})
 }
+
+func TestValidateOrUpdate_MatchingURLLeavesFileIdentical(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.hpp")
+	url := "https://test.run.app"
+
+	// A timestamped header from an earlier run must not be rewritten either
+	generated := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := WriteHeader(path, url, WithTimestamp(generated)); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ValidateOrUpdate(path, url, WithTimestamp(time.Now())); err != nil {
+		t.Fatalf("ValidateOrUpdate() error = %v", err)
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Errorf("file changed:\nbefore:\n%s\nafter:\n%s", before, after)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(old) {
+		t.Errorf("mod time = %v, want %v (file was rewritten)", info.ModTime(), old)
+	}
+}
+
+func TestWriteHeader_Stable(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.hpp")
+	second := filepath.Join(dir, "second.hpp")
+
+	if err := WriteHeader(first, "https://test.run.app"); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteHeader(second, "https://test.run.app"); err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := os.ReadFile(first)
+	b, _ := os.ReadFile(second)
+	if !bytes.Equal(a, b) {
+		t.Error("WriteHeader() output differs between runs")
+	}
+	if bytes.Contains(a, []byte("Generated:")) {
+		t.Error("WriteHeader() added a timestamp without WithTimestamp")
+	}
+	if !bytes.HasSuffix(a, []byte("} // namespace cloud::endpoints\n")) {
+		t.Error("WriteHeader() output does not end with a single trailing newline")
+	}
+}
+
+func TestWriteHeader_WithTimestamp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.hpp")
+	generated := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	if err := WriteHeader(path, "https://test.run.app", WithTimestamp(generated)); err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ := os.ReadFile(path)
+	if !strings.Contains(string(content), "// Generated: 2024-01-02T03:04:05Z\n") {
+		t.Errorf("header missing timestamp:\n%s", content)
+	}
+}
+
+func TestCompareBaseURL(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "endpoints.hpp")
+	if err := WriteHeader(path, "https://old.run.app"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("match", func(t *testing.T) {
+		got, err := CompareBaseURL(path, "https://old.run.app")
+		if err != nil {
+			t.Fatalf("CompareBaseURL() error = %v", err)
+		}
+		if got.Stale() || got.Missing {
+			t.Errorf("CompareBaseURL() = %+v, want matching", got)
+		}
+	})
+
+	t.Run("differ", func(t *testing.T) {
+		got, err := CompareBaseURL(path, "https://new.run.app")
+		if err != nil {
+			t.Fatalf("CompareBaseURL() error = %v", err)
+		}
+		if !got.Stale() {
+			t.Errorf("Stale() = false, want true")
+		}
+		if got.Current != "https://old.run.app" || got.Expected != "https://new.run.app" {
+			t.Errorf("CompareBaseURL() = %+v", got)
+		}
+
+		// Comparing must not touch the header
+		current, _ := ReadBaseURL(path)
+		if current != "https://old.run.app" {
+			t.Errorf("header BASE_URL = %q after compare", current)
+		}
+	})
+
+	t.Run("missing header", func(t *testing.T) {
+		got, err := CompareBaseURL(filepath.Join(tmpDir, "missing.hpp"), "https://new.run.app")
+		if err != nil {
+			t.Fatalf("CompareBaseURL() error = %v", err)
+		}
+		if !got.Missing || got.Stale() {
+			t.Errorf("CompareBaseURL() = %+v, want missing and not stale", got)
+		}
+	})
+
+	t.Run("header without BASE_URL", func(t *testing.T) {
+		empty := filepath.Join(tmpDir, "empty.hpp")
+		if err := os.WriteFile(empty, []byte("#pragma once\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := CompareBaseURL(empty, "https://new.run.app")
+		if err != nil {
+			t.Fatalf("CompareBaseURL() error = %v", err)
+		}
+		if !got.Missing {
+			t.Errorf("Missing = false, want true")
+		}
+	})
+}
+
+func TestNormalizeBaseURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr string
+	}{
+		{name: "canonical", raw: "https://api.run.app", want: "https://api.run.app"},
+		{name: "trailing slash", raw: "https://api.run.app/", want: "https://api.run.app"},
+		{name: "trailing slashes after path", raw: "https://api.example.com/v1//", want: "https://api.example.com/v1"},
+		{name: "trailing whitespace", raw: "https://api.run.app \n", want: "https://api.run.app"},
+		{name: "bare hostname", raw: "api.run.app", want: "https://api.run.app"},
+		{name: "ipv4 with port", raw: "https://192.168.1.10:8443/", want: "https://192.168.1.10:8443"},
+		{name: "ipv6", raw: "https://[2001:db8::1]/", want: "https://[2001:db8::1]"},
+		{name: "empty", raw: "  ", wantErr: "empty"},
+		{name: "http", raw: "http://api.run.app", wantErr: "must use https"},
+		{name: "missing host", raw: "https:///telemetry", wantErr: "no host"},
+		{name: "invalid host", raw: "https://api_run.app", wantErr: "invalid host"},
+		{name: "query", raw: "https://api.run.app/?debug=1", wantErr: "query"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeBaseURL(tt.raw)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("NormalizeBaseURL(%q) error = %v, want %q", tt.raw, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NormalizeBaseURL(%q) error = %v", tt.raw, err)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeBaseURL(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteHeader_NormalizesURL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.hpp")
+
+	if err := WriteHeader(path, " https://api.run.app/ "); err != nil {
+		t.Fatalf("WriteHeader() error = %v", err)
+	}
+	got, err := ReadBaseURL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "https://api.run.app" {
+		t.Errorf("BASE_URL = %q, want %q", got, "https://api.run.app")
+	}
+
+	// The normalized header already matches, so nothing needs rewriting
+	if err := ValidateOrUpdate(path, "https://api.run.app/"); err != nil {
+		t.Errorf("ValidateOrUpdate() with trailing slash error = %v", err)
+	}
+
+	if err := WriteHeader(path, "https://"); err == nil {
+		t.Error("WriteHeader() accepted a URL without a host")
+	}
+}
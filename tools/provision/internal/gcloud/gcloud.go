@@ -1,16 +1,248 @@
 package gcloud
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 )
 
 const (
-	adminAPIKeySecret = "admin-api-key"
+	// DefaultAdminAPIKeySecret is the Secret Manager secret holding the admin API key.
+	DefaultAdminAPIKeySecret = "admin-api-key"
+	installURL               = "https://cloud.google.com/sdk/docs/install"
 )
 
+// CommandRunner executes gcloud and returns its stdout. Allows mocking in tests.
+type CommandRunner interface {
+	Output(name string, args ...string) ([]byte, error)
+}
+
+type execRunner struct{}
+
+func (execRunner) Output(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).Output()
+}
+
+var runner CommandRunner = execRunner{}
+
+// RetryPolicy controls how GetServiceURL and GetAdminAPIKey retry gcloud
+// failures that look transient (network errors, 5xx, UNAVAILABLE). Permission
+// and not-found errors are never retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of gcloud calls, including the first.
+	// Values below 1 mean a single attempt.
+	MaxAttempts int
+	// Delay is the wait between attempts.
+	Delay time.Duration
+}
+
+var (
+	// DefaultRetryPolicy is used unless SetRetryPolicy is called.
+	DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, Delay: 2 * time.Second}
+	// NoRetry makes a single attempt.
+	NoRetry = RetryPolicy{MaxAttempts: 1}
+)
+
+var (
+	retry = DefaultRetryPolicy
+	sleep = time.Sleep
+)
+
+// SetRetryPolicy replaces the retry policy for gcloud describe and secret
+// access calls.
+func SetRetryPolicy(p RetryPolicy) {
+	retry = p
+}
+
+// transientMarkers are substrings of gcloud's stderr for failures worth
+// retrying: API 5xx/429 responses and network errors.
+var transientMarkers = []string{
+	"UNAVAILABLE",
+	"DEADLINE_EXCEEDED",
+	"INTERNAL",
+	"RESOURCE_EXHAUSTED",
+	"HTTP 500",
+	"HTTP 502",
+	"HTTP 503",
+	"HTTP 504",
+	"HTTP 429",
+	"Connection reset",
+	"Connection aborted",
+	"timed out",
+	"Temporary failure in name resolution",
+}
+
+// transient reports whether err is a gcloud exit error that looks like a
+// temporary API or network problem rather than a permission or lookup failure.
+func transient(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+
+	stderr := string(exitErr.Stderr)
+	for _, permanent := range []string{"PERMISSION_DENIED", "NOT_FOUND", "does not have", "could not be found"} {
+		if strings.Contains(stderr, permanent) {
+			return false
+		}
+	}
+	for _, marker := range transientMarkers {
+		if strings.Contains(stderr, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// outputWithRetry runs gcloud with args, retrying transient failures
+// according to the retry policy.
+func outputWithRetry(args ...string) ([]byte, error) {
+	attempts := max(retry.MaxAttempts, 1)
+
+	var output []byte
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		output, err = runner.Output("gcloud", args...)
+		if err == nil || !transient(err) {
+			return output, err
+		}
+		if attempt < attempts {
+			sleep(retry.Delay)
+		}
+	}
+	return output, err
+}
+
+// reauthMarkers are substrings of gcloud's stderr when the active account
+// still exists but its credentials have expired.
+var reauthMarkers = []string{
+	"Reauthentication required",
+	"reauthentication failed",
+	"problem refreshing your current auth tokens",
+	"invalid_grant",
+}
+
+// NeedsReauth reports whether err is a gcloud failure caused by expired
+// credentials, which only a fresh login fixes.
+func NeedsReauth(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	for _, marker := range reauthMarkers {
+		if strings.Contains(string(exitErr.Stderr), marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// loginArgs returns the gcloud command that refreshes the credentials err
+// complains about: application default credentials or the user login.
+func loginArgs(err error) []string {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && strings.Contains(string(exitErr.Stderr), "application-default") {
+		return []string{"auth", "application-default", "login"}
+	}
+	return []string{"auth", "login"}
+}
+
+// reauthError explains expired credentials when they weren't refreshed.
+func reauthError(err error) error {
+	return fmt.Errorf("gcloud credentials have expired - run: gcloud %s", strings.Join(loginArgs(err), " "))
+}
+
+var (
+	// reauth, when set, is asked to log in again after a call fails with
+	// expired credentials. SetReauthPrompt sets it.
+	reauth func(args []string) error
+	// login runs an interactive gcloud login command. Tests replace it.
+	login = func(args ...string) error {
+		cmd := exec.Command("gcloud", args...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+)
+
+// SetReauthPrompt makes GetServiceURL and GetAdminAPIKey offer, on in and
+// out, to run gcloud auth login when the credentials have expired and then
+// retry once. in should be the reader every other prompt uses, so answers
+// piped in for later prompts are not swallowed. A nil in turns the prompt off.
+func SetReauthPrompt(in *bufio.Reader, out io.Writer) {
+	if in == nil {
+		reauth = nil
+		return
+	}
+	reauth = func(args []string) error {
+		fmt.Fprintf(out, "  gcloud credentials have expired. Run gcloud %s now? [Y/n]: ", strings.Join(args, " "))
+		answer, _ := in.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "n", "no":
+			return errors.New("login declined")
+		}
+		if err := login(args...); err != nil {
+			return fmt.Errorf("gcloud %s failed: %w", strings.Join(args, " "), err)
+		}
+		return nil
+	}
+}
+
+// outputWithReauth is outputWithRetry, except that when the credentials have
+// expired and a reauth prompt is set it logs in again and retries once.
+func outputWithReauth(args ...string) ([]byte, error) {
+	output, err := outputWithRetry(args...)
+	if err == nil || reauth == nil || !NeedsReauth(err) {
+		return output, err
+	}
+	if loginErr := reauth(loginArgs(err)); loginErr != nil {
+		return output, err
+	}
+	return outputWithRetry(args...)
+}
+
+// requiredCommands are the gcloud command groups used by the provisioning flow.
+var requiredCommands = [][]string{
+	{"auth"},
+	{"run", "services"},
+	{"secrets", "versions"},
+}
+
+// EnsureComponents verifies that gcloud is installed and that every command
+// group the tool relies on is available.
+func EnsureComponents() error {
+	for _, command := range requiredCommands {
+		args := append(append([]string{}, command...), "--help")
+		if _, err := runner.Output("gcloud", args...); err != nil {
+			return componentError(strings.Join(command, " "), err)
+		}
+	}
+	return nil
+}
+
+func componentError(command string, err error) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("gcloud CLI not found on PATH - install the Google Cloud SDK: %s", installURL)
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		msg := fmt.Sprintf("gcloud %s is not available - run: gcloud components update", command)
+		if stderr := strings.TrimSpace(string(exitErr.Stderr)); stderr != "" {
+			msg += fmt.Sprintf(" (%s)", stderr)
+		}
+		return errors.New(msg)
+	}
+
+	return fmt.Errorf("gcloud %s check failed: %w", command, err)
+}
+
 func EnsureAuthenticated() error {
 	cmd := exec.Command("gcloud", "auth", "list", "--filter=status:ACTIVE", "--format=value(account)")
 	output, err := cmd.Output()
@@ -92,12 +324,14 @@ func GetCurrentProject() (string, error) {
 	return project, nil
 }
 
+// GetServiceURL returns the URL of the Cloud Run service in region.
 func GetServiceURL(service, region string) (string, error) {
-	cmd := exec.Command("gcloud", "run", "services", "describe", service,
+	output, err := outputWithReauth("run", "services", "describe", service,
 		"--region", region,
 		"--format", "value(status.url)")
-
-	output, err := cmd.Output()
+	if NeedsReauth(err) {
+		return "", reauthError(err)
+	}
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			return "", fmt.Errorf("gcloud failed: %s", string(exitErr.Stderr))
@@ -113,19 +347,25 @@ func GetServiceURL(service, region string) (string, error) {
 	return url, nil
 }
 
-// GetAdminAPIKey fetches the admin API key from Secret Manager
+// GetAdminAPIKey fetches the admin API key from the Secret Manager secret
+// secretName (DefaultAdminAPIKeySecret when empty).
 // User must have roles/secretmanager.secretAccessor on the secret
-func GetAdminAPIKey(projectID string) (string, error) {
-	cmd := exec.Command("gcloud", "secrets", "versions", "access", "latest",
-		"--secret", adminAPIKeySecret,
-		"--project", projectID)
+func GetAdminAPIKey(projectID, secretName string) (string, error) {
+	if secretName == "" {
+		secretName = DefaultAdminAPIKeySecret
+	}
 
-	output, err := cmd.Output()
+	output, err := outputWithReauth("secrets", "versions", "access", "latest",
+		"--secret", secretName,
+		"--project", projectID)
+	if NeedsReauth(err) {
+		return "", reauthError(err)
+	}
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			stderr := strings.TrimSpace(string(exitErr.Stderr))
 			if strings.Contains(stderr, "PERMISSION_DENIED") || strings.Contains(stderr, "does not have") {
-				return "", fmt.Errorf("no permission to access secret %s - contact infra team to add your email to provisioner_users", adminAPIKeySecret)
+				return "", fmt.Errorf("no permission to access secret %s - contact infra team to add your email to provisioner_users", secretName)
 			}
 			return "", fmt.Errorf("failed to access secret: %s", stderr)
 		}
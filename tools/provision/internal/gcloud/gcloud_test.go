@@ -0,0 +1,360 @@
+package gcloud
+
+import (
+	"bufio"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeRunner struct {
+	calls  [][]string
+	fail   map[string]error
+	output string
+}
+
+func (f *fakeRunner) Output(name string, args ...string) ([]byte, error) {
+	f.calls = append(f.calls, append([]string{name}, args...))
+	if err, ok := f.fail[args[0]]; ok {
+		return nil, err
+	}
+	return []byte(f.output), nil
+}
+
+func withRunner(t *testing.T, r CommandRunner) {
+	t.Helper()
+	old := runner
+	runner = r
+	t.Cleanup(func() { runner = old })
+}
+
+func TestEnsureComponents(t *testing.T) {
+	fake := &fakeRunner{}
+	withRunner(t, fake)
+
+	if err := EnsureComponents(); err != nil {
+		t.Fatalf("EnsureComponents() error = %v", err)
+	}
+	if len(fake.calls) != len(requiredCommands) {
+		t.Fatalf("ran %d commands, want %d", len(fake.calls), len(requiredCommands))
+	}
+	if got := strings.Join(fake.calls[1], " "); got != "gcloud run services --help" {
+		t.Errorf("second check = %q", got)
+	}
+}
+
+func TestEnsureComponentsMissingBinary(t *testing.T) {
+	withRunner(t, &fakeRunner{fail: map[string]error{
+		"auth": &exec.Error{Name: "gcloud", Err: exec.ErrNotFound},
+	}})
+
+	err := EnsureComponents()
+	if err == nil {
+		t.Fatal("expected error when gcloud is missing")
+	}
+	if !strings.Contains(err.Error(), "gcloud CLI not found on PATH") || !strings.Contains(err.Error(), installURL) {
+		t.Errorf("error = %q, want install instructions", err)
+	}
+}
+
+func TestEnsureComponentsMissingCommand(t *testing.T) {
+	withRunner(t, &fakeRunner{fail: map[string]error{
+		"run": &exec.ExitError{Stderr: []byte("ERROR: (gcloud) Invalid choice: 'run'.\n")},
+	}})
+
+	err := EnsureComponents()
+	if err == nil {
+		t.Fatal("expected error when gcloud run is unavailable")
+	}
+	want := "gcloud run services is not available - run: gcloud components update (ERROR: (gcloud) Invalid choice: 'run'.)"
+	if err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+}
+
+func TestEnsureComponentsOtherError(t *testing.T) {
+	cause := errors.New("boom")
+	withRunner(t, &fakeRunner{fail: map[string]error{"secrets": cause}})
+
+	err := EnsureComponents()
+	if !errors.Is(err, cause) {
+		t.Errorf("error = %v, want it to wrap %v", err, cause)
+	}
+}
+
+func TestGetAdminAPIKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		secretName string
+		wantSecret string
+	}{
+		{"default", "", "admin-api-key"},
+		{"override", "admin-api-key-staging", "admin-api-key-staging"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeRunner{output: "key-value\n"}
+			withRunner(t, fake)
+
+			key, err := GetAdminAPIKey("my-project", tt.secretName)
+			if err != nil {
+				t.Fatalf("GetAdminAPIKey() error = %v", err)
+			}
+			if key != "key-value" {
+				t.Errorf("key = %q, want %q", key, "key-value")
+			}
+			want := "gcloud secrets versions access latest --secret " + tt.wantSecret + " --project my-project"
+			if got := strings.Join(fake.calls[0], " "); got != want {
+				t.Errorf("command = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestGetAdminAPIKeyPermissionDenied(t *testing.T) {
+	withRunner(t, &fakeRunner{fail: map[string]error{
+		"secrets": &exec.ExitError{Stderr: []byte("ERROR: (gcloud.secrets.versions.access) PERMISSION_DENIED: Permission denied on resource\n")},
+	}})
+
+	_, err := GetAdminAPIKey("my-project", "admin-api-key-staging")
+	if err == nil {
+		t.Fatal("expected permission error")
+	}
+	if !strings.Contains(err.Error(), "no permission to access secret admin-api-key-staging") {
+		t.Errorf("error = %q, want it to name the overridden secret", err)
+	}
+}
+
+// flakyRunner fails with errs in order, then succeeds with output.
+type flakyRunner struct {
+	errs   []error
+	output string
+	calls  int
+}
+
+func (f *flakyRunner) Output(name string, args ...string) ([]byte, error) {
+	f.calls++
+	if f.calls <= len(f.errs) {
+		return nil, f.errs[f.calls-1]
+	}
+	return []byte(f.output), nil
+}
+
+func withRetryPolicy(t *testing.T, p RetryPolicy) *[]time.Duration {
+	t.Helper()
+	oldRetry, oldSleep := retry, sleep
+	var delays []time.Duration
+	SetRetryPolicy(p)
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+	t.Cleanup(func() { retry, sleep = oldRetry, oldSleep })
+	return &delays
+}
+
+func exitError(stderr string) error {
+	return &exec.ExitError{Stderr: []byte(stderr)}
+}
+
+func TestGetServiceURLRetry(t *testing.T) {
+	unavailable := exitError("ERROR: (gcloud.run.services.describe) UNAVAILABLE: The service is currently unavailable.\n")
+	notFound := exitError("ERROR: (gcloud.run.services.describe) Cannot find service [telemetry-api]: NOT_FOUND\n")
+
+	tests := []struct {
+		name      string
+		policy    RetryPolicy
+		errs      []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{"transient then success", DefaultRetryPolicy, []error{unavailable}, 2, false},
+		{"persistent transient", DefaultRetryPolicy, []error{unavailable, unavailable, unavailable}, 3, true},
+		{"not found is not retried", DefaultRetryPolicy, []error{notFound}, 1, true},
+		{"single attempt", NoRetry, []error{unavailable}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delays := withRetryPolicy(t, tt.policy)
+			fake := &flakyRunner{errs: tt.errs, output: "https://telemetry-api.run.app\n"}
+			withRunner(t, fake)
+
+			url, err := GetServiceURL("telemetry-api", "us-west1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetServiceURL() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && url != "https://telemetry-api.run.app" {
+				t.Errorf("url = %q", url)
+			}
+			if fake.calls != tt.wantCalls {
+				t.Errorf("gcloud called %d times, want %d", fake.calls, tt.wantCalls)
+			}
+			if len(*delays) != tt.wantCalls-1 {
+				t.Errorf("slept %d times, want %d", len(*delays), tt.wantCalls-1)
+			}
+		})
+	}
+}
+
+func TestGetAdminAPIKeyPermissionDeniedNotRetried(t *testing.T) {
+	withRetryPolicy(t, DefaultRetryPolicy)
+	fake := &flakyRunner{errs: []error{
+		exitError("ERROR: (gcloud.secrets.versions.access) PERMISSION_DENIED: Permission denied on resource\n"),
+	}}
+	withRunner(t, fake)
+
+	if _, err := GetAdminAPIKey("my-project", ""); err == nil {
+		t.Fatal("expected permission error")
+	}
+	if fake.calls != 1 {
+		t.Errorf("gcloud called %d times, want 1", fake.calls)
+	}
+}
+
+func TestTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{exitError("ERROR: gcloud crashed (ConnectionError): Connection reset by peer"), true},
+		{exitError("ERROR: HTTP 503 Service Unavailable"), true},
+		{exitError("ERROR: (gcloud.secrets.versions.access) PERMISSION_DENIED: denied"), false},
+		{exitError("ERROR: Secret [admin-api-key] NOT_FOUND"), false},
+		{exitError("ERROR: invalid argument --region"), false},
+		{exec.ErrNotFound, false},
+	}
+
+	for _, tt := range tests {
+		if got := transient(tt.err); got != tt.want {
+			t.Errorf("transient(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
+
+// withReauthPrompt answers the reauth prompt with input and records the
+// login commands run, failing them with loginErr.
+func withReauthPrompt(t *testing.T, input string, loginErr error) (*[][]string, *strings.Builder) {
+	t.Helper()
+	oldReauth, oldLogin := reauth, login
+	t.Cleanup(func() { reauth, login = oldReauth, oldLogin })
+
+	var logins [][]string
+	login = func(args ...string) error {
+		logins = append(logins, args)
+		return loginErr
+	}
+	var out strings.Builder
+	SetReauthPrompt(bufio.NewReader(strings.NewReader(input)), &out)
+	return &logins, &out
+}
+
+func TestNeedsReauth(t *testing.T) {
+	tests := []struct {
+		err       error
+		want      bool
+		wantLogin string
+	}{
+		{exitError("ERROR: (gcloud.run.services.describe) There was a problem refreshing your current auth tokens: Reauthentication required.\n"), true, "auth login"},
+		{exitError("ERROR: (gcloud.secrets.versions.access) invalid_grant: reauth related error (invalid_rapt)\n"), true, "auth login"},
+		{exitError("ERROR: Reauthentication required. Please run: gcloud auth application-default login\n"), true, "auth application-default login"},
+		{exitError("ERROR: (gcloud.run.services.describe) PERMISSION_DENIED: Permission denied\n"), false, "auth login"},
+		{errors.New("Reauthentication required"), false, "auth login"},
+	}
+
+	for _, tt := range tests {
+		if got := NeedsReauth(tt.err); got != tt.want {
+			t.Errorf("NeedsReauth(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+		if got := strings.Join(loginArgs(tt.err), " "); got != tt.wantLogin {
+			t.Errorf("loginArgs(%v) = %q, want %q", tt.err, got, tt.wantLogin)
+		}
+	}
+}
+
+func TestGetServiceURLReauth(t *testing.T) {
+	expired := exitError("ERROR: (gcloud.run.services.describe) There was a problem refreshing your current auth tokens: Reauthentication required.\n")
+
+	t.Run("relogin then single retry", func(t *testing.T) {
+		withRetryPolicy(t, NoRetry)
+		logins, out := withReauthPrompt(t, "\n", nil)
+		fake := &flakyRunner{errs: []error{expired}, output: "https://telemetry-api.run.app\n"}
+		withRunner(t, fake)
+
+		url, err := GetServiceURL("telemetry-api", "us-west1")
+		if err != nil {
+			t.Fatalf("GetServiceURL() error = %v", err)
+		}
+		if url != "https://telemetry-api.run.app" {
+			t.Errorf("url = %q", url)
+		}
+		if len(*logins) != 1 || strings.Join((*logins)[0], " ") != "auth login" {
+			t.Errorf("logins = %v, want one gcloud auth login", *logins)
+		}
+		if fake.calls != 2 {
+			t.Errorf("gcloud called %d times, want 2", fake.calls)
+		}
+		if !strings.Contains(out.String(), "[Y/n]") {
+			t.Errorf("no prompt shown: %q", out.String())
+		}
+	})
+
+	t.Run("still expired after relogin", func(t *testing.T) {
+		withRetryPolicy(t, NoRetry)
+		logins, _ := withReauthPrompt(t, "y\n", nil)
+		fake := &flakyRunner{errs: []error{expired, expired, expired}}
+		withRunner(t, fake)
+
+		_, err := GetServiceURL("telemetry-api", "us-west1")
+		if err == nil || !strings.Contains(err.Error(), "credentials have expired - run: gcloud auth login") {
+			t.Fatalf("GetServiceURL() error = %v", err)
+		}
+		if len(*logins) != 1 || fake.calls != 2 {
+			t.Errorf("logins = %d, calls = %d, want a single login and retry", len(*logins), fake.calls)
+		}
+	})
+
+	t.Run("declined", func(t *testing.T) {
+		withRetryPolicy(t, NoRetry)
+		logins, _ := withReauthPrompt(t, "n\n", nil)
+		fake := &flakyRunner{errs: []error{expired}}
+		withRunner(t, fake)
+
+		if _, err := GetServiceURL("telemetry-api", "us-west1"); err == nil || !strings.Contains(err.Error(), "credentials have expired") {
+			t.Fatalf("GetServiceURL() error = %v", err)
+		}
+		if len(*logins) != 0 || fake.calls != 1 {
+			t.Errorf("logins = %d, calls = %d, want no login or retry", len(*logins), fake.calls)
+		}
+	})
+
+	t.Run("non-interactive", func(t *testing.T) {
+		withRetryPolicy(t, NoRetry)
+		withReauthPrompt(t, "", nil)
+		SetReauthPrompt(nil, nil)
+		fake := &flakyRunner{errs: []error{expired}}
+		withRunner(t, fake)
+
+		if _, err := GetServiceURL("telemetry-api", "us-west1"); err == nil || !strings.Contains(err.Error(), "credentials have expired") {
+			t.Fatalf("GetServiceURL() error = %v", err)
+		}
+		if fake.calls != 1 {
+			t.Errorf("gcloud called %d times, want 1", fake.calls)
+		}
+	})
+}
+
+func TestGetAdminAPIKeyReauth(t *testing.T) {
+	withRetryPolicy(t, NoRetry)
+	logins, _ := withReauthPrompt(t, "y\n", nil)
+	fake := &flakyRunner{errs: []error{exitError("ERROR: (gcloud.secrets.versions.access) invalid_grant: reauth related error\n")}, output: "admin-key\n"}
+	withRunner(t, fake)
+
+	key, err := GetAdminAPIKey("my-project", "")
+	if err != nil {
+		t.Fatalf("GetAdminAPIKey() error = %v", err)
+	}
+	if key != "admin-key" || len(*logins) != 1 || fake.calls != 2 {
+		t.Errorf("key = %q, logins = %d, calls = %d", key, len(*logins), fake.calls)
+	}
+}
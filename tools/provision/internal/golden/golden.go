@@ -0,0 +1,67 @@
+// Package golden compares generated files against checked-in golden copies
+// under a package's testdata directory.
+//
+// Run the tests with -update-golden to rewrite the golden files from the
+// current output:
+//
+//	go test ./internal/endpoints -update-golden
+package golden
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+var update = flag.Bool("update-golden", false, "rewrite golden files in testdata with the current output")
+
+// timestampPattern matches the value of a "Generated:" comment, which changes
+// on every run and is masked before comparing.
+var timestampPattern = regexp.MustCompile(`(Generated: )\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})`)
+
+// Normalize replaces "Generated:" timestamps in data with a fixed placeholder.
+func Normalize(data []byte) []byte {
+	return timestampPattern.ReplaceAll(data, []byte("${1}<timestamp>"))
+}
+
+// Assert fails t unless got matches testdata/name byte for byte, apart from
+// "Generated:" timestamps. With -update-golden the golden file is rewritten
+// instead.
+func Assert(t testing.TB, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	got = Normalize(got)
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file (run with -update-golden to create it): %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from golden file (run with -update-golden to accept)\n--- got\n%s\n--- want\n%s", path, got, want)
+	}
+}
+
+// AssertFile is Assert for the contents of the file at path.
+func AssertFile(t testing.TB, name, path string) {
+	t.Helper()
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Assert(t, name, got)
+}
@@ -0,0 +1,21 @@
+package golden
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"// Generated: 2024-01-02T03:04:05Z\n", "// Generated: <timestamp>\n"},
+		{"// Generated: 2024-01-02T03:04:05.123+02:00\n", "// Generated: <timestamp>\n"},
+		{"#pragma once\n", "#pragma once\n"},
+		{" * Generated by: go run tools/setup\n", " * Generated by: go run tools/setup\n"},
+	}
+
+	for _, tt := range tests {
+		if got := string(Normalize([]byte(tt.in))); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
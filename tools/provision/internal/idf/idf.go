@@ -0,0 +1,107 @@
+package idf
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// MarkerPath is the file, relative to the ESP-IDF root, that the provisioning
+// tool needs from the installation.
+var MarkerPath = filepath.Join("components", "nvs_flash", "nvs_partition_generator", "nvs_partition_gen.py")
+
+// lookPath resolves a tool on PATH. Tests replace it.
+var lookPath = exec.LookPath
+
+// toolHints tell the user how to get each external tool onto PATH.
+var toolHints = map[string]string{
+	"python3":    "install Python 3 or activate the ESP-IDF environment (. $IDF_PATH/export.sh)",
+	"esptool.py": "activate the ESP-IDF environment (. $IDF_PATH/export.sh)",
+	"idf.py":     "activate the ESP-IDF environment (. $IDF_PATH/export.sh)",
+}
+
+// RequireTool reports an actionable error if name is not on PATH, so a
+// missing tool isn't surfaced as a bare exec "file not found".
+func RequireTool(name string) error {
+	if _, err := lookPath(name); err != nil {
+		hint, ok := toolHints[name]
+		if !ok {
+			hint = "check that it is installed and on PATH"
+		}
+		return fmt.Errorf("%s not found - %s", name, hint)
+	}
+	return nil
+}
+
+// Find returns the ESP-IDF root to use. An explicit override wins, then
+// $IDF_PATH, then an idf.py on PATH, then the standard install locations.
+func Find(override string) (string, error) {
+	if override != "" {
+		if err := Validate(override); err != nil {
+			return "", fmt.Errorf("-idf-path: %w", err)
+		}
+		return override, nil
+	}
+
+	if env := os.Getenv("IDF_PATH"); env != "" {
+		if err := Validate(env); err != nil {
+			return "", fmt.Errorf("IDF_PATH: %w", err)
+		}
+		return env, nil
+	}
+
+	for _, candidate := range Candidates() {
+		if Validate(candidate) == nil {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("ESP-IDF not found - source ESP-IDF environment or pass -idf-path")
+}
+
+// Candidates lists the locations searched when IDF_PATH is not set.
+func Candidates() []string {
+	var candidates []string
+
+	if idfPy, err := lookPath("idf.py"); err == nil {
+		if resolved, err := filepath.EvalSymlinks(idfPy); err == nil {
+			idfPy = resolved
+		}
+		// idf.py lives in <idf>/tools/idf.py
+		candidates = append(candidates, filepath.Dir(filepath.Dir(idfPy)))
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return candidates
+	}
+
+	candidates = append(candidates,
+		filepath.Join(home, "esp", "esp-idf"),
+		filepath.Join(home, ".espressif", "esp-idf"),
+	)
+
+	// Versioned installs, e.g. ~/.espressif/v5.2/esp-idf
+	if matches, err := filepath.Glob(filepath.Join(home, ".espressif", "*", "esp-idf")); err == nil {
+		candidates = append(candidates, matches...)
+	}
+
+	return candidates
+}
+
+// Validate checks that path is an ESP-IDF root containing MarkerPath.
+func Validate(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+
+	if _, err := os.Stat(filepath.Join(path, MarkerPath)); err != nil {
+		return fmt.Errorf("%s does not look like ESP-IDF (missing %s)", path, MarkerPath)
+	}
+	return nil
+}
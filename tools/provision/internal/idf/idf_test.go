@@ -0,0 +1,146 @@
+package idf
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// makeIDF creates a fake ESP-IDF tree under dir.
+func makeIDF(t *testing.T, dir string) {
+	t.Helper()
+	script := filepath.Join(dir, MarkerPath)
+	if err := os.MkdirAll(filepath.Dir(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(script, []byte("# generator"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := t.TempDir()
+	makeIDF(t, valid)
+
+	incomplete := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(incomplete, "components", "nvs_flash"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "esp-idf")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{name: "valid install", path: valid},
+		{name: "missing generator", path: incomplete, wantErr: "does not look like ESP-IDF"},
+		{name: "nonexistent", path: filepath.Join(valid, "missing"), wantErr: "no such file"},
+		{name: "not a directory", path: file, wantErr: "not a directory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.path)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	valid := t.TempDir()
+	makeIDF(t, valid)
+	invalid := t.TempDir()
+
+	t.Run("override wins", func(t *testing.T) {
+		t.Setenv("IDF_PATH", invalid)
+		got, err := Find(valid)
+		if err != nil || got != valid {
+			t.Errorf("Find() = %q, %v, want %q", got, err, valid)
+		}
+	})
+
+	t.Run("invalid override", func(t *testing.T) {
+		if _, err := Find(invalid); err == nil {
+			t.Error("Find() expected error for invalid override")
+		}
+	})
+
+	t.Run("env", func(t *testing.T) {
+		t.Setenv("IDF_PATH", valid)
+		got, err := Find("")
+		if err != nil || got != valid {
+			t.Errorf("Find() = %q, %v, want %q", got, err, valid)
+		}
+	})
+
+	t.Run("home fallback", func(t *testing.T) {
+		home := t.TempDir()
+		makeIDF(t, filepath.Join(home, "esp", "esp-idf"))
+		t.Setenv("IDF_PATH", "")
+		t.Setenv("HOME", home)
+		t.Setenv("PATH", "")
+
+		got, err := Find("")
+		if err != nil {
+			t.Fatalf("Find() error = %v", err)
+		}
+		if want := filepath.Join(home, "esp", "esp-idf"); got != want {
+			t.Errorf("Find() = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestRequireTool(t *testing.T) {
+	orig := lookPath
+	t.Cleanup(func() { lookPath = orig })
+
+	tests := []struct {
+		name    string
+		found   bool
+		wantErr string
+	}{
+		{name: "esptool.py", found: true},
+		{name: "esptool.py", wantErr: "esptool.py not found - activate the ESP-IDF environment"},
+		{name: "python3", wantErr: "python3 not found - install Python 3"},
+		{name: "idf.py", wantErr: "idf.py not found - activate the ESP-IDF environment"},
+		{name: "cmake", wantErr: "cmake not found - check that it is installed"},
+	}
+
+	for _, tt := range tests {
+		lookPath = func(file string) (string, error) {
+			if file != tt.name {
+				t.Errorf("lookPath(%q), want %q", file, tt.name)
+			}
+			if tt.found {
+				return "/usr/bin/" + file, nil
+			}
+			return "", &exec.Error{Name: file, Err: exec.ErrNotFound}
+		}
+
+		err := RequireTool(tt.name)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("RequireTool(%q) error = %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("RequireTool(%q) error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
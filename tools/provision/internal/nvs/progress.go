@@ -0,0 +1,85 @@
+package nvs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// progressRe matches esptool's progress lines, e.g.
+// "Writing at 0x00010000... (25 %)".
+var progressRe = regexp.MustCompile(`(\w+) at 0x[0-9a-fA-F]+\.*\s*\((\d{1,3}) ?%\)`)
+
+// parseProgress extracts the phase ("Writing", "Erasing", ...) and percentage
+// from an esptool output line.
+func parseProgress(line string) (phase string, percent int, ok bool) {
+	m := progressRe.FindStringSubmatch(line)
+	if m == nil {
+		return "", 0, false
+	}
+	percent, err := strconv.Atoi(m[2])
+	if err != nil || percent > 100 {
+		return "", 0, false
+	}
+	return m[1], percent, true
+}
+
+// progressWriter passes esptool output through unchanged and keeps a
+// one-line status with the current percentage on status.
+type progressWriter struct {
+	out     io.Writer // esptool's own output; nil discards it
+	status  io.Writer
+	partial []byte
+	phase   string
+	percent int
+}
+
+func newProgressWriter(out, status io.Writer) *progressWriter {
+	return &progressWriter{out: out, status: status, percent: -1}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	if p.out != nil {
+		if _, err := p.out.Write(b); err != nil {
+			return 0, err
+		}
+	}
+
+	// esptool ends progress lines with \r on a TTY and \n otherwise
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexAny(p.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		p.update(string(p.partial[:i]))
+		p.partial = p.partial[i+1:]
+	}
+	return len(b), nil
+}
+
+func (p *progressWriter) update(line string) {
+	phase, percent, ok := parseProgress(line)
+	if !ok || (phase == p.phase && percent == p.percent) {
+		return
+	}
+	p.phase, p.percent = phase, percent
+
+	fmt.Fprintf(p.status, "\r  ⏳ %s %3d%%", phase, percent)
+	if percent == 100 {
+		fmt.Fprintln(p.status)
+	}
+}
+
+// terminalOrNil returns f if it is a terminal, so that progress is only
+// shown interactively.
+func terminalOrNil(f *os.File) io.Writer {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	return f
+}
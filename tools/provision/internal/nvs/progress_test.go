@@ -0,0 +1,64 @@
+package nvs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseProgress(t *testing.T) {
+	tests := []struct {
+		line        string
+		wantPhase   string
+		wantPercent int
+		wantOK      bool
+	}{
+		{"Writing at 0x00009000... (33 %)", "Writing", 33, true},
+		{"Writing at 0x00010000... (100 %)", "Writing", 100, true},
+		{"Writing at 0x0000e000 (0 %)", "Writing", 0, true},
+		{"Erasing at 0x00009000... (50%)", "Erasing", 50, true},
+		{"Compressed 24576 bytes to 145...", "", 0, false},
+		{"Wrote 24576 bytes (145 compressed) at 0x00009000 in 0.1 seconds (effective 1638.4 kbit/s)...", "", 0, false},
+		{"Hash of data verified.", "", 0, false},
+		{"Writing at 0x00009000... (250 %)", "", 0, false},
+		{"", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			phase, percent, ok := parseProgress(tt.line)
+			if ok != tt.wantOK || phase != tt.wantPhase || percent != tt.wantPercent {
+				t.Errorf("parseProgress(%q) = (%q, %d, %t), want (%q, %d, %t)",
+					tt.line, phase, percent, ok, tt.wantPhase, tt.wantPercent, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestProgressWriter(t *testing.T) {
+	var out, status bytes.Buffer
+	p := newProgressWriter(&out, &status)
+
+	esptool := "Compressed 24576 bytes to 145...\n" +
+		"Writing at 0x00009000... (50 %)\r" +
+		"Writing at 0x00009000... (50 %)\r" +
+		"Writing at 0x0000a000... (100 %)\n" +
+		"Hash of data verified.\n"
+
+	// Split writes mid-line, as pipe reads do
+	for _, chunk := range []string{esptool[:40], esptool[40:70], esptool[70:]} {
+		if _, err := p.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	if out.String() != esptool {
+		t.Errorf("esptool output was modified:\n%q", out.String())
+	}
+	if got := strings.Count(status.String(), "%"); got != 2 {
+		t.Errorf("status = %q, want one update per new percentage", status.String())
+	}
+	if !strings.HasSuffix(status.String(), "Writing 100%\n") {
+		t.Errorf("status = %q, want it to end on 100%% with a newline", status.String())
+	}
+}
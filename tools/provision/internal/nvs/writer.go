@@ -1,33 +1,243 @@
 package nvs
 
 import (
+	"bytes"
 	"encoding/csv"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+
+	"measurement-probe/tools/provision/internal/idf"
+	"measurement-probe/tools/provision/internal/serial"
+)
+
+// NVS layout constants used to estimate the encoded size of a partition.
+// Each 4 KiB page holds 126 32-byte entries after its header and state
+// bitmap, and the library keeps one page free for garbage collection.
+const (
+	pageSize         = 4096
+	entrySize        = 32
+	entriesPerPage   = 126
+	reservedPages    = 1
+	minPartitionSize = 3 * pageSize
 )
 
+// maxKeyLen is the longest namespace or key name NVS accepts.
+const maxKeyLen = 15
+
 type Credentials struct {
 	DeviceID string
 	Secret   string
 }
 
+// Entry is a string value stored under Key.
+type Entry struct {
+	Key   string
+	Value string
+}
+
+// Namespace is an NVS namespace and the entries written to it.
+type Namespace struct {
+	Name    string
+	Entries []Entry
+}
+
+// CommandRunner executes external tools. Allows mocking in tests.
+type CommandRunner interface {
+	Run(name string, args ...string) error
+}
+
+// ExecRunner is the default CommandRunner using os/exec.
+type ExecRunner struct {
+	Stdout io.Writer
+	// Progress, if set, receives a status line with the percentage parsed
+	// from esptool's output. Stdout still gets the full output.
+	Progress io.Writer
+}
+
+// Run executes a command, sending its output to Stdout and os.Stderr. The
+// last line of stderr is included in the returned error.
+func (r *ExecRunner) Run(name string, args ...string) error {
+	if err := idf.RequireTool(name); err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = r.Stdout
+	if r.Progress != nil {
+		cmd.Stdout = newProgressWriter(r.Stdout, r.Progress)
+	}
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	if err := cmd.Run(); err != nil {
+		if msg := lastLine(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		s = s[i+1:]
+	}
+	return strings.TrimSpace(s)
+}
+
+// keysFileName is the name nvs_partition_gen.py gives a generated key
+// partition binary, in a keys directory next to the NVS binary.
+const keysFileName = "nvs_keys.bin"
+
+// Encryption configures encrypted NVS: the NVS binary is encrypted with the
+// XTS keys in an nvs_keys partition, which is flashed alongside it.
+type Encryption struct {
+	// KeyFile is an existing nvs_keys partition binary. When empty, a new key
+	// is generated for each NVS binary.
+	KeyFile string
+	// KeysOffset is the flash offset of the nvs_keys partition.
+	KeysOffset int
+}
+
 type Writer struct {
 	espIdfPath string
 	port       string
 	namespace  string
+	runner     CommandRunner
+	encryption *Encryption
+	eraseFirst bool
+	extra      []Namespace
 }
 
 func NewWriter(espIdfPath, port string) *Writer {
+	return NewWriterWithRunner(espIdfPath, port, &ExecRunner{Stdout: os.Stdout, Progress: terminalOrNil(os.Stderr)})
+}
+
+// NewWriterWithRunner creates a writer with a custom command runner (for testing).
+func NewWriterWithRunner(espIdfPath, port string, runner CommandRunner) *Writer {
 	return &Writer{
 		espIdfPath: espIdfPath,
 		port:       port,
 		namespace:  "cloud",
+		runner:     runner,
 	}
 }
 
-func (w *Writer) GenerateCSV(creds *Credentials, outputPath string) error {
+// SetOutput redirects the output of the invoked tools (stdout by default).
+// It has no effect when a custom runner is used.
+func (w *Writer) SetOutput(out io.Writer) {
+	if r, ok := w.runner.(*ExecRunner); ok {
+		r.Stdout = out
+	}
+}
+
+// SetEncryption makes the writer generate encrypted NVS binaries and flash
+// the key partition with them. A nil enc restores plaintext NVS.
+func (w *Writer) SetEncryption(enc *Encryption) {
+	w.encryption = enc
+}
+
+// SetEraseBeforeWrite makes WriteCredentials erase the whole NVS partition
+// before flashing, so keys left in other namespaces don't survive.
+func (w *Writer) SetEraseBeforeWrite(erase bool) {
+	w.eraseFirst = erase
+}
+
+// AddNamespace adds a namespace to write alongside the credentials, e.g. to
+// pre-seed Wi-Fi settings. Names must be unique and at most 15 characters.
+func (w *Writer) AddNamespace(ns Namespace) error {
+	if err := checkKeyName("namespace", ns.Name); err != nil {
+		return err
+	}
+	for _, existing := range w.Namespaces(&Credentials{}) {
+		if existing.Name == ns.Name {
+			return fmt.Errorf("namespace %q is already written", ns.Name)
+		}
+	}
+	for _, entry := range ns.Entries {
+		if err := checkKeyName("key", entry.Key); err != nil {
+			return fmt.Errorf("namespace %q: %w", ns.Name, err)
+		}
+	}
+	w.extra = append(w.extra, ns)
+	return nil
+}
+
+// Namespaces returns every namespace the writer stores: the credentials
+// namespace first, then those added with AddNamespace in order.
+func (w *Writer) Namespaces(creds *Credentials) []Namespace {
+	namespaces := []Namespace{{
+		Name: w.namespace,
+		Entries: []Entry{
+			{Key: "device_id", Value: creds.DeviceID},
+			{Key: "secret", Value: creds.Secret},
+		},
+	}}
+	return append(namespaces, w.extra...)
+}
+
+func checkKeyName(kind, name string) error {
+	if name == "" {
+		return fmt.Errorf("empty %s name", kind)
+	}
+	if len(name) > maxKeyLen {
+		return fmt.Errorf("%s %q is longer than %d characters", kind, name, maxKeyLen)
+	}
+	return nil
+}
+
+// KeyPath returns the key partition binary that goes with the NVS binary at
+// binPath, or "" when the writer doesn't encrypt.
+func (w *Writer) KeyPath(binPath string) string {
+	switch {
+	case w.encryption == nil:
+		return ""
+	case w.encryption.KeyFile != "":
+		return w.encryption.KeyFile
+	default:
+		return filepath.Join(filepath.Dir(binPath), "keys", keysFileName)
+	}
+}
+
+// EstimateSize returns the number of partition bytes needed to store the
+// namespaces, including the page reserved by NVS.
+func EstimateSize(namespaces []Namespace) int {
+	entries := 0
+	for _, ns := range namespaces {
+		entries++ // namespace entry
+		for _, entry := range ns.Entries {
+			entries += stringEntries(entry.Value)
+		}
+	}
+
+	pages := (entries+entriesPerPage-1)/entriesPerPage + reservedPages
+	return max(pages*pageSize, minPartitionSize)
+}
+
+// stringEntries returns the entries a string value occupies: one header
+// entry plus its NUL-terminated data rounded up to whole entries.
+func stringEntries(value string) int {
+	return 1 + (len(value)+1+entrySize-1)/entrySize
+}
+
+// CheckCapacity reports an error if the credentials will not fit in a
+// partition of partitionSize bytes.
+func (w *Writer) CheckCapacity(creds *Credentials, partitionSize int) error {
+	need := EstimateSize(w.Namespaces(creds))
+	if need > partitionSize {
+		return fmt.Errorf("credentials need %d bytes but partition is %d bytes (short by %d)",
+			need, partitionSize, need-partitionSize)
+	}
+	return nil
+}
+
+// GenerateCSV writes the nvs_partition_gen.py input for namespaces, one
+// block per namespace in order.
+func (w *Writer) GenerateCSV(namespaces []Namespace, outputPath string) error {
 	file, err := os.Create(outputPath)
 	if err != nil {
 		return fmt.Errorf("create CSV: %w", err)
@@ -42,21 +252,15 @@ func (w *Writer) GenerateCSV(creds *Credentials, outputPath string) error {
 		return err
 	}
 
-	// Write namespace
-	if err := writer.Write([]string{w.namespace, "namespace", "", ""}); err != nil {
-		return err
-	}
-
-	// Write credentials
-	records := [][]string{
-		{"device_id", "data", "string", creds.DeviceID},
-		{"secret", "data", "string", creds.Secret},
-	}
-
-	for _, record := range records {
-		if err := writer.Write(record); err != nil {
+	for _, ns := range namespaces {
+		if err := writer.Write([]string{ns.Name, "namespace", "", ""}); err != nil {
 			return err
 		}
+		for _, entry := range ns.Entries {
+			if err := writer.Write([]string{entry.Key, "data", "string", entry.Value}); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -65,11 +269,24 @@ func (w *Writer) GenerateCSV(creds *Credentials, outputPath string) error {
 func (w *Writer) GenerateBinary(csvPath, binPath string, size int) error {
 	scriptPath := filepath.Join(w.espIdfPath, "components", "nvs_flash", "nvs_partition_generator", "nvs_partition_gen.py")
 
-	cmd := exec.Command("python3", scriptPath, "generate", csvPath, binPath, fmt.Sprintf("0x%x", size))
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	size0x := fmt.Sprintf("0x%x", size)
 
-	if err := cmd.Run(); err != nil {
+	args := []string{scriptPath, "generate", csvPath, binPath, size0x}
+	if w.encryption != nil {
+		// The generator resolves the output and key paths against --outdir
+		absBin, err := filepath.Abs(binPath)
+		if err != nil {
+			return err
+		}
+		args = []string{scriptPath, "encrypt", csvPath, absBin, size0x, "--outdir", filepath.Dir(absBin)}
+		if w.encryption.KeyFile != "" {
+			args = append(args, "--inputkey", w.encryption.KeyFile)
+		} else {
+			args = append(args, "--keygen", "--keyfile", keysFileName)
+		}
+	}
+
+	if err := w.runner.Run("python3", args...); err != nil {
 		return fmt.Errorf("nvs_partition_gen.py failed: %w", err)
 	}
 
@@ -77,25 +294,46 @@ func (w *Writer) GenerateBinary(csvPath, binPath string, size int) error {
 }
 
 func (w *Writer) Flash(binPath string, offset int) error {
-	cmd := exec.Command("esptool.py",
+	if err := w.runner.Run("esptool.py",
 		"--port", w.port,
 		"write_flash", fmt.Sprintf("0x%x", offset), binPath,
-	)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	); err != nil {
+		if serial.IsBusy(err.Error()) {
+			return serial.ExplainBusy(w.port, err)
+		}
+		return fmt.Errorf("esptool.py failed: %w", err)
+	}
 
-	if err := cmd.Run(); err != nil {
+	return nil
+}
+
+// EraseRegion erases size bytes of flash starting at offset. Both must be
+// multiples of the 4 KiB flash sector.
+func (w *Writer) EraseRegion(offset, size int) error {
+	if err := w.runner.Run("esptool.py",
+		"--port", w.port,
+		"erase_region", fmt.Sprintf("0x%x", offset), fmt.Sprintf("0x%x", size),
+	); err != nil {
+		if serial.IsBusy(err.Error()) {
+			return serial.ExplainBusy(w.port, err)
+		}
 		return fmt.Errorf("esptool.py failed: %w", err)
 	}
 
 	return nil
 }
 
-func (w *Writer) WriteCredentials(creds *Credentials, tmpDir string, partitionOffset, partitionSize int) error {
+// GenerateImage builds the NVS partition binary for creds at binPath without
+// flashing it, failing first if they don't fit (see CheckCapacity). tmpDir
+// holds the intermediate CSV.
+func (w *Writer) GenerateImage(creds *Credentials, tmpDir, binPath string, partitionSize int) error {
 	csvPath := filepath.Join(tmpDir, "nvs_creds.csv")
-	binPath := filepath.Join(tmpDir, "nvs_creds.bin")
 
-	if err := w.GenerateCSV(creds, csvPath); err != nil {
+	if err := w.CheckCapacity(creds, partitionSize); err != nil {
+		return err
+	}
+
+	if err := w.GenerateCSV(w.Namespaces(creds), csvPath); err != nil {
 		return fmt.Errorf("generate CSV: %w", err)
 	}
 
@@ -103,9 +341,31 @@ func (w *Writer) WriteCredentials(creds *Credentials, tmpDir string, partitionOf
 		return fmt.Errorf("generate binary: %w", err)
 	}
 
+	return nil
+}
+
+func (w *Writer) WriteCredentials(creds *Credentials, tmpDir string, partitionOffset, partitionSize int) error {
+	binPath := filepath.Join(tmpDir, "nvs_creds.bin")
+
+	if err := w.GenerateImage(creds, tmpDir, binPath, partitionSize); err != nil {
+		return err
+	}
+
+	if w.eraseFirst {
+		if err := w.EraseRegion(partitionOffset, partitionSize); err != nil {
+			return fmt.Errorf("erase NVS: %w", err)
+		}
+	}
+
 	if err := w.Flash(binPath, partitionOffset); err != nil {
 		return fmt.Errorf("flash: %w", err)
 	}
 
+	if w.encryption != nil {
+		if err := w.Flash(w.KeyPath(binPath), w.encryption.KeysOffset); err != nil {
+			return fmt.Errorf("flash NVS keys: %w", err)
+		}
+	}
+
 	return nil
 }
@@ -1,10 +1,13 @@
 package nvs
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"measurement-probe/tools/provision/internal/serial"
 )
 
 func TestGenerateCSV(t *testing.T) {
@@ -17,7 +20,7 @@ func TestGenerateCSV(t *testing.T) {
 		Secret:   "test-secret-value",
 	}
 
-	if err := writer.GenerateCSV(creds, csvPath); err != nil {
+	if err := writer.GenerateCSV(writer.Namespaces(creds), csvPath); err != nil {
 		t.Fatalf("GenerateCSV() error = %v", err)
 	}
 
@@ -76,3 +79,287 @@ func TestNewWriter(t *testing.T) {
 		t.Errorf("namespace = %s, want cloud", writer.namespace)
 	}
 }
+
+func TestEstimateSize(t *testing.T) {
+	creds := &Credentials{DeviceID: "device-123", Secret: strings.Repeat("s", 64)}
+
+	// 1 namespace + device_id (1+1) + secret (1+3) = 7 entries, one page plus the
+	// reserved page, clamped to the generator's three-page minimum.
+	writer := NewWriter("/fake/idf", "/dev/ttyUSB0")
+	if got := EstimateSize(writer.Namespaces(creds)); got != 0x3000 {
+		t.Errorf("EstimateSize() = 0x%X, want 0x3000", got)
+	}
+
+	large := &Credentials{DeviceID: "device-123", Secret: strings.Repeat("s", 8000)}
+	if got := EstimateSize(writer.Namespaces(large)); got <= 0x3000 {
+		t.Errorf("EstimateSize(large) = 0x%X, want more than 0x3000", got)
+	}
+}
+
+func TestGenerateCSV_MultipleNamespaces(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "test.csv")
+
+	writer := NewWriter("/fake/idf", "/dev/ttyUSB0")
+	wifi := Namespace{Name: "wifi", Entries: []Entry{
+		{Key: "ssid", Value: "factory-ap"},
+		{Key: "password", Value: "hunter2"},
+	}}
+	if err := writer.AddNamespace(wifi); err != nil {
+		t.Fatalf("AddNamespace() error = %v", err)
+	}
+
+	creds := &Credentials{DeviceID: "test-device-id", Secret: "test-secret-value"}
+	if err := writer.GenerateCSV(writer.Namespaces(creds), csvPath); err != nil {
+		t.Fatalf("GenerateCSV() error = %v", err)
+	}
+
+	content, err := os.ReadFile(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "key,type,encoding,value\n" +
+		"cloud,namespace,,\n" +
+		"device_id,data,string,test-device-id\n" +
+		"secret,data,string,test-secret-value\n" +
+		"wifi,namespace,,\n" +
+		"ssid,data,string,factory-ap\n" +
+		"password,data,string,hunter2\n"
+	if string(content) != want {
+		t.Errorf("CSV =\n%s\nwant:\n%s", content, want)
+	}
+}
+
+func TestAddNamespace_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		ns      Namespace
+		wantErr string
+	}{
+		{"duplicate credentials namespace", Namespace{Name: "cloud"}, "already written"},
+		{"empty name", Namespace{}, "empty namespace name"},
+		{"long name", Namespace{Name: "a_very_long_namespace"}, "longer than 15"},
+		{"long key", Namespace{Name: "wifi", Entries: []Entry{{Key: "station_password", Value: "x"}}}, `namespace "wifi": key`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer := NewWriter("/fake/idf", "/dev/ttyUSB0")
+			err := writer.AddNamespace(tt.ns)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("AddNamespace() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckCapacity(t *testing.T) {
+	writer := NewWriter("/fake/idf", "/dev/ttyUSB0")
+
+	tests := []struct {
+		name    string
+		creds   *Credentials
+		size    int
+		wantErr bool
+	}{
+		{
+			name:  "fits default partition",
+			creds: &Credentials{DeviceID: "device-123", Secret: "secret-value"},
+			size:  0x6000,
+		},
+		{
+			name:    "partition below minimum",
+			creds:   &Credentials{DeviceID: "device-123", Secret: "secret-value"},
+			size:    0x2000,
+			wantErr: true,
+		},
+		{
+			name:    "secret too large",
+			creds:   &Credentials{DeviceID: "device-123", Secret: strings.Repeat("s", 30000)},
+			size:    0x6000,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := writer.CheckCapacity(tt.creds, tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckCapacity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "short by") {
+				t.Errorf("CheckCapacity() error = %q, want shortfall", err)
+			}
+		})
+	}
+}
+
+// failingRunner is a test double for CommandRunner that always fails with err.
+type failingRunner struct {
+	err error
+}
+
+func (r *failingRunner) Run(name string, args ...string) error {
+	return r.err
+}
+
+func TestFlashBusyPort(t *testing.T) {
+	runner := &failingRunner{err: errors.New("exit status 2: A fatal error occurred: Could not open /dev/ttyUSB0, the port is busy or doesn't exist.")}
+	writer := NewWriterWithRunner("/fake/idf", "/dev/ttyUSB0", runner)
+
+	err := writer.Flash("nvs.bin", 0x9000)
+	if !errors.Is(err, serial.ErrPortBusy) {
+		t.Fatalf("Flash() error = %v, want ErrPortBusy", err)
+	}
+	if !strings.Contains(err.Error(), "/dev/ttyUSB0") {
+		t.Errorf("Flash() error = %q, want port name", err)
+	}
+}
+
+// recordingRunner is a test double for CommandRunner that records each command.
+type recordingRunner struct {
+	commands [][]string
+}
+
+func (r *recordingRunner) Run(name string, args ...string) error {
+	r.commands = append(r.commands, append([]string{name}, args...))
+	return nil
+}
+
+func TestGenerateBinaryCommand(t *testing.T) {
+	tmpDir := t.TempDir()
+	csvPath := filepath.Join(tmpDir, "nvs.csv")
+	binPath := filepath.Join(tmpDir, "nvs.bin")
+	script := filepath.Join("/idf", "components", "nvs_flash", "nvs_partition_generator", "nvs_partition_gen.py")
+
+	tests := []struct {
+		name       string
+		encryption *Encryption
+		want       []string
+		wantKey    string
+	}{
+		{
+			name: "plaintext",
+			want: []string{"python3", script, "generate", csvPath, binPath, "0x6000"},
+		},
+		{
+			name:       "encrypted with generated key",
+			encryption: &Encryption{KeysOffset: 0xe000},
+			want: []string{"python3", script, "encrypt", csvPath, binPath, "0x6000",
+				"--outdir", tmpDir, "--keygen", "--keyfile", "nvs_keys.bin"},
+			wantKey: filepath.Join(tmpDir, "keys", "nvs_keys.bin"),
+		},
+		{
+			name:       "encrypted with existing key",
+			encryption: &Encryption{KeyFile: "/secure/nvs_keys.bin", KeysOffset: 0xe000},
+			want: []string{"python3", script, "encrypt", csvPath, binPath, "0x6000",
+				"--outdir", tmpDir, "--inputkey", "/secure/nvs_keys.bin"},
+			wantKey: "/secure/nvs_keys.bin",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &recordingRunner{}
+			writer := NewWriterWithRunner("/idf", "/dev/ttyUSB0", runner)
+			writer.SetEncryption(tt.encryption)
+
+			if err := writer.GenerateBinary(csvPath, binPath, 0x6000); err != nil {
+				t.Fatalf("GenerateBinary() error = %v", err)
+			}
+			if len(runner.commands) != 1 {
+				t.Fatalf("ran %d commands, want 1", len(runner.commands))
+			}
+			if got := strings.Join(runner.commands[0], " "); got != strings.Join(tt.want, " ") {
+				t.Errorf("command = %s\nwant      %s", got, strings.Join(tt.want, " "))
+			}
+			if got := writer.KeyPath(binPath); got != tt.wantKey {
+				t.Errorf("KeyPath() = %q, want %q", got, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestWriteCredentialsEncryptedFlashesKeys(t *testing.T) {
+	tmpDir := t.TempDir()
+	creds := &Credentials{DeviceID: "device-123", Secret: "secret-value"}
+
+	t.Run("plaintext", func(t *testing.T) {
+		runner := &recordingRunner{}
+		writer := NewWriterWithRunner("/idf", "/dev/ttyUSB0", runner)
+		if err := writer.WriteCredentials(creds, tmpDir, 0x9000, 0x5000); err != nil {
+			t.Fatalf("WriteCredentials() error = %v", err)
+		}
+
+		flashes := flashCommands(runner)
+		if len(flashes) != 1 || !strings.Contains(flashes[0], "write_flash 0x9000") {
+			t.Errorf("flash commands = %q, want only the NVS partition", flashes)
+		}
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		runner := &recordingRunner{}
+		writer := NewWriterWithRunner("/idf", "/dev/ttyUSB0", runner)
+		writer.SetEncryption(&Encryption{KeysOffset: 0xe000})
+		if err := writer.WriteCredentials(creds, tmpDir, 0x9000, 0x5000); err != nil {
+			t.Fatalf("WriteCredentials() error = %v", err)
+		}
+
+		flashes := flashCommands(runner)
+		if len(flashes) != 2 {
+			t.Fatalf("flash commands = %q, want NVS and keys", flashes)
+		}
+		if !strings.Contains(flashes[0], "write_flash 0x9000 "+filepath.Join(tmpDir, "nvs_creds.bin")) {
+			t.Errorf("first flash = %q, want encrypted NVS at 0x9000", flashes[0])
+		}
+		if !strings.Contains(flashes[1], "write_flash 0xe000 "+filepath.Join(tmpDir, "keys", "nvs_keys.bin")) {
+			t.Errorf("second flash = %q, want generated keys at 0xe000", flashes[1])
+		}
+	})
+}
+
+func TestEraseRegionCommand(t *testing.T) {
+	runner := &recordingRunner{}
+	writer := NewWriterWithRunner("/idf", "/dev/ttyUSB0", runner)
+
+	if err := writer.EraseRegion(0x9000, 0x6000); err != nil {
+		t.Fatalf("EraseRegion() error = %v", err)
+	}
+
+	want := "esptool.py --port /dev/ttyUSB0 erase_region 0x9000 0x6000"
+	if len(runner.commands) != 1 || strings.Join(runner.commands[0], " ") != want {
+		t.Errorf("commands = %q, want %q", runner.commands, want)
+	}
+}
+
+func TestWriteCredentialsErasesFirst(t *testing.T) {
+	creds := &Credentials{DeviceID: "device-123", Secret: "secret-value"}
+
+	runner := &recordingRunner{}
+	writer := NewWriterWithRunner("/idf", "/dev/ttyUSB0", runner)
+	writer.SetEraseBeforeWrite(true)
+	if err := writer.WriteCredentials(creds, t.TempDir(), 0x9000, 0x6000); err != nil {
+		t.Fatalf("WriteCredentials() error = %v", err)
+	}
+
+	esptool := flashCommands(runner)
+	if len(esptool) != 2 {
+		t.Fatalf("esptool commands = %q, want erase then flash", esptool)
+	}
+	if !strings.Contains(esptool[0], "erase_region 0x9000 0x6000") {
+		t.Errorf("first command = %q, want erase of the NVS partition", esptool[0])
+	}
+	if !strings.Contains(esptool[1], "write_flash 0x9000") {
+		t.Errorf("second command = %q, want NVS flash", esptool[1])
+	}
+}
+
+func flashCommands(r *recordingRunner) []string {
+	var flashes []string
+	for _, cmd := range r.commands {
+		if cmd[0] == "esptool.py" {
+			flashes = append(flashes, strings.Join(cmd, " "))
+		}
+	}
+	return flashes
+}
@@ -0,0 +1,140 @@
+package partition
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ESP-IDF binary partition table layout (partition-table.bin): 32-byte
+// entries starting with entryMagic, optionally followed by an MD5 entry over
+// the preceding entries, terminated by 0xFF padding.
+const (
+	binaryEntrySize = 32
+	binaryNameSize  = 16
+	maxBinarySize   = 0xC00
+
+	flagEncrypted = 1 << 0
+	flagReadOnly  = 1 << 1
+)
+
+var (
+	entryMagic = []byte{0xAA, 0x50}
+	md5Magic   = []byte{0xEB, 0xEB}
+)
+
+var binaryTypes = map[byte]string{
+	0x00: "app",
+	0x01: "data",
+	0x02: "bootloader",
+	0x03: "partition_table",
+}
+
+var binarySubTypes = map[string]map[byte]string{
+	"app": {
+		0x00: "factory",
+		0x20: "test",
+	},
+	"data": {
+		0x00: "ota",
+		0x01: "phy",
+		0x02: "nvs",
+		0x03: "coredump",
+		0x04: "nvs_keys",
+		0x05: "efuse",
+		0x06: "undefined",
+		0x80: "esphttpd",
+		0x81: "fat",
+		0x82: "spiffs",
+		0x83: "littlefs",
+	},
+	"bootloader": {
+		0x00: "primary",
+		0x01: "ota",
+	},
+	"partition_table": {
+		0x00: "primary",
+		0x01: "ota",
+	},
+}
+
+// ParseBinary reads a partition table compiled by ESP-IDF, such as
+// build/partition_table/partition-table.bin, into the same entries ParseFile
+// returns for the CSV. Types and subtypes without a name are kept as hex
+// (e.g. "0x40"). The MD5 entry, if present, is verified.
+func ParseBinary(path string) (*Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("open partition table: %w", err)
+	}
+	if len(data) > maxBinarySize {
+		data = data[:maxBinarySize]
+	}
+
+	var entries []Entry
+	for off := 0; off+binaryEntrySize <= len(data); off += binaryEntrySize {
+		raw := data[off : off+binaryEntrySize]
+
+		switch {
+		case bytes.HasPrefix(raw, entryMagic):
+			entries = append(entries, decodeEntry(raw))
+		case bytes.HasPrefix(raw, md5Magic):
+			if sum := md5.Sum(data[:off]); !bytes.Equal(raw[16:], sum[:]) {
+				return nil, fmt.Errorf("partition table %s: MD5 mismatch", path)
+			}
+		case bytes.Equal(raw, bytes.Repeat([]byte{0xFF}, binaryEntrySize)):
+			return newBinaryTable(path, entries)
+		default:
+			return nil, fmt.Errorf("partition table %s: invalid entry at offset 0x%x", path, off)
+		}
+	}
+	return newBinaryTable(path, entries)
+}
+
+func newBinaryTable(path string, entries []Entry) (*Table, error) {
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("partition table %s: no entries", path)
+	}
+	return &Table{entries: entries}, nil
+}
+
+func decodeEntry(raw []byte) Entry {
+	typ := binaryTypes[raw[2]]
+	if typ == "" {
+		typ = fmt.Sprintf("0x%02x", raw[2])
+	}
+
+	subType := binarySubTypes[typ][raw[3]]
+	if typ == "app" && raw[3] >= 0x10 && raw[3] < 0x20 {
+		subType = fmt.Sprintf("ota_%d", raw[3]-0x10)
+	}
+	if subType == "" {
+		subType = fmt.Sprintf("0x%02x", raw[3])
+	}
+
+	name := raw[12 : 12+binaryNameSize]
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+
+	var flags []string
+	bits := binary.LittleEndian.Uint32(raw[28:32])
+	if bits&flagEncrypted != 0 {
+		flags = append(flags, "encrypted")
+	}
+	if bits&flagReadOnly != 0 {
+		flags = append(flags, "readonly")
+	}
+
+	return Entry{
+		Name:    string(name),
+		Type:    typ,
+		SubType: subType,
+		Offset:  int(binary.LittleEndian.Uint32(raw[4:8])),
+		Size:    int(binary.LittleEndian.Uint32(raw[8:12])),
+		Flags:   strings.Join(flags, ":"),
+	}
+}
@@ -0,0 +1,120 @@
+package partition
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// binaryEntry encodes one 32-byte partition table entry as gen_esp32part.py does.
+func binaryEntry(typ, subType byte, offset, size uint32, name string, flags uint32) []byte {
+	raw := make([]byte, binaryEntrySize)
+	copy(raw, entryMagic)
+	raw[2], raw[3] = typ, subType
+	binary.LittleEndian.PutUint32(raw[4:], offset)
+	binary.LittleEndian.PutUint32(raw[8:], size)
+	copy(raw[12:12+binaryNameSize], name)
+	binary.LittleEndian.PutUint32(raw[28:], flags)
+	return raw
+}
+
+// binaryTable joins entries, appends the MD5 entry when withMD5 is set and
+// pads with 0xFF to the full table size.
+func binaryTable(withMD5 bool, entries ...[]byte) []byte {
+	data := bytes.Join(entries, nil)
+	if withMD5 {
+		sum := md5.Sum(data)
+		md5Entry := append(append([]byte{}, md5Magic...), bytes.Repeat([]byte{0xFF}, 14)...)
+		data = append(append(data, md5Entry...), sum[:]...)
+	}
+	return append(data, bytes.Repeat([]byte{0xFF}, maxBinarySize-len(data))...)
+}
+
+func writeBinary(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "partition-table.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseBinary(t *testing.T) {
+	data := binaryTable(true,
+		binaryEntry(0x01, 0x02, 0x9000, 0x6000, "nvs", 0),
+		binaryEntry(0x01, 0x01, 0xf000, 0x1000, "phy_init", 0),
+		binaryEntry(0x00, 0x00, 0x10000, 0x100000, "factory", 0),
+		binaryEntry(0x00, 0x11, 0x110000, 0x100000, "ota_1", 0),
+		binaryEntry(0x01, 0x04, 0x210000, 0x1000, "nvs_keys", flagEncrypted),
+		binaryEntry(0x40, 0x07, 0x211000, 0x1000, "custom", flagEncrypted|flagReadOnly),
+	)
+
+	table, err := ParseBinary(writeBinary(t, data))
+	if err != nil {
+		t.Fatalf("ParseBinary() error = %v", err)
+	}
+
+	want := []Entry{
+		{Name: "nvs", Type: "data", SubType: "nvs", Offset: 0x9000, Size: 0x6000},
+		{Name: "phy_init", Type: "data", SubType: "phy", Offset: 0xf000, Size: 0x1000},
+		{Name: "factory", Type: "app", SubType: "factory", Offset: 0x10000, Size: 0x100000},
+		{Name: "ota_1", Type: "app", SubType: "ota_1", Offset: 0x110000, Size: 0x100000},
+		{Name: "nvs_keys", Type: "data", SubType: "nvs_keys", Offset: 0x210000, Size: 0x1000, Flags: "encrypted"},
+		{Name: "custom", Type: "0x40", SubType: "0x07", Offset: 0x211000, Size: 0x1000, Flags: "encrypted:readonly"},
+	}
+	if got := table.Entries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseBinary() entries =\n%+v\nwant\n%+v", got, want)
+	}
+
+	nvs, err := table.FindByName("nvs")
+	if err != nil || nvs.Offset != 0x9000 {
+		t.Errorf("FindByName(nvs) = %+v, %v", nvs, err)
+	}
+}
+
+func TestParseBinaryWithoutMD5(t *testing.T) {
+	table, err := ParseBinary(writeBinary(t, binaryTable(false, binaryEntry(0x01, 0x02, 0x9000, 0x6000, "nvs", 0))))
+	if err != nil {
+		t.Fatalf("ParseBinary() error = %v", err)
+	}
+	if len(table.Entries()) != 1 {
+		t.Errorf("ParseBinary() got %d entries, want 1", len(table.Entries()))
+	}
+}
+
+func TestParseBinaryErrors(t *testing.T) {
+	corrupt := binaryTable(true, binaryEntry(0x01, 0x02, 0x9000, 0x6000, "nvs", 0))
+	corrupt[5] ^= 0xFF // offset changed after the MD5 was computed
+
+	garbage := binaryTable(false, binaryEntry(0x01, 0x02, 0x9000, 0x6000, "nvs", 0))
+	copy(garbage[binaryEntrySize:], "not a partition entry")
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"md5 mismatch", corrupt, "MD5 mismatch"},
+		{"invalid entry", garbage, "invalid entry at offset 0x20"},
+		{"empty table", binaryTable(false), "no entries"},
+		{"csv file", []byte("nvs, data, nvs, 0x9000, 0x6000,\n" + strings.Repeat(" ", 64)), "invalid entry at offset 0x0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseBinary(writeBinary(t, tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseBinary() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := ParseBinary(filepath.Join(t.TempDir(), "missing.bin")); err == nil {
+		t.Error("ParseBinary() expected error for missing file")
+	}
+}
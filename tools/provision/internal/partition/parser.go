@@ -2,10 +2,12 @@ package partition
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 )
 
 type Entry struct {
@@ -14,6 +16,7 @@ type Entry struct {
 	SubType string
 	Offset  int
 	Size    int
+	Flags   string
 }
 
 type Table struct {
@@ -53,6 +56,48 @@ func ParseFile(path string) (*Table, error) {
 	return &Table{entries: entries}, nil
 }
 
+// NewTable returns a table with a copy of entries, e.g. to build a test
+// fixture and write it with WriteCSV.
+func NewTable(entries []Entry) *Table {
+	t := &Table{entries: make([]Entry, len(entries))}
+	copy(t.entries, entries)
+	return t
+}
+
+// WriteCSV writes the table to path as an ESP-IDF partition CSV that
+// ParseFile reads back to the same entries. Offsets are written in hex and
+// sizes with HumanSize; a zero offset is left empty so the partition tool
+// places it.
+func (t *Table) WriteCSV(path string) error {
+	var buf bytes.Buffer
+	buf.WriteString("# ESP-IDF Partition Table\n")
+
+	w := tabwriter.NewWriter(&buf, 0, 0, 1, ' ', 0)
+	fmt.Fprintln(w, "# Name,\tType,\tSubType,\tOffset,\tSize,\tFlags")
+	for _, e := range t.entries {
+		fmt.Fprintf(w, "%s,\t%s,\t%s,\t%s,\t%s,", e.Name, e.Type, e.SubType, e.OffsetHex(), e.HumanSize())
+		if e.Flags != "" {
+			fmt.Fprintf(w, "\t%s", e.Flags)
+		}
+		fmt.Fprintln(w)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("write partition table: %w", err)
+	}
+	return nil
+}
+
+// Entries returns a copy of the parsed partition entries in file order.
+func (t *Table) Entries() []Entry {
+	entries := make([]Entry, len(t.entries))
+	copy(entries, t.entries)
+	return entries
+}
+
 func (t *Table) FindByName(name string) (*Entry, error) {
 	for _, e := range t.entries {
 		if e.Name == name {
@@ -71,8 +116,73 @@ func (t *Table) FindBySubType(subType string) (*Entry, error) {
 	return nil, fmt.Errorf("partition with subtype %q not found", subType)
 }
 
+// FindApp returns the partition the application image boots from: the
+// factory partition, or ota_0 on OTA-only layouts.
+func (t *Table) FindApp() (*Entry, error) {
+	for _, subType := range []string{"factory", "ota_0"} {
+		if e, err := t.FindBySubType(subType); err == nil && e.Type == "app" {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("no factory or ota_0 app partition found")
+}
+
+// OffsetHex returns the offset in hex, e.g. "0x9000", or "" for a zero
+// offset, which the partition tool assigns itself.
+func (e Entry) OffsetHex() string {
+	if e.Offset == 0 {
+		return ""
+	}
+	return fmt.Sprintf("0x%x", e.Offset)
+}
+
+// HumanSize returns the size as partition CSVs write it: "1M" or "16K" when
+// it is a whole number of MiB or KiB, otherwise the number of bytes.
+func (e Entry) HumanSize() string {
+	switch {
+	case e.Size != 0 && e.Size%(1<<20) == 0:
+		return fmt.Sprintf("%dM", e.Size>>20)
+	case e.Size != 0 && e.Size%(1<<10) == 0:
+		return fmt.Sprintf("%dK", e.Size>>10)
+	default:
+		return strconv.Itoa(e.Size)
+	}
+}
+
+// Overlaps reports whether e and other share any bytes of flash.
+func (e Entry) Overlaps(other Entry) bool {
+	return e.Offset < other.Offset+other.Size && other.Offset < e.Offset+e.Size
+}
+
+// FindAllBySubType returns every partition with the given subtype, in file order.
+func (t *Table) FindAllBySubType(subType string) []Entry {
+	var matches []Entry
+	for _, e := range t.entries {
+		if e.SubType == subType {
+			matches = append(matches, e)
+		}
+	}
+	return matches
+}
+
+// FindAllByType returns every partition with the given type, in file order.
+func (t *Table) FindAllByType(typ string) []Entry {
+	var matches []Entry
+	for _, e := range t.entries {
+		if e.Type == typ {
+			matches = append(matches, e)
+		}
+	}
+	return matches
+}
+
 func parseLine(line string) (Entry, error) {
-	// Format: Name, Type, SubType, Offset, Size, [Flags]
+	// Format: Name, Type, SubType, Offset, Size, [Flags] [# comment]
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	line = strings.TrimRight(line, "\r")
+
 	parts := strings.Split(line, ",")
 	if len(parts) < 5 {
 		return Entry{}, fmt.Errorf("invalid line: %s", line)
@@ -88,21 +198,36 @@ func parseLine(line string) (Entry, error) {
 		return Entry{}, fmt.Errorf("parse size: %w", err)
 	}
 
+	var flags string
+	if len(parts) > 5 {
+		flags = strings.TrimSpace(parts[5])
+	}
+
 	return Entry{
 		Name:    strings.TrimSpace(parts[0]),
 		Type:    strings.TrimSpace(parts[1]),
 		SubType: strings.TrimSpace(parts[2]),
 		Offset:  offset,
 		Size:    size,
+		Flags:   flags,
 	}, nil
 }
 
+// parseHexOrInt parses a hex or decimal number, with an optional K or M
+// suffix as ESP-IDF's partition tool accepts.
 func parseHexOrInt(s string) (int, error) {
 	s = strings.TrimSpace(s)
 	if s == "" {
 		return 0, nil
 	}
 
+	for suffix, mult := range map[string]int{"K": 1 << 10, "M": 1 << 20} {
+		if n, ok := strings.CutSuffix(strings.ToUpper(s), suffix); ok && n != "" {
+			val, err := parseHexOrInt(n)
+			return val * mult, err
+		}
+	}
+
 	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
 		val, err := strconv.ParseInt(s[2:], 16, 64)
 		return int(val), err
@@ -3,6 +3,8 @@ package partition
 import (
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -28,6 +30,31 @@ func TestParseLine(t *testing.T) {
 			line: "  nvs  ,  data  ,  nvs  ,  0x9000  ,  0x4000  ,",
 			want: Entry{Name: "nvs", Type: "data", SubType: "nvs", Offset: 0x9000, Size: 0x4000},
 		},
+		{
+			name: "encrypted flag",
+			line: "nvs_keys, data, nvs_keys, 0xd000, 0x1000, encrypted",
+			want: Entry{Name: "nvs_keys", Type: "data", SubType: "nvs_keys", Offset: 0xd000, Size: 0x1000, Flags: "encrypted"},
+		},
+		{
+			name: "inline comment",
+			line: "factory, app, factory, 0x10000, 0x100000, # main app",
+			want: Entry{Name: "factory", Type: "app", SubType: "factory", Offset: 0x10000, Size: 0x100000},
+		},
+		{
+			name: "inline comment after flags",
+			line: "nvs_keys, data, nvs_keys, 0xd000, 0x1000, encrypted # keys",
+			want: Entry{Name: "nvs_keys", Type: "data", SubType: "nvs_keys", Offset: 0xd000, Size: 0x1000, Flags: "encrypted"},
+		},
+		{
+			name: "trailing carriage return",
+			line: "nvs, data, nvs, 0x9000, 0x4000,\r",
+			want: Entry{Name: "nvs", Type: "data", SubType: "nvs", Offset: 0x9000, Size: 0x4000},
+		},
+		{
+			name: "no flags column",
+			line: "nvs, data, nvs, 0x9000, 0x4000",
+			want: Entry{Name: "nvs", Type: "data", SubType: "nvs", Offset: 0x9000, Size: 0x4000},
+		},
 		{
 			name:    "too few fields",
 			line:    "nvs, data, nvs",
@@ -65,6 +92,10 @@ func TestParseHexOrInt(t *testing.T) {
 		{"4096", 4096, false},
 		{"", 0, false},
 		{"  0x100  ", 0x100, false},
+		{"16K", 0x4000, false},
+		{"1M", 0x100000, false},
+		{"0x10k", 0x4000, false},
+		{"K", 0, true},
 		{"invalid", 0, true},
 	}
 
@@ -101,8 +132,41 @@ factory,  app,  factory, 0x10000, 1M,
 		t.Fatalf("ParseFile() error = %v", err)
 	}
 
-	if len(table.entries) != 3 { // factory line has "1M" which fails parsing
-		t.Errorf("ParseFile() got %d entries, want 3 (valid ones)", len(table.entries))
+	if len(table.entries) != 4 {
+		t.Fatalf("ParseFile() got %d entries, want 4", len(table.entries))
+	}
+	if factory := table.entries[3]; factory.Size != 0x100000 {
+		t.Errorf("factory size = 0x%x, want 0x100000 from 1M", factory.Size)
+	}
+}
+
+func TestParseFileCRLF(t *testing.T) {
+	content := "# Name, Type, SubType, Offset, Size, Flags\r\n" +
+		"nvs,      data, nvs,     0x9000,  0x4000,\r\n" +
+		"\r\n" +
+		"factory,  app,  factory, 0x10000, 0x100000, # main app\r\n"
+
+	path := filepath.Join(t.TempDir(), "partitions.csv")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("ParseFile() error = %v", err)
+	}
+
+	want := []Entry{
+		{Name: "nvs", Type: "data", SubType: "nvs", Offset: 0x9000, Size: 0x4000},
+		{Name: "factory", Type: "app", SubType: "factory", Offset: 0x10000, Size: 0x100000},
+	}
+	if len(table.entries) != len(want) {
+		t.Fatalf("ParseFile() got %d entries, want %d", len(table.entries), len(want))
+	}
+	for i, e := range table.entries {
+		if e != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, e, want[i])
+		}
 	}
 }
 
@@ -148,3 +212,197 @@ func TestTableFindBySubType(t *testing.T) {
 		t.Errorf("FindBySubType() name = %s, want ota_0", entry.Name)
 	}
 }
+
+func TestTableFindAll(t *testing.T) {
+	table := &Table{
+		entries: []Entry{
+			{Name: "nvs", Type: "data", SubType: "nvs", Offset: 0x9000, Size: 0x4000},
+			{Name: "otadata", Type: "data", SubType: "ota", Offset: 0xd000, Size: 0x2000},
+			{Name: "ota_0", Type: "app", SubType: "ota_0", Offset: 0x10000, Size: 0x100000},
+			{Name: "ota_1", Type: "app", SubType: "ota_1", Offset: 0x110000, Size: 0x100000},
+		},
+	}
+
+	apps := table.FindAllByType("app")
+	if len(apps) != 2 || apps[0].Name != "ota_0" || apps[1].Name != "ota_1" {
+		t.Errorf("FindAllByType(app) = %+v, want ota_0 and ota_1", apps)
+	}
+
+	data := table.FindAllByType("data")
+	if len(data) != 2 {
+		t.Errorf("FindAllByType(data) got %d entries, want 2", len(data))
+	}
+
+	if got := table.FindAllBySubType("ota_1"); len(got) != 1 || got[0].Offset != 0x110000 {
+		t.Errorf("FindAllBySubType(ota_1) = %+v, want single ota_1 entry", got)
+	}
+
+	if got := table.FindAllBySubType("spiffs"); len(got) != 0 {
+		t.Errorf("FindAllBySubType(spiffs) = %+v, want none", got)
+	}
+}
+
+func TestTableEntries(t *testing.T) {
+	table := &Table{
+		entries: []Entry{
+			{Name: "nvs", Type: "data", SubType: "nvs", Offset: 0x9000, Size: 0x4000},
+			{Name: "nvs_keys", Type: "data", SubType: "nvs_keys", Offset: 0xd000, Size: 0x1000, Flags: "encrypted"},
+		},
+	}
+
+	entries := table.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("Entries() got %d entries, want 2", len(entries))
+	}
+	if entries[1].Flags != "encrypted" {
+		t.Errorf("Entries()[1].Flags = %q, want %q", entries[1].Flags, "encrypted")
+	}
+
+	entries[0].Name = "modified"
+	if table.entries[0].Name != "nvs" {
+		t.Error("Entries() returned a slice aliasing the table's entries")
+	}
+}
+
+func TestTableFindApp(t *testing.T) {
+	factory := &Table{entries: []Entry{
+		{Name: "ota_0", Type: "app", SubType: "ota_0", Offset: 0x110000, Size: 0x100000},
+		{Name: "factory", Type: "app", SubType: "factory", Offset: 0x10000, Size: 0x100000},
+	}}
+	if app, err := factory.FindApp(); err != nil || app.Name != "factory" {
+		t.Errorf("FindApp() = %v, %v, want factory", app, err)
+	}
+
+	ota := &Table{entries: []Entry{
+		{Name: "ota_0", Type: "app", SubType: "ota_0", Offset: 0x10000, Size: 0x100000},
+	}}
+	if app, err := ota.FindApp(); err != nil || app.Name != "ota_0" {
+		t.Errorf("FindApp() = %v, %v, want ota_0", app, err)
+	}
+
+	empty := &Table{}
+	if _, err := empty.FindApp(); err == nil {
+		t.Error("FindApp() expected error without app partitions")
+	}
+}
+
+func TestEntryOffsetHex(t *testing.T) {
+	tests := []struct {
+		offset int
+		want   string
+	}{
+		{0x9000, "0x9000"},
+		{0x10000, "0x10000"},
+		{0, ""},
+	}
+	for _, tt := range tests {
+		if got := (Entry{Offset: tt.offset}).OffsetHex(); got != tt.want {
+			t.Errorf("OffsetHex(0x%x) = %q, want %q", tt.offset, got, tt.want)
+		}
+	}
+}
+
+func TestEntryHumanSize(t *testing.T) {
+	tests := []struct {
+		size int
+		want string
+	}{
+		{0x4000, "16K"},
+		{0x100000, "1M"},
+		{0x1000, "4K"},
+		{0x180000, "1536K"},
+		{0x300000, "3M"},
+		{1500, "1500"},
+		{0x1800, "6K"},
+		{0, "0"},
+	}
+	for _, tt := range tests {
+		if got := (Entry{Size: tt.size}).HumanSize(); got != tt.want {
+			t.Errorf("HumanSize(0x%x) = %q, want %q", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestEntryOverlaps(t *testing.T) {
+	nvs := Entry{Offset: 0x9000, Size: 0x6000}
+
+	tests := []struct {
+		name  string
+		other Entry
+		want  bool
+	}{
+		{"adjacent after", Entry{Offset: 0xf000, Size: 0x1000}, false},
+		{"adjacent before", Entry{Offset: 0x8000, Size: 0x1000}, false},
+		{"overlapping", Entry{Offset: 0xe000, Size: 0x2000}, true},
+		{"contained", Entry{Offset: 0xa000, Size: 0x1000}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nvs.Overlaps(tt.other); got != tt.want {
+				t.Errorf("Overlaps() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTableWriteCSVRoundTrip(t *testing.T) {
+	content := `# Name,   Type, SubType,  Offset,   Size,     Flags
+nvs,      data, nvs,      0x9000,   0x5000,
+nvs_keys, data, nvs_keys, 0xe000,   0x1000,   encrypted
+otadata,  data, ota,      ,         0x2000,
+ota_0,    app,  ota_0,    0x20000,  1572864,
+storage,  data, littlefs, 0x320000, 0xE0000, # files
+`
+	dir := t.TempDir()
+	path := filepath.Join(dir, "partitions.csv")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	original, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("ParseFile() error = %v", err)
+	}
+
+	written := filepath.Join(dir, "written.csv")
+	if err := original.WriteCSV(written); err != nil {
+		t.Fatalf("WriteCSV() error = %v", err)
+	}
+
+	reparsed, err := ParseFile(written)
+	if err != nil {
+		t.Fatalf("ParseFile(written) error = %v", err)
+	}
+
+	want, got := original.Entries(), reparsed.Entries()
+	if len(want) != 5 {
+		t.Fatalf("original has %d entries, want 5", len(want))
+	}
+	if !reflect.DeepEqual(got, want) {
+		data, _ := os.ReadFile(written)
+		t.Errorf("round trip entries = %+v, want %+v\nwritten CSV:\n%s", got, want, data)
+	}
+}
+
+func TestTableWriteCSVFromEntries(t *testing.T) {
+	table := NewTable([]Entry{
+		{Name: "nvs", Type: "data", SubType: "nvs", Offset: 0x9000, Size: 0x4000},
+		{Name: "factory", Type: "app", SubType: "factory", Offset: 0x10000, Size: 0x100000},
+	})
+
+	path := filepath.Join(t.TempDir(), "partitions.csv")
+	if err := table.WriteCSV(path); err != nil {
+		t.Fatalf("WriteCSV() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# ESP-IDF Partition Table\n", "nvs,     data, nvs,     0x9000,  16K,", "0x10000, 1M,"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("CSV missing %q:\n%s", want, data)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Markers printed by the firmware's DeviceAuth and CloudManager while
+// authenticating against /auth/device.
+var (
+	authSuccessRe = regexp.MustCompile(`Authentication successful|Authenticated with cloud`)
+	authFailureRe = regexp.MustCompile(`Authentication failed|Auth request failed|device revoked`)
+)
+
+// ErrAuthFailed is returned by VerifyAuth when the device reports that it
+// could not authenticate with the backend.
+var ErrAuthFailed = errors.New("device authentication failed")
+
+type authStatus int
+
+const (
+	authUnknown authStatus = iota
+	authSucceeded
+	authFailed
+)
+
+// classifyAuthLine reports whether a log line is an authentication success or
+// failure marker.
+func classifyAuthLine(line string) authStatus {
+	switch {
+	case authFailureRe.MatchString(line):
+		return authFailed
+	case authSuccessRe.MatchString(line):
+		return authSucceeded
+	default:
+		return authUnknown
+	}
+}
+
+// VerifyAuth resets the device on port and watches its log until it reports
+// authenticating with the backend. It returns ErrAuthFailed if the firmware
+// logs an authentication failure, or an error if neither marker appears
+// within timeout.
+func VerifyAuth(port string, timeout time.Duration) error {
+	line, err := scanBootLog(port, timeout, func(line string) bool {
+		return classifyAuthLine(line) != authUnknown
+	})
+	if errors.Is(err, errScanTimeout) {
+		return fmt.Errorf("no authentication result from device within %s", timeout)
+	}
+	if err != nil {
+		return err
+	}
+
+	if classifyAuthLine(line) == authFailed {
+		return fmt.Errorf("%w: %s", ErrAuthFailed, line)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package serial
+
+import "testing"
+
+func TestClassifyAuthLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want authStatus
+	}{
+		{"I (5123) DeviceAuth: === Authentication successful ===", authSucceeded},
+		{"I (5130) CloudManager: Authenticated with cloud", authSucceeded},
+		{"E (5123) DeviceAuth: Authentication failed: state=3, error=401", authFailed},
+		{"E (5120) DeviceAuth: Auth request failed: ESP_ERR_HTTP_CONNECT", authFailed},
+		{"W (5200) DeviceAuth: get_auth_header: device revoked", authFailed},
+		{"E (5140) CloudManager: Authentication failed: 259", authFailed},
+		{"I (4100) DeviceAuth: === Starting authentication ===", authUnknown},
+		{"I (4200) DeviceAuth: Authenticating device 3f2a9c1e", authUnknown},
+		{"I (312) wifi: connected", authUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			if got := classifyAuthLine(tt.line); got != tt.want {
+				t.Errorf("classifyAuthLine(%q) = %d, want %d", tt.line, got, tt.want)
+			}
+		})
+	}
+}
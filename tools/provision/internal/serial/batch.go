@@ -0,0 +1,80 @@
+package serial
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ReadMACFunc reads the MAC of the device on port, giving up when ctx is done.
+type ReadMACFunc func(ctx context.Context, port string) (string, error)
+
+// ReadMACWithEsptool is the default ReadMACFunc.
+func ReadMACWithEsptool(ctx context.Context, port string) (string, error) {
+	return NewMACReader(port).ReadMACContext(ctx)
+}
+
+// ReadMACs reads the MAC of every port concurrently, running at most
+// maxConcurrent reads at once and allowing each device timeout. A failing
+// port does not stop the batch: successes are returned in macs and failures
+// in errs, both keyed by port.
+func ReadMACs(ports []string, maxConcurrent int, timeout time.Duration, read ReadMACFunc) (macs map[string]string, errs map[string]error) {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+
+	macs = make(map[string]string)
+	errs = make(map[string]error)
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, maxConcurrent)
+	)
+
+	for _, port := range ports {
+		wg.Add(1)
+		go func(port string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			mac, err := readMACWithTimeout(port, timeout, read)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[port] = err
+			} else {
+				macs[port] = mac
+			}
+		}(port)
+	}
+
+	wg.Wait()
+	return macs, errs
+}
+
+// readMACWithTimeout enforces timeout even if read ignores its context.
+func readMACWithTimeout(port string, timeout time.Duration, read ReadMACFunc) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	type result struct {
+		mac string
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		mac, err := read(ctx, port)
+		done <- result{mac, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.mac, r.err
+	case <-ctx.Done():
+		return "", fmt.Errorf("timeout reading MAC after %s", timeout)
+	}
+}
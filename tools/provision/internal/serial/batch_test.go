@@ -0,0 +1,83 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadMACs(t *testing.T) {
+	var active, peak int32
+
+	read := func(ctx context.Context, port string) (string, error) {
+		if port == "/dev/ttyUSB3" {
+			time.Sleep(time.Second) // ignores ctx; outlives its slot, so not counted
+			return "aa:aa:aa:aa:aa:aa", nil
+		}
+
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+
+		switch port {
+		case "/dev/ttyUSB1":
+			return "", errors.New("esptool read_mac failed")
+		case "/dev/ttyUSB2":
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+		time.Sleep(10 * time.Millisecond)
+		return "aa:bb:cc:dd:ee:0" + port[len(port)-1:], nil
+	}
+
+	ports := []string{"/dev/ttyUSB0", "/dev/ttyUSB1", "/dev/ttyUSB2", "/dev/ttyUSB3", "/dev/ttyUSB4", "/dev/ttyUSB5"}
+	macs, errs := ReadMACs(ports, 2, 100*time.Millisecond, read)
+
+	wantMACs := map[string]string{
+		"/dev/ttyUSB0": "aa:bb:cc:dd:ee:00",
+		"/dev/ttyUSB4": "aa:bb:cc:dd:ee:04",
+		"/dev/ttyUSB5": "aa:bb:cc:dd:ee:05",
+	}
+	if len(macs) != len(wantMACs) {
+		t.Errorf("got %d MACs, want %d: %v", len(macs), len(wantMACs), macs)
+	}
+	for port, want := range wantMACs {
+		if macs[port] != want {
+			t.Errorf("macs[%s] = %q, want %q", port, macs[port], want)
+		}
+	}
+
+	if len(errs) != 3 {
+		t.Errorf("got %d errors, want 3: %v", len(errs), errs)
+	}
+	if err := errs["/dev/ttyUSB1"]; err == nil || !strings.Contains(err.Error(), "read_mac failed") {
+		t.Errorf("errs[ttyUSB1] = %v", err)
+	}
+	for _, port := range []string{"/dev/ttyUSB2", "/dev/ttyUSB3"} {
+		if err := errs[port]; err == nil || !strings.Contains(err.Error(), "timeout") {
+			t.Errorf("errs[%s] = %v, want timeout", port, err)
+		}
+	}
+
+	if peak > 2 {
+		t.Errorf("peak concurrency = %d, want at most 2", peak)
+	}
+}
+
+func TestReadMACsEmpty(t *testing.T) {
+	macs, errs := ReadMACs(nil, 4, time.Second, func(ctx context.Context, port string) (string, error) {
+		t.Errorf("unexpected read of %s", port)
+		return "", nil
+	})
+	if len(macs) != 0 || len(errs) != 0 {
+		t.Errorf("ReadMACs(nil) = %v, %v, want empty", macs, errs)
+	}
+}
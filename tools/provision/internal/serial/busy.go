@@ -0,0 +1,40 @@
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrPortBusy is returned when another process, typically `idf.py monitor`,
+// holds the serial port open.
+var ErrPortBusy = errors.New("serial port is busy")
+
+// busyMarkers are fragments of the errors reported by the OS, pyserial and
+// esptool when a port is held by another process.
+var busyMarkers = []string{
+	"resource busy",
+	"port is busy",
+	"errno 16",
+	"access is denied", // Windows
+}
+
+// IsBusy reports whether msg describes a serial port held by another process.
+func IsBusy(msg string) bool {
+	msg = strings.ToLower(msg)
+	for _, marker := range busyMarkers {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExplainBusy replaces a busy-port error on port with a message telling the
+// user to close their serial monitor. Other errors are returned unchanged.
+func ExplainBusy(port string, err error) error {
+	if err == nil || !IsBusy(err.Error()) {
+		return err
+	}
+	return fmt.Errorf("%w: %s is open in another program - close idf.py monitor (or any serial monitor) and retry", ErrPortBusy, port)
+}
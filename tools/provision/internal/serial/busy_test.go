@@ -0,0 +1,58 @@
+package serial
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExplainBusy(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantBusy bool
+	}{
+		{
+			name:     "linux open error",
+			err:      errors.New("open port: open /dev/ttyUSB0: device or resource busy"),
+			wantBusy: true,
+		},
+		{
+			name:     "esptool output",
+			err:      errors.New("exit status 2: Could not open /dev/ttyUSB0, the port is busy or doesn't exist. ([Errno 16] Device or resource busy: '/dev/ttyUSB0')"),
+			wantBusy: true,
+		},
+		{
+			name:     "windows",
+			err:      errors.New("could not open port 'COM3': PermissionError(13, 'Access is denied.', None, 5)"),
+			wantBusy: true,
+		},
+		{
+			name: "missing device",
+			err:  errors.New("open /dev/ttyUSB0: no such file or directory"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ExplainBusy("/dev/ttyUSB0", tt.err)
+			if errors.Is(got, ErrPortBusy) != tt.wantBusy {
+				t.Fatalf("ExplainBusy() = %v, want busy %t", got, tt.wantBusy)
+			}
+			if !tt.wantBusy {
+				if got != tt.err {
+					t.Errorf("ExplainBusy() = %v, want original error", got)
+				}
+				return
+			}
+			msg := got.Error()
+			if !strings.Contains(msg, "/dev/ttyUSB0") || !strings.Contains(msg, "monitor") {
+				t.Errorf("ExplainBusy() = %q, want port name and monitor hint", msg)
+			}
+		})
+	}
+
+	if ExplainBusy("/dev/ttyUSB0", nil) != nil {
+		t.Error("ExplainBusy(nil) should return nil")
+	}
+}
@@ -2,6 +2,8 @@ package serial
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"os/exec"
 	"regexp"
@@ -9,42 +11,173 @@ import (
 	"time"
 
 	"go.bug.st/serial"
+
+	"measurement-probe/tools/provision/internal/idf"
 )
 
+// CommandRunner executes external tools and returns their combined output.
+// Allows mocking in tests.
+type CommandRunner interface {
+	CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// ExecRunner is the default CommandRunner using os/exec.
+type ExecRunner struct{}
+
+// CombinedOutput runs the command, killing it when ctx is done.
+func (ExecRunner) CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if err := idf.RequireTool(name); err != nil {
+		return nil, err
+	}
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+var (
+	macRe        = regexp.MustCompile(`MAC:\s*([0-9a-fA-F:]{17})`)
+	labeledMACRe = regexp.MustCompile(`(?m)^\s*([A-Za-z][A-Za-z0-9 ._-]*?)?\s*MAC:\s*([0-9a-fA-F:]{17})`)
+)
+
+// macLabelRank orders the MAC labels esptool prints by preference (lower is
+// better). The firmware registers with the Wi-Fi station MAC
+// (esp_wifi_get_mac(WIFI_IF_STA)), which is the base MAC. An unlabeled
+// "MAC:" line, as printed by esptool v4, is also the base MAC. The factory
+// eFuse MAC only differs when a custom base MAC is burned. Other labels (AP,
+// BT, Ethernet) are derived addresses and are never preferred.
+var macLabelRank = map[string]int{
+	"base":      0,
+	"wifi":      0,
+	"wifi sta":  0,
+	"wi-fi":     0,
+	"wi-fi sta": 0,
+	"sta":       0,
+	"":          1,
+	"efuse":     2,
+}
+
+// labeledMAC is one MAC line of esptool output.
+type labeledMAC struct {
+	Label string
+	MAC   string
+}
+
+// DefaultReadTimeout bounds an esptool MAC read unless SetTimeout is used.
+const DefaultReadTimeout = 30 * time.Second
+
+// ErrReadTimeout is returned when esptool does not finish reading the MAC in time.
+var ErrReadTimeout = errors.New("timed out reading MAC")
+
 type MACReader struct {
-	port string
+	port    string
+	runner  CommandRunner
+	timeout time.Duration
 }
 
 func NewMACReader(port string) *MACReader {
-	return &MACReader{port: port}
+	return NewMACReaderWithRunner(port, ExecRunner{})
+}
+
+// NewMACReaderWithRunner creates a reader with a custom command runner (for testing).
+func NewMACReaderWithRunner(port string, runner CommandRunner) *MACReader {
+	return &MACReader{port: port, runner: runner, timeout: DefaultReadTimeout}
+}
+
+// SetTimeout sets how long esptool may take to read the MAC before it is
+// killed (DefaultReadTimeout). Zero or less disables the timeout.
+func (r *MACReader) SetTimeout(timeout time.Duration) {
+	r.timeout = timeout
 }
 
+// ReadMAC reads the device MAC with esptool, giving up after the reader's
+// timeout with ErrReadTimeout.
 func (r *MACReader) ReadMAC() (string, error) {
-	cmd := exec.Command("esptool.py", "--port", r.port, "read_mac")
-	output, err := cmd.CombinedOutput()
+	return r.ReadMACContext(context.Background())
+}
+
+// ReadMACContext is ReadMAC with esptool also killed when ctx is done.
+func (r *MACReader) ReadMACContext(ctx context.Context) (string, error) {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	output, err := r.runner.CombinedOutput(ctx, "esptool.py", "--port", r.port, "read_mac")
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "", fmt.Errorf("%w on %s after %s - check the USB cable and that the device is in bootloader mode (hold BOOT, press RESET)",
+			ErrReadTimeout, r.port, r.timeout)
+	}
 	if err != nil {
+		if IsBusy(string(output)) {
+			return "", ExplainBusy(r.port, fmt.Errorf("%w: %s", err, output))
+		}
+		if strings.Contains(strings.ToLower(string(output)), "permission denied") {
+			return "", fmt.Errorf("no permission to open %s (add your user to the dialout/uucp group): %w\nOutput: %s",
+				r.port, err, string(output))
+		}
 		return "", fmt.Errorf("esptool read_mac failed: %w\nOutput: %s", err, string(output))
 	}
 
-	// Parse MAC from output
-	// Expected format: "MAC: aa:bb:cc:dd:ee:ff"
-	re := regexp.MustCompile(`MAC:\s*([0-9a-fA-F:]{17})`)
-	matches := re.FindStringSubmatch(string(output))
-	if len(matches) < 2 {
-		return "", fmt.Errorf("could not find MAC in output: %s", string(output))
+	return parseMAC(string(output))
+}
+
+// parseMAC extracts the MAC the firmware registers with from esptool read_mac
+// output. When several MACs are printed, the best ranked label in
+// macLabelRank wins, earliest first on ties. Without a known label the first
+// MAC is used.
+func parseMAC(output string) (string, error) {
+	macs := parseMACLines(output)
+	if len(macs) == 0 {
+		return "", fmt.Errorf("could not find MAC in output: %s", output)
+	}
+
+	best, bestRank := macs[0], -1
+	for _, m := range macs {
+		rank, ok := macLabelRank[m.Label]
+		if ok && (bestRank < 0 || rank < bestRank) {
+			best, bestRank = m, rank
+		}
 	}
 
-	return strings.ToLower(matches[1]), nil
+	return best.MAC, nil
+}
+
+// parseMACLines returns every MAC line in output with its normalized label
+// ("base", "efuse", "wifi sta", ... or "" when unlabeled).
+func parseMACLines(output string) []labeledMAC {
+	var macs []labeledMAC
+	for _, m := range labeledMACRe.FindAllStringSubmatch(output, -1) {
+		label := strings.ToLower(strings.TrimSpace(m[1]))
+		label = strings.Join(strings.Fields(strings.NewReplacer("_", " ", ".", " ").Replace(label)), " ")
+		macs = append(macs, labeledMAC{Label: label, MAC: strings.ToLower(m[2])})
+	}
+	return macs
 }
 
 func (r *MACReader) ReadMACFromSerial(timeout time.Duration) (string, error) {
+	line, err := scanBootLog(r.port, timeout, macRe.MatchString)
+	if errors.Is(err, errScanTimeout) {
+		return "", fmt.Errorf("timeout waiting for MAC address")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(macRe.FindStringSubmatch(line)[1]), nil
+}
+
+// errScanTimeout is returned by scanBootLog when no line matched in time.
+var errScanTimeout = errors.New("timeout scanning serial output")
+
+// scanBootLog resets the device on portName and reads its serial output until
+// match reports true for a line, which is returned.
+func scanBootLog(portName string, timeout time.Duration, match func(line string) bool) (string, error) {
 	mode := &serial.Mode{
 		BaudRate: 115200,
 	}
 
-	port, err := serial.Open(r.port, mode)
+	port, err := serial.Open(portName, mode)
 	if err != nil {
-		return "", fmt.Errorf("open port: %w", err)
+		return "", ExplainBusy(portName, fmt.Errorf("open port: %w", err))
 	}
 	defer port.Close()
 
@@ -59,21 +192,19 @@ func (r *MACReader) ReadMACFromSerial(timeout time.Duration) (string, error) {
 		_ = port.SetDTR(true)
 	}
 
-	// Read output looking for MAC
 	scanner := bufio.NewScanner(port)
-	re := regexp.MustCompile(`MAC:\s*([0-9a-fA-F:]{17})`)
 
 	deadline := time.Now().Add(timeout)
 	for time.Now().Before(deadline) {
 		if scanner.Scan() {
 			line := scanner.Text()
-			if matches := re.FindStringSubmatch(line); len(matches) >= 2 {
-				return strings.ToLower(matches[1]), nil
+			if match(line) {
+				return line, nil
 			}
 		}
 	}
 
-	return "", fmt.Errorf("timeout waiting for MAC address")
+	return "", errScanTimeout
 }
 
 func ListPorts() ([]string, error) {
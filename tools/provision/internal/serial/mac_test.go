@@ -1,9 +1,12 @@
 package serial
 
 import (
+	"context"
+	"errors"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestMACRegex(t *testing.T) {
@@ -48,3 +51,207 @@ func TestNewMACReader(t *testing.T) {
 		t.Errorf("port = %s, want /dev/ttyUSB0", reader.port)
 	}
 }
+
+type cannedRunner struct {
+	output string
+	err    error
+	args   []string
+}
+
+func (r *cannedRunner) CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	r.args = append([]string{name}, args...)
+	return []byte(r.output), r.err
+}
+
+func TestReadMACContext(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			name: "esptool v4",
+			output: `esptool.py v4.7.0
+Serial port /dev/ttyUSB0
+Connecting....
+Detecting chip type... ESP32-C3
+Chip is ESP32-C3 (QFN32) (revision v0.4)
+Features: WiFi, BLE
+Crystal is 40MHz
+MAC: 58:CF:79:A1:B2:C3
+Uploading stub...
+Running stub...
+Stub running...
+MAC: 58:cf:79:a1:b2:c3
+Hard resetting via RTS pin...
+`,
+			want: "58:cf:79:a1:b2:c3",
+		},
+		{
+			name: "base and eFuse MAC",
+			output: `esptool.py v4.8.1
+Chip is ESP32-S3 (QFN56) (revision v0.2)
+Crystal is 40MHz
+eFuse MAC: 34:85:18:00:00:01
+Uploading stub...
+Stub running...
+BASE MAC: 34:85:18:AA:BB:CC
+MAC_EXT: ff:fe
+Hard resetting via RTS pin...
+`,
+			want: "34:85:18:aa:bb:cc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &cannedRunner{output: tt.output}
+			got, err := NewMACReaderWithRunner("/dev/ttyUSB0", runner).ReadMACContext(context.Background())
+			if err != nil {
+				t.Fatalf("ReadMACContext() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ReadMACContext() = %q, want %q", got, tt.want)
+			}
+			if strings.Join(runner.args, " ") != "esptool.py --port /dev/ttyUSB0 read_mac" {
+				t.Errorf("ran %q", runner.args)
+			}
+		})
+	}
+}
+
+func TestReadMACContextPermissionDenied(t *testing.T) {
+	runner := &cannedRunner{
+		output: `esptool.py v4.7.0
+Serial port /dev/ttyUSB0
+
+A fatal error occurred: Could not open /dev/ttyUSB0, the port doesn't exist or is busy: [Errno 13] Permission denied: '/dev/ttyUSB0'
+`,
+		err: errors.New("exit status 2"),
+	}
+
+	_, err := NewMACReaderWithRunner("/dev/ttyUSB0", runner).ReadMACContext(context.Background())
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "no permission to open /dev/ttyUSB0") {
+		t.Errorf("error = %q, want a permission hint", err)
+	}
+}
+
+func TestReadMACContextNoMAC(t *testing.T) {
+	runner := &cannedRunner{output: "esptool.py v4.7.0\nHard resetting via RTS pin...\n"}
+
+	_, err := NewMACReaderWithRunner("/dev/ttyUSB0", runner).ReadMACContext(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "could not find MAC") {
+		t.Errorf("error = %v, want could not find MAC", err)
+	}
+}
+
+func TestParseMACSelection(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			name: "base preferred over eFuse and derived MACs",
+			output: `eFuse MAC: 34:85:18:00:00:01
+BT MAC: 34:85:18:00:00:03
+Base MAC: 34:85:18:00:00:10
+`,
+			want: "34:85:18:00:00:10",
+		},
+		{
+			name: "wifi sta preferred over bluetooth",
+			output: `BT MAC: 34:85:18:00:00:03
+WiFi STA MAC: 34:85:18:00:00:01
+`,
+			want: "34:85:18:00:00:01",
+		},
+		{
+			name: "unlabeled preferred over eFuse",
+			output: `eFuse MAC: 34:85:18:00:00:01
+MAC: 34:85:18:00:00:10
+`,
+			want: "34:85:18:00:00:10",
+		},
+		{
+			name: "eFuse preferred over derived MACs",
+			output: `Ethernet MAC: 34:85:18:00:00:04
+eFuse MAC: 34:85:18:00:00:01
+`,
+			want: "34:85:18:00:00:01",
+		},
+		{
+			name: "first MAC when no label is known",
+			output: `BT MAC: 34:85:18:00:00:03
+AP MAC: 34:85:18:00:00:02
+`,
+			want: "34:85:18:00:00:03",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMAC(tt.output)
+			if err != nil {
+				t.Fatalf("parseMAC() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("parseMAC() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMACLines(t *testing.T) {
+	got := parseMACLines("eFuse MAC: AA:BB:CC:DD:EE:01\nWiFi_STA MAC: aa:bb:cc:dd:ee:02\nMAC: aa:bb:cc:dd:ee:03\nMAC_EXT: ff:fe\n")
+	want := []labeledMAC{
+		{"efuse", "aa:bb:cc:dd:ee:01"},
+		{"wifi sta", "aa:bb:cc:dd:ee:02"},
+		{"", "aa:bb:cc:dd:ee:03"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("parseMACLines() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+// slowRunner is a CommandRunner that blocks until ctx is done, like esptool
+// waiting on a device that never answers.
+type slowRunner struct{}
+
+func (slowRunner) CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	<-ctx.Done()
+	return []byte("Connecting........_____....."), errors.New("signal: killed")
+}
+
+func TestReadMACTimeout(t *testing.T) {
+	reader := NewMACReaderWithRunner("/dev/ttyUSB0", slowRunner{})
+	reader.SetTimeout(20 * time.Millisecond)
+
+	start := time.Now()
+	_, err := reader.ReadMAC()
+	if !errors.Is(err, ErrReadTimeout) {
+		t.Fatalf("ReadMAC() error = %v, want ErrReadTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("ReadMAC() took %v, want it to stop at the timeout", elapsed)
+	}
+	for _, want := range []string{"/dev/ttyUSB0", "20ms", "bootloader mode"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %q, want it to mention %q", err, want)
+		}
+	}
+}
+
+func TestNewMACReaderDefaultTimeout(t *testing.T) {
+	if got := NewMACReader("/dev/ttyUSB0").timeout; got != DefaultReadTimeout {
+		t.Errorf("timeout = %v, want %v", got, DefaultReadTimeout)
+	}
+}
@@ -0,0 +1,96 @@
+package serial
+
+import (
+	"fmt"
+	"strings"
+
+	"go.bug.st/serial/enumerator"
+)
+
+// PortInfo describes a serial port and, for USB ports, the device behind it.
+type PortInfo struct {
+	Path string
+	// Product is the OS-reported product or manufacturer string, if any.
+	Product string
+	// VID and PID are the USB vendor and product IDs in hex, empty for
+	// ports that aren't USB.
+	VID string
+	PID string
+}
+
+// IsUSB reports whether the port belongs to a USB device.
+func (p PortInfo) IsUSB() bool {
+	return p.VID != ""
+}
+
+// String renders the port as "path - product (vid:pid)", leaving out the
+// parts that are unknown.
+func (p PortInfo) String() string {
+	s := p.Path
+	if p.Product != "" {
+		s += " - " + p.Product
+	}
+	if p.IsUSB() {
+		s += fmt.Sprintf(" (%s:%s)", strings.ToLower(p.VID), strings.ToLower(p.PID))
+	}
+	return s
+}
+
+// FormatPorts renders ports as numbered lines, one per port.
+func FormatPorts(ports []PortInfo) []string {
+	lines := make([]string, len(ports))
+	for i, p := range ports {
+		lines[i] = fmt.Sprintf("%d: %s", i+1, p)
+	}
+	return lines
+}
+
+// Enumerator lists serial ports with their USB details. Allows mocking in
+// tests.
+type Enumerator interface {
+	DetailedPorts() ([]*enumerator.PortDetails, error)
+}
+
+// systemEnumerator is the Enumerator backed by the OS.
+type systemEnumerator struct{}
+
+func (systemEnumerator) DetailedPorts() ([]*enumerator.PortDetails, error) {
+	return enumerator.GetDetailedPortsList()
+}
+
+// ListPortsDetailed returns the connected serial ports with their product
+// string and USB IDs. Where the OS can't provide details, only the paths are
+// filled in.
+func ListPortsDetailed() ([]PortInfo, error) {
+	ports, err := listPortsDetailed(systemEnumerator{})
+	if err == nil {
+		return ports, nil
+	}
+
+	paths, listErr := ListPorts()
+	if listErr != nil {
+		return nil, listErr
+	}
+	ports = make([]PortInfo, len(paths))
+	for i, path := range paths {
+		ports[i] = PortInfo{Path: path}
+	}
+	return ports, nil
+}
+
+func listPortsDetailed(e Enumerator) ([]PortInfo, error) {
+	details, err := e.DetailedPorts()
+	if err != nil {
+		return nil, fmt.Errorf("get port details: %w", err)
+	}
+
+	ports := make([]PortInfo, 0, len(details))
+	for _, d := range details {
+		info := PortInfo{Path: d.Name, Product: strings.TrimSpace(d.Product)}
+		if d.IsUSB {
+			info.VID, info.PID = d.VID, d.PID
+		}
+		ports = append(ports, info)
+	}
+	return ports, nil
+}
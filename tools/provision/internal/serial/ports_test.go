@@ -0,0 +1,50 @@
+package serial
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.bug.st/serial/enumerator"
+)
+
+type fakeEnumerator struct {
+	details []*enumerator.PortDetails
+	err     error
+}
+
+func (f fakeEnumerator) DetailedPorts() ([]*enumerator.PortDetails, error) {
+	return f.details, f.err
+}
+
+func TestListPortsDetailed(t *testing.T) {
+	e := fakeEnumerator{details: []*enumerator.PortDetails{
+		{Name: "/dev/ttyS0"},
+		{Name: "/dev/ttyUSB0", IsUSB: true, VID: "10C4", PID: "EA60", Product: "CP2102 USB to UART Bridge Controller "},
+		{Name: "/dev/ttyACM0", IsUSB: true, VID: "303a", PID: "1001"},
+	}}
+
+	ports, err := listPortsDetailed(e)
+	if err != nil {
+		t.Fatalf("listPortsDetailed() error = %v", err)
+	}
+
+	want := []string{
+		"1: /dev/ttyS0",
+		"2: /dev/ttyUSB0 - CP2102 USB to UART Bridge Controller (10c4:ea60)",
+		"3: /dev/ttyACM0 (303a:1001)",
+	}
+	if got := FormatPorts(ports); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("FormatPorts() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if ports[0].IsUSB() || !ports[1].IsUSB() {
+		t.Errorf("IsUSB() = %t, %t, want false, true", ports[0].IsUSB(), ports[1].IsUSB())
+	}
+}
+
+func TestListPortsDetailedError(t *testing.T) {
+	_, err := listPortsDetailed(fakeEnumerator{err: errors.New("not implemented")})
+	if err == nil || !strings.Contains(err.Error(), "not implemented") {
+		t.Errorf("listPortsDetailed() error = %v, want enumerator error", err)
+	}
+}
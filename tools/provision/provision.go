@@ -0,0 +1,408 @@
+// Package provision registers a measurement probe with the telemetry backend
+// and writes the issued credentials to the device. cmd/provision is the CLI
+// around it; other tools can import the package to run the same flow with
+// their own inputs and, where needed, their own Cloud, Backend, MACReader and
+// Flasher implementations.
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"measurement-probe/tools/provision/internal/api"
+	"measurement-probe/tools/provision/internal/endpoints"
+	"measurement-probe/tools/provision/internal/gcloud"
+	"measurement-probe/tools/provision/internal/serial"
+)
+
+const (
+	// DefaultService is the Cloud Run service hosting the backend.
+	DefaultService = "telemetry-api"
+	// DefaultRegion is the region of DefaultService.
+	DefaultRegion = "us-west1"
+)
+
+// Credentials are what the backend issues for a device.
+type Credentials struct {
+	DeviceID  string
+	Secret    string
+	CreatedAt time.Time // zero when the backend didn't send it
+	Region    string
+}
+
+// Backend registers devices with the telemetry backend.
+type Backend interface {
+	ProvisionDevice(ctx context.Context, mac string) (Credentials, error)
+}
+
+// MACReader reads the MAC address of the device on a serial port.
+type MACReader interface {
+	ReadMAC(ctx context.Context, port string) (string, error)
+}
+
+// Flasher writes issued credentials to the device on port.
+type Flasher interface {
+	Flash(ctx context.Context, port string, creds Credentials) error
+}
+
+// FlasherFunc adapts a function to a Flasher.
+type FlasherFunc func(ctx context.Context, port string, creds Credentials) error
+
+func (f FlasherFunc) Flash(ctx context.Context, port string, creds Credentials) error {
+	return f(ctx, port, creds)
+}
+
+// Logger receives progress messages. Formats carry their own newlines.
+type Logger interface {
+	Infof(format string, args ...any)
+	Warnf(format string, args ...any)
+}
+
+// Options are the resolved inputs of a provisioning run. Zero-valued
+// dependencies use the real implementations (gcloud, the admin API, esptool).
+type Options struct {
+	// Project is the GCP project; the gcloud default when empty.
+	Project string
+	// Service and Region locate the Cloud Run backend (DefaultService,
+	// DefaultRegion).
+	Service string
+	Region  string
+	// BaseURL is the backend URL; when set, the Cloud Run lookup is skipped.
+	BaseURL string
+	// APIKey is the admin API key; when empty it is read from the Secret
+	// Manager secret SecretName ("admin-api-key" when empty).
+	APIKey     string
+	SecretName string
+	// AccountDomain, if set, requires the active gcloud account to be in
+	// this domain.
+	AccountDomain string
+	// CACert is a PEM bundle to trust for the backend in addition to the
+	// system roots.
+	CACert string
+
+	// Port is the device's serial port, detected when both Port and MAC are
+	// empty.
+	Port string
+	// MAC is the device MAC; read from the device when empty.
+	MAC string
+	// MACTimeout bounds the MAC read (serial.DefaultReadTimeout).
+	MACTimeout time.Duration
+	// MACSource selects how the MAC is read when MACReader is nil
+	// (MACSourceEsptool).
+	MACSource MACSource
+	// DryRun provisions the device with the backend but doesn't flash it.
+	DryRun bool
+
+	// Cloud answers gcloud queries (NewGCloud). It is only used when BaseURL
+	// or APIKey is missing or AccountDomain is set.
+	Cloud Cloud
+	// NewBackend connects to the backend (the admin API client).
+	NewBackend func(baseURL, apiKey string) (Backend, error)
+	// DetectPort finds the device's serial port (DetectPort).
+	DetectPort func() (string, error)
+	// MACReader reads the device MAC (NewMACReader for MACSource).
+	MACReader MACReader
+	// Flasher writes the credentials to the device. Required unless DryRun.
+	Flasher Flasher
+	// PrepareFirmware, if set, runs once the backend URL is known and before
+	// the device is touched, e.g. to rebuild firmware against that URL.
+	PrepareFirmware func(baseURL string) error
+	// ConfirmFlash, if set, is called with the device and backend before the
+	// device is registered (so the Result has no credentials yet). An error
+	// aborts the run with nothing registered or flashed. It is not called on
+	// a DryRun.
+	ConfirmFlash func(Result) error
+	// Log receives progress messages (discarded when nil).
+	Log Logger
+}
+
+func (o Options) withDefaults() Options {
+	if o.Service == "" {
+		o.Service = DefaultService
+	}
+	if o.Region == "" {
+		o.Region = DefaultRegion
+	}
+	if o.SecretName == "" {
+		o.SecretName = gcloud.DefaultAdminAPIKeySecret
+	}
+	if o.MACTimeout == 0 {
+		o.MACTimeout = serial.DefaultReadTimeout
+	}
+	if o.Log == nil {
+		o.Log = nopLogger{}
+	}
+	if o.Cloud == nil {
+		o.Cloud = NewGCloud()
+	}
+	if o.NewBackend == nil {
+		caCert, log := o.CACert, o.Log
+		o.NewBackend = func(baseURL, apiKey string) (Backend, error) {
+			client, err := api.NewClientWithCA(baseURL, apiKey, caCert)
+			if err != nil {
+				return nil, err
+			}
+			return apiBackend{client: client, log: log}, nil
+		}
+	}
+	if o.DetectPort == nil {
+		log := o.Log
+		o.DetectPort = func() (string, error) { return DetectPort(log) }
+	}
+	if o.MACReader == nil {
+		o.MACReader = NewMACReader(o.MACSource, o.MACTimeout)
+	}
+	return o
+}
+
+// Connection is the backend a run provisions against.
+type Connection struct {
+	// ProjectID is the GCP project, empty when gcloud was not needed.
+	ProjectID string
+	// BaseURL is the normalized backend URL.
+	BaseURL string
+	// APIKey is the admin API key.
+	APIKey string
+}
+
+// Result is the outcome of Provision. On error it holds what the run had
+// resolved before failing.
+type Result struct {
+	Credentials
+	MAC        string
+	Port       string
+	BackendURL string
+}
+
+// Connect resolves the backend URL and admin API key, checking gcloud
+// authentication and project access first when either has to come from
+// gcloud.
+func Connect(ctx context.Context, opts Options) (Connection, error) {
+	opts = opts.withDefaults()
+	log := opts.Log
+
+	// Steps 1-2: gcloud authentication and project access, only when needed
+	var conn Connection
+	if opts.BaseURL == "" || opts.APIKey == "" || opts.AccountDomain != "" {
+		log.Infof("→ Checking gcloud authentication...\n")
+		account, err := authenticate(opts.Cloud)
+		if err != nil {
+			return Connection{}, err
+		}
+		if err := CheckAccountDomain(account, opts.AccountDomain); err != nil {
+			return Connection{}, err
+		}
+		log.Infof("  ✓ Authenticated as: %s\n", account)
+
+		log.Infof("\n→ Checking GCP project access...\n")
+		if conn.ProjectID, err = selectProject(opts.Cloud, opts.Project); err != nil {
+			return Connection{}, err
+		}
+		log.Infof("  ✓ Project: %s\n", conn.ProjectID)
+	}
+
+	// Step 3: Cloud Run service URL and admin API key
+	baseURL := opts.BaseURL
+	if baseURL == "" {
+		log.Infof("\n→ Fetching Cloud Run service URL (%s in %s)...\n", opts.Service, opts.Region)
+		var err error
+		if baseURL, err = opts.Cloud.ServiceURL(opts.Service, opts.Region); err != nil {
+			return Connection{}, fmt.Errorf("failed to get service URL: %w", err)
+		}
+	}
+	var err error
+	if conn.BaseURL, err = endpoints.NormalizeBaseURL(baseURL); err != nil {
+		return Connection{}, err
+	}
+	log.Infof("  ✓ Service URL: %s\n", conn.BaseURL)
+
+	conn.APIKey = opts.APIKey
+	if conn.APIKey == "" {
+		log.Infof("  Fetching admin API key from Secret Manager (%s)...\n", opts.SecretName)
+		if conn.APIKey, err = opts.Cloud.AdminAPIKey(conn.ProjectID, opts.SecretName); err != nil {
+			return Connection{}, fmt.Errorf("get admin API key: %w", err)
+		}
+		log.Infof("  ✓ API key retrieved from Secret Manager\n")
+	}
+
+	return conn, ctx.Err()
+}
+
+// Provision runs the whole flow for one device: resolve the backend (see
+// Connect), prepare the firmware, find the device and read its MAC, register
+// it with the backend and flash the issued credentials.
+func Provision(ctx context.Context, opts Options) (Result, error) {
+	opts = opts.withDefaults()
+	log := opts.Log
+
+	if opts.Flasher == nil && !opts.DryRun {
+		return Result{}, errors.New("provision: Options.Flasher is required unless DryRun is set")
+	}
+
+	conn, err := Connect(ctx, opts)
+	if err != nil {
+		return Result{}, err
+	}
+	res := Result{BackendURL: conn.BaseURL}
+
+	// Steps 4-5: firmware preparation, e.g. endpoints.hpp and a rebuild
+	if opts.PrepareFirmware != nil {
+		if err := opts.PrepareFirmware(conn.BaseURL); err != nil {
+			return res, err
+		}
+	}
+
+	// Step 6: serial port
+	log.Infof("\n→ Detecting device...\n")
+	res.Port = opts.Port
+	if res.Port == "" && opts.MAC == "" {
+		if res.Port, err = opts.DetectPort(); err != nil {
+			return res, err
+		}
+	}
+	if res.Port != "" {
+		log.Infof("  ✓ Port: %s\n", res.Port)
+	}
+
+	// Step 7: MAC address
+	res.MAC = opts.MAC
+	if res.MAC == "" {
+		log.Infof("\n→ Reading device MAC address...\n")
+		if res.MAC, err = opts.MACReader.ReadMAC(ctx, res.Port); err != nil {
+			return res, fmt.Errorf("read MAC: %w", err)
+		}
+	}
+	log.Infof("  ✓ Device MAC: %s\n", res.MAC)
+
+	// Confirm before registering: a secret issued and then not flashed would
+	// be lost, and the MAC could not be provisioned again
+	if opts.ConfirmFlash != nil && !opts.DryRun {
+		if err := opts.ConfirmFlash(res); err != nil {
+			return res, err
+		}
+	}
+
+	// Step 8: register with the backend
+	log.Infof("\n→ Provisioning device with backend...\n")
+	backend, err := opts.NewBackend(conn.BaseURL, conn.APIKey)
+	if err != nil {
+		return res, err
+	}
+	if res.Credentials, err = backend.ProvisionDevice(ctx, res.MAC); err != nil {
+		return res, fmt.Errorf("provision failed: %w", err)
+	}
+	log.Infof("  ✓ Device ID: %s\n", res.DeviceID)
+
+	if opts.DryRun {
+		log.Infof("\n[Dry run] Skipping NVS flash\n")
+		return res, nil
+	}
+
+	// Step 9: write the credentials to the device
+	if err := opts.Flasher.Flash(ctx, res.Port, res.Credentials); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// DetectPort returns the only connected serial port, or an error when there
+// is none or more than one (listing the candidates on log).
+func DetectPort(log Logger) (string, error) {
+	ports, err := serial.ListPortsDetailed()
+	if err != nil {
+		return "", fmt.Errorf("list ports: %w", err)
+	}
+	if len(ports) == 0 {
+		return "", fmt.Errorf("no serial ports found - is device connected?")
+	}
+	if len(ports) > 1 {
+		log.Infof("  Multiple ports found:\n")
+		for _, line := range serial.FormatPorts(ports) {
+			log.Infof("    %s\n", line)
+		}
+		return "", fmt.Errorf("specify port with --port flag")
+	}
+	return ports[0].Path, nil
+}
+
+// apiBackend is the Backend backed by the admin API client.
+type apiBackend struct {
+	client *api.Client
+	log    Logger
+}
+
+func (b apiBackend) ProvisionDevice(ctx context.Context, mac string) (Credentials, error) {
+	resp, err := b.client.ProvisionDevice(mac)
+	if err != nil {
+		return Credentials{}, err
+	}
+	if resp.BadCreatedAt != "" {
+		b.log.Warnf("  ⚠️  Ignoring unreadable created_at %q from the backend\n", resp.BadCreatedAt)
+	}
+	return Credentials{
+		DeviceID:  resp.DeviceID,
+		Secret:    resp.Secret,
+		CreatedAt: resp.CreatedAt,
+		Region:    resp.Region,
+	}, nil
+}
+
+// MACSource names a way of reading the device MAC.
+type MACSource string
+
+// MAC sources. Esptool resets the board into download mode and asks the
+// ROM; serial reads the MAC the firmware prints while booting, for boards
+// whose auto-program circuit can't enter download mode.
+const (
+	MACSourceEsptool MACSource = "esptool"
+	MACSourceSerial  MACSource = "serial"
+)
+
+// ParseMACSource validates a MAC source name. Empty selects MACSourceEsptool.
+func ParseMACSource(name string) (MACSource, error) {
+	switch source := MACSource(name); source {
+	case "":
+		return MACSourceEsptool, nil
+	case MACSourceEsptool, MACSourceSerial:
+		return source, nil
+	default:
+		return "", fmt.Errorf("unknown MAC source %q (want %s or %s)", name, MACSourceEsptool, MACSourceSerial)
+	}
+}
+
+// NewMACReader returns the MACReader for source, giving up after timeout.
+// Unknown sources read with esptool.
+func NewMACReader(source MACSource, timeout time.Duration) MACReader {
+	if source == MACSourceSerial {
+		return serialMACReader{timeout: timeout}
+	}
+	return esptoolMACReader{timeout: timeout}
+}
+
+// esptoolMACReader reads the MAC with esptool, giving up after timeout.
+type esptoolMACReader struct {
+	timeout time.Duration
+}
+
+func (r esptoolMACReader) ReadMAC(ctx context.Context, port string) (string, error) {
+	reader := serial.NewMACReader(port)
+	reader.SetTimeout(r.timeout)
+	return reader.ReadMACContext(ctx)
+}
+
+// serialMACReader reads the MAC from the device's boot log, giving up after
+// timeout.
+type serialMACReader struct {
+	timeout time.Duration
+}
+
+func (r serialMACReader) ReadMAC(ctx context.Context, port string) (string, error) {
+	return serial.NewMACReader(port).ReadMACFromSerial(r.timeout)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Infof(string, ...any) {}
+func (nopLogger) Warnf(string, ...any) {}
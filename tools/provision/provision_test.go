@@ -0,0 +1,345 @@
+package provision
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeCloud is a Cloud that records its calls and succeeds unless an error
+// is set.
+type fakeCloud struct {
+	calls      []string
+	account    string
+	serviceErr error
+	setProject string
+}
+
+func (f *fakeCloud) record(call string)             { f.calls = append(f.calls, call) }
+func (f *fakeCloud) EnsureComponents() error        { f.record("components"); return nil }
+func (f *fakeCloud) EnsureAuthenticated() error     { f.record("auth"); return nil }
+func (f *fakeCloud) ActiveAccount() (string, error) { return f.account, nil }
+func (f *fakeCloud) CurrentProject() (string, error) {
+	f.record("current-project")
+	return "default-project", nil
+}
+func (f *fakeCloud) EnsureProject(project string) error { f.record("project " + project); return nil }
+func (f *fakeCloud) SetProject(project string) error {
+	f.setProject = project
+	return nil
+}
+func (f *fakeCloud) ServiceURL(service, region string) (string, error) {
+	f.record("service " + service + " " + region)
+	if f.serviceErr != nil {
+		return "", f.serviceErr
+	}
+	return "https://" + service + "-" + region + ".run.app/", nil
+}
+func (f *fakeCloud) AdminAPIKey(project, secret string) (string, error) {
+	f.record("secret " + project + " " + secret)
+	return "secret-manager-key", nil
+}
+
+// fakeBackend issues credentials for any MAC unless err is set.
+type fakeBackend struct {
+	baseURL, apiKey string
+	macs            []string
+	err             error
+}
+
+func (f *fakeBackend) ProvisionDevice(ctx context.Context, mac string) (Credentials, error) {
+	f.macs = append(f.macs, mac)
+	if f.err != nil {
+		return Credentials{}, f.err
+	}
+	return Credentials{
+		DeviceID:  "device-123",
+		Secret:    "secret-456",
+		CreatedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		Region:    "us-west1",
+	}, nil
+}
+
+type fakeMACReader struct {
+	ports []string
+}
+
+func (f *fakeMACReader) ReadMAC(ctx context.Context, port string) (string, error) {
+	f.ports = append(f.ports, port)
+	return "AA:BB:CC:DD:EE:FF", nil
+}
+
+// flashCall is one call to the recording flasher.
+type flashCall struct {
+	port  string
+	creds Credentials
+}
+
+// testLogger collects progress messages.
+type testLogger struct {
+	bytes.Buffer
+}
+
+func (l *testLogger) Infof(format string, args ...any) { fmt.Fprintf(l, format, args...) }
+func (l *testLogger) Warnf(format string, args ...any) { fmt.Fprintf(l, format, args...) }
+
+// fakes wires Options to fakes for every dependency.
+type fakes struct {
+	cloud   *fakeCloud
+	backend *fakeBackend
+	reader  *fakeMACReader
+	flashes []flashCall
+	log     *testLogger
+}
+
+func newFakes() *fakes {
+	return &fakes{
+		cloud:   &fakeCloud{account: "dev@example.com"},
+		backend: &fakeBackend{},
+		reader:  &fakeMACReader{},
+		log:     &testLogger{},
+	}
+}
+
+func (f *fakes) options() Options {
+	return Options{
+		Cloud: f.cloud,
+		NewBackend: func(baseURL, apiKey string) (Backend, error) {
+			f.backend.baseURL, f.backend.apiKey = baseURL, apiKey
+			return f.backend, nil
+		},
+		DetectPort: func() (string, error) { return "/dev/ttyUSB0", nil },
+		MACReader:  f.reader,
+		Flasher: FlasherFunc(func(ctx context.Context, port string, creds Credentials) error {
+			f.flashes = append(f.flashes, flashCall{port, creds})
+			return nil
+		}),
+		Log: f.log,
+	}
+}
+
+func TestProvision(t *testing.T) {
+	f := newFakes()
+	var prepared string
+	opts := f.options()
+	opts.PrepareFirmware = func(baseURL string) error {
+		prepared = baseURL
+		return nil
+	}
+
+	res, err := Provision(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Provision() error = %v", err)
+	}
+
+	const wantURL = "https://telemetry-api-us-west1.run.app"
+	if res.DeviceID != "device-123" || res.Secret != "secret-456" || res.Region != "us-west1" || res.CreatedAt.IsZero() {
+		t.Errorf("credentials = %+v", res.Credentials)
+	}
+	if res.MAC != "AA:BB:CC:DD:EE:FF" || res.Port != "/dev/ttyUSB0" || res.BackendURL != wantURL {
+		t.Errorf("result = %+v", res)
+	}
+
+	wantCalls := []string{"components", "auth", "current-project", "project default-project", "service telemetry-api us-west1", "secret default-project admin-api-key"}
+	if got := strings.Join(f.cloud.calls, ", "); got != strings.Join(wantCalls, ", ") {
+		t.Errorf("cloud calls = %s, want %s", got, strings.Join(wantCalls, ", "))
+	}
+	if prepared != wantURL {
+		t.Errorf("PrepareFirmware got %q, want %q", prepared, wantURL)
+	}
+	if f.backend.baseURL != wantURL || f.backend.apiKey != "secret-manager-key" {
+		t.Errorf("backend = (%q, %q)", f.backend.baseURL, f.backend.apiKey)
+	}
+	if len(f.reader.ports) != 1 || f.reader.ports[0] != "/dev/ttyUSB0" {
+		t.Errorf("MAC read on %v, want the detected port", f.reader.ports)
+	}
+	if len(f.flashes) != 1 || f.flashes[0].port != "/dev/ttyUSB0" || f.flashes[0].creds != res.Credentials {
+		t.Errorf("flashes = %+v, want the issued credentials on /dev/ttyUSB0", f.flashes)
+	}
+	for _, want := range []string{"✓ Authenticated as: dev@example.com", "✓ Service URL: " + wantURL, "✓ Device ID: device-123"} {
+		if !strings.Contains(f.log.String(), want) {
+			t.Errorf("log missing %q:\n%s", want, f.log.String())
+		}
+	}
+}
+
+func TestProvisionResolvedInputsSkipGcloud(t *testing.T) {
+	f := newFakes()
+	opts := f.options()
+	opts.BaseURL = "https://staging.example.com"
+	opts.APIKey = "flag-key"
+	opts.MAC = "11:22:33:44:55:66"
+	opts.Port = "/dev/ttyACM0"
+
+	res, err := Provision(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Provision() error = %v", err)
+	}
+	if len(f.cloud.calls) != 0 {
+		t.Errorf("gcloud called with resolved inputs: %v", f.cloud.calls)
+	}
+	if len(f.reader.ports) != 0 {
+		t.Error("MAC read although it was given")
+	}
+	if f.backend.apiKey != "flag-key" || res.MAC != "11:22:33:44:55:66" || res.Port != "/dev/ttyACM0" {
+		t.Errorf("result = %+v, api key %q", res, f.backend.apiKey)
+	}
+}
+
+func TestProvisionDryRun(t *testing.T) {
+	f := newFakes()
+	opts := f.options()
+	opts.DryRun = true
+	opts.Flasher = nil
+
+	res, err := Provision(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Provision() error = %v", err)
+	}
+	if res.DeviceID != "device-123" {
+		t.Errorf("DeviceID = %q", res.DeviceID)
+	}
+	if !strings.Contains(f.log.String(), "[Dry run] Skipping NVS flash") {
+		t.Errorf("log missing dry-run note:\n%s", f.log.String())
+	}
+}
+
+func TestProvisionErrors(t *testing.T) {
+	t.Run("backend failure", func(t *testing.T) {
+		f := newFakes()
+		f.backend.err = errors.New("409 conflict")
+
+		res, err := Provision(context.Background(), f.options())
+		if err == nil || !strings.Contains(err.Error(), "provision failed: 409 conflict") {
+			t.Fatalf("Provision() error = %v", err)
+		}
+		if res.MAC != "AA:BB:CC:DD:EE:FF" || res.DeviceID != "" {
+			t.Errorf("result = %+v, want the MAC but no device", res)
+		}
+		if len(f.flashes) != 0 {
+			t.Error("flashed after a failed provision")
+		}
+	})
+
+	t.Run("service lookup failure", func(t *testing.T) {
+		f := newFakes()
+		f.cloud.serviceErr = errors.New("NOT_FOUND")
+
+		if _, err := Provision(context.Background(), f.options()); err == nil || !strings.Contains(err.Error(), "failed to get service URL") {
+			t.Fatalf("Provision() error = %v", err)
+		}
+		if len(f.backend.macs) != 0 {
+			t.Error("backend called without a service URL")
+		}
+	})
+
+	t.Run("flash not confirmed", func(t *testing.T) {
+		f := newFakes()
+		opts := f.options()
+		var confirmed Result
+		opts.ConfirmFlash = func(res Result) error {
+			confirmed = res
+			return errors.New("flash cancelled")
+		}
+
+		res, err := Provision(context.Background(), opts)
+		if err == nil || !strings.Contains(err.Error(), "flash cancelled") {
+			t.Fatalf("Provision() error = %v", err)
+		}
+		if confirmed != res || confirmed.MAC != "AA:BB:CC:DD:EE:FF" || confirmed.Port != "/dev/ttyUSB0" {
+			t.Errorf("ConfirmFlash got %+v, want the result %+v", confirmed, res)
+		}
+		// Declining must not issue a secret that is then never flashed or saved
+		if len(f.backend.macs) != 0 || res.Secret != "" {
+			t.Errorf("device registered before confirmation (backend calls %v, result %+v)", f.backend.macs, res)
+		}
+		if len(f.flashes) != 0 {
+			t.Error("flashed without confirmation")
+		}
+	})
+
+	t.Run("no flasher", func(t *testing.T) {
+		f := newFakes()
+		opts := f.options()
+		opts.Flasher = nil
+
+		if _, err := Provision(context.Background(), opts); err == nil || !strings.Contains(err.Error(), "Flasher is required") {
+			t.Fatalf("Provision() error = %v", err)
+		}
+		if len(f.cloud.calls) != 0 {
+			t.Error("gcloud called before validating options")
+		}
+	})
+}
+
+func TestCheckAccountDomain(t *testing.T) {
+	tests := []struct {
+		account string
+		domain  string
+		wantErr bool
+	}{
+		{"dev@example.com", "", false},
+		{"dev@example.com", "@example.com", false},
+		{"dev@example.com", "example.com", false},
+		{"Dev@Example.COM", "@example.com", false},
+		{"dev@gmail.com", "@example.com", true},
+		{"dev@notexample.com", "@example.com", true},
+		{"dev@example.com.evil.io", "@example.com", true},
+		{"provisioner@probe.iam.gserviceaccount.com", "@example.com", true},
+		{"", "@example.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.account+"/"+tt.domain, func(t *testing.T) {
+			err := CheckAccountDomain(tt.account, tt.domain)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckAccountDomain(%q, %q) error = %v, wantErr %t", tt.account, tt.domain, err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "gcloud auth login") {
+				t.Errorf("error = %q, want a gcloud auth login hint", err)
+			}
+		})
+	}
+}
+
+func TestSelectProject(t *testing.T) {
+	fake := &fakeCloud{account: "dev@example.com"}
+	project, err := selectProject(fake, "")
+	if err != nil || project != "default-project" || fake.setProject != "" {
+		t.Errorf("selectProject(default) = %q, %v (set %q)", project, err, fake.setProject)
+	}
+
+	project, err = selectProject(fake, "explicit")
+	if err != nil || project != "explicit" || fake.setProject != "explicit" {
+		t.Errorf("selectProject(explicit) = %q, %v (set %q)", project, err, fake.setProject)
+	}
+}
+
+func TestNewMACReader(t *testing.T) {
+	tests := []struct {
+		name string
+		want MACReader
+	}{
+		{name: "", want: esptoolMACReader{timeout: time.Second}},
+		{name: "esptool", want: esptoolMACReader{timeout: time.Second}},
+		{name: "serial", want: serialMACReader{timeout: time.Second}},
+	}
+
+	for _, tt := range tests {
+		source, err := ParseMACSource(tt.name)
+		if err != nil {
+			t.Fatalf("ParseMACSource(%q) error = %v", tt.name, err)
+		}
+		if got := NewMACReader(source, time.Second); got != tt.want {
+			t.Errorf("NewMACReader(%q) = %#v, want %#v", source, got, tt.want)
+		}
+	}
+
+	if _, err := ParseMACSource("jtag"); err == nil || !strings.Contains(err.Error(), `unknown MAC source "jtag"`) {
+		t.Errorf("ParseMACSource(jtag) error = %v, want unknown source", err)
+	}
+}
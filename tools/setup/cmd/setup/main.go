@@ -3,8 +3,12 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"measurement-probe/tools/setup/internal/bsec"
 	"measurement-probe/tools/setup/internal/git"
@@ -13,6 +17,9 @@ import (
 	"measurement-probe/tools/setup/internal/provisioning"
 )
 
+// bme68xOnlyMode is the operation mode that builds without the BSEC library.
+const bme68xOnlyMode = "bme68x"
+
 // Menu options for BSEC configuration.
 var (
 	espChips = []prompt.Choice{
@@ -35,6 +42,7 @@ var (
 	modeOptions = []prompt.Choice{
 		{ID: "continuous", Display: "Continuous (3s sampling, LP mode) - for always-on devices"},
 		{ID: "deepsleep", Display: "Deep Sleep (300s sampling, ULP mode) - for battery devices"},
+		{ID: bme68xOnlyMode, Display: "BME68x only (no BSEC) - raw sensor API, no gas IAQ"},
 	}
 
 	historyOptions = []prompt.Choice{
@@ -50,8 +58,54 @@ func main() {
 	}
 }
 
+// options holds command-line settings for the setup tool.
+type options struct {
+	nonInteractive bool
+	dryRun         bool
+	offline        bool
+	checkOnly      bool
+	noBackup       bool
+	manifest       string
+	regenPoP       bool
+	bsec           bsecOptions
+}
+
+// bsecOptions holds the raw BSEC menu selections, either from prompts or flags.
+type bsecOptions struct {
+	ESPChip string
+	Sensor  string
+	Voltage string
+	Mode    string
+	History string
+}
+
+func parseFlags() options {
+	var opts options
+	flag.BoolVar(&opts.nonInteractive, "non-interactive", false, "Take all choices from flags and never prompt")
+	flag.BoolVar(&opts.noBackup, "no-backup", false, "Don't save app_config.hpp.bak before editing app_config.hpp")
+	flag.StringVar(&opts.manifest, "bsec-manifest", "", "sha256sum-style file of known-good BSEC library hashes to verify against")
+	flag.BoolVar(&opts.regenPoP, "regen-pop", false, "Generate a new provisioning secret even if one already exists")
+	flag.BoolVar(&opts.dryRun, "dry-run", false, "Print the files setup would create or modify without changing anything")
+	flag.BoolVar(&opts.checkOnly, "check-only", false, "Only verify that the BSEC files already match the chosen configuration, then exit (non-zero if stale)")
+	flag.BoolVar(&opts.offline, "offline", false, "Don't run git submodule update; only verify the submodules are already on disk")
+	flag.StringVar(&opts.bsec.ESPChip, "chip", "", "ESP chip: "+choiceIDs(espChips))
+	flag.StringVar(&opts.bsec.Sensor, "sensor", "", "Sensor chip: "+choiceIDs(sensorChips))
+	flag.StringVar(&opts.bsec.Voltage, "voltage", "", "Supply voltage: "+choiceIDs(voltageOptions))
+	flag.StringVar(&opts.bsec.Mode, "mode", "", "Operation mode: "+choiceIDs(modeOptions))
+	flag.StringVar(&opts.bsec.History, "history", "", "Calibration history: "+choiceIDs(historyOptions))
+	flag.Parse()
+	return opts
+}
+
 func run() error {
-	ui := prompt.New(os.Stdin, os.Stdout)
+	opts := parseFlags()
+
+	// Never read stdin in non-interactive mode, even if something prompts
+	var input io.Reader = os.Stdin
+	if opts.nonInteractive {
+		input = strings.NewReader("")
+	}
+	ui := prompt.New(input, os.Stdout)
 
 	printBanner(ui)
 
@@ -62,30 +116,53 @@ func run() error {
 	}
 	ui.Print("Project root: %s\n\n", proj.Root)
 
-	// Step 1: Submodules
-	ui.Println("─── Step 1: External Dependencies ───")
-	if err := setupSubmodules(proj, ui); err != nil {
+	// Step 1: BSEC configuration
+	ui.Println("─── Step 1: BSEC Configuration ───")
+	bsecOpts := opts.bsec
+	if !opts.nonInteractive {
+		bsecOpts = promptBSECOptions(ui)
+	}
+	config, err := buildBSECConfig(bsecOpts)
+	if err != nil {
 		return err
 	}
 
-	// Step 2: BSEC configuration
-	ui.Println("\n─── Step 2: BSEC Configuration ───")
-	config := promptBSECConfig(ui)
+	if opts.checkOnly {
+		return checkBSECConfig(proj, config, ui)
+	}
+
+	summary := &Summary{ProjectRoot: proj.Root}
+	if config != nil {
+		summary.BSECConfig = config.Name()
+		summary.DeepSleep = config.DeepSleep
+	}
+
+	// Step 2: Submodules
+	ui.Println("\n─── Step 2: External Dependencies ───")
+	summary.Submodules, err = setupSubmodules(proj, ui, opts, config != nil)
+	if err != nil {
+		return err
+	}
 
 	// Step 3: Apply configuration
 	ui.Println("\n─── Step 3: Applying Configuration ───")
-	if err := applyBSECConfig(proj, config, ui); err != nil {
+	if err := applyBSECConfig(proj, config, ui, opts); err != nil {
 		return err
 	}
 
 	// Step 4: Provisioning
 	ui.Println("\n─── Step 4: Provisioning Secret ───")
-	pop, err := setupProvisioning(proj, ui)
+	summary.PoP, summary.ProvisioningFile, err = setupProvisioning(proj, ui, opts)
 	if err != nil {
 		return err
 	}
 
-	printSuccess(ui, pop)
+	if opts.dryRun {
+		ui.Println("\nDry run complete - no files were changed.")
+		return nil
+	}
+
+	printSuccess(ui, summary)
 	return nil
 }
 
@@ -96,49 +173,117 @@ func printBanner(ui *prompt.Prompter) {
 	ui.Println()
 }
 
-func setupSubmodules(proj *project.Project, ui *prompt.Prompter) error {
-	ui.Println("Initializing git submodules...")
+// setupSubmodules initializes and verifies the external libraries, returning
+// their checked-out revisions. The BSEC submodule is only required when
+// useBSEC is set. In offline mode they are only verified.
+func setupSubmodules(proj *project.Project, ui *prompt.Prompter, opts options, useBSEC bool) ([]SubmoduleVersion, error) {
+	switch {
+	case opts.dryRun:
+	case opts.offline:
+		ui.Println("Verifying vendored submodules (offline)...")
+	default:
+		ui.Println("Initializing git submodules...")
+	}
 
 	// Define required submodules with their marker files
 	submodules := []git.Submodule{
-		{
-			Name:   "Bosch-BSEC2-Library",
-			Path:   proj.BSEC2Path,
-			Marker: "src/inc/bsec_interface.h",
-		},
 		{
 			Name:   "BME68x_SensorAPI",
 			Path:   proj.BME68xPath,
 			Marker: "bme68x.h",
 		},
 	}
+	if useBSEC {
+		submodules = append([]git.Submodule{{
+			Name:   "Bosch-BSEC2-Library",
+			Path:   proj.BSEC2Path,
+			Marker: "src/inc/bsec_interface.h",
+		}}, submodules...)
+	}
 
 	mgr := git.NewSubmoduleManager(proj.Root, submodules)
+	mgr.SetSkipInit(opts.offline)
+	if opts.dryRun {
+		if err := mgr.VerifySubmodules(); err != nil {
+			ui.Println("Would run: git submodule update --init --recursive")
+			return nil, nil
+		}
+		ui.Println("Submodules already initialized")
+		return nil, nil
+	}
 	if err := mgr.Setup(); err != nil {
-		return err
+		return nil, err
 	}
 
-	for _, sub := range submodules {
+	versions := make([]SubmoduleVersion, len(submodules))
+	for i, sub := range submodules {
 		ui.Print("✓ %s ready\n", sub.Name)
+		versions[i].Name = sub.Name
+		versions[i].Revision, _ = git.Revision(sub.Path) // Informational only
 	}
-	return nil
+	return versions, nil
 }
 
-func promptBSECConfig(ui *prompt.Prompter) *bsec.Config {
-	config := &bsec.Config{}
+func promptBSECOptions(ui *prompt.Prompter) bsecOptions {
+	var opts bsecOptions
 
 	ui.Section("1) Target ESP Chip")
-	config.ESPChip = ui.Select("Select ESP chip", espChips, 0)
+	opts.ESPChip = ui.Select("Select ESP chip", espChips, 0)
 
 	ui.Section("2) Sensor Chip Variant")
-	config.ChipVariant = ui.Select("Select sensor", sensorChips, 0)
+	opts.Sensor = ui.Select("Select sensor", sensorChips, 0)
 
 	ui.Section("3) Supply Voltage")
-	config.Voltage = ui.Select("Select voltage", voltageOptions, 0)
+	opts.Voltage = ui.Select("Select voltage", voltageOptions, 0)
 
 	ui.Section("4) Operation Mode")
-	mode := ui.Select("Select mode", modeOptions, 0)
-	if mode == "deepsleep" {
+	opts.Mode = ui.Select("Select mode", modeOptions, 0)
+	if opts.Mode == bme68xOnlyMode {
+		return opts
+	}
+
+	ui.Section("5) Calibration History")
+	opts.History = ui.Select("Select history", historyOptions, 0)
+
+	return opts
+}
+
+// buildBSECConfig validates the selections and assembles the BSEC configuration.
+// It returns a nil config in BME68x-only mode, where only -mode is required.
+func buildBSECConfig(opts bsecOptions) (*bsec.Config, error) {
+	if opts.Mode == bme68xOnlyMode {
+		return nil, nil
+	}
+
+	selections := []struct {
+		flag    string
+		value   string
+		choices []prompt.Choice
+	}{
+		{"chip", opts.ESPChip, espChips},
+		{"sensor", opts.Sensor, sensorChips},
+		{"voltage", opts.Voltage, voltageOptions},
+		{"mode", opts.Mode, modeOptions},
+		{"history", opts.History, historyOptions},
+	}
+
+	for _, sel := range selections {
+		if sel.value == "" {
+			return nil, fmt.Errorf("-%s is required (one of: %s)", sel.flag, choiceIDs(sel.choices))
+		}
+		if !hasChoice(sel.choices, sel.value) {
+			return nil, fmt.Errorf("invalid -%s %q (one of: %s)", sel.flag, sel.value, choiceIDs(sel.choices))
+		}
+	}
+
+	config := &bsec.Config{
+		ESPChip:     opts.ESPChip,
+		ChipVariant: opts.Sensor,
+		Voltage:     opts.Voltage,
+		History:     opts.History,
+	}
+
+	if opts.Mode == "deepsleep" {
 		config.DeepSleep = true
 		config.Interval = "300s"
 	} else {
@@ -146,16 +291,29 @@ func promptBSECConfig(ui *prompt.Prompter) *bsec.Config {
 		config.Interval = "3s"
 	}
 
-	ui.Section("5) Calibration History")
-	config.History = ui.Select("Select history", historyOptions, 0)
+	return config, nil
+}
 
-	return config
+func hasChoice(choices []prompt.Choice, id string) bool {
+	for _, c := range choices {
+		if c.ID == id {
+			return true
+		}
+	}
+	return false
 }
 
-func applyBSECConfig(proj *project.Project, config *bsec.Config, ui *prompt.Prompter) error {
-	ui.Print("Selected configuration: %s\n", config.Name())
+func choiceIDs(choices []prompt.Choice) string {
+	ids := make([]string, len(choices))
+	for i, c := range choices {
+		ids[i] = c.ID
+	}
+	return strings.Join(ids, ", ")
+}
 
-	paths := bsec.Paths{
+// bsecPaths returns the BSEC source and target locations in proj.
+func bsecPaths(proj *project.Project) bsec.Paths {
+	return bsec.Paths{
 		SourceDir:     proj.BSEC2Path,
 		TargetDir:     proj.BSEC2Target,
 		AppConfigPath: proj.AppConfigPath(),
@@ -163,9 +321,68 @@ func applyBSECConfig(proj *project.Project, config *bsec.Config, ui *prompt.Prom
 		ConfigFile:    "bsec_iaq.txt",
 		LibraryName:   "libalgobsec.a",
 	}
+}
+
+// checkBSECConfig reports whether a previous setup run left the BSEC files
+// matching config, without changing anything. It fails listing each
+// difference when they are stale.
+func checkBSECConfig(proj *project.Project, config *bsec.Config, ui *prompt.Prompter) error {
+	if config == nil {
+		ui.Println("BME68x-only mode - no BSEC files to check")
+		return nil
+	}
+
+	problems, err := bsec.NewSetup(bsecPaths(proj)).Check(config)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		ui.Print("BSEC setup does not match %s:\n", config.Name())
+		for _, p := range problems {
+			ui.Print("  - %s\n", p)
+		}
+		return fmt.Errorf("BSEC setup is stale (%d problems) - rerun setup", len(problems))
+	}
+
+	ui.Print("✓ BSEC setup matches %s\n", config.Name())
+	return nil
+}
+
+// applyBSECConfig installs the BSEC library for config, or only disables BSEC in
+// app_config.hpp when config is nil (BME68x-only mode).
+func applyBSECConfig(proj *project.Project, config *bsec.Config, ui *prompt.Prompter, opts options) error {
+	setup := bsec.NewSetup(bsecPaths(proj))
+	setup.SetBackup(!opts.noBackup)
+	if opts.manifest != "" {
+		manifest, err := bsec.LoadManifest(opts.manifest)
+		if err != nil {
+			return err
+		}
+		setup.SetManifest(manifest)
+	}
+
+	if config == nil {
+		return disableBSEC(setup, ui, opts.dryRun)
+	}
+
+	ui.Print("Selected configuration: %s\n", config.Name())
+	if err := setup.CheckChip(config.ESPChip); err != nil {
+		return err
+	}
+	if opts.dryRun {
+		actions, err := setup.Plan(config)
+		if err != nil {
+			return err
+		}
+		ui.Println("Would apply:")
+		for _, a := range actions {
+			ui.Print("  %s\n", a)
+		}
+		return nil
+	}
 
-	setup := bsec.NewSetup(paths)
-	if err := setup.Apply(config); err != nil {
+	res, err := setup.Apply(config)
+	if err != nil {
 		return err
 	}
 
@@ -177,22 +394,90 @@ func applyBSECConfig(proj *project.Project, config *bsec.Config, ui *prompt.Prom
 		ui.Println("  Mode: Continuous (LP, 3s intervals)")
 	}
 
+	ui.Println("  Files:")
+	for _, f := range res.Files {
+		ui.Print("    %s (%s)\n", relPath(proj.Root, f.Path), formatSize(f.Size))
+	}
+	if res.Library.Size == 0 {
+		ui.Print("⚠️  %s is empty - check the BSEC library download\n", relPath(proj.Root, res.Library.Path))
+	}
+
 	return nil
 }
 
-func setupProvisioning(proj *project.Project, ui *prompt.Prompter) (string, error) {
+// relPath returns path relative to root for display, or path itself when it
+// isn't under root.
+func relPath(root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return rel
+}
+
+// formatSize renders a byte count with a binary unit, keeping exact bytes
+// for small files.
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d bytes", n)
+	}
+}
+
+// disableBSEC configures a BME68x-only build without touching the BSEC library.
+func disableBSEC(setup *bsec.Setup, ui *prompt.Prompter, dryRun bool) error {
+	ui.Println("Selected configuration: BME68x only (BSEC disabled)")
+
+	actions := setup.PlanWithoutBSEC()
+	if dryRun {
+		ui.Println("Would apply:")
+		for _, a := range actions {
+			ui.Print("  %s\n", a)
+		}
+		return nil
+	}
+
+	if err := setup.Execute(actions); err != nil {
+		return err
+	}
+
+	ui.Println("✓ BSEC disabled (USE_BSEC = false)")
+	return nil
+}
+
+// setupProvisioning generates or reuses the provisioning secret, returning the
+// PoP and the path of the generated header.
+func setupProvisioning(proj *project.Project, ui *prompt.Prompter, opts options) (pop, path string, err error) {
 	defaults := provisioning.Defaults{
 		DeviceName:   "MeasureProbe",
 		TimeoutSec:   300,
 		PopBytes:     4, // 4 bytes = 8 hex chars
 		OutputFile:   "provisioning_config.h",
 		GeneratedDir: proj.GeneratedDir(),
+		Force:        opts.regenPoP,
 	}
 
 	setup := provisioning.NewSetup(defaults)
+	if opts.dryRun {
+		_, isNew, err := setup.Plan()
+		if err != nil {
+			return "", "", err
+		}
+		if isNew {
+			ui.Print("Would write: %s (new provisioning secret)\n", setup.Path())
+		} else {
+			ui.Print("Would keep existing provisioning secret in %s\n", setup.Path())
+		}
+		return "", setup.Path(), nil
+	}
+
 	config, isNew, err := setup.Generate()
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	if isNew {
@@ -201,14 +486,16 @@ func setupProvisioning(proj *project.Project, ui *prompt.Prompter) (string, erro
 		ui.Print("Using existing provisioning secret: %s\n", config.PoP)
 	}
 
-	return config.PoP, nil
+	return config.PoP, setup.Path(), nil
 }
 
-func printSuccess(ui *prompt.Prompter, pop string) {
+func printSuccess(ui *prompt.Prompter, summary *Summary) {
 	ui.Println("\n✓ Setup complete!")
+	ui.Println("\nSummary:")
+	ui.Print("%s", summary)
 	ui.Println("\n╔══════════════════════════════════════════════════════════╗")
 	ui.Println("║  PROVISIONING SECRET (keep this safe!)                   ║")
-	ui.Print("║  PoP: %-50s ║\n", pop)
+	ui.Print("║  PoP: %-50s ║\n", summary.PoP)
 	ui.Println("╚══════════════════════════════════════════════════════════╝")
 	ui.Println("\nNext steps:")
 	ui.Println("  1. Run 'idf.py build' to compile")
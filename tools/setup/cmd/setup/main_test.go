@@ -0,0 +1,220 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"measurement-probe/tools/setup/internal/project"
+	"measurement-probe/tools/setup/internal/prompt"
+)
+
+func TestBuildBSECConfig_MatchesInteractive(t *testing.T) {
+	t.Parallel()
+
+	// Menu answers: esp32s3, bme688, 1.8V, deep sleep, 28 days
+	ui := prompt.New(strings.NewReader("4\n2\n2\n2\n2\n"), io.Discard)
+	interactive, err := buildBSECConfig(promptBSECOptions(ui))
+	if err != nil {
+		t.Fatalf("buildBSECConfig(interactive) error = %v", err)
+	}
+
+	flags, err := buildBSECConfig(bsecOptions{
+		ESPChip: "esp32s3",
+		Sensor:  "bme688",
+		Voltage: "18v",
+		Mode:    "deepsleep",
+		History: "28d",
+	})
+	if err != nil {
+		t.Fatalf("buildBSECConfig(flags) error = %v", err)
+	}
+
+	if *interactive != *flags {
+		t.Errorf("interactive config = %+v, flag config = %+v", *interactive, *flags)
+	}
+
+	if !flags.DeepSleep || flags.Interval != "300s" {
+		t.Errorf("deepsleep mode: DeepSleep = %t, Interval = %q", flags.DeepSleep, flags.Interval)
+	}
+}
+
+func TestBuildBSECConfig_Continuous(t *testing.T) {
+	t.Parallel()
+
+	config, err := buildBSECConfig(bsecOptions{
+		ESPChip: "esp32c3",
+		Sensor:  "bme680",
+		Voltage: "33v",
+		Mode:    "continuous",
+		History: "4d",
+	})
+	if err != nil {
+		t.Fatalf("buildBSECConfig() error = %v", err)
+	}
+
+	if config.Name() != "bme680_iaq_33v_3s_4d" {
+		t.Errorf("Name() = %q, want %q", config.Name(), "bme680_iaq_33v_3s_4d")
+	}
+	if config.DeepSleep {
+		t.Error("DeepSleep = true, want false for continuous mode")
+	}
+}
+
+func TestBuildBSECConfig_Errors(t *testing.T) {
+	t.Parallel()
+
+	valid := bsecOptions{
+		ESPChip: "esp32c3",
+		Sensor:  "bme680",
+		Voltage: "33v",
+		Mode:    "continuous",
+		History: "4d",
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(*bsecOptions)
+		wantErr string
+	}{
+		{
+			name:    "missing chip",
+			modify:  func(o *bsecOptions) { o.ESPChip = "" },
+			wantErr: "-chip is required",
+		},
+		{
+			name:    "missing history",
+			modify:  func(o *bsecOptions) { o.History = "" },
+			wantErr: "-history is required",
+		},
+		{
+			name:    "invalid sensor",
+			modify:  func(o *bsecOptions) { o.Sensor = "bme280" },
+			wantErr: `invalid -sensor "bme280"`,
+		},
+		{
+			name:    "invalid mode",
+			modify:  func(o *bsecOptions) { o.Mode = "turbo" },
+			wantErr: `invalid -mode "turbo"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			opts := valid
+			tt.modify(&opts)
+
+			_, err := buildBSECConfig(opts)
+			if err == nil {
+				t.Fatal("buildBSECConfig() expected error")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %q, want it to contain %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBuildBSECConfig_BME68xOnly(t *testing.T) {
+	t.Parallel()
+
+	config, err := buildBSECConfig(bsecOptions{Mode: "bme68x"})
+	if err != nil {
+		t.Fatalf("buildBSECConfig() error = %v", err)
+	}
+	if config != nil {
+		t.Errorf("buildBSECConfig() = %+v, want nil config in BME68x-only mode", *config)
+	}
+}
+
+func TestApplyBSECConfig_BME68xOnly(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	proj := &project.Project{
+		Root:        root,
+		BSEC2Path:   filepath.Join(root, "components", "external", "Bosch-BSEC2-Library"),
+		BSEC2Target: filepath.Join(root, "components", "external", "bsec2"),
+	}
+
+	appConfig := "namespace app::config {\ninline constexpr bool BSEC_DEEP_SLEEP_MODE = false;\n} // namespace app::config\n"
+	if err := os.MkdirAll(filepath.Dir(proj.AppConfigPath()), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(proj.AppConfigPath(), []byte(appConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The BSEC library is absent, so invoking bsec.Setup.Apply would fail
+	ui := prompt.New(strings.NewReader(""), io.Discard)
+	if err := applyBSECConfig(proj, nil, ui, options{}); err != nil {
+		t.Fatalf("applyBSECConfig() error = %v", err)
+	}
+
+	content, err := os.ReadFile(proj.AppConfigPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "inline constexpr bool USE_BSEC = false;") {
+		t.Errorf("app_config.hpp does not disable BSEC:\n%s", content)
+	}
+
+	if _, err := os.Stat(proj.BSEC2Target); !os.IsNotExist(err) {
+		t.Errorf("BSEC target %s was created in BME68x-only mode", proj.BSEC2Target)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 bytes"},
+		{1023, "1023 bytes"},
+		{1536, "1.5 KiB"},
+		{3 << 20, "3.0 MiB"},
+	}
+	for _, tt := range tests {
+		if got := formatSize(tt.n); got != tt.want {
+			t.Errorf("formatSize(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestCheckBSECConfig_NotApplied(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	proj := &project.Project{
+		Root:        root,
+		BSEC2Path:   filepath.Join(root, "components", "external", "Bosch-BSEC2-Library"),
+		BSEC2Target: filepath.Join(root, "components", "external", "bsec2"),
+	}
+	config, err := buildBSECConfig(bsecOptions{ESPChip: "esp32c3", Sensor: "bme680", Voltage: "33v", Mode: "continuous", History: "4d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	ui := prompt.New(strings.NewReader(""), &out)
+	err = checkBSECConfig(proj, config, ui)
+	if err == nil || !strings.Contains(err.Error(), "stale") {
+		t.Fatalf("checkBSECConfig() error = %v, want stale", err)
+	}
+	if !strings.Contains(out.String(), "missing include/bsec_config.h") {
+		t.Errorf("output does not list the missing header:\n%s", out.String())
+	}
+	if _, err := os.Stat(proj.BSEC2Target); !os.IsNotExist(err) {
+		t.Errorf("checkBSECConfig() created %s", proj.BSEC2Target)
+	}
+
+	if err := checkBSECConfig(proj, nil, ui); err != nil {
+		t.Errorf("checkBSECConfig(BME68x-only) error = %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Summary collects the results of each setup step for the final report.
+type Summary struct {
+	ProjectRoot      string
+	Submodules       []SubmoduleVersion
+	BSECConfig       string // Config name, empty when BSEC is disabled
+	DeepSleep        bool
+	ProvisioningFile string
+	PoP              string
+}
+
+// SubmoduleVersion records the commit checked out for a submodule.
+type SubmoduleVersion struct {
+	Name     string
+	Revision string // Empty if it could not be determined
+}
+
+// String renders the summary as aligned "label: value" lines for pasting
+// into a setup log.
+func (s *Summary) String() string {
+	var b strings.Builder
+	line := func(label, value string) {
+		fmt.Fprintf(&b, "  %-18s %s\n", label+":", value)
+	}
+
+	line("Project root", s.ProjectRoot)
+	for _, sub := range s.Submodules {
+		revision := sub.Revision
+		if revision == "" {
+			revision = "unknown"
+		}
+		line(sub.Name, revision)
+	}
+
+	if s.BSECConfig == "" {
+		line("BSEC config", "disabled (BME68x only)")
+	} else {
+		line("BSEC config", s.BSECConfig)
+		line("Deep sleep", onOff(s.DeepSleep))
+	}
+
+	line("Provisioning file", s.ProvisioningFile)
+	line("PoP", s.PoP)
+	return b.String()
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
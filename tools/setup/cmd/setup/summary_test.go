@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSummary_String(t *testing.T) {
+	t.Parallel()
+
+	summary := &Summary{
+		ProjectRoot: "/home/dev/measurement-probe",
+		Submodules: []SubmoduleVersion{
+			{Name: "Bosch-BSEC2-Library", Revision: "0123456789ab"},
+			{Name: "BME68x_SensorAPI"},
+		},
+		BSECConfig:       "bme680_iaq_33v_300s_4d",
+		DeepSleep:        true,
+		ProvisioningFile: "/home/dev/measurement-probe/components/generated/provisioning_config.h",
+		PoP:              "a1b2c3d4",
+	}
+
+	got := summary.String()
+
+	wantLines := []string{
+		"Project root:      /home/dev/measurement-probe",
+		"Bosch-BSEC2-Library: 0123456789ab",
+		"BME68x_SensorAPI:  unknown",
+		"BSEC config:       bme680_iaq_33v_300s_4d",
+		"Deep sleep:        on",
+		"Provisioning file: /home/dev/measurement-probe/components/generated/provisioning_config.h",
+		"PoP:               a1b2c3d4",
+	}
+	for _, want := range wantLines {
+		if !strings.Contains(got, want) {
+			t.Errorf("summary missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestSummary_String_BSECDisabled(t *testing.T) {
+	t.Parallel()
+
+	summary := &Summary{ProjectRoot: "/p", PoP: "a1b2c3d4"}
+	got := summary.String()
+
+	if !strings.Contains(got, "disabled (BME68x only)") {
+		t.Errorf("summary does not report BSEC disabled:\n%s", got)
+	}
+	if strings.Contains(got, "Deep sleep") {
+		t.Errorf("summary reports deep sleep without BSEC:\n%s", got)
+	}
+}
@@ -2,9 +2,13 @@
 package bsec
 
 import (
+	"compress/gzip"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -45,44 +49,212 @@ type Paths struct {
 	TargetDir     string   // Path to target directory for copied files
 	AppConfigPath string   // Path to app_config.hpp (optional)
 	Headers       []string // Header files to copy from src/inc
-	ConfigFile    string   // Config data filename (e.g., "bsec_iaq.txt")
+	ConfigFile    string   // Config data filename (e.g., "bsec_iaq.txt"); a .gz copy is read when it is absent
 	LibraryName   string   // Library filename (e.g., "libalgobsec.a")
 }
 
 // Setup handles BSEC library configuration.
 type Setup struct {
-	paths Paths
+	paths    Paths
+	manifest Manifest
+	backup   bool
+	backedUp bool // app_config.hpp already backed up by this Setup
 }
 
 // NewSetup creates a BSEC setup handler with the given paths.
 func NewSetup(paths Paths) *Setup {
-	return &Setup{paths: paths}
+	return &Setup{paths: paths, backup: true}
 }
 
-// Apply configures the BSEC library with the given settings.
-func (s *Setup) Apply(config *Config) error {
+// SetManifest enables checksum verification of the BSEC library against m.
+// Libraries without an entry in m are not checked.
+func (s *Setup) SetManifest(m Manifest) {
+	s.manifest = m
+}
+
+// SetBackup controls whether app_config.hpp is copied to app_config.hpp.bak
+// before it is first modified (enabled by default).
+func (s *Setup) SetBackup(enabled bool) {
+	s.backup = enabled
+}
+
+// ActionKind identifies the type of filesystem change in a plan.
+type ActionKind string
+
+// Kinds of planned filesystem changes.
+const (
+	ActionMkdir  ActionKind = "create"
+	ActionCopy   ActionKind = "copy"
+	ActionWrite  ActionKind = "write"
+	ActionBackup ActionKind = "backup"
+)
+
+// backupSuffix is appended to app_config.hpp for the pre-edit backup.
+const backupSuffix = ".bak"
+
+// Action is a single filesystem change that Apply will make.
+type Action struct {
+	Kind    ActionKind
+	Path    string // Destination path
+	Source  string // Source path for copies
+	Content []byte // File contents for writes
+}
+
+// String describes the action for display.
+func (a Action) String() string {
+	switch a.Kind {
+	case ActionCopy:
+		return fmt.Sprintf("copy   %s -> %s", a.Source, a.Path)
+	case ActionWrite:
+		return fmt.Sprintf("write  %s (%d bytes)", a.Path, len(a.Content))
+	case ActionBackup:
+		return fmt.Sprintf("backup %s -> %s", a.Source, a.Path)
+	default:
+		return fmt.Sprintf("create %s/", a.Path)
+	}
+}
+
+// File is a file Apply copied or wrote, with its size on disk.
+type File struct {
+	Path string
+	Size int64
+}
+
+// Result describes the files Apply put in place.
+type Result struct {
+	Library File   // The copied BSEC library
+	Files   []File // Every file copied or written, in order, including Library
+}
+
+// Apply configures the BSEC library with the given settings and reports the
+// files it wrote.
+func (s *Setup) Apply(config *Config) (*Result, error) {
+	actions, err := s.Plan(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Execute(actions); err != nil {
+		return nil, err
+	}
+	return s.result(actions)
+}
+
+// result stats the destinations of the executed copy and write actions.
+func (s *Setup) result(actions []Action) (*Result, error) {
+	libPath := filepath.Join(s.paths.TargetDir, "lib", s.paths.LibraryName)
+
+	res := &Result{}
+	for _, a := range actions {
+		if a.Kind != ActionCopy && a.Kind != ActionWrite {
+			continue
+		}
+		info, err := os.Stat(a.Path)
+		if err != nil {
+			return nil, err
+		}
+		f := File{Path: a.Path, Size: info.Size()}
+		res.Files = append(res.Files, f)
+		if a.Path == libPath {
+			res.Library = f
+		}
+	}
+	return res, nil
+}
+
+// Plan validates the BSEC sources for config and returns the changes Apply
+// would make, in order, without touching the filesystem.
+func (s *Setup) Plan(config *Config) ([]Action, error) {
 	configSrcPath := s.configSourcePath(config)
 	if _, err := os.Stat(configSrcPath); os.IsNotExist(err) {
-		return fmt.Errorf("configuration not found: %s", configSrcPath)
+		return nil, fmt.Errorf("configuration not found: %s", configSrcPath)
 	}
 
-	if err := s.createTargetDirs(); err != nil {
-		return err
+	actions := s.planTargetDirs()
+
+	headers, err := s.planHeaders()
+	if err != nil {
+		return nil, err
 	}
+	actions = append(actions, headers...)
 
-	if err := s.copyHeaders(); err != nil {
-		return err
+	library, err := s.planLibrary(config.ESPChip)
+	if err != nil {
+		return nil, err
 	}
+	actions = append(actions, library)
 
-	if err := s.copyLibrary(config.ESPChip); err != nil {
-		return err
+	configHeader, err := s.planConfigHeader(configSrcPath, config)
+	if err != nil {
+		return nil, err
 	}
+	actions = append(actions, configHeader)
 
-	if err := s.generateConfigHeader(configSrcPath, config); err != nil {
-		return err
+	if appConfig, ok := s.planAppConfig(config); ok {
+		actions = s.withBackup(actions, appConfig)
+	}
+
+	return actions, nil
+}
+
+// Execute performs the actions returned by Plan.
+func (s *Setup) Execute(actions []Action) error {
+	for _, a := range actions {
+		switch a.Kind {
+		case ActionMkdir:
+			if err := os.MkdirAll(a.Path, 0755); err != nil {
+				return fmt.Errorf("failed to create directory %s: %w", a.Path, err)
+			}
+		case ActionCopy:
+			if err := copyFile(a.Source, a.Path); err != nil {
+				return fmt.Errorf("failed to copy %s: %w", filepath.Base(a.Source), err)
+			}
+		case ActionWrite:
+			if err := os.WriteFile(a.Path, a.Content, 0644); err != nil {
+				return fmt.Errorf("failed to write %s: %w", a.Path, err)
+			}
+		case ActionBackup:
+			if s.backedUp {
+				continue
+			}
+			if err := copyFile(a.Source, a.Path); err != nil {
+				return fmt.Errorf("failed to back up %s: %w", a.Source, err)
+			}
+			s.backedUp = true
+		}
+	}
+	return nil
+}
+
+// CheckChip verifies that the BSEC source ships a library for espChip. The
+// error lists the chips that do have one.
+func (s *Setup) CheckChip(espChip string) error {
+	if _, err := os.Stat(s.libraryPath(espChip)); err == nil {
+		return nil
+	}
+
+	available := s.AvailableChips()
+	if len(available) == 0 {
+		return fmt.Errorf("BSEC library not found for %s: no chip libraries in %s (submodule not initialized?)",
+			espChip, filepath.Join(s.paths.SourceDir, "src"))
+	}
+	return fmt.Errorf("BSEC library not found for %s: %s (available: %s)",
+		espChip, s.libraryPath(espChip), strings.Join(available, ", "))
+}
+
+// AvailableChips returns the chips with a library in the BSEC source, sorted.
+func (s *Setup) AvailableChips() []string {
+	matches, _ := filepath.Glob(filepath.Join(s.paths.SourceDir, "src", "*", s.paths.LibraryName))
+
+	chips := make([]string, 0, len(matches))
+	for _, m := range matches {
+		chips = append(chips, filepath.Base(filepath.Dir(m)))
 	}
+	sort.Strings(chips)
+	return chips
+}
 
-	return s.updateAppConfig(config)
+func (s *Setup) libraryPath(espChip string) string {
+	return filepath.Join(s.paths.SourceDir, "src", espChip, s.paths.LibraryName)
 }
 
 func (s *Setup) configSourcePath(config *Config) string {
@@ -92,52 +264,83 @@ func (s *Setup) configSourcePath(config *Config) string {
 	)
 }
 
-func (s *Setup) createTargetDirs() error {
-	dirs := []string{
-		filepath.Join(s.paths.TargetDir, "include"),
-		filepath.Join(s.paths.TargetDir, "lib"),
-	}
-	for _, dir := range dirs {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return fmt.Errorf("failed to create directory %s: %w", dir, err)
-		}
+func (s *Setup) planTargetDirs() []Action {
+	return []Action{
+		{Kind: ActionMkdir, Path: filepath.Join(s.paths.TargetDir, "include")},
+		{Kind: ActionMkdir, Path: filepath.Join(s.paths.TargetDir, "lib")},
 	}
-	return nil
 }
 
-func (s *Setup) copyHeaders() error {
+func (s *Setup) planHeaders() ([]Action, error) {
 	srcDir := filepath.Join(s.paths.SourceDir, "src", "inc")
 	dstDir := filepath.Join(s.paths.TargetDir, "include")
 
+	var actions []Action
 	for _, h := range s.paths.Headers {
-		if err := copyFile(filepath.Join(srcDir, h), filepath.Join(dstDir, h)); err != nil {
-			return fmt.Errorf("failed to copy header %s: %w", h, err)
+		src := filepath.Join(srcDir, h)
+		if _, err := os.Stat(src); err != nil {
+			return nil, fmt.Errorf("failed to copy header %s: %w", h, err)
 		}
+		actions = append(actions, Action{Kind: ActionCopy, Source: src, Path: filepath.Join(dstDir, h)})
 	}
-	return nil
+	return actions, nil
 }
 
-func (s *Setup) copyLibrary(espChip string) error {
-	srcPath := filepath.Join(s.paths.SourceDir, "src", espChip, s.paths.LibraryName)
-	if _, err := os.Stat(srcPath); os.IsNotExist(err) {
-		return fmt.Errorf("BSEC library not found for %s: %s", espChip, srcPath)
+func (s *Setup) planLibrary(espChip string) (Action, error) {
+	srcPath := s.libraryPath(espChip)
+	if err := s.CheckChip(espChip); err != nil {
+		return Action{}, err
+	}
+
+	if err := s.manifest.Verify(espChip, s.paths.LibraryName, srcPath); err != nil {
+		return Action{}, err
 	}
 
 	dstPath := filepath.Join(s.paths.TargetDir, "lib", s.paths.LibraryName)
-	return copyFile(srcPath, dstPath)
+	return Action{Kind: ActionCopy, Source: srcPath, Path: dstPath}, nil
 }
 
-func (s *Setup) generateConfigHeader(configSrcPath string, config *Config) error {
+func (s *Setup) planConfigHeader(configSrcPath string, config *Config) (Action, error) {
 	txtPath := filepath.Join(configSrcPath, s.paths.ConfigFile)
-	content, err := os.ReadFile(txtPath)
+	content, err := readConfigData(txtPath)
 	if err != nil {
-		return fmt.Errorf("failed to read config file: %w", err)
+		return Action{}, fmt.Errorf("failed to read config file: %w", err)
 	}
 
 	header := s.formatConfigHeader(config, string(content))
-	dstPath := filepath.Join(s.paths.TargetDir, "include", "bsec_config.h")
+	dstPath := filepath.Join(s.paths.TargetDir, "include", configHeaderName)
+
+	return Action{Kind: ActionWrite, Path: dstPath, Content: []byte(header)}, nil
+}
+
+// readConfigData returns the config data at path or, when only a gzipped
+// copy (path + ".gz") is vendored, its decompressed content.
+func readConfigData(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if !errors.Is(err, os.ErrNotExist) {
+		return content, err
+	}
+
+	f, gzErr := os.Open(path + ".gz")
+	if errors.Is(gzErr, os.ErrNotExist) {
+		return nil, err
+	}
+	if gzErr != nil {
+		return nil, gzErr
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s.gz: %w", path, err)
+	}
+	defer zr.Close()
 
-	return os.WriteFile(dstPath, []byte(header), 0644)
+	content, err = io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("%s.gz: %w", path, err)
+	}
+	return content, nil
 }
 
 func (s *Setup) formatConfigHeader(config *Config, rawData string) string {
@@ -183,39 +386,80 @@ static const uint32_t bsec_config_iaq_len = sizeof(bsec_config_iaq);
 	)
 }
 
-func (s *Setup) updateAppConfig(config *Config) error {
+// planAppConfig returns the app_config.hpp rewrite for config. It reports
+// false if there is no app config to update.
+func (s *Setup) planAppConfig(config *Config) (Action, bool) {
+	return s.planAppConfigEdit(func(lines []string) []string {
+		lines = setBoolConstant(lines, "BSEC_DEEP_SLEEP_MODE", config.DeepSleep, "BSEC operation mode")
+		return setBoolConstant(lines, "USE_BSEC", true, "BSEC library enabled")
+	})
+}
+
+// PlanWithoutBSEC returns the changes for a BME68x-only build: app_config.hpp
+// gets USE_BSEC = false and no BSEC files are copied or generated.
+func (s *Setup) PlanWithoutBSEC() []Action {
+	appConfig, ok := s.planAppConfigEdit(func(lines []string) []string {
+		return setBoolConstant(lines, "USE_BSEC", false, "BSEC library enabled")
+	})
+	if !ok {
+		return nil
+	}
+	return s.withBackup(nil, appConfig)
+}
+
+// withBackup appends appConfig to actions, preceded by a backup of the
+// original file if one is still needed.
+func (s *Setup) withBackup(actions []Action, appConfig Action) []Action {
+	if s.backup && !s.backedUp {
+		actions = append(actions, Action{
+			Kind:   ActionBackup,
+			Source: appConfig.Path,
+			Path:   appConfig.Path + backupSuffix,
+		})
+	}
+	return append(actions, appConfig)
+}
+
+// planAppConfigEdit reads app_config.hpp and returns a write of the lines
+// produced by edit. It reports false if there is no app config to update.
+func (s *Setup) planAppConfigEdit(edit func(lines []string) []string) (Action, bool) {
 	if s.paths.AppConfigPath == "" {
-		return nil // No app config to update
+		return Action{}, false // No app config to update
 	}
 
 	content, err := os.ReadFile(s.paths.AppConfigPath)
 	if err != nil {
-		return nil // Non-fatal: file might not exist yet
+		return Action{}, false // Non-fatal: file might not exist yet
 	}
 
-	lines := strings.Split(string(content), "\n")
-	found := false
+	lines := edit(strings.Split(string(content), "\n"))
 
+	return Action{
+		Kind:    ActionWrite,
+		Path:    s.paths.AppConfigPath,
+		Content: []byte(strings.Join(lines, "\n")),
+	}, true
+}
+
+// setBoolConstant sets `inline constexpr bool name = value;` in lines,
+// inserting it with comment before the closing namespace brace if missing.
+func setBoolConstant(lines []string, name string, value bool, comment string) []string {
 	for i, line := range lines {
-		if strings.Contains(line, "BSEC_DEEP_SLEEP_MODE") {
-			lines[i] = fmt.Sprintf("inline constexpr bool BSEC_DEEP_SLEEP_MODE = %t;", config.DeepSleep)
-			found = true
-			break
+		if strings.Contains(line, name) {
+			lines[i] = fmt.Sprintf("inline constexpr bool %s = %t;", name, value)
+			return lines
 		}
 	}
 
-	if !found {
-		// Insert before closing namespace brace
-		for i := len(lines) - 1; i >= 0; i-- {
-			if strings.Contains(lines[i], "} // namespace") {
-				insert := fmt.Sprintf("\n// BSEC operation mode (generated by setup tool)\ninline constexpr bool BSEC_DEEP_SLEEP_MODE = %t;\n", config.DeepSleep)
-				lines[i] = insert + lines[i]
-				break
-			}
+	// Insert before closing namespace brace
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.Contains(lines[i], "} // namespace") {
+			insert := fmt.Sprintf("\n// %s (generated by setup tool)\ninline constexpr bool %s = %t;\n", comment, name, value)
+			lines[i] = insert + lines[i]
+			break
 		}
 	}
-
-	return os.WriteFile(s.paths.AppConfigPath, []byte(strings.Join(lines, "\n")), 0644)
+	return lines
 }
 
 func formatConfigData(data string) string {
@@ -236,10 +480,56 @@ func formatConfigData(data string) string {
 	return strings.Join(lines, ",\n")
 }
 
+// copyFile copies src to dst, preserving its mode and modification time.
+// A partially written dst is removed on error.
 func copyFile(src, dst string) error {
-	content, err := os.ReadFile(src)
+	return copyFileWith(src, dst, nil)
+}
+
+// copyFileWith is copyFile with an optional wrapper around the destination
+// writer, used by tests to simulate failing writes.
+func copyFileWith(src, dst string, wrap func(io.Writer) io.Writer) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(dst)
+		}
+	}()
+
+	var w io.Writer = out
+	if wrap != nil {
+		w = wrap(out)
+	}
+
+	n, err := io.Copy(w, in)
 	if err != nil {
+		return fmt.Errorf("copy %s: %w", src, err)
+	}
+	if n != info.Size() {
+		return fmt.Errorf("copy %s: wrote %d of %d bytes", src, n, info.Size())
+	}
+
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// Chmod as well, since OpenFile's mode is subject to umask and ignored for existing files
+	if err := os.Chmod(dst, info.Mode().Perm()); err != nil {
 		return err
 	}
-	return os.WriteFile(dst, content, 0644)
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
 }
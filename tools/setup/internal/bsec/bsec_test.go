@@ -1,12 +1,17 @@
 package bsec_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"measurement-probe/tools/setup/internal/bsec"
+	"measurement-probe/tools/setup/internal/golden"
 )
 
 // testPaths returns standard paths for testing.
@@ -183,7 +188,7 @@ func TestSetup_Apply_MissingConfig(t *testing.T) {
 		History:     "4d",
 	}
 
-	err := setup.Apply(config)
+	_, err := setup.Apply(config)
 
 	if err == nil {
 		t.Error("Apply() should fail when config source doesn't exist")
@@ -217,7 +222,7 @@ func TestSetup_Apply_MissingHeaders(t *testing.T) {
 		History:     "4d",
 	}
 
-	err := setup.Apply(config)
+	_, err := setup.Apply(config)
 
 	if err == nil {
 		t.Error("Apply() should fail when headers are missing")
@@ -261,7 +266,7 @@ func TestSetup_Apply_MissingLibrary(t *testing.T) {
 		History:     "4d",
 	}
 
-	err := setup.Apply(config)
+	_, err := setup.Apply(config)
 
 	if err == nil {
 		t.Error("Apply() should fail when library is missing")
@@ -304,7 +309,7 @@ inline constexpr bool BSEC_DEEP_SLEEP_MODE = false;
 		DeepSleep:   true, // Change to true
 	}
 
-	if err := setup.Apply(config); err != nil {
+	if _, err := setup.Apply(config); err != nil {
 		t.Fatalf("Apply() failed: %v", err)
 	}
 
@@ -351,7 +356,7 @@ inline constexpr int SOME_OTHER_CONFIG = 42;
 		DeepSleep:   true,
 	}
 
-	if err := setup.Apply(config); err != nil {
+	if _, err := setup.Apply(config); err != nil {
 		t.Fatalf("Apply() failed: %v", err)
 	}
 
@@ -364,6 +369,9 @@ inline constexpr int SOME_OTHER_CONFIG = 42;
 	if !strings.Contains(string(content), "BSEC_DEEP_SLEEP_MODE = true") {
 		t.Errorf("BSEC_DEEP_SLEEP_MODE not inserted: %s", string(content))
 	}
+	if !strings.Contains(string(content), "USE_BSEC = true") {
+		t.Errorf("USE_BSEC not inserted: %s", string(content))
+	}
 }
 
 func TestSetup_Apply_NoAppConfig(t *testing.T) {
@@ -385,7 +393,7 @@ func TestSetup_Apply_NoAppConfig(t *testing.T) {
 	}
 
 	// Should not fail when AppConfigPath is empty
-	if err := setup.Apply(config); err != nil {
+	if _, err := setup.Apply(config); err != nil {
 		t.Errorf("Apply() should not fail when AppConfigPath is empty: %v", err)
 	}
 }
@@ -409,11 +417,57 @@ func TestSetup_Apply_AppConfigNotExists(t *testing.T) {
 	}
 
 	// Should not fail even if app_config.hpp doesn't exist
-	if err := setup.Apply(config); err != nil {
+	if _, err := setup.Apply(config); err != nil {
 		t.Errorf("Apply() should not fail when app_config.hpp doesn't exist: %v", err)
 	}
 }
 
+func TestSetup_Apply_Result(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	paths := testPaths(tmpDir)
+	setupMockBSECStructure(t, paths, "bme680", "33v", "3s", "4d", "esp32c3")
+
+	srcLib := filepath.Join(paths.SourceDir, "src", "esp32c3", paths.LibraryName)
+	if err := os.WriteFile(srcLib, make([]byte, 4321), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	setup := bsec.NewSetup(paths)
+	config := &bsec.Config{
+		ESPChip:     "esp32c3",
+		ChipVariant: "bme680",
+		Voltage:     "33v",
+		Interval:    "3s",
+		History:     "4d",
+	}
+
+	res, err := setup.Apply(config)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	wantLib := filepath.Join(paths.TargetDir, "lib", paths.LibraryName)
+	if res.Library.Path != wantLib || res.Library.Size != 4321 {
+		t.Errorf("Library = %+v, want %s with 4321 bytes", res.Library, wantLib)
+	}
+
+	// Two headers, the library and bsec_config.h; app_config.hpp is absent
+	if len(res.Files) != 4 {
+		t.Fatalf("Files = %+v, want 4 entries", res.Files)
+	}
+	for _, f := range res.Files {
+		info, err := os.Stat(f.Path)
+		if err != nil {
+			t.Fatalf("reported file %s: %v", f.Path, err)
+		}
+		if info.Size() != f.Size {
+			t.Errorf("%s: Size = %d, want %d", f.Path, f.Size, info.Size())
+		}
+	}
+}
+
 func TestSetup_Apply_Integration_AllChips(t *testing.T) {
 	t.Parallel()
 
@@ -437,7 +491,7 @@ func TestSetup_Apply_Integration_AllChips(t *testing.T) {
 				History:     "4d",
 			}
 
-			if err := setup.Apply(config); err != nil {
+			if _, err := setup.Apply(config); err != nil {
 				t.Errorf("Apply() failed for %s: %v", chip, err)
 			}
 
@@ -468,28 +522,11 @@ func TestSetup_Apply_ConfigHeader_ULP(t *testing.T) {
 		DeepSleep:   true,
 	}
 
-	if err := setup.Apply(config); err != nil {
+	if _, err := setup.Apply(config); err != nil {
 		t.Fatalf("Apply() failed: %v", err)
 	}
 
-	// Verify ULP-specific content
-	content, err := os.ReadFile(filepath.Join(paths.TargetDir, "include", "bsec_config.h"))
-	if err != nil {
-		t.Fatalf("failed to read config header: %v", err)
-	}
-
-	checks := []string{
-		"BSEC_SAMPLE_RATE_ULP",
-		"BSEC_CONFIGURED_INTERVAL_MS 300000",
-		"BME688",
-		"18v supply, 300s sample rate, 28d history",
-	}
-
-	for _, check := range checks {
-		if !strings.Contains(string(content), check) {
-			t.Errorf("config header missing %q", check)
-		}
-	}
+	golden.AssertFile(t, "bsec_config_ulp.h.golden", filepath.Join(paths.TargetDir, "include", "bsec_config.h"))
 }
 
 func TestSetup_Apply_ConfigDataFormatting(t *testing.T) {
@@ -511,19 +548,93 @@ func TestSetup_Apply_ConfigDataFormatting(t *testing.T) {
 		History:     "4d",
 	}
 
-	if err := setup.Apply(config); err != nil {
+	if _, err := setup.Apply(config); err != nil {
 		t.Fatalf("Apply() failed: %v", err)
 	}
 
-	content, err := os.ReadFile(filepath.Join(paths.TargetDir, "include", "bsec_config.h"))
-	if err != nil {
-		t.Fatalf("failed to read config header: %v", err)
-	}
+	golden.AssertFile(t, "bsec_config_wrapped.h.golden", filepath.Join(paths.TargetDir, "include", "bsec_config.h"))
+}
+
+func TestSetup_Apply_GzippedConfig(t *testing.T) {
+	t.Parallel()
 
-	// Verify data is formatted with indentation
-	if !strings.Contains(string(content), "    1, 1") {
-		t.Error("config data not properly indented")
+	configData := strings.Repeat("1, ", 50) + "1"
+	config := &bsec.Config{
+		ESPChip:     "esp32c3",
+		ChipVariant: "bme680",
+		Voltage:     "33v",
+		Interval:    "3s",
+		History:     "4d",
 	}
+	configDir := func(paths bsec.Paths) string {
+		return filepath.Join(paths.SourceDir, "src", "config", "bme680", "bme680_iaq_33v_3s_4d")
+	}
+	writeGzip := func(t *testing.T, path, data string) {
+		t.Helper()
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("gzip only", func(t *testing.T) {
+		t.Parallel()
+
+		paths := testPaths(t.TempDir())
+		setupMockBSECStructureWithData(t, paths, "bme680", "33v", "3s", "4d", "esp32c3", configData)
+		plain := filepath.Join(configDir(paths), paths.ConfigFile)
+		if err := os.Remove(plain); err != nil {
+			t.Fatal(err)
+		}
+		writeGzip(t, plain+".gz", configData)
+
+		if _, err := bsec.NewSetup(paths).Apply(config); err != nil {
+			t.Fatalf("Apply() failed: %v", err)
+		}
+
+		// Same output as the plaintext source in TestSetup_Apply_ConfigDataFormatting
+		golden.AssertFile(t, "bsec_config_wrapped.h.golden", filepath.Join(paths.TargetDir, "include", "bsec_config.h"))
+	})
+
+	t.Run("plain file preferred", func(t *testing.T) {
+		t.Parallel()
+
+		paths := testPaths(t.TempDir())
+		setupMockBSECStructureWithData(t, paths, "bme680", "33v", "3s", "4d", "esp32c3", configData)
+		writeGzip(t, filepath.Join(configDir(paths), paths.ConfigFile+".gz"), "9, 9, 9")
+
+		if _, err := bsec.NewSetup(paths).Apply(config); err != nil {
+			t.Fatalf("Apply() failed: %v", err)
+		}
+
+		golden.AssertFile(t, "bsec_config_wrapped.h.golden", filepath.Join(paths.TargetDir, "include", "bsec_config.h"))
+	})
+
+	t.Run("corrupt gzip", func(t *testing.T) {
+		t.Parallel()
+
+		paths := testPaths(t.TempDir())
+		setupMockBSECStructure(t, paths, "bme680", "33v", "3s", "4d", "esp32c3")
+		plain := filepath.Join(configDir(paths), paths.ConfigFile)
+		if err := os.Remove(plain); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(plain+".gz", []byte("not gzip"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := bsec.NewSetup(paths).Apply(config)
+		if err == nil || !strings.Contains(err.Error(), "bsec_iaq.txt.gz") {
+			t.Errorf("Apply() error = %v, want the .gz file named", err)
+		}
+	})
 }
 
 func TestSetup_Apply_MissingConfigTxt(t *testing.T) {
@@ -566,7 +677,7 @@ func TestSetup_Apply_MissingConfigTxt(t *testing.T) {
 		History:     "4d",
 	}
 
-	err := setup.Apply(config)
+	_, err := setup.Apply(config)
 
 	if err == nil {
 		t.Error("Apply() should fail when bsec_iaq.txt is missing")
@@ -608,7 +719,7 @@ inline constexpr bool BSEC_DEEP_SLEEP_MODE = true;
 		DeepSleep:   false, // Change to false
 	}
 
-	if err := setup.Apply(config); err != nil {
+	if _, err := setup.Apply(config); err != nil {
 		t.Fatalf("Apply() failed: %v", err)
 	}
 
@@ -665,7 +776,7 @@ func TestSetup_Apply_CustomHeaders(t *testing.T) {
 		History:     "4d",
 	}
 
-	if err := setup.Apply(config); err != nil {
+	if _, err := setup.Apply(config); err != nil {
 		t.Fatalf("Apply() failed: %v", err)
 	}
 
@@ -694,7 +805,7 @@ func TestSetup_Apply_CustomLibraryName(t *testing.T) {
 		History:     "4d",
 	}
 
-	if err := setup.Apply(config); err != nil {
+	if _, err := setup.Apply(config); err != nil {
 		t.Fatalf("Apply() failed: %v", err)
 	}
 
@@ -748,7 +859,7 @@ func TestSetup_Apply_CustomConfigFile(t *testing.T) {
 		History:     "4d",
 	}
 
-	if err := setup.Apply(config); err != nil {
+	if _, err := setup.Apply(config); err != nil {
 		t.Fatalf("Apply() failed: %v", err)
 	}
 
@@ -793,7 +904,7 @@ func TestSetup_Apply_EmptyHeaders(t *testing.T) {
 	}
 
 	// Should succeed even with no headers
-	if err := setup.Apply(config); err != nil {
+	if _, err := setup.Apply(config); err != nil {
 		t.Fatalf("Apply() failed with empty headers: %v", err)
 	}
 }
@@ -817,7 +928,7 @@ func TestSetup_Apply_NestedTargetDir(t *testing.T) {
 		History:     "4d",
 	}
 
-	if err := setup.Apply(config); err != nil {
+	if _, err := setup.Apply(config); err != nil {
 		t.Fatalf("Apply() failed: %v", err)
 	}
 
@@ -871,7 +982,7 @@ func TestSetup_Apply_MultipleHeaders(t *testing.T) {
 		History:     "4d",
 	}
 
-	if err := setup.Apply(config); err != nil {
+	if _, err := setup.Apply(config); err != nil {
 		t.Fatalf("Apply() failed: %v", err)
 	}
 
@@ -884,7 +995,244 @@ func TestSetup_Apply_MultipleHeaders(t *testing.T) {
 	}
 }
 
+func TestSetup_Plan_WritesNothing(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	paths := testPaths(tmpDir)
+	setupMockBSECStructure(t, paths, "bme680", "33v", "3s", "4d", "esp32c3")
+
+	mainDir := filepath.Dir(paths.AppConfigPath)
+	if err := os.MkdirAll(mainDir, 0755); err != nil {
+		t.Fatalf("failed to create main dir: %v", err)
+	}
+	appConfig := "namespace config {\ninline constexpr bool BSEC_DEEP_SLEEP_MODE = false;\n} // namespace config"
+	if err := os.WriteFile(paths.AppConfigPath, []byte(appConfig), 0644); err != nil {
+		t.Fatalf("failed to create app_config.hpp: %v", err)
+	}
+
+	setup := bsec.NewSetup(paths)
+	config := &bsec.Config{
+		ESPChip:     "esp32c3",
+		ChipVariant: "bme680",
+		Voltage:     "33v",
+		Interval:    "3s",
+		History:     "4d",
+		DeepSleep:   true,
+	}
+
+	actions, err := setup.Plan(config)
+	if err != nil {
+		t.Fatalf("Plan() failed: %v", err)
+	}
+
+	// 2 dirs + 2 headers + library + bsec_config.h + backup + app_config.hpp
+	if len(actions) != 8 {
+		t.Errorf("Plan() returned %d actions, want 8: %v", len(actions), actions)
+	}
+
+	if _, err := os.Stat(paths.TargetDir); !os.IsNotExist(err) {
+		t.Errorf("Plan() created target dir %s", paths.TargetDir)
+	}
+	if _, err := os.Stat(paths.AppConfigPath + ".bak"); !os.IsNotExist(err) {
+		t.Error("Plan() created app_config.hpp.bak")
+	}
+
+	content, err := os.ReadFile(paths.AppConfigPath)
+	if err != nil {
+		t.Fatalf("failed to read app_config.hpp: %v", err)
+	}
+	if string(content) != appConfig {
+		t.Errorf("Plan() modified app_config.hpp: %s", content)
+	}
+
+	last := actions[len(actions)-1]
+	if last.Kind != bsec.ActionWrite || last.Path != paths.AppConfigPath {
+		t.Errorf("last action = %v, want write of app_config.hpp", last)
+	}
+	if !strings.Contains(string(last.Content), "BSEC_DEEP_SLEEP_MODE = true") {
+		t.Errorf("planned app_config.hpp content = %s", last.Content)
+	}
+
+	if err := setup.Execute(actions); err != nil {
+		t.Fatalf("Execute() failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(paths.TargetDir, "include", "bsec_config.h")); err != nil {
+		t.Errorf("Execute() did not write bsec_config.h: %v", err)
+	}
+}
+
+func TestSetup_Apply_BacksUpAppConfig(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	paths := testPaths(tmpDir)
+	setupMockBSECStructure(t, paths, "bme680", "33v", "3s", "4d", "esp32c3")
+
+	if err := os.MkdirAll(filepath.Dir(paths.AppConfigPath), 0755); err != nil {
+		t.Fatalf("failed to create main dir: %v", err)
+	}
+	original := "namespace config {\ninline constexpr bool BSEC_DEEP_SLEEP_MODE = false;\n} // namespace config"
+	if err := os.WriteFile(paths.AppConfigPath, []byte(original), 0644); err != nil {
+		t.Fatalf("failed to create app_config.hpp: %v", err)
+	}
+
+	setup := bsec.NewSetup(paths)
+	config := &bsec.Config{
+		ESPChip:     "esp32c3",
+		ChipVariant: "bme680",
+		Voltage:     "33v",
+		Interval:    "3s",
+		History:     "4d",
+		DeepSleep:   true,
+	}
+
+	if _, err := setup.Apply(config); err != nil {
+		t.Fatalf("first Apply() failed: %v", err)
+	}
+
+	backupPath := paths.AppConfigPath + ".bak"
+	backup, err := os.ReadFile(backupPath)
+	if err != nil {
+		t.Fatalf("backup not created: %v", err)
+	}
+	if string(backup) != original {
+		t.Errorf("backup = %q, want original %q", backup, original)
+	}
+
+	// A second edit in the same run must keep the original backup
+	config.DeepSleep = false
+	if _, err := setup.Apply(config); err != nil {
+		t.Fatalf("second Apply() failed: %v", err)
+	}
+
+	backup, err = os.ReadFile(backupPath)
+	if err != nil {
+		t.Fatalf("failed to read backup: %v", err)
+	}
+	if string(backup) != original {
+		t.Errorf("backup overwritten by second edit: %q", backup)
+	}
+}
+
+func TestSetup_Apply_NoBackup(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	paths := testPaths(tmpDir)
+	setupMockBSECStructure(t, paths, "bme680", "33v", "3s", "4d", "esp32c3")
+
+	if err := os.MkdirAll(filepath.Dir(paths.AppConfigPath), 0755); err != nil {
+		t.Fatalf("failed to create main dir: %v", err)
+	}
+	if err := os.WriteFile(paths.AppConfigPath, []byte("} // namespace config"), 0644); err != nil {
+		t.Fatalf("failed to create app_config.hpp: %v", err)
+	}
+
+	setup := bsec.NewSetup(paths)
+	setup.SetBackup(false)
+	config := &bsec.Config{
+		ESPChip:     "esp32c3",
+		ChipVariant: "bme680",
+		Voltage:     "33v",
+		Interval:    "3s",
+		History:     "4d",
+	}
+
+	if _, err := setup.Apply(config); err != nil {
+		t.Fatalf("Apply() failed: %v", err)
+	}
+
+	if _, err := os.Stat(paths.AppConfigPath + ".bak"); !os.IsNotExist(err) {
+		t.Error("backup created despite SetBackup(false)")
+	}
+}
+
+func TestSetup_PlanWithoutBSEC(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	paths := testPaths(tmpDir)
+
+	if err := os.MkdirAll(filepath.Dir(paths.AppConfigPath), 0755); err != nil {
+		t.Fatalf("failed to create main dir: %v", err)
+	}
+	existingConfig := "namespace config {\ninline constexpr bool USE_BSEC = true;\n} // namespace config"
+	if err := os.WriteFile(paths.AppConfigPath, []byte(existingConfig), 0644); err != nil {
+		t.Fatalf("failed to create app_config.hpp: %v", err)
+	}
+
+	setup := bsec.NewSetup(paths)
+	actions := setup.PlanWithoutBSEC()
+	for _, a := range actions {
+		if a.Path != paths.AppConfigPath && a.Path != paths.AppConfigPath+".bak" {
+			t.Errorf("unexpected action outside app_config.hpp: %v", a)
+		}
+	}
+
+	if err := setup.Execute(actions); err != nil {
+		t.Fatalf("Execute() failed: %v", err)
+	}
+
+	content, err := os.ReadFile(paths.AppConfigPath)
+	if err != nil {
+		t.Fatalf("failed to read app_config.hpp: %v", err)
+	}
+	if !strings.Contains(string(content), "USE_BSEC = false") {
+		t.Errorf("USE_BSEC not disabled: %s", content)
+	}
+	if _, err := os.Stat(paths.TargetDir); !os.IsNotExist(err) {
+		t.Errorf("BSEC target dir created: %s", paths.TargetDir)
+	}
+}
+
 // Helper function to create mock BSEC structure
+func TestSetup_CheckChip_Supported(t *testing.T) {
+	t.Parallel()
+
+	paths := testPaths(t.TempDir())
+	setupMockBSECStructure(t, paths, "bme680", "33v", "3s", "4d", "esp32c3")
+
+	setup := bsec.NewSetup(paths)
+	if err := setup.CheckChip("esp32c3"); err != nil {
+		t.Errorf("CheckChip(esp32c3) error = %v", err)
+	}
+}
+
+func TestSetup_CheckChip_Unsupported(t *testing.T) {
+	t.Parallel()
+
+	paths := testPaths(t.TempDir())
+	setupMockBSECStructure(t, paths, "bme680", "33v", "3s", "4d", "esp32c3")
+	setupMockBSECStructure(t, paths, "bme680", "33v", "3s", "4d", "esp32")
+
+	setup := bsec.NewSetup(paths)
+	if got := setup.AvailableChips(); strings.Join(got, ",") != "esp32,esp32c3" {
+		t.Errorf("AvailableChips() = %v, want [esp32 esp32c3]", got)
+	}
+
+	config := &bsec.Config{
+		ESPChip:     "esp32s2",
+		ChipVariant: "bme680",
+		Voltage:     "33v",
+		Interval:    "3s",
+		History:     "4d",
+	}
+	_, applyErr := setup.Apply(config)
+	for name, err := range map[string]error{
+		"CheckChip": setup.CheckChip("esp32s2"),
+		"Apply":     applyErr,
+	} {
+		if err == nil {
+			t.Fatalf("%s() should fail for a chip without a library", name)
+		}
+		if !strings.Contains(err.Error(), "BSEC library not found for esp32s2") ||
+			!strings.Contains(err.Error(), "(available: esp32, esp32c3)") {
+			t.Errorf("%s() error = %q, want it to name the chip and list the available ones", name, err)
+		}
+	}
+}
+
 func setupMockBSECStructure(t *testing.T, paths bsec.Paths, chip, voltage, interval, history, espChip string) {
 	t.Helper()
 	setupMockBSECStructureWithData(t, paths, chip, voltage, interval, history, espChip, "1, 2, 3, 4, 5")
@@ -931,3 +1279,61 @@ func setupMockBSECStructureWithLib(t *testing.T, paths bsec.Paths, chip, voltage
 		t.Fatalf("failed to create library: %v", err)
 	}
 }
+
+// shortWriter accepts only half of each write without reporting an error.
+type shortWriter struct {
+	w io.Writer
+}
+
+func (s shortWriter) Write(p []byte) (int, error) {
+	return s.w.Write(p[:len(p)/2])
+}
+
+func TestCopyFile_ShortWrite(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "libalgobsec.a")
+	dst := filepath.Join(tmpDir, "copy.a")
+	if err := os.WriteFile(src, []byte(strings.Repeat("x", 4096)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := bsec.CopyFileWith(src, dst, func(w io.Writer) io.Writer { return shortWriter{w} })
+	if err == nil {
+		t.Fatal("CopyFileWith() should fail on a short write")
+	}
+	if _, statErr := os.Stat(dst); !os.IsNotExist(statErr) {
+		t.Errorf("partial destination %s was not removed", dst)
+	}
+}
+
+func TestCopyFile_PreservesModeAndTime(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "libalgobsec.a")
+	dst := filepath.Join(tmpDir, "copy.a")
+	if err := os.WriteFile(src, []byte("mock lib"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(src, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bsec.CopyFileWith(src, dst, nil); err != nil {
+		t.Fatalf("CopyFileWith() error = %v", err)
+	}
+
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("mode = %v, want 0640", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("mod time = %v, want %v", info.ModTime(), modTime)
+	}
+}
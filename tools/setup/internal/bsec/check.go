@@ -0,0 +1,123 @@
+package bsec
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// configHeaderName is the generated header holding the config data.
+const configHeaderName = "bsec_config.h"
+
+// ReadApplied parses the @target and @config tags of a bsec_config.h written
+// by Apply and returns the configuration it was generated for. DeepSleep is
+// not recorded in the header and is left false.
+func ReadApplied(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var target, name string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "*"))
+		if v, ok := strings.CutPrefix(line, "@target "); ok {
+			target = strings.TrimSpace(v)
+		} else if v, ok := strings.CutPrefix(line, "@config "); ok {
+			name = strings.TrimSpace(v)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if target == "" || name == "" {
+		return nil, fmt.Errorf("%s: no @target/@config tags (not generated by setup?)", path)
+	}
+
+	// Name is <sensor>_iaq_<voltage>_<interval>_<history>
+	parts := strings.Split(name, "_")
+	if len(parts) != 5 || parts[1] != "iaq" {
+		return nil, fmt.Errorf("%s: unrecognized @config %q", path, name)
+	}
+	return &Config{
+		ESPChip:     target,
+		ChipVariant: parts[0],
+		Voltage:     parts[2],
+		Interval:    parts[3],
+		History:     parts[4],
+	}, nil
+}
+
+// Check verifies, without changing anything, that the target directory holds
+// what Apply would install for config: the headers, the chip's library and a
+// bsec_config.h generated for the same chip, sensor, voltage, interval and
+// history. It returns one line per difference; none means up to date.
+func (s *Setup) Check(config *Config) ([]string, error) {
+	var problems []string
+	includeDir := filepath.Join(s.paths.TargetDir, "include")
+
+	for _, h := range s.paths.Headers {
+		if _, err := os.Stat(filepath.Join(includeDir, h)); err != nil {
+			problems = append(problems, fmt.Sprintf("missing header include/%s", h))
+		}
+	}
+
+	libProblem, err := s.checkLibrary(config.ESPChip)
+	if err != nil {
+		return nil, err
+	}
+	if libProblem != "" {
+		problems = append(problems, libProblem)
+	}
+
+	applied, err := ReadApplied(filepath.Join(includeDir, configHeaderName))
+	if errors.Is(err, os.ErrNotExist) {
+		return append(problems, "missing include/"+configHeaderName), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields := []struct{ name, got, want string }{
+		{"chip", applied.ESPChip, config.ESPChip},
+		{"sensor", applied.ChipVariant, config.ChipVariant},
+		{"voltage", applied.Voltage, config.Voltage},
+		{"interval", applied.Interval, config.Interval},
+		{"history", applied.History, config.History},
+	}
+	for _, f := range fields {
+		if f.got != f.want {
+			problems = append(problems, fmt.Sprintf("%s %s: applied %s, want %s", configHeaderName, f.name, f.got, f.want))
+		}
+	}
+	return problems, nil
+}
+
+// checkLibrary compares the installed library with the chip's library in the
+// BSEC source, when the source is available.
+func (s *Setup) checkLibrary(espChip string) (string, error) {
+	libPath := filepath.Join(s.paths.TargetDir, "lib", s.paths.LibraryName)
+	installed, err := fileSHA256(libPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return "missing library lib/" + s.paths.LibraryName, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	source, err := fileSHA256(s.libraryPath(espChip))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil // submodule not checked out; only presence can be checked
+	}
+	if err != nil {
+		return "", err
+	}
+	if installed != source {
+		return fmt.Sprintf("library lib/%s differs from the %s library", s.paths.LibraryName, espChip), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,127 @@
+package bsec_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"measurement-probe/tools/setup/internal/bsec"
+)
+
+func checkConfig(interval string) *bsec.Config {
+	return &bsec.Config{
+		ESPChip:     "esp32c3",
+		ChipVariant: "bme688",
+		Voltage:     "18v",
+		Interval:    interval,
+		History:     "28d",
+	}
+}
+
+func TestReadApplied(t *testing.T) {
+	t.Parallel()
+
+	config, err := bsec.ReadApplied(filepath.Join("testdata", "bsec_config_ulp.h.golden"))
+	if err != nil {
+		t.Fatalf("ReadApplied() error = %v", err)
+	}
+	want := checkConfig("300s")
+	if *config != *want {
+		t.Errorf("ReadApplied() = %+v, want %+v", *config, *want)
+	}
+
+	path := filepath.Join(t.TempDir(), "bsec_config.h")
+	if err := os.WriteFile(path, []byte("#pragma once\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bsec.ReadApplied(path); err == nil || !strings.Contains(err.Error(), "no @target/@config") {
+		t.Errorf("ReadApplied(hand-written header) error = %v", err)
+	}
+}
+
+func TestSetup_Check_UpToDate(t *testing.T) {
+	t.Parallel()
+
+	paths := testPaths(t.TempDir())
+	setupMockBSECStructure(t, paths, "bme688", "18v", "300s", "28d", "esp32c3")
+
+	setup := bsec.NewSetup(paths)
+	if _, err := setup.Apply(checkConfig("300s")); err != nil {
+		t.Fatalf("Apply() failed: %v", err)
+	}
+
+	problems, err := setup.Check(checkConfig("300s"))
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Check() = %q, want no problems", problems)
+	}
+}
+
+func TestSetup_Check_Stale(t *testing.T) {
+	t.Parallel()
+
+	paths := testPaths(t.TempDir())
+	setupMockBSECStructure(t, paths, "bme688", "18v", "3s", "28d", "esp32c3")
+	setupMockBSECStructure(t, paths, "bme688", "18v", "300s", "28d", "esp32c3")
+
+	setup := bsec.NewSetup(paths)
+	if _, err := setup.Apply(checkConfig("3s")); err != nil {
+		t.Fatalf("Apply() failed: %v", err)
+	}
+
+	problems, err := setup.Check(checkConfig("300s"))
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	want := []string{"bsec_config.h interval: applied 3s, want 300s"}
+	if strings.Join(problems, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Check() = %q, want %q", problems, want)
+	}
+}
+
+func TestSetup_Check_Missing(t *testing.T) {
+	t.Parallel()
+
+	paths := testPaths(t.TempDir())
+	setupMockBSECStructure(t, paths, "bme688", "18v", "300s", "28d", "esp32c3")
+
+	problems, err := bsec.NewSetup(paths).Check(checkConfig("300s"))
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	want := []string{
+		"missing header include/bsec_datatypes.h",
+		"missing header include/bsec_interface.h",
+		"missing library lib/libalgobsec.a",
+		"missing include/bsec_config.h",
+	}
+	if strings.Join(problems, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Check() = %q, want %q", problems, want)
+	}
+}
+
+func TestSetup_Check_WrongLibrary(t *testing.T) {
+	t.Parallel()
+
+	paths := testPaths(t.TempDir())
+	setupMockBSECStructure(t, paths, "bme688", "18v", "300s", "28d", "esp32c3")
+
+	setup := bsec.NewSetup(paths)
+	if _, err := setup.Apply(checkConfig("300s")); err != nil {
+		t.Fatalf("Apply() failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(paths.TargetDir, "lib", paths.LibraryName), []byte("esp32s3 lib"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := setup.Check(checkConfig("300s"))
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(problems) != 1 || !strings.Contains(problems[0], "differs from the esp32c3 library") {
+		t.Errorf("Check() = %q, want a library mismatch", problems)
+	}
+}
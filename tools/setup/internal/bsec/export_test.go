@@ -0,0 +1,4 @@
+package bsec
+
+// CopyFileWith exposes copyFileWith for tests.
+var CopyFileWith = copyFileWith
@@ -0,0 +1,91 @@
+package bsec
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Manifest maps "<chip>/<library>" (e.g. "esp32c3/libalgobsec.a") to the
+// expected lowercase hex SHA-256 of that library.
+type Manifest map[string]string
+
+// LoadManifest reads a manifest in sha256sum format, with paths relative to
+// the BSEC src directory:
+//
+//	3b4f...e1  esp32c3/libalgobsec.a
+//
+// Blank lines and lines starting with # are ignored.
+func LoadManifest(filename string) (Manifest, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	defer file.Close()
+
+	manifest := Manifest{}
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<sha256>  <chip>/<library>\"", filename, lineNum)
+		}
+
+		sum := strings.ToLower(fields[0])
+		if decoded, err := hex.DecodeString(sum); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("%s:%d: invalid SHA-256 %q", filename, lineNum, fields[0])
+		}
+
+		// sha256sum marks binary mode with a leading '*'
+		manifest[strings.TrimPrefix(fields[1], "*")] = sum
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// Verify checks the SHA-256 of the library at filename against the manifest
+// entry for chip and library. It passes if the manifest is nil or has no
+// entry for the library.
+func (m Manifest) Verify(chip, library, filename string) error {
+	want, ok := m[path.Join(chip, library)]
+	if !ok {
+		return nil
+	}
+
+	got, err := fileSHA256(filename)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", filename, err)
+	}
+
+	if got != want {
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s (corrupt submodule checkout?)", filename, got, want)
+	}
+	return nil
+}
+
+func fileSHA256(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
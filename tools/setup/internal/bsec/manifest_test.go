@@ -0,0 +1,112 @@
+package bsec_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"measurement-probe/tools/setup/internal/bsec"
+)
+
+// mockLibSHA256 is the SHA-256 of the "mock lib" fixture written by setupMockBSECStructure.
+func mockLibSHA256() string {
+	sum := sha256.Sum256([]byte("mock lib"))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestLoadManifest(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "bsec.sha256")
+	content := "# Known-good BSEC libraries\n\n" +
+		mockLibSHA256() + "  esp32c3/libalgobsec.a\n" +
+		strings.ToUpper(mockLibSHA256()) + " *esp32/libalgobsec.a\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := bsec.LoadManifest(path)
+	if err != nil {
+		t.Fatalf("LoadManifest() error = %v", err)
+	}
+
+	for _, key := range []string{"esp32c3/libalgobsec.a", "esp32/libalgobsec.a"} {
+		if manifest[key] != mockLibSHA256() {
+			t.Errorf("manifest[%q] = %q, want %q", key, manifest[key], mockLibSHA256())
+		}
+	}
+}
+
+func TestLoadManifest_Invalid(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "bsec.sha256")
+	if err := os.WriteFile(path, []byte("not-a-hash  esp32c3/libalgobsec.a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bsec.LoadManifest(path); err == nil || !strings.Contains(err.Error(), "invalid SHA-256") {
+		t.Errorf("LoadManifest() error = %v, want invalid SHA-256", err)
+	}
+}
+
+func TestSetup_Apply_Manifest(t *testing.T) {
+	t.Parallel()
+
+	config := &bsec.Config{
+		ESPChip:     "esp32c3",
+		ChipVariant: "bme680",
+		Voltage:     "33v",
+		Interval:    "3s",
+		History:     "4d",
+	}
+
+	tests := []struct {
+		name     string
+		manifest bsec.Manifest
+		wantErr  string
+	}{
+		{
+			name:     "matching hash",
+			manifest: bsec.Manifest{"esp32c3/libalgobsec.a": mockLibSHA256()},
+		},
+		{
+			name:     "mismatching hash",
+			manifest: bsec.Manifest{"esp32c3/libalgobsec.a": strings.Repeat("0", 64)},
+			wantErr:  "checksum mismatch",
+		},
+		{
+			name:     "no entry for library",
+			manifest: bsec.Manifest{"esp32s3/libalgobsec.a": strings.Repeat("0", 64)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			paths := testPaths(t.TempDir())
+			setupMockBSECStructure(t, paths, "bme680", "33v", "3s", "4d", "esp32c3")
+
+			setup := bsec.NewSetup(paths)
+			setup.SetManifest(tt.manifest)
+			_, err := setup.Apply(config)
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Apply() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Apply() error = %v, want %q", err, tt.wantErr)
+			}
+			if _, statErr := os.Stat(filepath.Join(paths.TargetDir, "lib", paths.LibraryName)); !os.IsNotExist(statErr) {
+				t.Error("library copied despite checksum mismatch")
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// shortRevisionLen is the length of abbreviated commit hashes in reports.
+const shortRevisionLen = 12
+
+// Revision returns the abbreviated commit checked out in the repository at
+// path, reading .git directly so it works without a git binary. Submodules,
+// whose .git is a "gitdir:" pointer file, are supported.
+func Revision(path string) (string, error) {
+	gitDir, err := resolveGitDir(path)
+	if err != nil {
+		return "", err
+	}
+
+	head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return "", fmt.Errorf("read HEAD: %w", err)
+	}
+
+	sha := strings.TrimSpace(string(head))
+	if ref, ok := strings.CutPrefix(sha, "ref: "); ok {
+		sha, err = resolveRef(gitDir, ref)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if len(sha) > shortRevisionLen {
+		sha = sha[:shortRevisionLen]
+	}
+	return sha, nil
+}
+
+func resolveGitDir(path string) (string, error) {
+	gitDir := filepath.Join(path, ".git")
+	info, err := os.Stat(gitDir)
+	if err != nil {
+		return "", fmt.Errorf("%s is not a git checkout: %w", path, err)
+	}
+	if info.IsDir() {
+		return gitDir, nil
+	}
+
+	content, err := os.ReadFile(gitDir)
+	if err != nil {
+		return "", err
+	}
+	dir, ok := strings.CutPrefix(strings.TrimSpace(string(content)), "gitdir:")
+	if !ok {
+		return "", fmt.Errorf("unexpected .git file in %s", path)
+	}
+
+	dir = strings.TrimSpace(dir)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(path, dir)
+	}
+	return dir, nil
+}
+
+func resolveRef(gitDir, ref string) (string, error) {
+	if content, err := os.ReadFile(filepath.Join(gitDir, filepath.FromSlash(ref))); err == nil {
+		return strings.TrimSpace(string(content)), nil
+	}
+
+	file, err := os.Open(filepath.Join(gitDir, "packed-refs"))
+	if err != nil {
+		return "", fmt.Errorf("resolve %s: %w", ref, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == ref {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("resolve %s: ref not found", ref)
+}
@@ -0,0 +1,90 @@
+package git_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"measurement-probe/tools/setup/internal/git"
+)
+
+const testSHA = "0123456789abcdef0123456789abcdef01234567"
+
+// writeFile creates path with content, including parent directories.
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRevision_Submodule(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	subPath := filepath.Join(root, "components", "external", "bme68x")
+	writeFile(t, filepath.Join(subPath, ".git"), "gitdir: ../../../.git/modules/bme68x\n")
+	writeFile(t, filepath.Join(root, ".git", "modules", "bme68x", "HEAD"), testSHA+"\n")
+
+	got, err := git.Revision(subPath)
+	if err != nil {
+		t.Fatalf("Revision() error = %v", err)
+	}
+	if got != testSHA[:12] {
+		t.Errorf("Revision() = %q, want %q", got, testSHA[:12])
+	}
+}
+
+func TestRevision_Refs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		files map[string]string
+	}{
+		{
+			name: "loose ref",
+			files: map[string]string{
+				"HEAD":            "ref: refs/heads/main\n",
+				"refs/heads/main": testSHA + "\n",
+			},
+		},
+		{
+			name: "packed ref",
+			files: map[string]string{
+				"HEAD":        "ref: refs/heads/main\n",
+				"packed-refs": "# pack-refs with: peeled fully-peeled sorted\n" + testSHA + " refs/heads/main\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			repo := t.TempDir()
+			for name, content := range tt.files {
+				writeFile(t, filepath.Join(repo, ".git", name), content)
+			}
+
+			got, err := git.Revision(repo)
+			if err != nil {
+				t.Fatalf("Revision() error = %v", err)
+			}
+			if got != testSHA[:12] {
+				t.Errorf("Revision() = %q, want %q", got, testSHA[:12])
+			}
+		})
+	}
+}
+
+func TestRevision_NotCheckout(t *testing.T) {
+	t.Parallel()
+
+	if _, err := git.Revision(t.TempDir()); err == nil {
+		t.Error("Revision() expected error for directory without .git")
+	}
+}
@@ -37,6 +37,7 @@ type SubmoduleManager struct {
 	rootPath   string
 	submodules []Submodule
 	runner     CommandRunner
+	skipInit   bool
 }
 
 // NewSubmoduleManager creates a manager for the given project root and submodules.
@@ -57,8 +58,20 @@ func NewSubmoduleManagerWithRunner(rootPath string, submodules []Submodule, runn
 	}
 }
 
-// Setup initializes and verifies git submodules.
+// SetSkipInit makes Setup only verify the submodules, without running git.
+// Use it offline, where vendored submodules are already on disk but
+// git submodule update would fail.
+func (m *SubmoduleManager) SetSkipInit(skip bool) {
+	m.skipInit = skip
+}
+
+// Setup initializes and verifies git submodules. With SetSkipInit it only
+// verifies them.
 func (m *SubmoduleManager) Setup() error {
+	if m.skipInit {
+		return m.VerifySubmodules()
+	}
+
 	if err := m.CheckGitmodules(); err != nil {
 		return err
 	}
@@ -419,6 +419,54 @@ func TestSubmoduleManager_Setup_FullFlow(t *testing.T) {
 	}
 }
 
+func TestSubmoduleManager_Setup_SkipInit(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	subs := testSubmodules(tmpDir)
+
+	// Vendored tree: markers present, no .gitmodules
+	for _, sub := range subs {
+		marker := filepath.Join(sub.Path, sub.Marker)
+		if err := os.MkdirAll(filepath.Dir(marker), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(marker, []byte(""), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runner := &mockRunner{runFunc: func(dir, name string, args ...string) error {
+		return errors.New("network unreachable")
+	}}
+	mgr := git.NewSubmoduleManagerWithRunner(tmpDir, subs, runner)
+	mgr.SetSkipInit(true)
+
+	if err := mgr.Setup(); err != nil {
+		t.Errorf("Setup should succeed with markers present: %v", err)
+	}
+	if len(runner.calls) != 0 {
+		t.Errorf("expected no git calls, got %v", runner.calls)
+	}
+}
+
+func TestSubmoduleManager_Setup_SkipInitMissingMarker(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	runner := &mockRunner{}
+	mgr := git.NewSubmoduleManagerWithRunner(tmpDir, testSubmodules(tmpDir), runner)
+	mgr.SetSkipInit(true)
+
+	var subErr *git.SubmoduleError
+	if err := mgr.Setup(); !errors.As(err, &subErr) {
+		t.Errorf("Setup() error = %v, want SubmoduleError", err)
+	}
+	if len(runner.calls) != 0 {
+		t.Errorf("expected no git calls, got %v", runner.calls)
+	}
+}
+
 func TestSubmoduleManager_Setup_FailsOnGitError(t *testing.T) {
 	t.Parallel()
 
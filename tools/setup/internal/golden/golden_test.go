@@ -0,0 +1,27 @@
+package golden_test
+
+import (
+	"testing"
+
+	"measurement-probe/tools/setup/internal/golden"
+)
+
+func TestNormalize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"// Generated: 2024-01-02T03:04:05Z\n", "// Generated: <timestamp>\n"},
+		{"// Generated: 2024-01-02T03:04:05.123+02:00\n", "// Generated: <timestamp>\n"},
+		{"#pragma once\n", "#pragma once\n"},
+		{" * Generated by: go run tools/setup\n", " * Generated by: go run tools/setup\n"},
+	}
+
+	for _, tt := range tests {
+		if got := string(golden.Normalize([]byte(tt.in))); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
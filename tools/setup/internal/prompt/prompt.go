@@ -29,31 +29,43 @@ type Choice struct {
 	Display string
 }
 
-// Select displays options and returns the selected ID.
+// maxSelectAttempts bounds how many invalid answers Select accepts before
+// falling back to the default.
+const maxSelectAttempts = 3
+
+// Select displays options and returns the selected ID. Empty input takes the
+// default; invalid or out-of-range input redraws the menu with an error, up
+// to maxSelectAttempts times, before falling back to the default.
 func (p *Prompter) Select(prompt string, choices []Choice, defaultIdx int) string {
-	for i, c := range choices {
-		mark := ""
-		if i == defaultIdx {
-			mark = " (default)"
+	for attempt := 1; ; attempt++ {
+		for i, c := range choices {
+			mark := ""
+			if i == defaultIdx {
+				mark = " (default)"
+			}
+			fmt.Fprintf(p.writer, "    %d. %s%s\n", i+1, c.Display, mark)
 		}
-		fmt.Fprintf(p.writer, "    %d. %s%s\n", i+1, c.Display, mark)
-	}
 
-	fmt.Fprintf(p.writer, "%s [1-%d, default=%d]: ", prompt, len(choices), defaultIdx+1)
+		fmt.Fprintf(p.writer, "%s [1-%d, default=%d]: ", prompt, len(choices), defaultIdx+1)
 
-	input, _ := p.reader.ReadString('\n')
-	input = strings.TrimSpace(input)
+		input, _ := p.reader.ReadString('\n')
+		input = strings.TrimSpace(input)
 
-	if input == "" {
-		return choices[defaultIdx].ID
-	}
+		if input == "" {
+			return choices[defaultIdx].ID
+		}
 
-	idx, err := strconv.Atoi(input)
-	if err != nil || idx < 1 || idx > len(choices) {
-		return choices[defaultIdx].ID
-	}
+		idx, err := strconv.Atoi(input)
+		if err == nil && idx >= 1 && idx <= len(choices) {
+			return choices[idx-1].ID
+		}
 
-	return choices[idx-1].ID
+		if attempt == maxSelectAttempts {
+			fmt.Fprintf(p.writer, "    Invalid choice %q, using default %d\n", input, defaultIdx+1)
+			return choices[defaultIdx].ID
+		}
+		fmt.Fprintf(p.writer, "    Invalid choice %q, enter a number from 1 to %d\n", input, len(choices))
+	}
 }
 
 // Section prints a section header.
@@ -48,29 +48,53 @@ func TestPrompter_Select(t *testing.T) {
 			wantID:     "third",
 		},
 		{
-			name:       "invalid input returns default",
+			name:       "invalid input then EOF returns default",
 			input:      "invalid\n",
 			defaultIdx: 1,
 			wantID:     "second",
 		},
 		{
-			name:       "out of range high returns default",
+			name:       "out of range high then EOF returns default",
 			input:      "99\n",
 			defaultIdx: 2,
 			wantID:     "third",
 		},
 		{
-			name:       "out of range zero returns default",
+			name:       "out of range zero then EOF returns default",
 			input:      "0\n",
 			defaultIdx: 0,
 			wantID:     "first",
 		},
 		{
-			name:       "negative number returns default",
+			name:       "negative number then EOF returns default",
 			input:      "-1\n",
 			defaultIdx: 1,
 			wantID:     "second",
 		},
+		{
+			name:       "invalid then valid",
+			input:      "abc\n2\n",
+			defaultIdx: 0,
+			wantID:     "second",
+		},
+		{
+			name:       "out of range then valid",
+			input:      "99\n0\n3\n",
+			defaultIdx: 0,
+			wantID:     "third",
+		},
+		{
+			name:       "invalid then empty returns default",
+			input:      "abc\n\n",
+			defaultIdx: 2,
+			wantID:     "third",
+		},
+		{
+			name:       "retries exhausted returns default",
+			input:      "a\nb\nc\n1\n",
+			defaultIdx: 1,
+			wantID:     "second",
+		},
 	}
 
 	for _, tt := range tests {
@@ -118,6 +142,30 @@ func TestPrompter_Select_OutputFormat(t *testing.T) {
 	}
 }
 
+func TestPrompter_Select_Reprompt(t *testing.T) {
+	t.Parallel()
+
+	choices := []prompt.Choice{
+		{ID: "opt1", Display: "Option One"},
+		{ID: "opt2", Display: "Option Two"},
+	}
+
+	output := &bytes.Buffer{}
+	p := prompt.New(strings.NewReader("abc\n2\n"), output)
+
+	if got := p.Select("Choose", choices, 0); got != "opt2" {
+		t.Errorf("Select() = %q, want %q", got, "opt2")
+	}
+
+	got := output.String()
+	if !strings.Contains(got, `Invalid choice "abc", enter a number from 1 to 2`) {
+		t.Errorf("output missing error line: %s", got)
+	}
+	if n := strings.Count(got, "1. Option One (default)"); n != 2 {
+		t.Errorf("menu drawn %d times, want 2: %s", n, got)
+	}
+}
+
 func TestPrompter_Section(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,6 @@
+package provisioning
+
+// Save exposes save for tests that need a header with a known PoP.
+func (s *Setup) Save(path string, config *Config) error {
+	return s.save(path, config)
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -24,6 +25,7 @@ type Defaults struct {
 	PopBytes     int    // Number of random bytes for PoP (hex encoded = 2x chars)
 	OutputFile   string // Output filename (relative to generated dir)
 	GeneratedDir string // Directory for generated files
+	Force        bool   // Ignore any existing config and generate a new PoP
 }
 
 // Setup handles provisioning configuration.
@@ -39,11 +41,30 @@ func NewSetup(defaults Defaults) *Setup {
 // Generate creates or retrieves the provisioning configuration.
 // Returns the config and whether it was newly generated.
 func (s *Setup) Generate() (*Config, bool, error) {
-	configPath := s.configPath()
+	config, isNew, err := s.Plan()
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Save to file
+	if isNew {
+		if err := s.save(s.configPath(), config); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return config, isNew, nil
+}
 
+// Plan returns the configuration Generate would use and whether it would be
+// newly generated, without writing anything. A new PoP returned by Plan is
+// never saved.
+func (s *Setup) Plan() (*Config, bool, error) {
 	// Check for existing config
-	if config, err := s.loadExisting(configPath); err == nil {
-		return config, false, nil
+	if !s.defaults.Force {
+		if config, err := s.loadExisting(s.configPath()); err == nil {
+			return config, false, nil
+		}
 	}
 
 	// Generate new config
@@ -52,14 +73,14 @@ func (s *Setup) Generate() (*Config, bool, error) {
 		return nil, false, err
 	}
 
-	// Save to file
-	if err := s.save(configPath, config); err != nil {
-		return nil, false, err
-	}
-
 	return config, true, nil
 }
 
+// Path returns the location of the generated provisioning header.
+func (s *Setup) Path() string {
+	return s.configPath()
+}
+
 func (s *Setup) configPath() string {
 	return filepath.Join(s.defaults.GeneratedDir, s.defaults.OutputFile)
 }
@@ -70,22 +91,101 @@ func (s *Setup) loadExisting(path string) (*Config, error) {
 		return nil, err
 	}
 
-	// Extract PoP from existing file
+	// Start from defaults so values missing from the file still get filled in
+	config := &Config{
+		DeviceName: s.defaults.DeviceName,
+		TimeoutSec: s.defaults.TimeoutSec,
+	}
+
 	for _, line := range strings.Split(string(content), "\n") {
-		if strings.Contains(line, "PROVISIONING_POP") && strings.Contains(line, "\"") {
-			start := strings.Index(line, "\"") + 1
-			end := strings.LastIndex(line, "\"")
-			if start > 0 && end > start {
-				return &Config{
-					PoP:        line[start:end],
-					DeviceName: s.defaults.DeviceName,
-					TimeoutSec: s.defaults.TimeoutSec,
-				}, nil
+		name, value, ok := parseDefine(line)
+		if !ok {
+			continue
+		}
+
+		switch name {
+		case "PROVISIONING_POP":
+			if pop, ok := quotedValue(value); ok {
+				config.PoP = pop
+			}
+		case "PROVISIONING_DEVICE_NAME":
+			if deviceName, ok := quotedValue(value); ok {
+				config.DeviceName = deviceName
+			}
+		case "PROVISIONING_TIMEOUT_SEC":
+			if timeout, err := strconv.Atoi(value); err == nil && timeout >= 0 {
+				config.TimeoutSec = timeout
 			}
 		}
 	}
 
-	return nil, fmt.Errorf("could not parse existing config")
+	if config.PoP == "" {
+		return nil, fmt.Errorf("could not parse existing config")
+	}
+
+	return config, nil
+}
+
+// parseDefine splits a "#define NAME value" line into its name and value.
+func parseDefine(line string) (name, value string, ok bool) {
+	rest, found := strings.CutPrefix(strings.TrimSpace(line), "#define")
+	if !found {
+		return "", "", false
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) < 2 {
+		return "", "", false
+	}
+
+	name = fields[0]
+	value = strings.TrimSpace(strings.TrimSpace(rest)[len(name):])
+	return name, value, true
+}
+
+// quotedValue extracts the non-empty contents of a double-quoted C string
+// value, undoing the escapes written by cString.
+func quotedValue(value string) (string, bool) {
+	start := strings.Index(value, "\"")
+	if start < 0 {
+		return "", false
+	}
+
+	var b strings.Builder
+	for i := start + 1; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '"':
+			return b.String(), b.Len() > 0
+		case '\\':
+			if i+1 == len(value) {
+				return "", false
+			}
+			i++
+			b.WriteByte(value[i])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", false
+}
+
+// cString renders s as a double-quoted C string literal, escaping quotes and
+// backslashes. Control characters are rejected rather than escaped, since
+// they have no place in a provisioning secret or device name.
+func cString(s string) (string, error) {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r < 0x20 || r == 0x7f:
+			return "", fmt.Errorf("%q contains control character %U", s, r)
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String(), nil
 }
 
 func (s *Setup) generateNew() (*Config, error) {
@@ -102,6 +202,15 @@ func (s *Setup) generateNew() (*Config, error) {
 }
 
 func (s *Setup) save(path string, config *Config) error {
+	pop, err := cString(config.PoP)
+	if err != nil {
+		return fmt.Errorf("invalid PoP: %w", err)
+	}
+	deviceName, err := cString(config.DeviceName)
+	if err != nil {
+		return fmt.Errorf("invalid device name: %w", err)
+	}
+
 	// Ensure directory exists
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
@@ -121,14 +230,14 @@ func (s *Setup) save(path string, config *Config) error {
 
 // Proof of Possession for BLE WiFi provisioning
 // Use this secret in the ESP BLE Provisioning app
-#define PROVISIONING_POP "%s"
+#define PROVISIONING_POP %s
 
 // Device name prefix (MAC suffix will be appended)
-#define PROVISIONING_DEVICE_NAME "%s"
+#define PROVISIONING_DEVICE_NAME %s
 
 // Provisioning timeout in seconds (0 = no timeout)
 #define PROVISIONING_TIMEOUT_SEC %d
-`, config.PoP, config.DeviceName, config.TimeoutSec)
+`, pop, deviceName, config.TimeoutSec)
 
 	return os.WriteFile(path, []byte(header), 0644)
 }
@@ -6,6 +6,7 @@ import (
 	"strings"
 	"testing"
 
+	"measurement-probe/tools/setup/internal/golden"
 	"measurement-probe/tools/setup/internal/provisioning"
 )
 
@@ -62,6 +63,70 @@ func TestSetup_Generate_NewSecret(t *testing.T) {
 	}
 }
 
+func TestSetup_Save_Golden(t *testing.T) {
+	t.Parallel()
+
+	defaults := testDefaults(t.TempDir())
+	setup := provisioning.NewSetup(defaults)
+	config := &provisioning.Config{PoP: "deadbeef", DeviceName: "TestDevice", TimeoutSec: 120}
+
+	if err := setup.Save(setup.Path(), config); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	golden.AssertFile(t, "provisioning_config.h.golden", setup.Path())
+}
+
+func TestSetup_Save_EscapesDeviceName(t *testing.T) {
+	t.Parallel()
+
+	defaults := testDefaults(t.TempDir())
+	setup := provisioning.NewSetup(defaults)
+	deviceName := `Lab "B" \ Probe`
+	config := &provisioning.Config{PoP: "deadbeef", DeviceName: deviceName, TimeoutSec: 120}
+
+	if err := setup.Save(setup.Path(), config); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	content, err := os.ReadFile(setup.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `#define PROVISIONING_DEVICE_NAME "Lab \"B\" \\ Probe"`
+	if !strings.Contains(string(content), want) {
+		t.Errorf("header missing escaped name %s:\n%s", want, content)
+	}
+
+	// The escaped header is read back as the original name
+	loaded, isNew, err := setup.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if isNew {
+		t.Fatal("Generate() regenerated instead of loading the saved config")
+	}
+	if loaded.DeviceName != deviceName || loaded.PoP != "deadbeef" {
+		t.Errorf("loaded = %+v, want DeviceName %q and PoP deadbeef", loaded, deviceName)
+	}
+}
+
+func TestSetup_Save_RejectsControlCharacters(t *testing.T) {
+	t.Parallel()
+
+	defaults := testDefaults(t.TempDir())
+	setup := provisioning.NewSetup(defaults)
+	config := &provisioning.Config{PoP: "deadbeef", DeviceName: "Probe\nEvil", TimeoutSec: 120}
+
+	err := setup.Save(setup.Path(), config)
+	if err == nil || !strings.Contains(err.Error(), "invalid device name") {
+		t.Errorf("Save() error = %v, want invalid device name", err)
+	}
+	if _, statErr := os.Stat(setup.Path()); !os.IsNotExist(statErr) {
+		t.Errorf("header written despite invalid device name")
+	}
+}
+
 func TestSetup_Generate_CustomPopBytes(t *testing.T) {
 	t.Parallel()
 
@@ -117,10 +182,127 @@ func TestSetup_Generate_ExistingSecret(t *testing.T) {
 		t.Errorf("PoP = %q, want %q", config.PoP, "deadbeef")
 	}
 
-	// DeviceName and TimeoutSec should come from defaults, not file
+	// DeviceName and TimeoutSec should be preserved from the file
+	if config.DeviceName != "OldDevice" {
+		t.Errorf("DeviceName = %q, want %q", config.DeviceName, "OldDevice")
+	}
+
+	if config.TimeoutSec != 60 {
+		t.Errorf("TimeoutSec = %d, want %d", config.TimeoutSec, 60)
+	}
+}
+
+func TestSetup_Generate_ExistingFullConfig(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	defaults := testDefaults(tmpDir)
+
+	// Write a complete config in the same format the tool generates
+	seed := provisioning.NewSetup(provisioning.Defaults{
+		DeviceName:   "Hand Tuned Probe",
+		TimeoutSec:   0,
+		PopBytes:     4,
+		OutputFile:   defaults.OutputFile,
+		GeneratedDir: defaults.GeneratedDir,
+	})
+	seeded, _, err := seed.Generate()
+	if err != nil {
+		t.Fatalf("failed to seed config: %v", err)
+	}
+
+	setup := provisioning.NewSetup(defaults)
+	config, isNew, err := setup.Generate()
+
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if isNew {
+		t.Error("Generate() isNew = true, want false for existing config")
+	}
+
+	if config.PoP != seeded.PoP {
+		t.Errorf("PoP = %q, want %q", config.PoP, seeded.PoP)
+	}
+
+	if config.DeviceName != "Hand Tuned Probe" {
+		t.Errorf("DeviceName = %q, want %q", config.DeviceName, "Hand Tuned Probe")
+	}
+
+	if config.TimeoutSec != 0 {
+		t.Errorf("TimeoutSec = %d, want %d", config.TimeoutSec, 0)
+	}
+}
+
+func TestSetup_Generate_ExistingConfigMissingTimeout(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	defaults := testDefaults(tmpDir)
+
+	if err := os.MkdirAll(defaults.GeneratedDir, 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	existingConfig := `#define PROVISIONING_POP "deadbeef"
+#define PROVISIONING_DEVICE_NAME "OldDevice"`
+
+	configPath := filepath.Join(defaults.GeneratedDir, defaults.OutputFile)
+	if err := os.WriteFile(configPath, []byte(existingConfig), 0644); err != nil {
+		t.Fatalf("failed to write existing config: %v", err)
+	}
+
+	setup := provisioning.NewSetup(defaults)
+	config, _, err := setup.Generate()
+
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if config.DeviceName != "OldDevice" {
+		t.Errorf("DeviceName = %q, want %q", config.DeviceName, "OldDevice")
+	}
+
+	// Missing timeout falls back to defaults
+	if config.TimeoutSec != 120 {
+		t.Errorf("TimeoutSec = %d, want %d", config.TimeoutSec, 120)
+	}
+}
+
+func TestSetup_Generate_ExistingConfigMalformedTimeout(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	defaults := testDefaults(tmpDir)
+
+	if err := os.MkdirAll(defaults.GeneratedDir, 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	existingConfig := `#define PROVISIONING_POP "deadbeef"
+#define PROVISIONING_DEVICE_NAME ""
+#define PROVISIONING_TIMEOUT_SEC soon`
+
+	configPath := filepath.Join(defaults.GeneratedDir, defaults.OutputFile)
+	if err := os.WriteFile(configPath, []byte(existingConfig), 0644); err != nil {
+		t.Fatalf("failed to write existing config: %v", err)
+	}
+
+	setup := provisioning.NewSetup(defaults)
+	config, _, err := setup.Generate()
+
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
 	if config.DeviceName != "TestDevice" {
 		t.Errorf("DeviceName = %q, want %q", config.DeviceName, "TestDevice")
 	}
+
+	if config.TimeoutSec != 120 {
+		t.Errorf("TimeoutSec = %d, want %d", config.TimeoutSec, 120)
+	}
 }
 
 func TestSetup_Generate_CreatesFile(t *testing.T) {
@@ -453,3 +635,89 @@ func TestSetup_Generate_CustomDeviceNameAndTimeout(t *testing.T) {
 		t.Error("custom timeout not in generated file")
 	}
 }
+
+func TestSetup_Plan_WritesNothing(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	defaults := testDefaults(tmpDir)
+
+	setup := provisioning.NewSetup(defaults)
+	config, isNew, err := setup.Plan()
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	if !isNew || config.PoP == "" {
+		t.Errorf("Plan() = %+v, isNew %t, want a new config", config, isNew)
+	}
+
+	if _, err := os.Stat(defaults.GeneratedDir); !os.IsNotExist(err) {
+		t.Errorf("Plan() created %s", defaults.GeneratedDir)
+	}
+
+	if setup.Path() != filepath.Join(defaults.GeneratedDir, defaults.OutputFile) {
+		t.Errorf("Path() = %q", setup.Path())
+	}
+}
+
+func TestSetup_Plan_ExistingSecret(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	defaults := testDefaults(tmpDir)
+
+	setup := provisioning.NewSetup(defaults)
+	generated, _, err := setup.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	planned, isNew, err := setup.Plan()
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if isNew {
+		t.Error("Plan() isNew = true, want false with existing config")
+	}
+	if planned.PoP != generated.PoP {
+		t.Errorf("Plan() PoP = %q, want %q", planned.PoP, generated.PoP)
+	}
+}
+
+func TestSetup_Generate_Force(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	defaults := testDefaults(tmpDir)
+	defaults.Force = true
+
+	if err := os.MkdirAll(defaults.GeneratedDir, 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	configPath := filepath.Join(defaults.GeneratedDir, defaults.OutputFile)
+	if err := os.WriteFile(configPath, []byte(`#define PROVISIONING_POP "deadbeef"`), 0644); err != nil {
+		t.Fatalf("failed to write existing config: %v", err)
+	}
+
+	setup := provisioning.NewSetup(defaults)
+	config, isNew, err := setup.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if !isNew {
+		t.Error("Generate() isNew = false, want true with Force")
+	}
+	if config.PoP == "deadbeef" || len(config.PoP) != 8 {
+		t.Errorf("PoP = %q, want a new 8-char secret", config.PoP)
+	}
+
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	if !strings.Contains(string(content), config.PoP) || strings.Contains(string(content), "deadbeef") {
+		t.Errorf("config file not replaced with new PoP:\n%s", content)
+	}
+}